@@ -0,0 +1,343 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+var (
+	ErrBranchMismatch = errors.New("merkle: trees of different branches")
+	ErrInvalidMessage = errors.New("merkle: invalid diff message")
+)
+
+// HashSource serves the hashes of the nodes of a tree, the leaves are at the level 0 and the
+// positions don't count the padding. Merkle and PersistentMerkle are both sources, a remote tree
+// is one through Serve.
+type HashSource interface {
+	Shape() (uint32, int) // The branches and the number of the leaves
+	Hashes(level int, positions []int) ([][]byte, error)
+}
+
+func (this *Merkle) Shape() (uint32, int) { return this.branch, this.Len() }
+
+func (this *Merkle) Hashes(level int, positions []int) ([][]byte, error) {
+	if level < 0 || level >= len(this.nodes) {
+		return nil, fmt.Errorf("%w: level %d of %d", ErrOutOfRange, level, len(this.nodes))
+	}
+
+	size := this.realLen(level)
+	hashes := make([][]byte, len(positions))
+	for i, position := range positions {
+		if position < 0 || position >= size {
+			return nil, fmt.Errorf("%w: node %d at level %d", ErrOutOfRange, position, level)
+		}
+		hashes[i] = this.nodes[level][position].hash
+	}
+	return hashes, nil
+}
+
+func (this *PersistentMerkle) Shape() (uint32, int) { return this.branch, this.Len() }
+
+func (this *PersistentMerkle) Hashes(level int, positions []int) ([][]byte, error) {
+	hashes := make([][]byte, len(positions))
+	for i, position := range positions {
+		hash, err := this.HashAt(level, position)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// Diff returns the indices of the leaves differing between the trees, in the ascending order. The
+// leaves only one of the trees has differ too. Only the subtrees whose hashes differ are descended into.
+func Diff(a, b HashSource) ([]int, error) {
+	differ := NewDiffer(a)
+	for request := differ.Next(); request != nil; request = differ.Next() {
+		response, err := Serve(b, request)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := differ.Receive(response); err != nil {
+			return nil, err
+		}
+	}
+	return differ.Result(), nil
+}
+
+// HashRequest asks a remote tree for the hashes of the nodes at the positions of the level. The first
+// request of a diff has no positions, it asks for the shape of the tree only.
+type HashRequest struct {
+	Level     uint32
+	Positions []uint64
+}
+
+// HashResponse carries the shape of the remote tree and the hashes requested, in the same order.
+type HashResponse struct {
+	Branch uint32
+	Leaves uint64
+	Hashes [][]byte
+}
+
+// Serve answers a request from the source, on the side of the remote tree.
+func Serve(source HashSource, request *HashRequest) (*HashResponse, error) {
+	branch, leaves := source.Shape()
+	response := &HashResponse{Branch: branch, Leaves: uint64(leaves)}
+	if len(request.Positions) == 0 {
+		return response, nil
+	}
+
+	positions := make([]int, len(request.Positions))
+	for i, position := range request.Positions {
+		positions[i] = int(position)
+	}
+
+	hashes, err := source.Hashes(int(request.Level), positions)
+	if err != nil {
+		return nil, err
+	}
+	response.Hashes = hashes
+	return response, nil
+}
+
+// Differ is the local side of a diff with a remote tree. It asks the remote for the hashes one level
+// at a time from the highest level both trees have down to the leaves, and only for the children of
+// the nodes found to differ:
+//
+//	for request := differ.Next(); request != nil; request = differ.Next() {
+//		differ.Receive(remote(request))
+//	}
+type Differ struct {
+	local   HashSource
+	branch  uint32
+	lens    [2][]int // The level lengths of the local and the remote trees, nil until the shape is known.
+	request *HashRequest
+	pending []int // The positions of the level to compare, all below the length of both trees.
+	level   int
+	differs [][2]int // The ranges of the differing leaves, [from, to), in no particular order.
+	done    bool
+}
+
+func NewDiffer(local HashSource) *Differ {
+	return &Differ{local: local, request: &HashRequest{}}
+}
+
+// Next returns the next request to send to the remote, nil once the diff is done.
+func (this *Differ) Next() *HashRequest {
+	if this.done {
+		return nil
+	}
+	return this.request
+}
+
+// Receive compares the response to the last request with the local tree, and prepares the next request.
+func (this *Differ) Receive(response *HashResponse) error {
+	if this.done {
+		return fmt.Errorf("%w: the diff is done", ErrInvalidMessage)
+	}
+
+	if len(this.request.Positions) == 0 {
+		return this.start(response)
+	}
+
+	if len(response.Hashes) != len(this.pending) {
+		return fmt.Errorf("%w: %d hashes for %d nodes", ErrInvalidMessage, len(response.Hashes), len(this.pending))
+	}
+
+	hashes, err := this.local.Hashes(this.level, this.pending)
+	if err != nil {
+		return err
+	}
+
+	differing := []int{}
+	for i, position := range this.pending {
+		if !bytes.Equal(hashes[i], response.Hashes[i]) {
+			differing = append(differing, position)
+		}
+	}
+
+	if this.level == 0 {
+		for _, position := range differing {
+			this.differs = append(this.differs, [2]int{position, position + 1})
+		}
+		this.done = true
+		return nil
+	}
+
+	// The children only one of the trees has are under the leaves marked at the start.
+	children, size := []int{}, min(this.lens[0][this.level-1], this.lens[1][this.level-1])
+	for _, position := range differing {
+		for child := position * int(this.branch); child < min((position+1)*int(this.branch), size); child++ {
+			children = append(children, child)
+		}
+	}
+	this.descend(this.level-1, children)
+	return nil
+}
+
+// Result returns the indices of the differing leaves found so far, all of them once Next returns nil.
+// With a remote tree much longer than the local one, Ranges avoids listing every leaf only it has.
+func (this *Differ) Result() []int {
+	leaves := []int{}
+	for _, differing := range this.Ranges() {
+		for leaf := differing[0]; leaf < differing[1]; leaf++ {
+			leaves = append(leaves, leaf)
+		}
+	}
+	return leaves
+}
+
+// Ranges returns the differing leaves found so far as the sorted and disjoint ranges [from, to).
+func (this *Differ) Ranges() [][2]int {
+	sorted := slices.Clone(this.differs)
+	slices.SortFunc(sorted, func(a, b [2]int) int { return a[0] - b[0] })
+
+	ranges := [][2]int{}
+	for _, differing := range sorted {
+		if last := len(ranges) - 1; last >= 0 && differing[0] <= ranges[last][1] {
+			ranges[last][1] = max(ranges[last][1], differing[1])
+		} else {
+			ranges = append(ranges, differing)
+		}
+	}
+	return ranges
+}
+
+// start sets up the diff from the shape of the remote tree, at the highest level both trees have.
+func (this *Differ) start(response *HashResponse) error {
+	if response.Leaves > math.MaxInt {
+		return fmt.Errorf("%w: %d leaves", ErrInvalidMessage, response.Leaves)
+	}
+
+	branch, leaves := this.local.Shape()
+	remote := int(response.Leaves)
+	if leaves == 0 || remote == 0 {
+		this.mark(0, max(leaves, remote))
+		this.done = true
+		return nil
+	}
+
+	if branch != response.Branch && leaves > 1 && remote > 1 {
+		return fmt.Errorf("%w: %d and %d", ErrBranchMismatch, branch, response.Branch)
+	}
+
+	if branch = max(branch, response.Branch); branch < 2 {
+		branch = 2 // A single leaf has no parent either way.
+	}
+
+	this.branch, this.lens = branch, [2][]int{levelLens(branch, leaves), levelLens(branch, remote)}
+
+	// The padding repeats the last leaf, so the shorter tree can hash like the longer one, the leaves
+	// only one of them has differ whatever the hashes are. The nodes only one of them has are all
+	// above these leaves, they aren't compared.
+	this.mark(min(leaves, remote), max(leaves, remote))
+
+	top := min(len(this.lens[0]), len(this.lens[1])) - 1
+	positions := make([]int, min(this.lens[0][top], this.lens[1][top]))
+	for i := range positions {
+		positions[i] = i
+	}
+	this.descend(top, positions)
+	return nil
+}
+
+// descend asks for the nodes at the positions of the level, which both trees have.
+func (this *Differ) descend(level int, positions []int) {
+	this.level, this.pending = level, positions
+	if len(this.pending) == 0 {
+		this.done = true
+		return
+	}
+
+	this.request = &HashRequest{Level: uint32(level), Positions: make([]uint64, len(this.pending))}
+	for i, position := range this.pending {
+		this.request.Positions[i] = uint64(position)
+	}
+}
+
+// mark marks the leaves from the first up to the last, exclusive, as differing.
+func (this *Differ) mark(first, last int) {
+	if first < last {
+		this.differs = append(this.differs, [2]int{first, last})
+	}
+}
+
+// Encode encodes the request in big endian, [level u32][positions u32][position u64]...
+func (this *HashRequest) Encode() []byte {
+	buffer := binary.BigEndian.AppendUint32(nil, this.Level)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(this.Positions)))
+	for _, position := range this.Positions {
+		buffer = binary.BigEndian.AppendUint64(buffer, position)
+	}
+	return buffer
+}
+
+func DecodeHashRequest(data []byte) (*HashRequest, error) {
+	reader := proofReader{data: data}
+	request := &HashRequest{Level: reader.uint32()}
+	count := reader.uint32()
+	if reader.err != nil || uint64(count)*8 != uint64(len(reader.data)) {
+		return nil, fmt.Errorf("%w: %d positions in %d bytes", ErrInvalidMessage, count, len(reader.data))
+	}
+
+	request.Positions = make([]uint64, count)
+	for i := range request.Positions {
+		request.Positions[i] = reader.uint64()
+	}
+	return request, nil
+}
+
+// Encode encodes the response in big endian, [branch u32][leaves u64][hashes u32][hash size u16][hash]...
+func (this *HashResponse) Encode() []byte {
+	hashSize := 0
+	if len(this.Hashes) > 0 {
+		hashSize = len(this.Hashes[0])
+	}
+
+	buffer := binary.BigEndian.AppendUint32(nil, this.Branch)
+	buffer = binary.BigEndian.AppendUint64(buffer, this.Leaves)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(this.Hashes)))
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(hashSize))
+	for _, hash := range this.Hashes {
+		buffer = append(buffer, hash...)
+	}
+	return buffer
+}
+
+func DecodeHashResponse(data []byte) (*HashResponse, error) {
+	reader := proofReader{data: data}
+	response := &HashResponse{Branch: reader.uint32(), Leaves: reader.uint64()}
+	count, hashSize := reader.uint32(), reader.uint16()
+	if reader.err != nil || uint64(count)*uint64(hashSize) != uint64(len(reader.data)) {
+		return nil, fmt.Errorf("%w: %d hashes of %d bytes in %d bytes", ErrInvalidMessage, count, hashSize, len(reader.data))
+	}
+
+	response.Hashes = make([][]byte, count)
+	for i := range response.Hashes {
+		response.Hashes[i] = reader.bytes(int(hashSize))
+	}
+	return response, nil
+}
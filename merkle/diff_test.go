@@ -0,0 +1,176 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func TestDiff(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, branch := range []int{2, 3, 4, 16} {
+		for round := 0; round < 20; round++ {
+			left := make([][]byte, 1+random.Intn(300))
+			for i := range left {
+				left[i] = []byte(fmt.Sprint("leaf-", i))
+			}
+
+			right := append([][]byte{}, left...)
+			if random.Intn(2) == 0 {
+				right = right[:1+random.Intn(len(right))]
+			} else {
+				for i := random.Intn(50); i > 0; i-- {
+					right = append(right, []byte(fmt.Sprint("extra-", i)))
+				}
+			}
+
+			for i := random.Intn(5); i > 0; i-- {
+				right[random.Intn(len(right))] = []byte(fmt.Sprint("changed-", i))
+			}
+
+			expected := []int{}
+			for i := 0; i < max(len(left), len(right)); i++ {
+				if i >= len(left) || i >= len(right) || string(left[i]) != string(right[i]) {
+					expected = append(expected, i)
+				}
+			}
+
+			a, b := NewMerkle(branch, Concatenator{}, Sha256{}), NewMerkle(branch, Concatenator{}, Sha256{})
+			a.Init(left, nil)
+			b.Init(right, nil)
+
+			differs, err := Diff(a, b)
+			if err != nil || !slices.Equal(differs, expected) {
+				t.Fatalf("%d and %d leaves of %d branches: expected %v, got %v, %v", len(left), len(right), branch, expected, differs, err)
+			}
+
+			// The same from the other side, and with a tree loaded on demand.
+			nodes := NewNodeStore(memdb.NewMemoryDB(), "b/", 16)
+			b.Save(nodes)
+			persistent, _ := OpenMerkle(nodes)
+			if differs, err = Diff(persistent, a); err != nil || !slices.Equal(differs, expected) {
+				t.Fatalf("%d and %d leaves of %d branches: expected %v, got %v, %v", len(right), len(left), branch, expected, differs, err)
+			}
+		}
+	}
+}
+
+func TestDiffProtocol(t *testing.T) {
+	data := make([][]byte, 4096)
+	for i := range data {
+		data[i] = []byte{byte(i), byte(i >> 8)}
+	}
+
+	local, remote := NewMerkle(4, Concatenator{}, Keccak256{}), NewMerkle(4, Concatenator{}, Keccak256{})
+	local.Init(data, nil)
+	data[1000] = []byte("changed")
+	remote.Init(data, nil)
+
+	// Only the children of the differing nodes are asked for, over the encoded messages.
+	differ, requested := NewDiffer(local), 0
+	for request := differ.Next(); request != nil; request = differ.Next() {
+		decoded, err := DecodeHashRequest(request.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		response, err := Serve(remote, decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if response, err = DecodeHashResponse(response.Encode()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := differ.Receive(response); err != nil {
+			t.Fatal(err)
+		}
+		requested += len(request.Positions)
+	}
+
+	if !slices.Equal(differ.Result(), []int{1000}) || requested != 1+4*6 {
+		t.Fatalf("expected leaf 1000 by 25 nodes, got %v by %d", differ.Result(), requested)
+	}
+
+	if _, err := DecodeHashResponse([]byte{1, 2, 3}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage, got %v", err)
+	}
+
+	other := NewMerkle(2, Concatenator{}, Keccak256{})
+	other.Init(data, nil)
+	if _, err := Diff(local, other); !errors.Is(err, ErrBranchMismatch) {
+		t.Fatalf("expected ErrBranchMismatch, got %v", err)
+	}
+}
+
+func TestDiffPaddedLengths(t *testing.T) {
+	for _, branch := range []int{2, 4} {
+		short := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+		padded := append(append([][]byte{}, short...), []byte("c")) // Hashes like the short one padded
+
+		a, b := NewMerkle(branch, Concatenator{}, Sha256{}), NewMerkle(branch, Concatenator{}, Sha256{})
+		a.Init(short, nil)
+		b.Init(padded, nil)
+
+		for _, pair := range [][2]*Merkle{{a, b}, {b, a}} {
+			if differs, err := Diff(pair[0], pair[1]); err != nil || !slices.Equal(differs, []int{3}) {
+				t.Fatalf("%d branches: expected [3], got %v, %v", branch, differs, err)
+			}
+		}
+	}
+}
+
+func TestDiffHostileLeaves(t *testing.T) {
+	data := make([][]byte, 8)
+	for i := range data {
+		data[i] = []byte(fmt.Sprint("leaf-", i))
+	}
+	local := NewMerkle(2, Concatenator{}, Sha256{})
+	local.Init(data, nil)
+
+	differ := NewDiffer(local)
+	if err := differ.Receive(&HashResponse{Branch: 2, Leaves: math.MaxUint64}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage, got %v", err)
+	}
+
+	// The remote claims a huge tree with the same leaves first, the extra leaves are kept as a range.
+	differ = NewDiffer(local)
+	for request := differ.Next(); request != nil; request = differ.Next() {
+		response, err := Serve(local, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Leaves = 1 << 40
+
+		if err := differ.Receive(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ranges := differ.Ranges(); !slices.Equal(ranges, [][2]int{{8, 1 << 40}}) {
+		t.Fatalf("expected [[8 %d]], got %v", 1<<40, ranges)
+	}
+}
@@ -62,6 +62,10 @@ func (*Merkle) Decode(bytes []byte) interface{} {
 		merkle.nodes = append(merkle.nodes, level)
 	}
 
+	if len(merkle.nodes) > 1 { // The branches aren't encoded, but every parent has all of them.
+		merkle.branch = uint32(len(merkle.nodes[1][0].children))
+	}
+
 	// merkle.encoder = Concatenator{}
 	return merkle
 }
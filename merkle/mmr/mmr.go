@@ -0,0 +1,218 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package mmr implements a Merkle Mountain Range, an append-only accumulator for the histories like
+// the block hashes, which proves any entry ever appended against the latest root.
+//
+// The leaves are grouped into perfect binary trees, the mountains, one for each bit set in the number
+// of the leaves, the highest on the left. Appending a leaf merges the mountains of the same height, so
+// no node is ever rewritten. The root bags the peaks from the right, along with the number of the
+// leaves, since the peaks alone don't tell the mountains they are the peaks of:
+//
+//	leaf      H(0x00 | data)
+//	parent    H(0x01 | left | right)
+//	bagged    H(0x01 | peak0 | H(0x01 | peak1 | ... peakN))
+//	root      H(0x02 | size u64 | bagged)
+//
+// A node at the height h and the index i covers the leaves [i*2^h, (i+1)*2^h).
+package mmr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+
+	"github.com/arcology-network/common-lib/merkle"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	NODE_LEAF   = 0
+	NODE_PARENT = 1
+	NODE_ROOT   = 2
+
+	META_KEY = "meta"
+)
+
+var (
+	ErrOutOfRange = errors.New("mmr: leaf index out of range")
+	ErrCorrupted  = errors.New("mmr: corrupted data")
+)
+
+// MMR is a Merkle Mountain Range stored in a ReadWriteStore under a prefix. The nodes are written once,
+// along with the size and the peaks, which are kept in memory too. It isn't safe for concurrent appends.
+type MMR struct {
+	store  stgintf.ReadWriteStore[string, []byte]
+	prefix string
+	hasher interface{ Hash([]byte) []byte }
+	size   uint64
+	peaks  [][]byte // From the highest mountain to the lowest
+}
+
+// NewMMR opens the MMR under the prefix, an empty one if nothing is stored there yet. The hasher is
+// merkle.Keccak256 if nil.
+func NewMMR(store stgintf.ReadWriteStore[string, []byte], prefix string, hasher interface{ Hash([]byte) []byte }) (*MMR, error) {
+	if hasher == nil {
+		hasher = merkle.Keccak256{}
+	}
+
+	mmr := &MMR{store: store, prefix: prefix, hasher: hasher}
+	data, err := store.Get(prefix + META_KEY)
+	if errors.Is(err, stgintf.ErrNotFound) {
+		return mmr, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	meta, ok := data.([]byte)
+	hashLen := len(hasher.Hash([]byte{0}))
+	if !ok || len(meta) < 8 {
+		return nil, fmt.Errorf("%w: the meta data", ErrCorrupted)
+	}
+
+	mmr.size = binary.BigEndian.Uint64(meta)
+	if len(meta) != 8+bits.OnesCount64(mmr.size)*hashLen {
+		return nil, fmt.Errorf("%w: %d bytes of meta data for %d leaves", ErrCorrupted, len(meta), mmr.size)
+	}
+
+	for offset := 8; offset < len(meta); offset += hashLen {
+		mmr.peaks = append(mmr.peaks, bytes.Clone(meta[offset:offset+hashLen]))
+	}
+	return mmr, nil
+}
+
+// Size returns the number of the leaves appended.
+func (this *MMR) Size() uint64 { return this.size }
+
+// Peaks returns the roots of the mountains, from the highest to the lowest.
+func (this *MMR) Peaks() [][]byte { return this.peaks }
+
+// Root returns the bagged peaks with the size, all zeros for an empty MMR.
+func (this *MMR) Root() []byte {
+	if len(this.peaks) == 0 {
+		return make([]byte, len(this.hasher.Hash([]byte{0})))
+	}
+	return rootHash(this.hasher, this.size, this.peaks)
+}
+
+// Append adds the leaves to the end, the first of them gets the index Size() had before. The new nodes
+// and the new peaks are written in one batch, nothing is changed if it fails.
+func (this *MMR) Append(data ...[]byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	size, peaks := this.size, append([][]byte{}, this.peaks...)
+	keys, values := []string{}, [][]byte{}
+	for _, leaf := range data {
+		hash := leafHash(this.hasher, leaf)
+		keys, values = append(keys, this.nodeKey(0, size)), append(values, hash)
+
+		// Every trailing 1 bit of the size is a mountain of the same height to merge with.
+		for height, index := 0, size; index%2 == 1; height, index = height+1, index/2 {
+			hash = parentHash(this.hasher, peaks[len(peaks)-1], hash)
+			peaks = peaks[:len(peaks)-1]
+			keys, values = append(keys, this.nodeKey(height+1, index/2)), append(values, hash)
+		}
+		peaks, size = append(peaks, hash), size+1
+	}
+
+	meta := binary.BigEndian.AppendUint64(nil, size)
+	for _, peak := range peaks {
+		meta = append(meta, peak...)
+	}
+	keys, values = append(keys, this.prefix+META_KEY), append(values, meta)
+
+	if err := errors.Join(this.store.SetBatch(keys, values)...); err != nil {
+		return err
+	}
+	this.size, this.peaks = size, peaks
+	return nil
+}
+
+// Prove returns the proof of the leaf at the index against the current root.
+func (this *MMR) Prove(index uint64) (*Proof, error) {
+	if index >= this.size {
+		return nil, fmt.Errorf("%w: %d of %d", ErrOutOfRange, index, this.size)
+	}
+
+	height, _ := mountainOf(index, this.size)
+	proof := &Proof{Index: index, Size: this.size, Peaks: this.peaks}
+	for h, i := 0, index; h < height; h, i = h+1, i/2 {
+		sibling, err := this.node(h, i^1)
+		if err != nil {
+			return nil, err
+		}
+		proof.Siblings = append(proof.Siblings, sibling)
+	}
+	return proof, nil
+}
+
+func (this *MMR) node(height int, index uint64) ([]byte, error) {
+	data, err := this.store.Get(this.nodeKey(height, index))
+	if err != nil {
+		return nil, fmt.Errorf("mmr: loading node %d at height %d: %w", index, height, err)
+	}
+
+	hash, ok := data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: node %d at height %d is a %T", ErrCorrupted, index, height, data)
+	}
+	return hash, nil
+}
+
+func (this *MMR) nodeKey(height int, index uint64) string {
+	return this.prefix + string(binary.BigEndian.AppendUint64([]byte{byte(height)}, index))
+}
+
+// mountainOf returns the height of the mountain of the leaf among the size leaves, and the position
+// of the mountain among the peaks.
+func mountainOf(index, size uint64) (int, int) {
+	start := uint64(0)
+	for position := 0; ; position++ {
+		height := 63 - bits.LeadingZeros64(size-start)
+		if index < start+1<<height {
+			return height, position
+		}
+		start += 1 << height
+	}
+}
+
+func bagPeaks(hasher interface{ Hash([]byte) []byte }, peaks [][]byte) []byte {
+	bagged := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		bagged = parentHash(hasher, peaks[i], bagged)
+	}
+	return bagged
+}
+
+// rootHash commits the root to the size, so a proof can't claim the peaks of another one.
+func rootHash(hasher interface{ Hash([]byte) []byte }, size uint64, peaks [][]byte) []byte {
+	return hasher.Hash(append(binary.BigEndian.AppendUint64([]byte{NODE_ROOT}, size), bagPeaks(hasher, peaks)...))
+}
+
+func leafHash(hasher interface{ Hash([]byte) []byte }, data []byte) []byte {
+	return hasher.Hash(append([]byte{NODE_LEAF}, data...))
+}
+
+func parentHash(hasher interface{ Hash([]byte) []byte }, left, right []byte) []byte {
+	return hasher.Hash(append(append([]byte{NODE_PARENT}, left...), right...))
+}
@@ -0,0 +1,174 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mmr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/arcology-network/common-lib/merkle"
+	"github.com/arcology-network/common-lib/storage/faulty"
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+// naiveRoot splits the leaves into the mountains and hashes each one as a perfect binary tree.
+func naiveRoot(hasher interface{ Hash([]byte) []byte }, leaves [][]byte) []byte {
+	hashes := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		hashes[i] = leafHash(hasher, leaf)
+	}
+
+	peaks := [][]byte{}
+	for len(hashes) > 0 {
+		width := 1
+		for width*2 <= len(hashes) {
+			width *= 2
+		}
+
+		level := hashes[:width]
+		for len(level) > 1 {
+			parents := [][]byte{}
+			for i := 0; i < len(level); i += 2 {
+				parents = append(parents, parentHash(hasher, level[i], level[i+1]))
+			}
+			level = parents
+		}
+		peaks, hashes = append(peaks, level[0]), hashes[width:]
+	}
+	return rootHash(hasher, uint64(len(leaves)), peaks)
+}
+
+func TestAppendAndProve(t *testing.T) {
+	hasher := merkle.Keccak256{}
+	mmr, err := NewMMR(memdb.NewMemoryDB(), "history/", hasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(mmr.Root(), make([]byte, 32)) {
+		t.Fatal("expected the zero root when empty")
+	}
+
+	leaves := [][]byte{}
+	for batch := 1; len(leaves) < 100; batch++ {
+		appended := [][]byte{}
+		for i := 0; i < batch%4; i++ {
+			appended = append(appended, []byte(fmt.Sprint("block-", len(leaves)+i)))
+		}
+
+		if err := mmr.Append(appended...); err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, appended...)
+
+		if len(leaves) == 0 {
+			continue
+		}
+
+		root := mmr.Root()
+		if mmr.Size() != uint64(len(leaves)) || !bytes.Equal(root, naiveRoot(hasher, leaves)) {
+			t.Fatalf("%d leaves: wrong root", len(leaves))
+		}
+
+		for i := range leaves {
+			proof, err := mmr.Prove(uint64(i))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !Verify(root, proof, leaves[i], hasher) {
+				t.Fatalf("%d leaves: failed to verify leaf %d", len(leaves), i)
+			}
+
+			if Verify(root, proof, []byte("forged"), hasher) {
+				t.Fatalf("%d leaves: verified a forged leaf %d", len(leaves), i)
+			}
+		}
+	}
+
+	if _, err := mmr.Prove(mmr.Size()); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	db := memdb.NewMemoryDB()
+	mmr, _ := NewMMR(db, "history/", nil)
+	for i := 0; i < 37; i++ {
+		mmr.Append([]byte{byte(i)})
+	}
+
+	reopened, err := NewMMR(db, "history/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reopened.Size() != 37 || !bytes.Equal(reopened.Root(), mmr.Root()) {
+		t.Fatal("expected the same MMR after reopening")
+	}
+
+	// Appending to the reopened one keeps both in step.
+	mmr.Append([]byte("next"))
+	reopened.Append([]byte("next"))
+	proof, err := reopened.Prove(5)
+	if err != nil || !Verify(mmr.Root(), proof, []byte{5}, merkle.Keccak256{}) {
+		t.Fatalf("failed to verify after reopening, %v", err)
+	}
+}
+
+func TestFailedAppend(t *testing.T) {
+	store := faulty.NewStore(memdb.NewMemoryDB(), faulty.Config{Rules: []faulty.Rule{{
+		Ops:         []string{faulty.OP_SET_BATCH},
+		Keys:        regexp.MustCompile("meta$"),
+		Probability: 1,
+	}}})
+
+	mmr, _ := NewMMR(store, "history/", nil)
+	if err := mmr.Append([]byte("a"), []byte("b")); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatalf("expected the injected failure, got %v", err)
+	}
+
+	if mmr.Size() != 0 || len(mmr.Peaks()) != 0 {
+		t.Fatal("expected nothing appended")
+	}
+}
+
+func TestVerifyRewrittenProof(t *testing.T) {
+	hasher := merkle.Keccak256{}
+	mmr, _ := NewMMR(memdb.NewMemoryDB(), "history/", hasher)
+	mmr.Append([]byte("a"), []byte("b"), []byte("c"))
+
+	proof, _ := mmr.Prove(2)
+	if !Verify(mmr.Root(), proof, []byte("c"), hasher) {
+		t.Fatal("expected the leaf at 2 to verify")
+	}
+
+	// The peaks of 3 leaves fit 5 leaves too, with c as the leaf at 4.
+	for _, rewritten := range []*Proof{
+		{Index: 4, Size: 5, Siblings: proof.Siblings, Peaks: proof.Peaks},
+		{Index: 2, Size: 5, Siblings: proof.Siblings, Peaks: proof.Peaks},
+		{Index: 4, Size: 3, Siblings: proof.Siblings, Peaks: proof.Peaks},
+	} {
+		if Verify(mmr.Root(), rewritten, []byte("c"), hasher) {
+			t.Fatalf("expected the rewritten proof of %d in %d to fail", rewritten.Index, rewritten.Size)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mmr
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// Proof is the path from a leaf up to the peak of its mountain, along with all the peaks to bag into
+// the root.
+type Proof struct {
+	Index    uint64
+	Size     uint64   // The number of the leaves when proven
+	Siblings [][]byte // From the leaf up
+	Peaks    [][]byte // From the highest mountain to the lowest
+}
+
+// Verify checks the proof shows the data is the leaf at the index under the root. The root commits
+// to the size, so the index and the size of the proof can't be rewritten.
+func Verify(root []byte, proof *Proof, data []byte, hasher interface{ Hash([]byte) []byte }) bool {
+	if proof == nil || proof.Index >= proof.Size || len(proof.Peaks) != bits.OnesCount64(proof.Size) {
+		return false
+	}
+
+	height, position := mountainOf(proof.Index, proof.Size)
+	if len(proof.Siblings) != height {
+		return false
+	}
+
+	hash := leafHash(hasher, data)
+	for i, sibling := range proof.Siblings {
+		if proof.Index>>i&1 == 0 {
+			hash = parentHash(hasher, hash, sibling)
+		} else {
+			hash = parentHash(hasher, sibling, hash)
+		}
+	}
+	return bytes.Equal(hash, proof.Peaks[position]) && bytes.Equal(rootHash(hasher, proof.Size, proof.Peaks), root)
+}
@@ -0,0 +1,369 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package mpt computes the roots and the proofs of the Ethereum Merkle Patricia Tries, so the
+// transactionsRoot, the receiptsRoot and the stateRoot of a block can be checked by the Ethereum
+// tooling.
+//
+// A Trie is built in memory from its contents when hashed, it is meant for the tries computed once
+// per block rather than for a persistent state.
+package mpt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/arcology-network/common-lib/merkle"
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// EMPTY_ROOT is the root of an empty trie, keccak256(rlp("")).
+var EMPTY_ROOT = ethCommon.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+
+var (
+	ErrInvalidProof = errors.New("mpt: invalid proof")
+	ErrMissingNode  = errors.New("mpt: missing node in the proof")
+)
+
+type node interface{ encoded() []byte }
+
+type leafNode struct {
+	path  []byte // Nibbles
+	value []byte
+	enc   []byte
+}
+
+type extensionNode struct {
+	path  []byte // Nibbles
+	child node
+	enc   []byte
+}
+
+type branchNode struct {
+	children [16]node
+	value    []byte
+	enc      []byte
+}
+
+type pair struct {
+	path  []byte // Nibbles
+	value []byte
+}
+
+// Trie is an Ethereum Merkle Patricia Trie held in memory.
+type Trie struct {
+	entries map[string][]byte
+	root    node // Built from the entries on demand
+	built   bool
+}
+
+func New() *Trie { return &Trie{entries: map[string][]byte{}} }
+
+// Update sets the value of the key, deleting the key if the value is empty like the Ethereum tries do.
+func (this *Trie) Update(key, value []byte) {
+	if len(value) == 0 {
+		delete(this.entries, string(key))
+	} else {
+		this.entries[string(key)] = bytes.Clone(value)
+	}
+	this.root, this.built = nil, false
+}
+
+func (this *Trie) Get(key []byte) ([]byte, bool) {
+	value, ok := this.entries[string(key)]
+	return value, ok
+}
+
+func (this *Trie) Len() int { return len(this.entries) }
+
+// Hash returns the root of the trie, EMPTY_ROOT if empty.
+func (this *Trie) Hash() ethCommon.Hash {
+	root := this.build()
+	if root == nil {
+		return EMPTY_ROOT
+	}
+	return ethCommon.BytesToHash(merkle.Keccak256{}.Hash(root.encoded()))
+}
+
+// Prove returns the nodes on the path of the key referenced by their hashes, from the root down, in
+// the form of the eth_getProof proofs. The proof of an absent key proves its absence.
+func (this *Trie) Prove(key []byte) [][]byte {
+	current := this.build()
+	path := toNibbles(key)
+
+	proof := [][]byte{}
+	for depth := 0; current != nil; depth++ {
+		if enc := current.encoded(); depth == 0 || len(enc) >= ethCommon.HashLength {
+			proof = append(proof, enc)
+		}
+
+		switch n := current.(type) {
+		case *leafNode:
+			return proof
+		case *extensionNode:
+			if !bytes.HasPrefix(path, n.path) {
+				return proof
+			}
+			path, current = path[len(n.path):], n.child
+		case *branchNode:
+			if len(path) == 0 {
+				return proof
+			}
+			path, current = path[1:], n.children[path[0]]
+		}
+	}
+	return proof
+}
+
+func (this *Trie) build() node {
+	if this.built {
+		return this.root
+	}
+
+	pairs := make([]pair, 0, len(this.entries))
+	for key, value := range this.entries {
+		pairs = append(pairs, pair{toNibbles([]byte(key)), value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].path, pairs[j].path) < 0 })
+
+	this.root, this.built = build(pairs, 0), true
+	return this.root
+}
+
+// build builds the subtrie of the sorted pairs sharing the first depth nibbles.
+func build(pairs []pair, depth int) node {
+	switch len(pairs) {
+	case 0:
+		return nil
+	case 1:
+		return &leafNode{path: pairs[0].path[depth:], value: pairs[0].value}
+	}
+
+	// The first and the last pairs share the shortest prefix of all, since they are sorted.
+	first, last := pairs[0].path[depth:], pairs[len(pairs)-1].path[depth:]
+	shared := 0
+	for shared < len(first) && shared < len(last) && first[shared] == last[shared] {
+		shared++
+	}
+
+	if shared > 0 {
+		return &extensionNode{path: first[:shared], child: build(pairs, depth+shared)}
+	}
+
+	branch := &branchNode{}
+	if len(first) == 0 { // A key ending here, sorted first
+		branch.value, pairs = pairs[0].value, pairs[1:]
+	}
+
+	for start := 0; start < len(pairs); {
+		nibble, end := pairs[start].path[depth], start+1
+		for end < len(pairs) && pairs[end].path[depth] == nibble {
+			end++
+		}
+		branch.children[nibble] = build(pairs[start:end], depth+1)
+		start = end
+	}
+	return branch
+}
+
+func (this *leafNode) encoded() []byte {
+	if this.enc == nil {
+		this.enc, _ = rlp.EncodeToBytes([]any{compact(this.path, true), this.value})
+	}
+	return this.enc
+}
+
+func (this *extensionNode) encoded() []byte {
+	if this.enc == nil {
+		this.enc, _ = rlp.EncodeToBytes([]any{compact(this.path, false), reference(this.child)})
+	}
+	return this.enc
+}
+
+func (this *branchNode) encoded() []byte {
+	if this.enc == nil {
+		items := make([]any, 17)
+		for i, child := range this.children {
+			items[i] = reference(child)
+		}
+		items[16] = this.value
+		if this.value == nil {
+			items[16] = []byte{}
+		}
+		this.enc, _ = rlp.EncodeToBytes(items)
+	}
+	return this.enc
+}
+
+// reference embeds the nodes shorter than a hash into their parents, and refers to the others by hash.
+func reference(child node) any {
+	if child == nil {
+		return []byte{}
+	}
+
+	if enc := child.encoded(); len(enc) < ethCommon.HashLength {
+		return rlp.RawValue(enc)
+	}
+	return merkle.Keccak256{}.Hash(child.encoded())
+}
+
+// VerifyProof walks the proof of the key from the root, and returns the value of the key, or nil
+// if the proof shows the key is absent.
+func VerifyProof(root ethCommon.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	nodes := make(map[ethCommon.Hash][]byte, len(proof))
+	for _, enc := range proof {
+		nodes[ethCommon.BytesToHash(merkle.Keccak256{}.Hash(enc))] = enc
+	}
+
+	if root == EMPTY_ROOT {
+		return nil, nil
+	}
+
+	enc, ok := nodes[root]
+	if !ok {
+		return nil, fmt.Errorf("%w: the root %x", ErrMissingNode, root)
+	}
+
+	path := toNibbles(key)
+	for {
+		items, err := splitItems(enc)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []byte
+		switch len(items) {
+		case 2:
+			nibbles, leaf, err := decodeCompact(items[0].value)
+			if err != nil {
+				return nil, err
+			}
+
+			if leaf {
+				if bytes.Equal(path, nibbles) {
+					return items[1].value, nil
+				}
+				return nil, nil
+			}
+
+			if !bytes.HasPrefix(path, nibbles) {
+				return nil, nil
+			}
+			path, next = path[len(nibbles):], items[1].raw
+		case 17:
+			if len(path) == 0 {
+				if len(items[16].value) == 0 {
+					return nil, nil
+				}
+				return items[16].value, nil
+			}
+			path, next = path[1:], items[path[0]].raw
+		default:
+			return nil, fmt.Errorf("%w: a node of %d items", ErrInvalidProof, len(items))
+		}
+
+		if enc, err = resolve(next, nodes); enc == nil || err != nil {
+			return nil, err
+		}
+	}
+}
+
+type item struct {
+	value []byte // The content
+	raw   []byte // The whole encoding
+}
+
+func splitItems(enc []byte) ([]item, error) {
+	content, _, err := rlp.SplitList(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	}
+
+	items := []item{}
+	for len(content) > 0 {
+		_, value, rest, err := rlp.Split(content)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+		}
+		items = append(items, item{value, content[:len(content)-len(rest)]})
+		content = rest
+	}
+	return items, nil
+}
+
+// resolve returns the encoding of a child, embedded or referred to by hash, nil for an empty one.
+func resolve(ref []byte, nodes map[ethCommon.Hash][]byte) ([]byte, error) {
+	kind, value, _, err := rlp.Split(ref)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProof, err)
+	case kind == rlp.List:
+		return ref, nil
+	case len(value) == 0:
+		return nil, nil
+	case len(value) == ethCommon.HashLength:
+		if enc, ok := nodes[ethCommon.BytesToHash(value)]; ok {
+			return enc, nil
+		}
+		return nil, fmt.Errorf("%w: %x", ErrMissingNode, value)
+	}
+	return nil, fmt.Errorf("%w: a reference of %d bytes", ErrInvalidProof, len(value))
+}
+
+func toNibbles(key []byte) []byte {
+	nibbles := make([]byte, len(key)*2)
+	for i, b := range key {
+		nibbles[2*i], nibbles[2*i+1] = b>>4, b&0x0f
+	}
+	return nibbles
+}
+
+// compact encodes the nibbles in the hex prefix encoding, flagging a leaf and an odd length.
+func compact(nibbles []byte, leaf bool) []byte {
+	flag := byte(0)
+	if leaf {
+		flag = 2
+	}
+
+	if len(nibbles)%2 == 1 {
+		flag, nibbles = (flag+1)<<4|nibbles[0], nibbles[1:]
+	} else {
+		flag <<= 4
+	}
+
+	encoded := []byte{flag}
+	for i := 0; i < len(nibbles); i += 2 {
+		encoded = append(encoded, nibbles[i]<<4|nibbles[i+1])
+	}
+	return encoded
+}
+
+func decodeCompact(encoded []byte) ([]byte, bool, error) {
+	if len(encoded) == 0 || encoded[0]>>4 > 3 {
+		return nil, false, fmt.Errorf("%w: bad hex prefix", ErrInvalidProof)
+	}
+
+	flag := encoded[0] >> 4
+	nibbles := toNibbles(encoded[1:])
+	if flag&1 == 1 {
+		nibbles = append([]byte{encoded[0] & 0x0f}, nibbles...)
+	}
+	return nibbles, flag&2 == 2, nil
+}
@@ -0,0 +1,179 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mpt
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/arcology-network/common-lib/types"
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	evmTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/ethereum/go-ethereum/triedb"
+	"github.com/holiman/uint256"
+)
+
+func testTransactions(n int) (types.StandardTransactions, evmTypes.Transactions) {
+	stds, natives := types.StandardTransactions{}, evmTypes.Transactions{}
+	for i := 0; i < n; i++ {
+		to := ethCommon.BigToAddress(big.NewInt(int64(i)))
+		var tx *evmTypes.Transaction
+		if i%2 == 0 {
+			tx = evmTypes.NewTx(&evmTypes.LegacyTx{Nonce: uint64(i), To: &to, Value: big.NewInt(int64(i)), Gas: 21000, GasPrice: big.NewInt(1)})
+		} else {
+			tx = evmTypes.NewTx(&evmTypes.DynamicFeeTx{ChainID: big.NewInt(118), Nonce: uint64(i), To: &to, Gas: 21000, GasFeeCap: big.NewInt(2), GasTipCap: big.NewInt(1), Data: bytes.Repeat([]byte{byte(i)}, i)})
+		}
+		stds = append(stds, &types.StandardTransaction{TxHash: tx.Hash(), NativeTransaction: tx})
+		natives = append(natives, tx)
+	}
+	return stds, natives
+}
+
+func TestTransactionsAndReceiptsRoots(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 16, 17, 200} {
+		stds, natives := testTransactions(n)
+		root, err := TransactionsRoot(stds)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := evmTypes.DeriveSha(natives, trie.NewStackTrie(nil)); root != expected {
+			t.Fatalf("%d transactions: expected %x, got %x", n, expected, root)
+		}
+
+		receipts := make(evmTypes.Receipts, n)
+		for i := range receipts {
+			receipts[i] = &evmTypes.Receipt{
+				Type:              natives[i].Type(),
+				Status:            uint64(i % 2),
+				CumulativeGasUsed: uint64(21000 * (i + 1)),
+				Logs:              []*evmTypes.Log{{Address: ethCommon.BigToAddress(big.NewInt(int64(i))), Data: []byte{byte(i)}}},
+			}
+		}
+
+		root, err = ReceiptsRoot(receipts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := evmTypes.DeriveSha(receipts, trie.NewStackTrie(nil)); root != expected {
+			t.Fatalf("%d receipts: expected %x, got %x", n, expected, root)
+		}
+	}
+}
+
+func TestStateRootAndProofs(t *testing.T) {
+	reference := trie.NewEmpty(triedb.NewDatabase(rawdb.NewMemoryDatabase(), nil))
+	accounts := map[ethCommon.Address]*evmTypes.StateAccount{}
+	for i := 0; i < 300; i++ {
+		slots := map[ethCommon.Hash]ethCommon.Hash{}
+		for j := 0; j < i%4; j++ {
+			slots[ethCommon.BigToHash(big.NewInt(int64(j)))] = ethCommon.BigToHash(big.NewInt(int64(i * j)))
+		}
+
+		address := ethCommon.BigToAddress(big.NewInt(int64(i * 7919)))
+		accounts[address] = &evmTypes.StateAccount{
+			Nonce:    uint64(i),
+			Balance:  uint256.NewInt(uint64(i) * 1e18),
+			Root:     StorageRoot(slots),
+			CodeHash: evmTypes.EmptyCodeHash[:],
+		}
+
+		encoded, _ := rlp.EncodeToBytes(accounts[address])
+		reference.MustUpdate(HashKey(address[:]), encoded)
+	}
+
+	state, err := StateTrie(accounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := state.Hash()
+	if root != reference.Hash() {
+		t.Fatalf("expected %x, got %x", reference.Hash(), root)
+	}
+
+	for i := 0; i < 310; i++ {
+		key := HashKey(ethCommon.BigToAddress(big.NewInt(int64(i * 7919))).Bytes())
+		expected, _ := state.Get(key)
+
+		// The proofs are interchangeable with the go-ethereum ones.
+		proof := memorydb.New()
+		reference.Prove(key, proof)
+		theirs, err := trie.VerifyProof(root, key, toProofDB(state.Prove(key)))
+		if err != nil || !bytes.Equal(theirs, expected) {
+			t.Fatalf("account %d: go-ethereum failed to verify the proof, %v", i, err)
+		}
+
+		ours, err := VerifyProof(root, key, fromProofDB(proof))
+		if err != nil || !bytes.Equal(ours, expected) {
+			t.Fatalf("account %d: failed to verify the go-ethereum proof, %v", i, err)
+		}
+	}
+
+	if StorageRoot(nil) != EMPTY_ROOT || evmTypes.EmptyRootHash != EMPTY_ROOT {
+		t.Fatal("expected the empty root")
+	}
+}
+
+func TestProofRejectsTampering(t *testing.T) {
+	items := make([][]byte, 50)
+	for i := range items {
+		items[i] = []byte(fmt.Sprint("item-", i))
+	}
+
+	list := ListTrie(items)
+	proof := list.Prove(IndexKey(7))
+	if value, err := VerifyProof(list.Hash(), IndexKey(7), proof); err != nil || !bytes.Equal(value, items[7]) {
+		t.Fatalf("expected item 7, got %s %v", value, err)
+	}
+
+	if value, err := VerifyProof(list.Hash(), IndexKey(70), list.Prove(IndexKey(70))); err != nil || value != nil {
+		t.Fatalf("expected the absence of item 70, got %s %v", value, err)
+	}
+
+	tampered := append([][]byte{}, proof...)
+	tampered[len(tampered)-1] = bytes.Replace(tampered[len(tampered)-1], items[7], []byte("item-X"), 1)
+	if _, err := VerifyProof(list.Hash(), IndexKey(7), tampered); err == nil {
+		t.Fatal("expected a tampered proof to fail")
+	}
+}
+
+func toProofDB(proof [][]byte) *memorydb.Database {
+	db := memorydb.New()
+	for _, node := range proof {
+		db.Put(HashKey(node), node)
+	}
+	return db
+}
+
+func fromProofDB(db *memorydb.Database) [][]byte {
+	proof := [][]byte{}
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		proof = append(proof, bytes.Clone(it.Value()))
+	}
+	return proof
+}
@@ -0,0 +1,140 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package mpt
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/arcology-network/common-lib/merkle"
+	"github.com/arcology-network/common-lib/types"
+	ethCommon "github.com/ethereum/go-ethereum/common"
+	evmTypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// IndexKey returns the key of the i-th item of a transaction or a receipt trie, rlp(i).
+func IndexKey(i int) []byte { return rlp.AppendUint64(nil, uint64(i)) }
+
+// HashKey returns the key of an account or a storage slot in a secure trie, keccak256(key).
+func HashKey(key []byte) []byte { return merkle.Keccak256{}.Hash(key) }
+
+// ListTrie builds the trie of the encoded items keyed by their indices, like the transactions and the
+// receipts tries are.
+func ListTrie(items [][]byte) *Trie {
+	trie := New()
+	for i, item := range items {
+		trie.Update(IndexKey(i), item)
+	}
+	return trie
+}
+
+// TransactionsTrie builds the trie of the transactions of a block, in their consensus encodings.
+func TransactionsTrie(txs types.StandardTransactions) (*Trie, error) {
+	items := make([][]byte, len(txs))
+	for i, tx := range txs {
+		if tx.NativeTransaction == nil {
+			items[i] = tx.TxRawData
+			continue
+		}
+
+		encoded, err := tx.NativeTransaction.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("mpt: encoding transaction %d: %w", i, err)
+		}
+		items[i] = encoded
+	}
+	return ListTrie(items), nil
+}
+
+// TransactionsRoot returns the transactionsRoot of a block.
+func TransactionsRoot(txs types.StandardTransactions) (ethCommon.Hash, error) {
+	trie, err := TransactionsTrie(txs)
+	if err != nil {
+		return ethCommon.Hash{}, err
+	}
+	return trie.Hash(), nil
+}
+
+// ReceiptsTrie builds the trie of the receipts of a block. types.EncodeReceipt wraps a typed receipt
+// into an RLP string, the trie takes the type and the payload without it.
+func ReceiptsTrie(receipts []*evmTypes.Receipt) (*Trie, error) {
+	items := make([][]byte, len(receipts))
+	for i, receipt := range receipts {
+		encoded, err := types.EncodeReceipt(receipt)
+		if err != nil {
+			return nil, fmt.Errorf("mpt: encoding receipt %d: %w", i, err)
+		}
+
+		if receipt.Type != evmTypes.LegacyTxType {
+			if encoded, _, err = rlp.SplitString(encoded); err != nil {
+				return nil, fmt.Errorf("mpt: encoding receipt %d: %w", i, err)
+			}
+		}
+		items[i] = encoded
+	}
+	return ListTrie(items), nil
+}
+
+// ReceiptsRoot returns the receiptsRoot of a block.
+func ReceiptsRoot(receipts []*evmTypes.Receipt) (ethCommon.Hash, error) {
+	trie, err := ReceiptsTrie(receipts)
+	if err != nil {
+		return ethCommon.Hash{}, err
+	}
+	return trie.Hash(), nil
+}
+
+// StateTrie builds the account trie, keyed by the hashes of the addresses.
+func StateTrie(accounts map[ethCommon.Address]*evmTypes.StateAccount) (*Trie, error) {
+	trie := New()
+	for address, account := range accounts {
+		encoded, err := rlp.EncodeToBytes(account)
+		if err != nil {
+			return nil, fmt.Errorf("mpt: encoding account %x: %w", address, err)
+		}
+		trie.Update(HashKey(address[:]), encoded)
+	}
+	return trie, nil
+}
+
+// StateRoot returns the stateRoot of the accounts, whose storage roots are set already.
+func StateRoot(accounts map[ethCommon.Address]*evmTypes.StateAccount) (ethCommon.Hash, error) {
+	trie, err := StateTrie(accounts)
+	if err != nil {
+		return ethCommon.Hash{}, err
+	}
+	return trie.Hash(), nil
+}
+
+// StorageTrie builds the storage trie of an account, the zero slots are absent.
+func StorageTrie(slots map[ethCommon.Hash]ethCommon.Hash) *Trie {
+	trie := New()
+	for slot, value := range slots {
+		if trimmed := bytes.TrimLeft(value[:], "\x00"); len(trimmed) > 0 {
+			encoded, _ := rlp.EncodeToBytes(trimmed)
+			trie.Update(HashKey(slot[:]), encoded)
+		}
+	}
+	return trie
+}
+
+// StorageRoot returns the storage root of an account.
+func StorageRoot(slots map[ethCommon.Hash]ethCommon.Hash) ethCommon.Hash {
+	return StorageTrie(slots).Hash()
+}
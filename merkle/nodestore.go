@@ -0,0 +1,150 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const NODE_META_KEY = "meta"
+
+var ErrCorruptedNode = errors.New("merkle: corrupted node")
+
+// NodeStore keeps the hashes of the nodes of a tree in a ReadWriteStore under a prefix, one entry
+// for each node keyed by its level and position, and caches the ones read in an LRU of a fixed number
+// of nodes. It is safe for concurrent reads.
+type NodeStore struct {
+	store    stgintf.ReadWriteStore[string, []byte]
+	prefix   string
+	capacity int
+
+	lock   sync.Mutex
+	order  *list.List               // *cachedNode, the most recently used first
+	cached map[string]*list.Element // Node key -> element in the order
+}
+
+type cachedNode struct {
+	key  string
+	hash []byte
+}
+
+func NewNodeStore(store stgintf.ReadWriteStore[string, []byte], prefix string, capacity int) *NodeStore {
+	return &NodeStore{
+		store:    store,
+		prefix:   prefix,
+		capacity: capacity,
+		order:    list.New(),
+		cached:   map[string]*list.Element{},
+	}
+}
+
+// Cached returns the number of the nodes in the cache.
+func (this *NodeStore) Cached() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.order.Len()
+}
+
+// Hash returns the hash of the node at the level and the position, from the cache if there.
+func (this *NodeStore) Hash(level int, position int) ([]byte, error) {
+	key := this.nodeKey(level, position)
+	this.lock.Lock()
+	if element, ok := this.cached[key]; ok {
+		this.order.MoveToFront(element)
+		this.lock.Unlock()
+		return element.Value.(*cachedNode).hash, nil
+	}
+	this.lock.Unlock()
+
+	data, err := this.store.Get(key)
+	if err != nil {
+		return nil, fmt.Errorf("merkle: loading node %d at level %d: %w", position, level, err)
+	}
+
+	hash, ok := data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: node %d at level %d is a %T", ErrCorruptedNode, position, level, data)
+	}
+	hash = bytes.Clone(hash)
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, ok := this.cached[key]; !ok && this.capacity > 0 {
+		this.cached[key] = this.order.PushFront(&cachedNode{key, hash})
+		for this.order.Len() > this.capacity {
+			delete(this.cached, this.order.Remove(this.order.Back()).(*cachedNode).key)
+		}
+	}
+	return hash, nil
+}
+
+// SetLevel writes the hashes of the consecutive nodes of the level from the position on.
+func (this *NodeStore) SetLevel(level int, position int, hashes [][]byte) error {
+	keys := make([]string, len(hashes))
+	for i := range hashes {
+		keys[i] = this.nodeKey(level, position+i)
+	}
+
+	if err := errors.Join(this.store.SetBatch(keys, hashes)...); err != nil {
+		return err
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for _, key := range keys {
+		if element, ok := this.cached[key]; ok {
+			this.order.Remove(element)
+			delete(this.cached, key)
+		}
+	}
+	return nil
+}
+
+// meta returns the branches and the number of the leaves of the tree stored, 0 leaves if none.
+func (this *NodeStore) meta() (uint32, int, error) {
+	data, err := this.store.Get(this.prefix + NODE_META_KEY)
+	if errors.Is(err, stgintf.ErrNotFound) {
+		return 0, 0, nil
+	}
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	meta, ok := data.([]byte)
+	if !ok || len(meta) != 4+8 {
+		return 0, 0, fmt.Errorf("%w: the meta data", ErrCorruptedNode)
+	}
+	return binary.BigEndian.Uint32(meta), int(binary.BigEndian.Uint64(meta[4:])), nil
+}
+
+func (this *NodeStore) setMeta(branch uint32, leaves int) error {
+	meta := binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint32(nil, branch), uint64(leaves))
+	return this.store.Set(this.prefix+NODE_META_KEY, meta)
+}
+
+func (this *NodeStore) nodeKey(level int, position int) string {
+	return this.prefix + string(binary.BigEndian.AppendUint64([]byte{byte(level)}, uint64(position)))
+}
@@ -0,0 +1,200 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"fmt"
+
+	slice "github.com/arcology-network/common-lib/exp/slice"
+)
+
+const WRITE_BATCH_SIZE = 4096
+
+// PersistentMerkle is a tree whose nodes live in a NodeStore, only the nodes on the paths being
+// proven are loaded. It proves the same way Merkle does, so the proofs verify with VerifyProof.
+type PersistentMerkle struct {
+	nodes  *NodeStore
+	branch uint32
+	lens   []int // The nodes of each level, without the padding
+}
+
+// OpenMerkle opens the tree written to the node store by Save or a Builder.
+func OpenMerkle(nodes *NodeStore) (*PersistentMerkle, error) {
+	branch, leaves, err := nodes.meta()
+	if err != nil {
+		return nil, err
+	}
+
+	if leaves == 0 {
+		return nil, fmt.Errorf("%w: no tree in the store", ErrCorruptedNode)
+	}
+
+	if branch < 2 {
+		return nil, fmt.Errorf("%w: %d branches", ErrCorruptedNode, branch)
+	}
+	return &PersistentMerkle{nodes: nodes, branch: branch, lens: levelLens(branch, leaves)}, nil
+}
+
+// Len returns the number of the leaves, without the padding.
+func (this *PersistentMerkle) Len() int { return this.lens[0] }
+
+func (this *PersistentMerkle) Branch() int { return int(this.branch) }
+
+func (this *PersistentMerkle) GetRoot() ([]byte, error) {
+	return this.nodes.Hash(len(this.lens)-1, 0)
+}
+
+// HashAt returns the hash of the node at the level and the position, the leaves are at the level 0.
+func (this *PersistentMerkle) HashAt(level int, position int) ([]byte, error) {
+	if level < 0 || level >= len(this.lens) || position < 0 || position >= this.lens[level] {
+		return nil, fmt.Errorf("%w: node %d at level %d", ErrOutOfRange, position, level)
+	}
+	return this.nodes.Hash(level, position)
+}
+
+// Prove returns the proof of the leaf at the index.
+func (this *PersistentMerkle) Prove(index int) (*Proof, error) { return this.ProveBatch([]int{index}) }
+
+// ProveBatch returns a multiproof of the leaves at the indices, loading only the nodes it needs.
+func (this *PersistentMerkle) ProveBatch(indices []int) (*Proof, error) {
+	return proveBatch(this.branch, this.lens, indices, this.nodes.Hash)
+}
+
+// Save writes the nodes of the tree to the node store, which can then be opened with OpenMerkle.
+func (this *Merkle) Save(nodes *NodeStore) error {
+	if this.Len() == 0 {
+		return fmt.Errorf("merkle: saving an empty tree")
+	}
+
+	for level := range this.nodes {
+		size := this.realLen(level)
+		for start := 0; start < size; start += WRITE_BATCH_SIZE {
+			end := min(start+WRITE_BATCH_SIZE, size)
+			hashes := make([][]byte, end-start)
+			for i := range hashes {
+				hashes[i] = this.nodes[level][start+i].hash
+			}
+
+			if err := nodes.SetLevel(level, start, hashes); err != nil {
+				return err
+			}
+		}
+	}
+	return nodes.setMeta(this.branch, this.Len())
+}
+
+// Builder writes a tree to a node store as the leaves are appended, keeping only the right edge of
+// the tree in memory, for the trees too large to build with Merkle. The tree is the same Merkle.Init
+// builds from the leaves.
+type Builder struct {
+	nodes   *NodeStore
+	branch  int
+	hasher  interface{ Hash([]byte) []byte }
+	edge    [][][]byte // The nodes of each level waiting for their siblings
+	lens    []int      // The nodes of each level written or waiting
+	pending [][][]byte // The nodes of each level to write, from the positions in from
+	from    []int
+}
+
+func NewBuilder(nodes *NodeStore, numBranches int, hasher interface{ Hash([]byte) []byte }) *Builder {
+	return &Builder{nodes: nodes, branch: numBranches, hasher: hasher}
+}
+
+// Append adds the leaves to the tree, the nodes are written in batches as their levels fill up.
+func (this *Builder) Append(data ...[]byte) error {
+	for _, leaf := range data {
+		if err := this.push(0, this.hasher.Hash(leaf)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish pads the right edge, writes the rest of the nodes and opens the tree written.
+func (this *Builder) Finish() (*PersistentMerkle, error) {
+	if len(this.lens) == 0 {
+		return nil, fmt.Errorf("merkle: building an empty tree")
+	}
+
+	for level := 0; this.lens[level] > 1; level++ {
+		if edge := this.edge[level]; len(edge) > 0 {
+			for len(edge) < this.branch {
+				edge = append(edge, edge[len(edge)-1])
+			}
+			this.edge[level] = nil
+			if err := this.push(level+1, this.hasher.Hash(slice.Flatten(edge))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for level := range this.pending {
+		if err := this.flush(level); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := this.nodes.setMeta(uint32(this.branch), this.lens[0]); err != nil {
+		return nil, err
+	}
+	return OpenMerkle(this.nodes)
+}
+
+// push adds a node to the level, hashing a parent once the node completes its siblings.
+func (this *Builder) push(level int, hash []byte) error {
+	if level == len(this.lens) {
+		this.edge, this.lens = append(this.edge, nil), append(this.lens, 0)
+		this.pending, this.from = append(this.pending, nil), append(this.from, 0)
+	}
+
+	this.lens[level]++
+	this.pending[level] = append(this.pending[level], hash)
+	if len(this.pending[level]) >= WRITE_BATCH_SIZE {
+		if err := this.flush(level); err != nil {
+			return err
+		}
+	}
+
+	this.edge[level] = append(this.edge[level], hash)
+	if len(this.edge[level]) < this.branch {
+		return nil
+	}
+
+	parent := this.hasher.Hash(slice.Flatten(this.edge[level]))
+	this.edge[level] = this.edge[level][:0]
+	return this.push(level+1, parent)
+}
+
+func (this *Builder) flush(level int) error {
+	if err := this.nodes.SetLevel(level, this.from[level], this.pending[level]); err != nil {
+		return err
+	}
+	this.from[level] += len(this.pending[level])
+	this.pending[level] = this.pending[level][:0]
+	return nil
+}
+
+// levelLens returns the nodes of each level of a tree of the leaves, without the padding.
+func levelLens(branch uint32, leaves int) []int {
+	lens := []int{leaves}
+	for last := leaves; last > 1; {
+		last = (last-1)/int(branch) + 1
+		lens = append(lens, last)
+	}
+	return lens
+}
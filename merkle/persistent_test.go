@@ -0,0 +1,117 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func TestPersistentMerkle(t *testing.T) {
+	for _, branch := range []int{2, 3, 16} {
+		for _, size := range []int{1, 2, 5, 16, 17, 100, 1000} {
+			data := make([][]byte, size)
+			for i := range data {
+				data[i] = []byte(fmt.Sprint("leaf-", i))
+			}
+
+			tree := NewMerkle(branch, Concatenator{}, Keccak256{})
+			tree.Init(data, nil)
+
+			builder := NewBuilder(NewNodeStore(memdb.NewMemoryDB(), "built/", 64), branch, Keccak256{})
+			for i := 0; i < size; i += 7 {
+				if err := builder.Append(data[i:min(i+7, size)]...); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			built, err := builder.Finish()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			saved := NewNodeStore(memdb.NewMemoryDB(), "saved/", 64)
+			if err := tree.Save(saved); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := OpenMerkle(saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, persistent := range []*PersistentMerkle{built, loaded} {
+				if root, err := persistent.GetRoot(); err != nil || !bytes.Equal(root, tree.GetRoot()) || persistent.Len() != size {
+					t.Fatalf("%d leaves of %d branches: wrong root, %v", size, branch, err)
+				}
+
+				indices := []int{0, size / 2, size - 1}
+				expected, _ := tree.ProveBatch(indices)
+				proof, err := persistent.ProveBatch(indices)
+				if err != nil || !bytes.Equal(proof.Encode(), expected.Encode()) {
+					t.Fatalf("%d leaves of %d branches: wrong proof, %v", size, branch, err)
+				}
+
+				leaves := [][]byte{data[proof.Indices[0]]}
+				for _, index := range proof.Indices[1:] {
+					leaves = append(leaves, data[index])
+				}
+
+				if !VerifyProof(tree.GetRoot(), uint64(size), proof, leaves, Keccak256{}, Concatenator{}) {
+					t.Fatalf("%d leaves of %d branches: failed to verify", size, branch)
+				}
+			}
+		}
+	}
+}
+
+func TestNodeStoreLoadsOnDemand(t *testing.T) {
+	nodes := NewNodeStore(memdb.NewMemoryDB(), "tree/", 8)
+	builder := NewBuilder(nodes, 4, Sha256{})
+	for i := 0; i < 5000; i++ {
+		builder.Append([]byte(fmt.Sprint(i)))
+	}
+
+	tree, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the siblings on the path are loaded, 3 for each of the levels but the root.
+	if _, err := tree.Prove(1234); err != nil || nodes.Cached() != 8 {
+		t.Fatalf("expected a full cache, got %d nodes, %v", nodes.Cached(), err)
+	}
+
+	if _, err := tree.HashAt(0, 5000); err == nil {
+		t.Fatal("expected an out of range node")
+	}
+
+	if _, err := OpenMerkle(NewNodeStore(memdb.NewMemoryDB(), "tree/", 8)); err == nil {
+		t.Fatal("expected no tree in an empty store")
+	}
+
+	opened, _ := OpenMerkle(nodes)
+	proof, _ := opened.Prove(4999)
+	root, _ := opened.GetRoot()
+	if !VerifyProof(root, 5000, proof, [][]byte{[]byte("4999")}, Sha256{}, Concatenator{}) {
+		t.Fatal("failed to verify the last leaf")
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const PROOF_VERSION = 1
+
+var (
+	ErrInvalidProof = errors.New("merkle: invalid proof")
+	ErrProofVersion = errors.New("merkle: unsupported proof version")
+)
+
+// Proof proves a set of leaves by their indices. It carries only the hashes the verifier can't
+// derive from the proven leaves, each one once even if shared by several of the leaves, level by
+// level from the leaves up and in the order of the positions within a level. The padding repeats
+// the last node of a level, so it never adds a hash of its own.
+//
+// The binary format, all the integers in big endian, is
+//
+//	[version u8][branch u32][leaves u64][indices u32][index u64]...[hashes u32][hash size u16][hash]...
+type Proof struct {
+	Branch  uint32   // The children of a node
+	Leaves  uint64   // The number of the leaves in the tree, without the padding, checked against the trusted one by VerifyProof
+	Indices []uint64 // The proven leaves in the ascending order
+	Hashes  [][]byte
+}
+
+// GetProofNodesAt returns the nodes on the path from the leaf at the index to the root, like
+// GetProofNodes does for a leaf found by its data.
+func (this *Merkle) GetProofNodesAt(index int) ([]*Node, error) {
+	if index < 0 || index >= this.Len() {
+		return nil, fmt.Errorf("%w: %d of %d", ErrOutOfRange, index, this.Len())
+	}
+
+	path := []*Node{this.nodes[0][index]}
+	for {
+		last := path[len(path)-1]
+		if int(last.level)+1 >= len(this.nodes) {
+			return path, nil
+		}
+		path = append(path, this.nodes[last.level+1][last.parent])
+	}
+}
+
+// Prove returns the proof of the leaf at the index.
+func (this *Merkle) Prove(index int) (*Proof, error) { return this.ProveBatch([]int{index}) }
+
+// ProveBatch returns a multiproof of the leaves at the indices, the duplicate indices are proven once.
+func (this *Merkle) ProveBatch(indices []int) (*Proof, error) {
+	lens := make([]int, len(this.nodes))
+	for level := range lens {
+		lens[level] = this.realLen(level)
+	}
+	return proveBatch(this.branch, lens, indices, func(level, position int) ([]byte, error) {
+		return this.nodes[level][position].hash, nil
+	})
+}
+
+// proveBatch collects the hashes of a multiproof from a tree of the level lengths, without the padding,
+// through hashAt, so the trees held in memory and the ones loaded on demand prove the same way.
+func proveBatch(branch uint32, lens []int, indices []int, hashAt func(level, position int) ([]byte, error)) (*Proof, error) {
+	size := 0
+	if len(lens) > 0 {
+		size = lens[0]
+	}
+
+	if len(indices) == 0 {
+		return nil, fmt.Errorf("%w: no leaves to prove", ErrInvalidProof)
+	}
+
+	known := map[int]struct{}{}
+	for _, index := range indices {
+		if index < 0 || index >= size {
+			return nil, fmt.Errorf("%w: %d of %d", ErrOutOfRange, index, size)
+		}
+		known[index] = struct{}{}
+	}
+
+	proof := &Proof{Branch: branch, Leaves: uint64(size)}
+	for _, index := range sortedKeys(known) {
+		proof.Indices = append(proof.Indices, uint64(index))
+	}
+
+	width := int(branch)
+	for level := 0; level < len(lens)-1; level++ {
+		last := lens[level] - 1
+		parents := map[int]struct{}{}
+		for _, position := range sortedKeys(known) {
+			if _, ok := parents[position/width]; ok {
+				continue
+			}
+			parents[position/width] = struct{}{}
+
+			for child := position / width * width; child < (position/width+1)*width; child++ {
+				if _, ok := known[min(child, last)]; !ok {
+					hash, err := hashAt(level, min(child, last))
+					if err != nil {
+						return nil, err
+					}
+					known[min(child, last)] = struct{}{}
+					proof.Hashes = append(proof.Hashes, hash)
+				}
+			}
+		}
+		known = parents
+	}
+	return proof, nil
+}
+
+// VerifyProof checks the proof against the root without the tree, the leaves are the data of the proven
+// leaves in the order of the indices. The parents are hashed from the encoded children like in Verify.
+//
+// The size is the number of the leaves of the tree, which must come from a trusted source along with
+// the root, the proof is rejected if it claims another one. The root doesn't commit to the size, since
+// the padding repeats the last node, so a tree of 3 leaves has the root of the same tree padded to 4,
+// and a proof trusting its own size could pass the leaf at 2 as the one at 3.
+func VerifyProof(root []byte, size uint64, proof *Proof, leaves [][]byte, hasher interface{ Hash([]byte) []byte }, encoder interface{ Encode([][]byte) []byte }) bool {
+	if proof == nil || proof.Branch < 2 || proof.Leaves == 0 || proof.Leaves != size || len(proof.Indices) == 0 || len(leaves) != len(proof.Indices) {
+		return false
+	}
+
+	known := map[uint64][]byte{}
+	for i, index := range proof.Indices {
+		if index >= proof.Leaves || (i > 0 && index <= proof.Indices[i-1]) {
+			return false
+		}
+		known[index] = hasher.Hash(leaves[i])
+	}
+
+	branch, next := uint64(proof.Branch), 0
+	for size := proof.Leaves; size > 1; size = (size + branch - 1) / branch {
+		parents := map[uint64][]byte{}
+		for _, position := range sortedKeys(known) {
+			parent := position / branch
+			if _, ok := parents[parent]; ok {
+				continue
+			}
+
+			children := make([][]byte, branch)
+			for i := range children {
+				child := min(parent*branch+uint64(i), size-1)
+				hash, ok := known[child]
+				if !ok {
+					if next >= len(proof.Hashes) {
+						return false
+					}
+					hash, next = proof.Hashes[next], next+1
+					known[child] = hash
+				}
+				children[i] = hash
+			}
+			parents[parent] = hasher.Hash(encoder.Encode(children))
+		}
+		known = parents
+	}
+	return next == len(proof.Hashes) && bytes.Equal(known[0], root)
+}
+
+func (this *Proof) Encode() []byte {
+	hashSize := 0
+	if len(this.Hashes) > 0 {
+		hashSize = len(this.Hashes[0])
+	}
+
+	buffer := make([]byte, 0, 1+4+8+4+8*len(this.Indices)+4+2+hashSize*len(this.Hashes))
+	buffer = append(buffer, PROOF_VERSION)
+	buffer = binary.BigEndian.AppendUint32(buffer, this.Branch)
+	buffer = binary.BigEndian.AppendUint64(buffer, this.Leaves)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(this.Indices)))
+	for _, index := range this.Indices {
+		buffer = binary.BigEndian.AppendUint64(buffer, index)
+	}
+
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(this.Hashes)))
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(hashSize))
+	for _, hash := range this.Hashes {
+		buffer = append(buffer, hash...)
+	}
+	return buffer
+}
+
+// DecodeProof decodes a proof from the binary format, checking the sizes against the data so a
+// malformed proof is rejected rather than allocated.
+func DecodeProof(data []byte) (*Proof, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidProof)
+	}
+
+	if data[0] != PROOF_VERSION {
+		return nil, fmt.Errorf("%w: %d", ErrProofVersion, data[0])
+	}
+
+	reader := proofReader{data: data[1:]}
+	proof := &Proof{Branch: reader.uint32(), Leaves: reader.uint64()}
+	count := reader.uint32()
+	if reader.err == nil && uint64(count)*8 > uint64(len(reader.data)) {
+		return nil, fmt.Errorf("%w: %d indices in %d bytes", ErrInvalidProof, count, len(reader.data))
+	}
+
+	proof.Indices = make([]uint64, count)
+	for i := range proof.Indices {
+		proof.Indices[i] = reader.uint64()
+	}
+
+	count, hashSize := reader.uint32(), reader.uint16()
+	if reader.err == nil && uint64(count)*uint64(hashSize) != uint64(len(reader.data)) {
+		return nil, fmt.Errorf("%w: %d hashes of %d bytes in %d bytes", ErrInvalidProof, count, hashSize, len(reader.data))
+	}
+
+	proof.Hashes = make([][]byte, count)
+	for i := range proof.Hashes {
+		proof.Hashes[i] = reader.bytes(int(hashSize))
+	}
+
+	if reader.err != nil {
+		return nil, reader.err
+	}
+	return proof, nil
+}
+
+type proofReader struct {
+	data []byte
+	err  error
+}
+
+func (this *proofReader) bytes(n int) []byte {
+	if this.err != nil || len(this.data) < n {
+		this.err = fmt.Errorf("%w: truncated", ErrInvalidProof)
+		return make([]byte, n)
+	}
+
+	b := bytes.Clone(this.data[:n])
+	this.data = this.data[n:]
+	return b
+}
+
+func (this *proofReader) uint16() uint16 { return binary.BigEndian.Uint16(this.bytes(2)) }
+func (this *proofReader) uint32() uint32 { return binary.BigEndian.Uint32(this.bytes(4)) }
+func (this *proofReader) uint64() uint64 { return binary.BigEndian.Uint64(this.bytes(8)) }
+
+func sortedKeys[K int | uint64, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
@@ -0,0 +1,152 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestMerkleProofByIndex(t *testing.T) {
+	for _, branch := range []int{2, 3, 4, 16} {
+		for _, size := range []int{1, 2, 5, 17, 100} {
+			data := make([][]byte, size)
+			for i := range data {
+				data[i] = []byte(fmt.Sprint(i % 7)) // Duplicate leaves, which GetProofNodes can't tell apart.
+			}
+
+			merkle := NewMerkle(branch, Concatenator{}, Keccak256{})
+			merkle.Init(data, nil)
+			for i := range data {
+				proof, err := merkle.Prove(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded, err := DecodeProof(proof.Encode())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !VerifyProof(merkle.GetRoot(), uint64(size), decoded, data[i:i+1], Keccak256{}, Concatenator{}) {
+					t.Fatalf("leaf %d of %d in %d branches failed to verify", i, size, branch)
+				}
+
+				if VerifyProof(merkle.GetRoot(), uint64(size), decoded, [][]byte{[]byte("forged")}, Keccak256{}, Concatenator{}) {
+					t.Fatalf("expected a forged leaf %d to fail", i)
+				}
+
+				nodes, _ := merkle.GetProofNodesAt(i)
+				_, hashes := merkle.NodesToHashes(nodes)
+				if !merkle.Verify(hashes, merkle.GetRoot(), Keccak256{}.Hash(data[i])) {
+					t.Fatalf("expected the path of leaf %d to verify", i)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleMultiproof(t *testing.T) {
+	data := make([][]byte, 1000)
+	for i := range data {
+		data[i] = []byte(fmt.Sprint(i))
+	}
+
+	merkle := NewMerkle(4, Concatenator{}, Sha256{})
+	merkle.Init(data, nil)
+	root := merkle.GetRoot()
+
+	random := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		indices := []int{999}
+		for i := 0; i < 1+random.Intn(30); i++ {
+			indices = append(indices, random.Intn(len(data)))
+		}
+
+		multiproof, err := merkle.ProveBatch(indices)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		leaves, separate := [][]byte{}, 0
+		for _, index := range multiproof.Indices {
+			leaves = append(leaves, data[index])
+			single, _ := merkle.Prove(int(index))
+			separate += len(single.Hashes)
+		}
+
+		if len(multiproof.Hashes) > separate {
+			t.Fatalf("expected the multiproof to share the hashes, %d vs %d", len(multiproof.Hashes), separate)
+		}
+
+		decoded, err := DecodeProof(multiproof.Encode())
+		if err != nil || !VerifyProof(root, uint64(len(data)), decoded, leaves, Sha256{}, Concatenator{}) {
+			t.Fatalf("expected the multiproof to verify, %v", err)
+		}
+
+		leaves[0] = []byte("forged")
+		if VerifyProof(root, uint64(len(data)), decoded, leaves, Sha256{}, Concatenator{}) {
+			t.Fatal("expected a forged leaf to fail")
+		}
+	}
+
+	proof, _ := merkle.ProveBatch([]int{1, 2, 3})
+	encoded := proof.Encode()
+	if _, err := DecodeProof(encoded[:len(encoded)-1]); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+
+	encoded[0] = 9
+	if _, err := DecodeProof(encoded); !errors.Is(err, ErrProofVersion) {
+		t.Fatalf("expected ErrProofVersion, got %v", err)
+	}
+
+	if _, err := merkle.Prove(1000); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestMerkleProofTrustedSize(t *testing.T) {
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	merkle := NewMerkle(2, Concatenator{}, Sha256{})
+	merkle.Init(data, nil)
+	root := merkle.GetRoot()
+
+	proof, _ := merkle.Prove(2)
+	if !VerifyProof(root, 3, proof, data[2:], Sha256{}, Concatenator{}) {
+		t.Fatal("expected the leaf at 2 to verify")
+	}
+
+	// The padded tree of 4 leaves has the same root, so the leaf at 2 passes as the one at 3 with
+	// the size taken from the proof.
+	forged := &Proof{Branch: proof.Branch, Leaves: 4, Indices: []uint64{3}, Hashes: append([][]byte{Sha256{}.Hash(data[2])}, proof.Hashes...)}
+	if !VerifyProof(root, 4, forged, data[2:], Sha256{}, Concatenator{}) {
+		t.Fatal("expected the padded tree to have the same root")
+	}
+
+	if VerifyProof(root, 3, forged, data[2:], Sha256{}, Concatenator{}) {
+		t.Fatal("expected the proof of another size to fail")
+	}
+
+	forged.Leaves = 3
+	if VerifyProof(root, 3, forged, data[2:], Sha256{}, Concatenator{}) {
+		t.Fatal("expected the index out of the trusted size to fail")
+	}
+}
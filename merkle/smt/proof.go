@@ -0,0 +1,225 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const PROOF_VERSION = 1
+
+var (
+	ErrInvalidProof = errors.New("smt: invalid proof")
+	ErrProofVersion = errors.New("smt: unsupported proof version")
+)
+
+// Proof is the path from the root to where a key is, or would be. The empty siblings are left out
+// and marked in the bitmap. The path ends at the leaf of the key for an inclusion proof, and at an
+// empty subtree or the leaf of another key sharing the path for an exclusion proof.
+//
+// The binary format, all the integers in big endian, is
+//
+//	[version u8][depth u16][bitmap (depth+7)/8][hash size u16][sibling]...[has leaf u8]([key 32][value hash])
+type Proof struct {
+	Depth     uint16
+	Bitmap    []byte   // Bit i, from the most significant, is set if the sibling at the depth i isn't empty.
+	Siblings  [][]byte // The non empty siblings from the root down
+	Leaf      *Key     // The key of the leaf the path ends at, nil for an empty subtree
+	ValueHash []byte   // The hash of the value of the leaf
+}
+
+// Prove returns the proof of the key, an inclusion proof if the key is present, an exclusion one if not.
+func (this *Tree) Prove(key Key) (*Proof, error) {
+	proof := &Proof{}
+	siblings := [][]byte{}
+	hash := this.root
+	for depth := 0; !bytes.Equal(hash, this.empty); depth++ {
+		current, err := this.load(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if current.kind == NODE_LEAF {
+			leaf := current.key
+			proof.Leaf, proof.ValueHash = &leaf, this.hasher.Hash(current.value)
+			break
+		}
+
+		hash = current.child(key.Bit(depth))
+		siblings = append(siblings, current.child(1-key.Bit(depth)))
+	}
+
+	proof.Depth = uint16(len(siblings))
+	proof.Bitmap = make([]byte, (len(siblings)+7)/8)
+	for i, sibling := range siblings {
+		if !bytes.Equal(sibling, this.empty) {
+			proof.Bitmap[i/8] |= 0x80 >> (i % 8)
+			proof.Siblings = append(proof.Siblings, sibling)
+		}
+	}
+	return proof, nil
+}
+
+// VerifyInclusion checks the proof shows the key has the value under the root.
+func VerifyInclusion(root []byte, key Key, value []byte, proof *Proof, hasher interface{ Hash([]byte) []byte }) bool {
+	if proof == nil || proof.Leaf == nil || *proof.Leaf != key || !bytes.Equal(proof.ValueHash, hasher.Hash(value)) {
+		return false
+	}
+	return proof.verify(root, key, leafHash(hasher, key, proof.ValueHash), hasher)
+}
+
+// VerifyExclusion checks the proof shows the key is absent under the root.
+func VerifyExclusion(root []byte, key Key, proof *Proof, hasher interface{ Hash([]byte) []byte }) bool {
+	if proof == nil {
+		return false
+	}
+
+	if proof.Leaf == nil {
+		return proof.verify(root, key, make([]byte, len(root)), hasher)
+	}
+
+	// Another leaf is where the key would be, only if it shares the path of the key.
+	if *proof.Leaf == key {
+		return false
+	}
+
+	for depth := 0; depth < int(proof.Depth); depth++ {
+		if proof.Leaf.Bit(depth) != key.Bit(depth) {
+			return false
+		}
+	}
+	return proof.verify(root, key, leafHash(hasher, *proof.Leaf, proof.ValueHash), hasher)
+}
+
+// verify hashes the path up from the end of it. The bits of the bitmap past the depth have to be
+// clear, and every sibling used, so a proof has only one encoding.
+func (this *Proof) verify(root []byte, key Key, hash []byte, hasher interface{ Hash([]byte) []byte }) bool {
+	if int(this.Depth) > KEY_LEN*8 || len(this.Bitmap) != (int(this.Depth)+7)/8 || padded(this.Bitmap, this.Depth) ||
+		popCount(this.Bitmap) != len(this.Siblings) {
+		return false
+	}
+
+	empty := make([]byte, len(root))
+	next := len(this.Siblings) - 1
+	for depth := int(this.Depth) - 1; depth >= 0; depth-- {
+		sibling := empty
+		if this.Bitmap[depth/8]&(0x80>>(depth%8)) != 0 {
+			sibling, next = this.Siblings[next], next-1
+		}
+
+		if key.Bit(depth) == 0 {
+			hash = internalHash(hasher, hash, sibling)
+		} else {
+			hash = internalHash(hasher, sibling, hash)
+		}
+	}
+	return next == -1 && bytes.Equal(hash, root)
+}
+
+func (this *Proof) Encode() []byte {
+	hashSize := len(this.ValueHash)
+	if len(this.Siblings) > 0 {
+		hashSize = len(this.Siblings[0])
+	}
+
+	buffer := []byte{PROOF_VERSION}
+	buffer = binary.BigEndian.AppendUint16(buffer, this.Depth)
+	buffer = append(buffer, this.Bitmap...)
+	buffer = binary.BigEndian.AppendUint16(buffer, uint16(hashSize))
+	for _, sibling := range this.Siblings {
+		buffer = append(buffer, sibling...)
+	}
+
+	if this.Leaf == nil {
+		return append(buffer, 0)
+	}
+	buffer = append(append(buffer, 1), this.Leaf[:]...)
+	return append(buffer, this.ValueHash...)
+}
+
+// DecodeProof decodes a proof from the binary format, the sizes are checked against the data.
+func DecodeProof(data []byte) (*Proof, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidProof)
+	}
+
+	if data[0] != PROOF_VERSION {
+		return nil, fmt.Errorf("%w: %d", ErrProofVersion, data[0])
+	}
+
+	truncated := fmt.Errorf("%w: truncated", ErrInvalidProof)
+	data = data[1:]
+	if len(data) < 2 {
+		return nil, truncated
+	}
+
+	proof := &Proof{Depth: binary.BigEndian.Uint16(data)}
+	if proof.Depth > KEY_LEN*8 {
+		return nil, fmt.Errorf("%w: depth %d", ErrInvalidProof, proof.Depth)
+	}
+
+	data = data[2:]
+	size := (int(proof.Depth) + 7) / 8
+	if len(data) < size+2 {
+		return nil, truncated
+	}
+	proof.Bitmap, data = bytes.Clone(data[:size]), data[size:]
+	if padded(proof.Bitmap, proof.Depth) {
+		return nil, fmt.Errorf("%w: bits set past the depth %d", ErrInvalidProof, proof.Depth)
+	}
+
+	hashSize := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	for i := popCount(proof.Bitmap); i > 0; i-- {
+		if len(data) < hashSize {
+			return nil, truncated
+		}
+		proof.Siblings, data = append(proof.Siblings, bytes.Clone(data[:hashSize])), data[hashSize:]
+	}
+
+	switch {
+	case len(data) == 1 && data[0] == 0:
+		return proof, nil
+	case len(data) == 1+KEY_LEN+hashSize && data[0] == 1:
+		var leaf Key
+		copy(leaf[:], data[1:])
+		proof.Leaf, proof.ValueHash = &leaf, bytes.Clone(data[1+KEY_LEN:])
+		return proof, nil
+	}
+	return nil, fmt.Errorf("%w: %d bytes left for the leaf", ErrInvalidProof, len(data))
+}
+
+// padded tells if any bit of the bitmap past the depth is set.
+func padded(bitmap []byte, depth uint16) bool {
+	if depth%8 == 0 || len(bitmap) == 0 {
+		return false
+	}
+	return bitmap[len(bitmap)-1]&(0xff>>(depth%8)) != 0
+}
+
+func popCount(bitmap []byte) int {
+	count := 0
+	for _, b := range bitmap {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
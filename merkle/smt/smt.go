@@ -0,0 +1,339 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package smt implements a sparse Merkle tree over 256 bit keys, which proves both the presence and
+// the absence of a key.
+//
+// A key is a path of 256 bits from the root, 0 to the left. An empty subtree hashes to all zeros
+// at any height, and a subtree with a single leaf is the leaf itself, so a path only goes as deep
+// as needed to tell its key apart from the others:
+//
+//	empty     0x00 * 32
+//	leaf      H(0x00 | key | H(value))
+//	internal  H(0x01 | left | right)
+//
+// The nodes are content addressed in a ReadWriteStore and never overwritten, so every root written
+// remains readable.
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/arcology-network/common-lib/merkle"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	KEY_LEN = 32
+
+	NODE_LEAF     = 0
+	NODE_INTERNAL = 1
+)
+
+var ErrCorruptedNode = errors.New("smt: corrupted node")
+
+// Key is the path of a value in the tree, usually the hash of an address or a storage key.
+type Key [KEY_LEN]byte
+
+// KeyOf hashes the data into a key.
+func KeyOf(hasher interface{ Hash([]byte) []byte }, data []byte) Key {
+	var key Key
+	copy(key[:], hasher.Hash(data))
+	return key
+}
+
+// Bit returns the bit of the key at the depth.
+func (this Key) Bit(depth int) byte { return this[depth/8] >> (7 - depth%8) & 1 }
+
+type node struct {
+	kind  byte
+	key   Key    // Leaf
+	value []byte // Leaf
+	left  []byte // Internal
+	right []byte // Internal
+}
+
+type entry struct {
+	key   Key
+	value []byte // Deleting the key if empty
+}
+
+// Tree is a sparse Merkle tree stored in a ReadWriteStore under a prefix. A Tree is a view of one root,
+// it isn't safe for concurrent updates.
+type Tree struct {
+	store  stgintf.ReadWriteStore[string, []byte]
+	prefix string
+	hasher interface{ Hash([]byte) []byte }
+	empty  []byte
+	root   []byte
+}
+
+// NewTree opens the tree at the root, an empty one if the root is nil. The hasher is merkle.Keccak256
+// if nil.
+func NewTree(store stgintf.ReadWriteStore[string, []byte], prefix string, hasher interface{ Hash([]byte) []byte }, root []byte) *Tree {
+	if hasher == nil {
+		hasher = merkle.Keccak256{}
+	}
+
+	tree := &Tree{
+		store:  store,
+		prefix: prefix,
+		hasher: hasher,
+		empty:  make([]byte, len(hasher.Hash([]byte{0}))),
+	}
+
+	tree.root = tree.empty
+	if len(root) > 0 {
+		tree.root = bytes.Clone(root)
+	}
+	return tree
+}
+
+// Root returns the root hash, all zeros for an empty tree.
+func (this *Tree) Root() []byte { return this.root }
+
+// At returns a view of the same tree at another root, for example one of an earlier block.
+func (this *Tree) At(root []byte) *Tree {
+	return NewTree(this.store, this.prefix, this.hasher, root)
+}
+
+// Get returns the value of the key, stgintf.ErrNotFound if absent.
+func (this *Tree) Get(key Key) ([]byte, error) {
+	hash := this.root
+	for depth := 0; ; depth++ {
+		if bytes.Equal(hash, this.empty) {
+			return nil, stgintf.ErrNotFound
+		}
+
+		current, err := this.load(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		if current.kind == NODE_LEAF {
+			if current.key != key {
+				return nil, stgintf.ErrNotFound
+			}
+			return current.value, nil
+		}
+		hash = current.child(key.Bit(depth))
+	}
+}
+
+// Update sets the value of the key, deleting the key if the value is empty.
+func (this *Tree) Update(key Key, value []byte) error {
+	return this.UpdateBatch([]Key{key}, [][]byte{value})
+}
+
+func (this *Tree) Delete(key Key) error { return this.Update(key, nil) }
+
+// UpdateBatch sets the values of the keys, the later ones winning for the same key. The keys sharing a
+// path are updated together, so every node on the paths is hashed and written once. Nothing is
+// changed if the nodes fail to write.
+func (this *Tree) UpdateBatch(keys []Key, values [][]byte) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("smt: %d keys for %d values", len(keys), len(values))
+	}
+
+	latest := map[Key][]byte{}
+	for i, key := range keys {
+		latest[key] = values[i]
+	}
+
+	entries := make([]entry, 0, len(latest))
+	for key, value := range latest {
+		entries = append(entries, entry{key, value})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key[:], entries[j].key[:]) < 0 })
+
+	writes := map[string][]byte{}
+	root, _, err := this.update(this.root, 0, entries, writes)
+	if err != nil {
+		return err
+	}
+
+	storeKeys, encoded := make([]string, 0, len(writes)), make([][]byte, 0, len(writes))
+	for hash, data := range writes {
+		storeKeys, encoded = append(storeKeys, this.prefix+hash), append(encoded, data)
+	}
+
+	if err := errors.Join(this.store.SetBatch(storeKeys, encoded)...); err != nil {
+		return err
+	}
+	this.root = root
+	return nil
+}
+
+// update applies the sorted entries to the subtree at the depth, and returns the new hash of the
+// subtree and if it is a leaf.
+func (this *Tree) update(hash []byte, depth int, entries []entry, writes map[string][]byte) ([]byte, bool, error) {
+	if len(entries) == 0 {
+		return hash, false, nil // Untouched, the caller checks if it is a leaf only when needed.
+	}
+
+	if bytes.Equal(hash, this.empty) {
+		return this.build(depth, entries, writes)
+	}
+
+	current, err := this.load(hash)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if current.kind == NODE_LEAF { // Pushed down among the entries, unless one of them replaces it.
+		i := sort.Search(len(entries), func(i int) bool { return bytes.Compare(entries[i].key[:], current.key[:]) >= 0 })
+		if i == len(entries) || entries[i].key != current.key {
+			entries = append(entries[:i:i], append([]entry{{current.key, current.value}}, entries[i:]...)...)
+		}
+		return this.build(depth, entries, writes)
+	}
+
+	split := sort.Search(len(entries), func(i int) bool { return entries[i].key.Bit(depth) == 1 })
+	left, leftLeaf, err := this.update(current.left, depth+1, entries[:split], writes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	right, rightLeaf, err := this.update(current.right, depth+1, entries[split:], writes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The untouched side may have to collapse up if the other side is emptied.
+	if split == 0 && bytes.Equal(right, this.empty) {
+		leftLeaf, err = this.isLeaf(left)
+	} else if split == len(entries) && bytes.Equal(left, this.empty) {
+		rightLeaf, err = this.isLeaf(right)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+	return this.join(left, leftLeaf, right, rightLeaf, writes)
+}
+
+// build builds the subtree of the entries from scratch, dropping the deleted ones.
+func (this *Tree) build(depth int, entries []entry, writes map[string][]byte) ([]byte, bool, error) {
+	live := entries[:0:0]
+	for _, entry := range entries {
+		if len(entry.value) > 0 {
+			live = append(live, entry)
+		}
+	}
+
+	switch len(live) {
+	case 0:
+		return this.empty, false, nil
+	case 1:
+		leaf := &node{kind: NODE_LEAF, key: live[0].key, value: live[0].value}
+		hash := this.hash(leaf)
+		writes[string(hash)] = leaf.encode()
+		return hash, true, nil
+	}
+
+	split := sort.Search(len(live), func(i int) bool { return live[i].key.Bit(depth) == 1 })
+	left, leftLeaf, _ := this.build(depth+1, live[:split], writes)
+	right, rightLeaf, _ := this.build(depth+1, live[split:], writes)
+	return this.join(left, leftLeaf, right, rightLeaf, writes)
+}
+
+// join makes the parent of the children, collapsing a single leaf or nothing into itself.
+func (this *Tree) join(left []byte, leftLeaf bool, right []byte, rightLeaf bool, writes map[string][]byte) ([]byte, bool, error) {
+	leftEmpty, rightEmpty := bytes.Equal(left, this.empty), bytes.Equal(right, this.empty)
+	switch {
+	case leftEmpty && rightEmpty:
+		return this.empty, false, nil
+	case leftEmpty && rightLeaf:
+		return right, true, nil
+	case rightEmpty && leftLeaf:
+		return left, true, nil
+	}
+
+	internal := &node{kind: NODE_INTERNAL, left: left, right: right}
+	hash := this.hash(internal)
+	writes[string(hash)] = internal.encode()
+	return hash, false, nil
+}
+
+func (this *Tree) hash(n *node) []byte {
+	if n.kind == NODE_LEAF {
+		return leafHash(this.hasher, n.key, this.hasher.Hash(n.value))
+	}
+	return internalHash(this.hasher, n.left, n.right)
+}
+
+func (this *Tree) isLeaf(hash []byte) (bool, error) {
+	if bytes.Equal(hash, this.empty) {
+		return false, nil
+	}
+
+	current, err := this.load(hash)
+	if err != nil {
+		return false, err
+	}
+	return current.kind == NODE_LEAF, nil
+}
+
+func (this *Tree) load(hash []byte) (*node, error) {
+	data, err := this.store.Get(this.prefix + string(hash))
+	if err != nil {
+		return nil, fmt.Errorf("smt: loading node %x: %w", hash, err)
+	}
+
+	raw, ok := data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: %x is a %T", ErrCorruptedNode, hash, data)
+	}
+	return decodeNode(raw, len(this.empty))
+}
+
+func (this *node) child(bit byte) []byte {
+	if bit == 0 {
+		return this.left
+	}
+	return this.right
+}
+
+func (this *node) encode() []byte {
+	if this.kind == NODE_LEAF {
+		return append(append([]byte{NODE_LEAF}, this.key[:]...), this.value...)
+	}
+	return append(append([]byte{NODE_INTERNAL}, this.left...), this.right...)
+}
+
+func decodeNode(data []byte, hashLen int) (*node, error) {
+	switch {
+	case len(data) > KEY_LEN && data[0] == NODE_LEAF:
+		n := &node{kind: NODE_LEAF, value: bytes.Clone(data[1+KEY_LEN:])}
+		copy(n.key[:], data[1:])
+		return n, nil
+	case len(data) == 1+2*hashLen && data[0] == NODE_INTERNAL:
+		return &node{kind: NODE_INTERNAL, left: bytes.Clone(data[1 : 1+hashLen]), right: bytes.Clone(data[1+hashLen:])}, nil
+	}
+	return nil, fmt.Errorf("%w: %d bytes", ErrCorruptedNode, len(data))
+}
+
+func leafHash(hasher interface{ Hash([]byte) []byte }, key Key, valueHash []byte) []byte {
+	return hasher.Hash(append(append([]byte{NODE_LEAF}, key[:]...), valueHash...))
+}
+
+func internalHash(hasher interface{ Hash([]byte) []byte }, left, right []byte) []byte {
+	return hasher.Hash(append(append([]byte{NODE_INTERNAL}, left...), right...))
+}
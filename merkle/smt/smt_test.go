@@ -0,0 +1,185 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/arcology-network/common-lib/merkle"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func testKeys(n int) []Key {
+	keys := make([]Key, n)
+	for i := range keys {
+		keys[i] = KeyOf(merkle.Keccak256{}, []byte(fmt.Sprint("account-", i)))
+	}
+	return keys
+}
+
+func TestSparseMerkleUpdates(t *testing.T) {
+	keys := testKeys(300)
+	values := make([][]byte, len(keys))
+	for i := range values {
+		values[i] = []byte(fmt.Sprint("balance-", i))
+	}
+
+	batched := NewTree(memdb.NewMemoryDB(), "smt/", nil, nil)
+	if err := batched.UpdateBatch(keys, values); err != nil {
+		t.Fatal(err)
+	}
+
+	// The root only depends on the contents, not the order of the updates.
+	single := NewTree(memdb.NewMemoryDB(), "smt/", nil, nil)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(len(keys)) {
+		if err := single.Update(keys[i], values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(batched.Root(), single.Root()) {
+		t.Fatal("expected the same root for the same contents")
+	}
+
+	root := batched.Root()
+	for i, key := range keys {
+		if value, err := batched.Get(key); err != nil || !bytes.Equal(value, values[i]) {
+			t.Fatalf("expected %s, got %s %v", values[i], value, err)
+		}
+	}
+
+	// Deleting all but the first key collapses the tree into its leaf.
+	deleted := make([][]byte, len(keys)-1)
+	if err := batched.UpdateBatch(keys[1:], deleted); err != nil {
+		t.Fatal(err)
+	}
+
+	only := NewTree(memdb.NewMemoryDB(), "smt/", nil, nil)
+	only.Update(keys[0], values[0])
+	if !bytes.Equal(batched.Root(), only.Root()) {
+		t.Fatal("expected the root of the only key left")
+	}
+
+	if _, err := batched.Get(keys[1]); !errors.Is(err, stgintf.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	batched.Delete(keys[0])
+	if !bytes.Equal(batched.Root(), make([]byte, 32)) {
+		t.Fatal("expected an empty root")
+	}
+
+	// The earlier roots remain readable.
+	if value, err := batched.At(root).Get(keys[7]); err != nil || !bytes.Equal(value, values[7]) {
+		t.Fatalf("expected the value at the earlier root, got %s %v", value, err)
+	}
+}
+
+func TestSparseMerkleProofs(t *testing.T) {
+	hasher := merkle.Keccak256{}
+	keys := testKeys(200)
+	tree := NewTree(memdb.NewMemoryDB(), "", hasher, nil)
+
+	absent := KeyOf(hasher, []byte("absent"))
+	if proof, _ := tree.Prove(absent); !VerifyExclusion(tree.Root(), absent, proof, hasher) {
+		t.Fatal("expected the exclusion proof of an empty tree to verify")
+	}
+
+	for i, key := range keys[:100] {
+		tree.Update(key, []byte(fmt.Sprint(i)))
+	}
+	root := tree.Root()
+
+	for i, key := range keys {
+		proof, err := tree.Prove(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := DecodeProof(proof.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i < 100 {
+			if !VerifyInclusion(root, key, []byte(fmt.Sprint(i)), decoded, hasher) {
+				t.Fatalf("expected the inclusion of key %d", i)
+			}
+
+			if VerifyInclusion(root, key, []byte("forged"), decoded, hasher) || VerifyExclusion(root, key, decoded, hasher) {
+				t.Fatalf("expected the forged proofs of key %d to fail", i)
+			}
+			continue
+		}
+
+		if !VerifyExclusion(root, key, decoded, hasher) {
+			t.Fatalf("expected the exclusion of key %d", i)
+		}
+
+		if VerifyInclusion(root, key, []byte("x"), decoded, hasher) {
+			t.Fatalf("expected the inclusion of absent key %d to fail", i)
+		}
+	}
+
+	// An inclusion proof of one key can't prove another absent.
+	proof, _ := tree.Prove(keys[0])
+	if VerifyExclusion(root, keys[1], proof, hasher) {
+		t.Fatal("expected a proof of another path to fail")
+	}
+
+	encoded := proof.Encode()
+	if _, err := DecodeProof(encoded[:len(encoded)-1]); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("expected ErrInvalidProof, got %v", err)
+	}
+}
+
+func TestSparseMerkleProofPadding(t *testing.T) {
+	hasher := merkle.Keccak256{}
+	keys := testKeys(100)
+	tree := NewTree(memdb.NewMemoryDB(), "", hasher, nil)
+	for i, key := range keys {
+		tree.Update(key, []byte(fmt.Sprint(i)))
+	}
+
+	for i, key := range keys {
+		proof, _ := tree.Prove(key)
+		if proof.Depth%8 == 0 || len(proof.Siblings) == 0 {
+			continue
+		}
+
+		// A bit set past the depth with an extra sibling that is never used.
+		forged := *proof
+		forged.Bitmap = bytes.Clone(proof.Bitmap)
+		forged.Bitmap[len(forged.Bitmap)-1] |= 0x80 >> (proof.Depth % 8)
+		forged.Siblings = append([][]byte{proof.Siblings[0]}, proof.Siblings...)
+		if VerifyInclusion(tree.Root(), key, []byte(fmt.Sprint(i)), &forged, hasher) {
+			t.Fatalf("expected the padded proof of key %d to fail", i)
+		}
+
+		if _, err := DecodeProof(forged.Encode()); !errors.Is(err, ErrInvalidProof) {
+			t.Fatalf("expected ErrInvalidProof, got %v", err)
+		}
+		return
+	}
+	t.Fatal("no proof with a partial bitmap byte")
+}
@@ -0,0 +1,204 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/arcology-network/common-lib/common"
+)
+
+var ErrOutOfRange = errors.New("merkle: leaf index out of range")
+
+// Len returns the number of leaves, without the padding.
+func (this *Merkle) Len() int {
+	if len(this.nodes) == 0 {
+		return 0
+	}
+	return this.realLen(0)
+}
+
+// realLen returns the number of the nodes of the level without the padding. The padding repeats the
+// last node, so it is where the ids stop matching the positions.
+func (this *Merkle) realLen(level int) int {
+	nodes := this.nodes[level]
+	n := 0
+	for n < len(nodes) && nodes[n].id == uint32(n) {
+		n++
+	}
+	return n
+}
+
+// Update replaces the data of the leaf at the index, only its ancestors are rehashed.
+func (this *Merkle) Update(index int, data []byte) error {
+	return this.UpdateBatch([]int{index}, [][]byte{data})
+}
+
+// UpdateBatch replaces the data of the leaves at the indices, the ancestors shared by the leaves are
+// rehashed once. The last data of a repeated index wins. Nothing is changed if any of the indices is
+// out of range.
+func (this *Merkle) UpdateBatch(indices []int, data [][]byte) error {
+	if len(indices) != len(data) {
+		return fmt.Errorf("merkle: %d indices for %d leaves", len(indices), len(data))
+	}
+
+	size := this.Len()
+	for _, index := range indices {
+		if index < 0 || index >= size {
+			return fmt.Errorf("%w: %d of %d", ErrOutOfRange, index, size)
+		}
+	}
+
+	if len(indices) == 0 {
+		return nil
+	}
+
+	// The workers write the leaves by the indices, so each index has to be written by one of them.
+	last := make(map[int]int, len(indices))
+	for i, index := range indices {
+		last[index] = i
+	}
+
+	positions := make([]int, 0, len(last))
+	for i, index := range indices {
+		if last[index] == i {
+			positions = append(positions, i)
+		}
+	}
+
+	leaves := append([]*Node{}, this.nodes[0][:size]...)
+	worker := func(start, end, index int, args ...any) {
+		for _, i := range positions[start:end] {
+			leaves[indices[i]] = new(Node)
+			leaves[indices[i]].Init(uint32(indices[i]), 0, this.hasher.Hash(data[i]))
+		}
+	}
+	common.ParallelWorker(len(positions), common.IfThen(len(positions) < 1024, 1, concurrency), worker)
+
+	dirty := make([]int, len(positions))
+	for i, position := range positions {
+		dirty[i] = indices[position]
+	}
+	this.rebuild(leaves, dirty)
+	return nil
+}
+
+// Append adds the leaves to the end of the tree, only the nodes on the right edge are rehashed.
+func (this *Merkle) Append(data ...[]byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	size := this.Len()
+	appended := this.newLeafNodes(data)
+	dirty := make([]int, len(appended))
+	for i, leaf := range appended {
+		leaf.id = uint32(size + i)
+		dirty[i] = size + i
+	}
+
+	leaves := []*Node{}
+	if size > 0 {
+		leaves = append(leaves, this.nodes[0][:size]...)
+	}
+	this.rebuild(append(leaves, appended...), dirty)
+}
+
+// Truncate keeps the first n leaves, only the nodes on the new right edge are rehashed.
+func (this *Merkle) Truncate(n int) error {
+	size := this.Len()
+	if n < 0 || n > size {
+		return fmt.Errorf("%w: %d of %d", ErrOutOfRange, n, size)
+	}
+
+	if n == size {
+		return nil
+	}
+	this.rebuild(append([]*Node{}, this.nodes[0][:n]...), nil)
+	return nil
+}
+
+// rebuild replaces the leaves and rehashes the ancestors of the dirty positions level by level. The
+// positions whose padding changes with the length of a level are dirty too. The parents of a level are
+// rehashed in parallel, the untouched ones are kept.
+func (this *Merkle) rebuild(leaves []*Node, dirty []int) {
+	if len(leaves) == 0 {
+		this.Reset()
+		return
+	}
+
+	oldLens := make([]int, len(this.nodes))
+	for level := range this.nodes {
+		oldLens[level] = this.realLen(level)
+	}
+
+	branch := int(this.branch)
+	nodes := leaves
+	for level := 0; ; level++ {
+		oldLen := 0
+		if level < len(oldLens) {
+			oldLen = oldLens[level]
+		}
+
+		if len(nodes) != oldLen {
+			for i := max(min(oldLen, len(nodes))-1, 0); i < len(nodes); i++ {
+				dirty = append(dirty, i)
+			}
+		}
+
+		if level == len(this.nodes) {
+			this.nodes = append(this.nodes, nil)
+		}
+
+		if len(nodes) == 1 { // The root
+			this.nodes[level] = nodes
+			this.nodes = this.nodes[:level+1]
+			return
+		}
+
+		padded := this.Pad(nodes, branch)
+		this.nodes[level] = padded
+
+		parents := make([]*Node, len(padded)/branch)
+		if level+1 < len(oldLens) {
+			copy(parents, this.nodes[level+1][:min(len(parents), oldLens[level+1])])
+		}
+
+		positions := map[int]struct{}{}
+		for _, i := range dirty {
+			positions[i/branch] = struct{}{}
+		}
+
+		dirty = dirty[:0]
+		for p := range positions {
+			dirty = append(dirty, p)
+		}
+		sort.Ints(dirty)
+
+		worker := func(start, end, index int, args ...any) {
+			for i := start; i < end; i++ {
+				p := dirty[i]
+				parents[p] = this.BuildParent(uint32(p), padded[p*branch:(p+1)*branch], index, nil)
+			}
+		}
+		common.ParallelWorker(len(dirty), common.IfThen(len(dirty) < 64, 1, concurrency), worker)
+		nodes = parents
+	}
+}
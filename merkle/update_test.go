@@ -0,0 +1,143 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// checkAgainstInit compares the tree with one built from scratch over the same data.
+func checkAgainstInit(t *testing.T, merkle *Merkle, data [][]byte) {
+	t.Helper()
+	expected := NewMerkle(int(merkle.branch), Concatenator{}, Sha256{})
+	expected.Init(data, nil)
+
+	if merkle.Len() != len(data) {
+		t.Fatalf("expected %d leaves, got %d", len(data), merkle.Len())
+	}
+
+	if !bytes.Equal(merkle.GetRoot(), expected.GetRoot()) {
+		t.Fatalf("roots mismatch with %d leaves in %d branches", len(data), merkle.branch)
+	}
+
+	if len(merkle.nodes) != len(expected.nodes) {
+		t.Fatalf("expected %d levels, got %d", len(expected.nodes), len(merkle.nodes))
+	}
+
+	if len(data) > 1 {
+		if errs := merkle.CheckStructure(); len(errs) > 0 {
+			t.Fatalf("%d nodes don't match their children", len(errs))
+		}
+
+		seed := Sha256{}.Hash(data[len(data)-1])
+		_, proofs := merkle.NodesToHashes(merkle.GetProofNodes(data[len(data)-1]))
+		if !merkle.Verify(proofs, merkle.GetRoot(), seed) {
+			t.Fatal("expected the proof of the last leaf to verify")
+		}
+	}
+}
+
+func TestMerkleIncrementalUpdates(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, branch := range []int{2, 3, 4, 8, 16} {
+		merkle := NewMerkle(branch, Concatenator{}, Sha256{})
+		data := [][]byte{}
+		for round := 0; round < 200; round++ {
+			switch op := random.Intn(4); {
+			case op == 0 || len(data) == 0:
+				appended := make([][]byte, 1+random.Intn(40))
+				for i := range appended {
+					appended[i] = []byte(fmt.Sprintf("%d-%d", round, i))
+				}
+				merkle.Append(appended...)
+				data = append(data, appended...)
+			case op == 1:
+				n := random.Intn(len(data) + 1)
+				if err := merkle.Truncate(n); err != nil {
+					t.Fatal(err)
+				}
+				data = data[:n]
+			case op == 2:
+				index := random.Intn(len(data))
+				data[index] = []byte(fmt.Sprintf("updated-%d", round))
+				if err := merkle.Update(index, data[index]); err != nil {
+					t.Fatal(err)
+				}
+			default:
+				indices, values := []int{}, [][]byte{}
+				for i := 0; i < 1+random.Intn(10); i++ {
+					index := random.Intn(len(data))
+					data[index] = []byte(fmt.Sprintf("batch-%d-%d", round, i))
+					indices, values = append(indices, index), append(values, data[index])
+				}
+				if err := merkle.UpdateBatch(indices, values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkAgainstInit(t, merkle, data)
+		}
+	}
+}
+
+func TestMerkleUpdateDecoded(t *testing.T) {
+	data := [][]byte{}
+	for i := 0; i < 23; i++ {
+		data = append(data, []byte(fmt.Sprint(i)))
+	}
+
+	in := NewMerkle(4, Concatenator{}, Sha256{})
+	in.Init(data, nil)
+	merkle := (&Merkle{}).Decode(in.Encode()).(*Merkle)
+	merkle.SetEncoder(Concatenator{})
+
+	data[22] = []byte("last")
+	merkle.Update(22, data[22])
+	merkle.Append([]byte("23"))
+	checkAgainstInit(t, merkle, append(data, []byte("23")))
+
+	if err := merkle.Update(24, nil); !errors.Is(err, ErrOutOfRange) {
+		t.Fatalf("expected ErrOutOfRange, got %v", err)
+	}
+}
+
+func TestMerkleUpdateBatchRepeatedIndices(t *testing.T) {
+	data := make([][]byte, 64)
+	for i := range data {
+		data[i] = []byte(fmt.Sprint(i))
+	}
+
+	merkle := NewMerkle(4, Concatenator{}, Sha256{})
+	merkle.Init(data, nil)
+
+	// Enough indices for the parallel workers, every leaf written many times, the last write wins.
+	indices, values := []int{}, [][]byte{}
+	for i := 0; i < 4096; i++ {
+		index := (i * 7) % len(data)
+		data[index] = []byte(fmt.Sprintf("updated-%d", i))
+		indices, values = append(indices, index), append(values, data[index])
+	}
+
+	if err := merkle.UpdateBatch(indices, values); err != nil {
+		t.Fatal(err)
+	}
+	checkAgainstInit(t, merkle, data)
+}
@@ -22,7 +22,10 @@ import (
 	"github.com/dgraph-io/badger"
 )
 
-var _ stgintf.ReadWriteStore[string, []byte] = (*BadgerDB)(nil)
+var (
+	_ stgintf.ReadWriteStore[string, []byte] = (*BadgerDB)(nil)
+	_ stgintf.Syncable                       = (*BadgerDB)(nil)
+)
 
 type BadgerDB struct {
 	impl    *badger.DB
@@ -189,6 +192,35 @@ func (db *BadgerDB) Query(prefix string, checker func(string, []byte) bool) (key
 	return keys, values, nil
 }
 
+// Sync makes the writes made so far durable, in case the DB was opened without synced writes.
+func (db *BadgerDB) Sync() error {
+	return db.impl.Sync()
+}
+
+// Iterate calls the visitor for the entries from the key on, in the ascending order of the keys, until
+// the visitor returns false. The entries are read in a single read-only transaction.
+func (db *BadgerDB) Iterate(from string, visitor func(string, []byte) bool) error {
+	return db.impl.View(func(txn *badger.Txn) error { return iterate(txn, from, visitor) })
+}
+
+func iterate(txn *badger.Txn, from string, visitor func(string, []byte) bool) error {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek([]byte(from)); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+
+		if !visitor(string(item.Key()), value) {
+			break
+		}
+	}
+	return nil
+}
+
 func (db *BadgerDB) Close() error {
 	return db.impl.Close()
 }
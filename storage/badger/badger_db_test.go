@@ -19,7 +19,10 @@ package badgerdb
 
 import (
 	"bytes"
+	"path/filepath"
 	"testing"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
 )
 
 func TestBadgerDBFunctions(t *testing.T) {
@@ -91,3 +94,45 @@ func TestBadgerDBFunctions(t *testing.T) {
 	t.Log(queryKeys)
 	t.Log(queryValues)
 }
+
+func TestBadgerDBSnapshotAndCheckpoint(t *testing.T) {
+	db := NewBadgerDB(tempBadgerPath(t))
+	defer db.Close()
+
+	db.Set("a01", []byte{1})
+	db.Set("a02", []byte{2})
+
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+
+	// Writes after the snapshot aren't visible to it or to the checkpoint copied from it.
+	db.Set("a01", []byte{11})
+	db.Delete("a02")
+	db.Set("a03", []byte{3})
+
+	if v, err := snapshot.Get("a01"); err != nil || !bytes.Equal(v.([]byte), []byte{1}) {
+		t.Fatal("expected the value as of the snapshot", v, err)
+	}
+	if !snapshot.Has("a02") || snapshot.Has("a03") {
+		t.Fatal("the snapshot sees the later writes")
+	}
+	if _, err := snapshot.Get("a03"); err != stgintf.ErrNotFound {
+		t.Fatal("expected ErrNotFound", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := snapshot.WriteTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(dir); err == nil {
+		t.Fatal("expected an error checkpointing into an existing directory")
+	}
+
+	copied := NewBadgerDB(dir)
+	defer copied.Close()
+
+	keys, values, errs := copied.Query("", nil)
+	if len(errs) > 0 || len(keys) != 2 || !bytes.Equal(values[0], []byte{1}) || !bytes.Equal(values[1], []byte{2}) {
+		t.Fatal("unexpected checkpoint content", keys, values, errs)
+	}
+}
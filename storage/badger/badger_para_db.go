@@ -17,27 +17,48 @@
 package badgerdb
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"math"
 	"os"
 	"path"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	common "github.com/arcology-network/common-lib/common"
 	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/sharding"
 )
 
-var _ stgintf.ReadWriteStore[string, []byte] = (*ParaBadgerDB)(nil)
+var (
+	_ stgintf.ReadWriteStore[string, []byte] = (*ParaBadgerDB)(nil)
+	_ stgintf.Syncable                       = (*ParaBadgerDB)(nil)
+)
 
 type ParaBadgerDB struct {
-	impls      [16]*BadgerDB
-	shardLocks [16]sync.RWMutex
+	impls      []*BadgerDB // Indexed by the shard ID, nil for the shards that have left the ring.
+	shardLocks []*sync.RWMutex
 	shardFunc  func(int, string) int
+
+	// Only used by the DBs created with NewParaBadgerDBWithRing.
+	root         string
+	decoder      func(string, any, any) (any, error)
+	topology     sync.RWMutex
+	ring         *sharding.Ring
+	previous     *sharding.Ring // The ring before the ongoing rebalance, nil if there is none.
+	manifest     *sharding.Manifest
+	rebalancing  sync.WaitGroup
+	rebalanceErr error
+	closed       atomic.Bool
 }
 
 func NewParaBadgerDB(root string, shardFunc func(numOfShard int, key string) int, decoder ...func(string, any, any) (any, error)) *ParaBadgerDB {
-	var paraBadgerDB ParaBadgerDB
+	paraBadgerDB := ParaBadgerDB{
+		impls:      make([]*BadgerDB, 16),
+		shardLocks: make([]*sync.RWMutex, 16),
+	}
 	if _, err := os.Stat(root); os.IsNotExist(err) {
 		if err := os.MkdirAll(root, fs.ModePerm); err != nil {
 			panic(err)
@@ -57,6 +78,7 @@ func NewParaBadgerDB(root string, shardFunc func(numOfShard int, key string) int
 			}
 		}
 		paraBadgerDB.impls[i] = NewBadgerDB(path, decode)
+		paraBadgerDB.shardLocks[i] = &sync.RWMutex{}
 	}
 
 	if shardFunc != nil {
@@ -67,11 +89,47 @@ func NewParaBadgerDB(root string, shardFunc func(numOfShard int, key string) int
 	return &paraBadgerDB
 }
 
+// NewParaBadgerDBWithRing creates a sharded DB whose keys are distributed by a consistent hashing ring
+// with the given number of virtual nodes per shard. The shards live in the subdirectories of the root,
+// and the ring membership is persisted in a manifest under the root directory. The initial number of
+// shards is only used when the manifest doesn't exist yet. An unfinished rebalance found in the
+// manifest is resumed in the background.
+func NewParaBadgerDBWithRing(root string, initialShards, vnodes int, decoder ...func(string, any, any) (any, error)) (*ParaBadgerDB, error) {
+	if err := os.MkdirAll(root, fs.ModePerm); err != nil {
+		return nil, err
+	}
+
+	manifest, err := sharding.LoadManifest(root, vnodes, initialShards)
+	if err != nil {
+		return nil, err
+	}
+
+	paraBadgerDB := &ParaBadgerDB{
+		root:     root,
+		manifest: manifest,
+		ring:     manifest.Ring(),
+		previous: manifest.PreviousRing(),
+	}
+
+	if len(decoder) > 0 {
+		paraBadgerDB.decoder = decoder[0]
+	}
+
+	for _, id := range manifest.AllShards() {
+		if err := paraBadgerDB.openShard(id); err != nil {
+			paraBadgerDB.Close()
+			return nil, err
+		}
+	}
+
+	if paraBadgerDB.previous != nil {
+		paraBadgerDB.startRebalance(paraBadgerDB.previous.Shards())
+	}
+	return paraBadgerDB, nil
+}
+
 func (this *ParaBadgerDB) Get(key string) (value any, err error) {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.Get(key)
+	return this.GetAs(key, nil)
 }
 
 func (this *ParaBadgerDB) GetAs(key string, typeHint any) (any, error) {
@@ -79,59 +137,61 @@ func (this *ParaBadgerDB) GetAs(key string, typeHint any) (any, error) {
 		return nil, stgintf.ErrNotFound
 	}
 
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.GetAs(key, typeHint)
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.getAs(key, typeHint)
 }
 
 func (this *ParaBadgerDB) Has(key string) bool {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	idx, db := this.getShard(key)
 	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.Has(key)
+	found := db.Has(key)
+	this.shardLocks[idx].RUnlock()
+
+	if !found {
+		if prevIdx, prevDB, ok := this.getPreviousShard(key); ok { // Not moved yet
+			this.shardLocks[prevIdx].RLock()
+			defer this.shardLocks[prevIdx].RUnlock()
+			return prevDB.Has(key)
+		}
+	}
+	return found
 }
 
 func (this *ParaBadgerDB) Set(key string, value []byte) error {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].Lock()
-	defer this.shardLocks[idx].Unlock()
-	return db.Set(key, value)
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.router().SetBatch([]string{key}, [][]byte{value})[0]
 }
 
 func (this *ParaBadgerDB) Delete(key string) error {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].Lock()
-	defer this.shardLocks[idx].Unlock()
-	return db.Delete(key)
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.router().DeleteBatch([]string{key})[0]
 }
 
 func (this *ParaBadgerDB) DeleteBatch(keys []string) []error {
-	errs := make([]error, len(keys))
-	for i := 0; i < len(keys); i++ {
-		idx, db := this.getShard(keys[i])
-		this.shardLocks[idx].Lock()
-		err := db.Delete(keys[i])
-		this.shardLocks[idx].Unlock()
-		if err != nil {
-			errs[i] = err
-		}
-	}
-	if allNil(errs) {
-		return nil
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	if errs := this.router().DeleteBatch(keys); !allNil(errs) {
+		return errs
 	}
-	return errs
+	return nil
 }
 
 func (this *ParaBadgerDB) GetBatch(keys []string) ([]any, []error) {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	results := make([]any, len(keys))
 	errs := make([]error, len(keys))
 	finder := func(start, end, index int, args ...interface{}) {
 		for i := start; i < end; i++ {
-			idx, db := this.getShard(keys[i])
-			this.shardLocks[idx].RLock()
-			v, err := db.Get(keys[i])
-			this.shardLocks[idx].RUnlock()
+			v, err := this.getAs(keys[i], nil)
 			if err != nil {
 				errs[i] = err
 				continue
@@ -147,45 +207,244 @@ func (this *ParaBadgerDB) GetBatch(keys []string) ([]any, []error) {
 }
 
 func (this *ParaBadgerDB) SetBatch(keys []string, values [][]byte) []error {
-	errs := make([]error, len(keys))
-	finder := func(start, end, index int, args ...interface{}) {
-		for i := start; i < end; i++ {
-			idx, db := this.getShard(keys[i])
-			this.shardLocks[idx].Lock()
-			err := db.Set(keys[i], values[i])
-			this.shardLocks[idx].Unlock()
-			if err != nil {
-				errs[i] = err
-			}
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	if errs := this.router().SetBatch(keys, values); !allNil(errs) {
+		return errs
+	}
+	return nil
+}
+
+func (this *ParaBadgerDB) getAs(key string, typeHint any) (any, error) {
+	idx, db := this.getShard(key)
+	this.shardLocks[idx].RLock()
+	v, err := db.GetAs(key, typeHint)
+	this.shardLocks[idx].RUnlock()
+
+	if err == stgintf.ErrNotFound {
+		if prevIdx, prevDB, ok := this.getPreviousShard(key); ok { // Not moved yet
+			this.shardLocks[prevIdx].RLock()
+			defer this.shardLocks[prevIdx].RUnlock()
+			return prevDB.GetAs(key, typeHint)
 		}
 	}
-	common.ParallelWorker(len(keys), len(this.impls), finder)
-	if allNil(errs) {
-		return nil
+	return v, err
+}
+
+// router returns the write path over the shards, the topology lock has to be held while it is used.
+func (this *ParaBadgerDB) router() sharding.Router {
+	return sharding.Router{
+		Shard:  func(id int) stgintf.ReadWriteStore[string, []byte] { return this.impls[id] },
+		Lock:   func(id int) *sync.RWMutex { return this.shardLocks[id] },
+		Locate: func(key string) int { idx, _ := this.getShard(key); return idx },
+		Previous: func(key string) (int, bool) {
+			idx, _, ok := this.getPreviousShard(key)
+			return idx, ok
+		},
 	}
-	return errs
 }
 
 func (this *ParaBadgerDB) Query(prefix string, checker func(string, []byte) bool) (keys []string, values [][]byte, errs []error) {
-	shardIdx, db := this.getShard(prefix)
+	this.topology.RLock()
+	defer this.topology.RUnlock()
 
+	shardIdx, db := this.getShard(prefix)
 	this.shardLocks[shardIdx].RLock()
 	defer this.shardLocks[shardIdx].RUnlock()
 	return db.Query(prefix, checker)
 }
 
+// Iterate calls the visitor for the entries of all the shards from the key on, in the ascending order of
+// the keys, until the visitor returns false. The shards are walked through a snapshot, so the writers
+// aren't blocked while the entries are being visited.
+func (this *ParaBadgerDB) Iterate(from string, visitor func(string, []byte) bool) error {
+	snapshot := this.Snapshot()
+	defer snapshot.Close()
+	return snapshot.Iterate(from, visitor)
+}
+
+// Sync makes the writes made so far to all the shards durable.
+func (this *ParaBadgerDB) Sync() error {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	var errs []error
+	for _, db := range this.impls {
+		if db != nil {
+			errs = append(errs, db.Sync())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (this *ParaBadgerDB) Close() error {
+	this.closed.Store(true)
+	this.rebalancing.Wait()
+
 	for _, db := range this.impls {
-		db.Close()
+		if db != nil {
+			db.Close()
+		}
+	}
+	return nil
+}
+
+// AddShard adds a new shard to the ring, and moves the keys it takes over from the other shards
+// in the background. The DB stays fully readable and writable during the rebalance.
+func (this *ParaBadgerDB) AddShard() (int, error) {
+	this.topology.Lock()
+	defer this.topology.Unlock()
+
+	if this.ring == nil {
+		return -1, sharding.ErrNoRing
+	}
+
+	if this.previous != nil {
+		return -1, sharding.ErrRebalancing
+	}
+
+	id := this.manifest.NextID
+	if err := this.openShard(id); err != nil {
+		return -1, err
+	}
+
+	sources := this.ring.Shards() // Any existing shard may lose keys to the new one.
+	if err := this.manifest.Begin(this.ring, this.ring.With(id)); err != nil {
+		return -1, err
+	}
+
+	this.previous, this.ring = this.ring, this.ring.With(id)
+	this.startRebalance(sources)
+	return id, nil
+}
+
+// RemoveShard removes a shard from the ring, its keys are moved to the remaining shards in the
+// background. The shard is closed and deleted from the disk when the rebalance completes.
+func (this *ParaBadgerDB) RemoveShard(id int) error {
+	this.topology.Lock()
+	defer this.topology.Unlock()
+
+	if this.ring == nil {
+		return sharding.ErrNoRing
+	}
+
+	if this.previous != nil {
+		return sharding.ErrRebalancing
+	}
+
+	if !this.ring.Contains(id) {
+		return sharding.ErrUnknownShard
+	}
+
+	if len(this.ring.Shards()) == 1 {
+		return sharding.ErrLastShard
+	}
+
+	if err := this.manifest.Begin(this.ring, this.ring.Without(id)); err != nil {
+		return err
+	}
+
+	this.previous, this.ring = this.ring, this.ring.Without(id)
+	this.startRebalance([]int{id})
+	return nil
+}
+
+// Shards returns the IDs of the shards in the ring.
+func (this *ParaBadgerDB) Shards() []int {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	if this.ring == nil {
+		shards := make([]int, len(this.impls))
+		for i := range shards {
+			shards[i] = i
+		}
+		return shards
 	}
+	return this.ring.Shards()
+}
+
+// WaitRebalance blocks until the ongoing rebalance, if any, is done.
+func (this *ParaBadgerDB) WaitRebalance() error {
+	this.rebalancing.Wait()
+
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.rebalanceErr
+}
+
+func (this *ParaBadgerDB) startRebalance(sources []int) {
+	ring := this.ring
+	shardOf := func(id int) stgintf.ReadWriteStore[string, []byte] { return this.impls[id] }
+
+	this.rebalanceErr = nil
+	this.rebalancing.Add(1)
+	go func() {
+		defer this.rebalancing.Done()
+		err := sharding.Rebalance(sources, shardOf, ring, &this.topology, this.closed.Load)
+
+		this.topology.Lock()
+		defer this.topology.Unlock()
+		if err != nil {
+			this.rebalanceErr = err // The rebalance will be resumed from the manifest on the next start.
+			return
+		}
+
+		removed, err := this.manifest.Complete()
+		if err != nil {
+			this.rebalanceErr = err
+			return
+		}
+
+		this.previous = nil
+		for _, id := range removed {
+			if err := this.impls[id].Close(); err != nil {
+				this.rebalanceErr = err
+			}
+			this.impls[id] = nil
+			os.RemoveAll(filepath.Join(this.root, fmt.Sprint(id)))
+		}
+	}()
+}
+
+func (this *ParaBadgerDB) openShard(id int) error {
+	dir := filepath.Join(this.root, fmt.Sprint(id))
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		return err
+	}
+
+	for len(this.impls) <= id {
+		this.impls = append(this.impls, nil)
+		this.shardLocks = append(this.shardLocks, &sync.RWMutex{})
+	}
+	this.impls[id] = NewBadgerDB(dir, this.decoder)
 	return nil
 }
 
 func (this *ParaBadgerDB) getShard(key string) (int, *BadgerDB) {
+	if this.ring != nil {
+		shardIdx := this.ring.Locate(key)
+		return shardIdx, this.impls[shardIdx]
+	}
+
 	shardIdx := this.shardFunc(len(this.impls), key)
 	return shardIdx, this.impls[shardIdx]
 }
 
+// getPreviousShard returns the owner of the key before the ongoing rebalance, if it isn't the current owner.
+func (this *ParaBadgerDB) getPreviousShard(key string) (int, *BadgerDB, bool) {
+	if this.previous == nil {
+		return -1, nil, false
+	}
+
+	prevIdx := this.previous.Locate(key)
+	if prevIdx == this.ring.Locate(key) {
+		return -1, nil, false
+	}
+	return prevIdx, this.impls[prevIdx], true
+}
+
 func (this *ParaBadgerDB) hash32(numOfShard int, key string) int {
 	if len(key) == 0 {
 		return int(math.MaxUint32 % uint32(numOfShard))
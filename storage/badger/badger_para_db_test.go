@@ -19,6 +19,9 @@ package badgerdb
 
 import (
 	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
 	"testing"
 
 	common "github.com/arcology-network/common-lib/common"
@@ -101,3 +104,156 @@ func TestParaBadgerDBFunctions(t *testing.T) {
 	t.Log(queryKeys)
 	t.Log(queryValues)
 }
+
+func TestParaBadgerDBResharding(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "badger-ring")
+	db, err := NewParaBadgerDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 500)
+	values := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		values[i] = []byte(keys[i])
+	}
+	if errs := db.SetBatch(keys, values); errs != nil {
+		t.Fatal(errs)
+	}
+
+	id, err := db.AddShard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads stay correct while the keys are being moved.
+	got, errs := db.GetBatch(keys)
+	for i := range keys {
+		if (errs != nil && errs[i] != nil) || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("GetBatch(%s) during the rebalance failed", keys[i])
+		}
+	}
+
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RemoveShard(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = NewParaBadgerDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if shards := db.Shards(); !slices.Equal(shards, []int{0, id}) {
+		t.Fatalf("unexpected shards %v", shards)
+	}
+
+	if db.Has(keys[1]) {
+		t.Error("the deleted key came back")
+	}
+
+	for i := 2; i < len(keys); i++ {
+		if v, err := db.Get(keys[i]); err != nil || !bytes.Equal(v.([]byte), values[i]) {
+			t.Fatalf("Get(%s) after the restart: %v", keys[i], err)
+		}
+	}
+}
+
+func TestParaBadgerDBSnapshotAndCheckpoint(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "badger-ring")
+	db, err := NewParaBadgerDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 300)
+	values := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		values[i] = []byte(keys[i])
+	}
+	db.SetBatch(keys, values)
+
+	// The snapshot may be taken in the middle of the rebalance.
+	if _, err := db.AddShard(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Delete(keys[0])
+	db.Set(keys[1], []byte("updated"))
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, errs := snapshot.GetBatch(keys)
+	for i := range keys {
+		if (errs != nil && errs[i] != nil) || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("snapshot GetBatch(%s) failed", keys[i])
+		}
+	}
+	if snapshotKeys, _, _ := snapshot.Query("", nil); len(snapshotKeys) != len(keys) {
+		t.Fatal("expected", len(keys), "keys in the snapshot, got", len(snapshotKeys))
+	}
+	db.Close()
+
+	// The checkpoint reopens with the ring it was taken with, and finishes the rebalance by itself.
+	copied, err := NewParaBadgerDBWithRing(dir, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copied.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+	if shards := copied.Shards(); len(shards) != 3 {
+		t.Fatal("expected 3 shards, got", shards)
+	}
+	got, errs = copied.GetBatch(keys)
+	for i := range keys {
+		if (errs != nil && errs[i] != nil) || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("checkpoint GetBatch(%s) failed", keys[i])
+		}
+	}
+	copied.Close()
+}
+
+func TestParaBadgerDBLegacyCheckpoint(t *testing.T) {
+	db := NewParaBadgerDB(filepath.Join(t.TempDir(), "legacy"), nil)
+	defer db.Close()
+
+	keys := []string{"a01", "b02", "c03", "d04"}
+	db.SetBatch(keys, [][]byte{{1}, {2}, {3}, {4}})
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a01", []byte{11})
+
+	copied := NewParaBadgerDB(dir, nil)
+	defer copied.Close()
+
+	for i, key := range keys {
+		if v, err := copied.Get(key); err != nil || !bytes.Equal(v.([]byte), []byte{byte(i + 1)}) {
+			t.Fatal("unexpected value of", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package badgerdb
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	common "github.com/arcology-network/common-lib/common"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/sharding"
+	"github.com/dgraph-io/badger"
+)
+
+var (
+	_ stgintf.ReadableStore[string, []byte] = (*BadgerSnapshot)(nil)
+	_ stgintf.ReadableStore[string, []byte] = (*ParaBadgerSnapshot)(nil)
+	_ stgintf.Iterable[string, []byte]      = (*BadgerDB)(nil)
+	_ stgintf.Iterable[string, []byte]      = (*ParaBadgerDB)(nil)
+)
+
+// BadgerSnapshot is a read-only view of a BadgerDB at the moment it was taken, backed by a
+// read-only transaction. The writes made to the DB afterwards aren't visible. The snapshot
+// keeps the old versions from being garbage collected, so it should be closed as soon as
+// it is no longer needed.
+type BadgerSnapshot struct {
+	txn     *badger.Txn
+	decoder func(string, any, any) (any, error)
+}
+
+// Snapshot returns a consistent read-only view of the DB, without blocking the writers.
+func (db *BadgerDB) Snapshot() *BadgerSnapshot {
+	return &BadgerSnapshot{txn: db.impl.NewTransaction(false), decoder: db.decoder}
+}
+
+// Checkpoint writes an openable copy of the DB to a new directory. Badger doesn't support hard
+// linking its files, so the entries visible to a snapshot are copied into a new DB instead, and
+// the writers aren't blocked during the copy. The directory must not exist yet.
+func (db *BadgerDB) Checkpoint(dir string) error {
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+	return snapshot.WriteTo(dir)
+}
+
+func (snap *BadgerSnapshot) Get(key string) (any, error) {
+	return snap.GetAs(key, nil)
+}
+
+func (snap *BadgerSnapshot) GetAs(key string, typeHint any) (any, error) {
+	item, err := snap.txn.Get([]byte(key))
+	if err == badger.ErrKeyNotFound {
+		return nil, stgintf.ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if snap.decoder != nil {
+		return snap.decoder(key, value, typeHint)
+	}
+	return value, nil
+}
+
+func (snap *BadgerSnapshot) Has(key string) bool {
+	_, err := snap.txn.Get([]byte(key))
+	return err == nil
+}
+
+func (snap *BadgerSnapshot) GetBatch(keys []string) (values []any, errs []error) {
+	values = make([]any, len(keys))
+	errs = make([]error, len(keys))
+	for i := range keys {
+		if len(keys[i]) == 0 {
+			errs[i] = stgintf.ErrNotFound
+			continue
+		}
+		values[i], errs[i] = snap.Get(keys[i])
+	}
+	if allNil(errs) {
+		return values, nil
+	}
+	return values, errs
+}
+
+// Query scans the keys with the prefix as of the snapshot.
+func (snap *BadgerSnapshot) Query(prefix string, checker func(string, []byte) bool) (keys []string, values [][]byte, errs []error) {
+	it := snap.txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: true,
+		PrefetchSize:   100,
+		Prefix:         []byte(prefix),
+	})
+	defer it.Close()
+
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		key := string(item.Key())
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		if checker != nil && !checker(key, val) {
+			continue
+		}
+		keys = append(keys, key)
+		values = append(values, val)
+	}
+	return keys, values, nil
+}
+
+// Iterate calls the visitor for the entries from the key on as of the snapshot, in the ascending order
+// of the keys, until the visitor returns false.
+func (snap *BadgerSnapshot) Iterate(from string, visitor func(string, []byte) bool) error {
+	return iterate(snap.txn, from, visitor)
+}
+
+// WriteTo copies all the entries visible to the snapshot into a new BadgerDB under the directory.
+func (snap *BadgerSnapshot) WriteTo(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return &fs.PathError{Op: "checkpoint", Path: dir, Err: fs.ErrExist}
+	}
+
+	target, err := badger.Open(badger.DefaultOptions(dir))
+	if err != nil {
+		return err
+	}
+
+	batch := target.NewWriteBatch()
+	it := snap.txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err == nil {
+			err = batch.Set(item.KeyCopy(nil), value)
+		}
+
+		if err != nil {
+			it.Close()
+			batch.Cancel()
+			target.Close()
+			return err
+		}
+	}
+	it.Close()
+
+	if err := batch.Flush(); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+func (snap *BadgerSnapshot) Close() error {
+	snap.txn.Discard()
+	return nil
+}
+
+// ParaBadgerSnapshot is a read-only view of all the shards of a ParaBadgerDB at the same instant.
+// Keys are located with the sharding of the DB at the time the snapshot was taken, including
+// the keys that were still waiting to be moved by an ongoing rebalance.
+type ParaBadgerSnapshot struct {
+	impls     []*BadgerSnapshot // Indexed by the shard ID
+	shardFunc func(int, string) int
+	ring      *sharding.Ring
+	previous  *sharding.Ring
+}
+
+// Snapshot returns a read-only view of the DB that is consistent across all the shards. The DB
+// is only paused while the read transactions of the shards are being opened.
+func (this *ParaBadgerDB) Snapshot() *ParaBadgerSnapshot {
+	this.topology.Lock() // Holding the topology lock excludes all the readers, writers and key moves.
+	defer this.topology.Unlock()
+	return this.snapshot()
+}
+
+// Checkpoint writes an openable copy of the DB, which can be opened with the same constructor as
+// the DB. All the shards are copied from the snapshots taken at the same instant, so the writers
+// are only paused while the snapshots are being taken.
+func (this *ParaBadgerDB) Checkpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return &fs.PathError{Op: "checkpoint", Path: dir, Err: fs.ErrExist}
+	}
+
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		return err
+	}
+
+	this.topology.Lock()
+	snapshot := this.snapshot()
+	var err error
+	if this.manifest != nil {
+		err = this.manifest.SaveTo(dir)
+	}
+	this.topology.Unlock()
+	defer snapshot.Close()
+
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(snapshot.impls))
+	writer := func(start, end, index int, args ...interface{}) {
+		for i := start; i < end; i++ {
+			if snapshot.impls[i] == nil {
+				continue
+			}
+
+			shardDir := path.Join(dir+fmt.Sprint(i)) + "/" // The layout of NewParaBadgerDB
+			if snapshot.ring != nil {
+				shardDir = filepath.Join(dir, fmt.Sprint(i))
+			}
+			errs[i] = snapshot.impls[i].WriteTo(shardDir)
+		}
+	}
+	common.ParallelWorker(len(snapshot.impls), len(snapshot.impls), writer)
+	return errors.Join(errs...)
+}
+
+func (this *ParaBadgerDB) snapshot() *ParaBadgerSnapshot {
+	snapshot := &ParaBadgerSnapshot{
+		impls:     make([]*BadgerSnapshot, len(this.impls)),
+		shardFunc: this.shardFunc,
+		ring:      this.ring,
+		previous:  this.previous,
+	}
+
+	for i, db := range this.impls {
+		if db != nil {
+			snapshot.impls[i] = db.Snapshot()
+		}
+	}
+	return snapshot
+}
+
+func (this *ParaBadgerSnapshot) Get(key string) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *ParaBadgerSnapshot) GetAs(key string, typeHint any) (any, error) {
+	v, err := this.getShard(key).GetAs(key, typeHint)
+	if err == stgintf.ErrNotFound {
+		if prev := this.getPreviousShard(key); prev != nil {
+			return prev.GetAs(key, typeHint)
+		}
+	}
+	return v, err
+}
+
+func (this *ParaBadgerSnapshot) GetBatch(keys []string) ([]any, []error) {
+	values := make([]any, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		values[i], errs[i] = this.Get(key)
+	}
+	if allNil(errs) {
+		return values, nil
+	}
+	return values, errs
+}
+
+func (this *ParaBadgerSnapshot) Has(key string) bool {
+	if this.getShard(key).Has(key) {
+		return true
+	}
+
+	prev := this.getPreviousShard(key)
+	return prev != nil && prev.Has(key)
+}
+
+// Query scans the keys with the prefix in all the shards as of the snapshot.
+func (this *ParaBadgerSnapshot) Query(prefix string, checker func(string, []byte) bool) (keys []string, values [][]byte, errs []error) {
+	for _, snapshot := range this.impls {
+		if snapshot == nil {
+			continue
+		}
+		shardKeys, shardValues, shardErrs := snapshot.Query(prefix, checker)
+		if len(shardErrs) > 0 {
+			return nil, nil, shardErrs
+		}
+		keys = append(keys, shardKeys...)
+		values = append(values, shardValues...)
+	}
+	return keys, values, nil
+}
+
+// Iterate calls the visitor for the entries of all the shards from the key on as of the snapshot, in
+// the ascending order of the keys, until the visitor returns false.
+func (this *ParaBadgerSnapshot) Iterate(from string, visitor func(string, []byte) bool) error {
+	shards := make([]stgintf.Iterable[string, []byte], 0, len(this.impls))
+	for _, snapshot := range this.impls {
+		if snapshot != nil {
+			shards = append(shards, snapshot)
+		}
+	}
+	return sharding.Iterate(shards, from, visitor)
+}
+
+func (this *ParaBadgerSnapshot) Close() error {
+	for _, snapshot := range this.impls {
+		if snapshot != nil {
+			snapshot.Close()
+		}
+	}
+	return nil
+}
+
+func (this *ParaBadgerSnapshot) getShard(key string) *BadgerSnapshot {
+	if this.ring != nil {
+		return this.impls[this.ring.Locate(key)]
+	}
+	return this.impls[this.shardFunc(len(this.impls), key)]
+}
+
+func (this *ParaBadgerSnapshot) getPreviousShard(key string) *BadgerSnapshot {
+	if this.previous == nil {
+		return nil
+	}
+
+	if prevIdx := this.previous.Locate(key); prevIdx != this.ring.Locate(key) {
+		return this.impls[prevIdx]
+	}
+	return nil
+}
@@ -22,6 +22,7 @@ import (
 
 	mapi "github.com/arcology-network/common-lib/exp/map"
 	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/metrics"
 )
 
 var _ stgintf.ReadWriteStore[string, any] = (*Cache[string, any])(nil)
@@ -37,6 +38,9 @@ type Cache[K stgintf.Key, V any] struct {
 	decoder     func(K, any, any) (any, error)
 	epoch       atomic.Uint64
 	enabled     bool
+	metrics     metrics.Recorder
+	hitsName    string
+	missesName  string
 }
 
 func NewCache[K stgintf.Key, V any](
@@ -59,6 +63,7 @@ func NewCache[K stgintf.Key, V any](
 		cachePolicy: cachePolicy,
 		decoder:     decode,
 		enabled:     true,
+		metrics:     metrics.Nop{},
 	}
 	newReadCache.epoch.Store(0)
 	return newReadCache
@@ -85,12 +90,14 @@ func (this *Cache[K, V]) GetAs(key K, typeHint any) (any, error) {
 		if record, ok := this.ConcurrentMap.Get(key); ok {
 			if record != nil {
 				record.visits++
+				this.metrics.Add(this.hitsName, 1)
 				if this.decoder != nil {
 					return this.decoder(key, record.value, typeHint)
 				}
 				return record.value, nil
 			}
 		}
+		this.metrics.Add(this.missesName, 1)
 	}
 	return nil, stgintf.ErrNotFound
 }
@@ -110,14 +117,18 @@ func (this *Cache[K, V]) GetBatch(keys []K) ([]any, []error) {
 
 	values := make([]any, len(keys))
 	errs := make([]error, len(keys))
+	hits := 0
 	for i, key := range keys {
 		if record, ok := this.ConcurrentMap.Get(key); ok && record != nil {
 			record.visits++
 			values[i] = record.value
+			hits++
 			continue
 		}
 		errs[i] = stgintf.ErrNotFound
 	}
+	this.metrics.Add(this.hitsName, int64(hits))
+	this.metrics.Add(this.missesName, int64(len(keys)-hits))
 	return values, errs
 }
 
@@ -236,6 +247,12 @@ func (this *Cache[K, V]) Evict() {
 	}
 }
 
+// SetMetrics reports the cache hits and misses of the reads to the recorder, as the counters
+// <name>.hits and <name>.misses.
+func (this *Cache[K, V]) SetMetrics(recorder metrics.Recorder, name string) {
+	this.metrics, this.hitsName, this.missesName = recorder, name+".hits", name+".misses"
+}
+
 func (this *Cache[K, V]) Status() bool            { return this.enabled }
 func (this *Cache[K, V]) SetStatus(flag bool)     { this.enabled = flag }
 func (this *Cache[K, V]) Hash(k K) uint64         { return this.ConcurrentMap.Hash(k) }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/metrics"
 )
 
 // For entrySize test: type with MemSize method
@@ -235,3 +236,18 @@ func TestCacheDisabledBypassesAllOperations(t *testing.T) {
 		t.Fatalf("expected disabled cache Delete to avoid mutating entries")
 	}
 }
+
+func TestCacheMetrics(t *testing.T) {
+	c := NewCache(4, testStringHash, NewCachePolicy(100, func(v int) uint64 { return uint64(v) }))
+	registry := metrics.NewRegistry()
+	c.SetMetrics(registry, "cache")
+
+	c.Set("alpha", 1)
+	c.Get("alpha")
+	c.Get("missing")
+	c.GetBatch([]string{"alpha", "beta", "gamma"})
+
+	if hits, misses := registry.Counter("cache.hits"), registry.Counter("cache.misses"); hits != 2 || misses != 3 {
+		t.Fatalf("expected 2 hits and 3 misses, got %d and %d", hits, misses)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cachedstore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+var (
+	ErrIndexExists  = errors.New("cachedstore: index already registered")
+	ErrUnknownIndex = errors.New("cachedstore: unknown index")
+)
+
+// secondaryIndex maps the index keys extracted from the records to their primary keys.
+type secondaryIndex[K0 comparable, V0 any] struct {
+	name    string
+	extract func(K0, V0) []string
+	entries map[string]map[K0]struct{} // Index key -> primary keys
+	keys    map[K0][]string            // Primary key -> the index keys extracted from its current value
+}
+
+func (this *secondaryIndex[K0, V0]) set(key K0, value V0) {
+	this.remove(key)
+	indexKeys := this.extract(key, value)
+	if len(indexKeys) == 0 {
+		return
+	}
+
+	for _, indexKey := range indexKeys {
+		primaries, ok := this.entries[indexKey]
+		if !ok {
+			primaries = map[K0]struct{}{}
+			this.entries[indexKey] = primaries
+		}
+		primaries[key] = struct{}{}
+	}
+	this.keys[key] = indexKeys
+}
+
+func (this *secondaryIndex[K0, V0]) remove(key K0) {
+	for _, indexKey := range this.keys[key] {
+		if primaries := this.entries[indexKey]; primaries != nil {
+			delete(primaries, key)
+			if len(primaries) == 0 {
+				delete(this.entries, indexKey)
+			}
+		}
+	}
+	delete(this.keys, key)
+}
+
+// RegisterIndex adds a secondary index, the extractor returns the index keys of a record, or nothing
+// if the record isn't indexed. From now on, the index is updated with every successful write, it
+// should be populated with RebuildIndexes if the store isn't empty. The indexes should be registered
+// before the store is in use.
+func (this *CachedStore[K0, V0, K1, V1]) RegisterIndex(name string, extract func(K0, V0) []string) error {
+	this.indexLock.Lock()
+	defer this.indexLock.Unlock()
+
+	for _, index := range this.indexes {
+		if index.name == name {
+			return fmt.Errorf("%w: %s", ErrIndexExists, name)
+		}
+	}
+
+	this.indexes = append(this.indexes, &secondaryIndex[K0, V0]{
+		name:    name,
+		extract: extract,
+		entries: map[string]map[K0]struct{}{},
+		keys:    map[K0][]string{},
+	})
+	this.indexed.Store(true)
+	return nil
+}
+
+// Lookup returns the primary keys of the records with the index key, in ascending order.
+func (this *CachedStore[K0, V0, K1, V1]) Lookup(indexName string, key string) ([]K0, error) {
+	this.indexLock.RLock()
+	defer this.indexLock.RUnlock()
+
+	for _, index := range this.indexes {
+		if index.name != indexName {
+			continue
+		}
+
+		primaries := make([]K0, 0, len(index.entries[key]))
+		for primary := range index.entries[key] {
+			primaries = append(primaries, primary)
+		}
+		slices.Sort(primaries)
+		return primaries, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, indexName)
+}
+
+// RebuildIndexes repopulates the named indexes, or all of them if none is named, from the records
+// under the prefix. The indexes are kept in memory only, so they must be rebuilt after every restart
+// and after an index is registered on a store that isn't empty. A rebuild is a pass over the records
+// under the prefix, streamed from the backend if it is iterable, which is why the indexed records
+// should be kept under a prefix of their own on large stores.
+func (this *CachedStore[K0, V0, K1, V1]) RebuildIndexes(prefix K0, names ...string) error {
+	this.indexLock.Lock()
+	defer this.indexLock.Unlock()
+
+	indexes := make([]*secondaryIndex[K0, V0], 0, len(this.indexes))
+	for _, name := range names {
+		i := slices.IndexFunc(this.indexes, func(index *secondaryIndex[K0, V0]) bool { return index.name == name })
+		if i < 0 {
+			return fmt.Errorf("%w: %s", ErrUnknownIndex, name)
+		}
+		indexes = append(indexes, this.indexes[i])
+	}
+	if len(names) == 0 {
+		indexes = append(indexes, this.indexes...)
+	}
+
+	for _, index := range indexes {
+		index.entries, index.keys = map[string]map[K0]struct{}{}, map[K0][]string{}
+	}
+	return this.scan(prefix, func(key K0, value V0) {
+		for _, index := range indexes {
+			index.set(key, value)
+		}
+	})
+}
+
+// scan visits the records under the prefix. The writes go through to the backend, so an iterable
+// backend with string keys is streamed on its own, without loading all the records at once.
+func (this *CachedStore[K0, V0, K1, V1]) scan(prefix K0, visitor func(K0, V0)) error {
+	backendPrefix, _, err := this.converter.ForwardConvert(prefix, this.zero)
+	if err != nil {
+		return err
+	}
+
+	iterable, ok := any(this.backend).(stgintf.Iterable[K1, V1])
+	from, isString := any(backendPrefix).(string)
+	if this.backend == nil || !ok || !isString {
+		keys, values, errs := this.Query(prefix, func(K0, V0) bool { return true })
+		if err := errors.Join(errs...); err != nil {
+			return err
+		}
+		for i := range keys {
+			visitor(keys[i], values[i])
+		}
+		return nil
+	}
+
+	if iterErr := iterable.Iterate(backendPrefix, func(key K1, value V1) bool {
+		if !strings.HasPrefix(any(key).(string), from) {
+			return false
+		}
+
+		decodedKey, decodedValue, decodeErr := this.converter.BackwardConvert(key, value)
+		if err = decodeErr; err != nil {
+			return false
+		}
+		visitor(decodedKey, decodedValue)
+		return true
+	}); iterErr != nil {
+		return iterErr
+	}
+	return err
+}
+
+// lockIndexes serializes the writes while there are indexes, so the indexes are updated in the
+// same order as the records. It returns the function to unlock.
+func (this *CachedStore[K0, V0, K1, V1]) lockIndexes() func() {
+	if !this.indexed.Load() {
+		return func() {}
+	}
+	this.indexLock.Lock()
+	return this.indexLock.Unlock
+}
+
+func (this *CachedStore[K0, V0, K1, V1]) indexSet(key K0, value V0) {
+	for _, index := range this.indexes {
+		index.set(key, value)
+	}
+}
+
+func (this *CachedStore[K0, V0, K1, V1]) indexRemove(key K0) {
+	for _, index := range this.indexes {
+		index.remove(key)
+	}
+}
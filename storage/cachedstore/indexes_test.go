@@ -0,0 +1,184 @@
+/*
+*   Copyright (c) 2026 Arcology Network
+
+*   This program is free software: you can redistribute it and/or modify
+*   it under the terms of the GNU General Public License as published by
+*   the Free Software Foundation, either version 3 of the License, or
+*   (at your option) any later version.
+
+*   This program is distributed in the hope that it will be useful,
+*   but WITHOUT ANY WARRANTY; without even the implied warranty of
+*   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*   GNU General Public License for more details.
+
+*   You should have received a copy of the GNU General Public License
+*   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package cachedstore
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+
+	badgerdb "github.com/arcology-network/common-lib/storage/badger"
+	stgcodec "github.com/arcology-network/common-lib/storage/codec"
+	"github.com/arcology-network/common-lib/storage/faulty"
+	memdb "github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func newIndexedStore(t *testing.T, backend *faulty.Store[string, []byte]) *CachedStore[string, []byte, string, []byte] {
+	t.Helper()
+	codec := stgcodec.NewStorageCodec[string, []byte, string, []byte](
+		func(k string, v []byte) (string, []byte, error) { return k, v, nil },
+		func(k string, v []byte) (string, []byte, error) { return k, v, nil },
+	)
+
+	store := NewCachedStore[string, []byte, string, []byte](backend, codec, 1024, func([]byte) uint64 { return 1 })
+	if err := store.RegisterIndex("from", func(_ string, v []byte) []string { return []string{string(v)} }); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestStoreSecondaryIndexes(t *testing.T) {
+	db := memdb.NewMemoryDB()
+	backend := faulty.NewStore[string, []byte](db, faulty.Config{Rules: []faulty.Rule{{
+		Ops:         []string{faulty.OP_SET, faulty.OP_SET_BATCH},
+		Keys:        regexp.MustCompile("^tx/3$"),
+		Probability: 1,
+	}}})
+	store := newIndexedStore(t, backend)
+
+	if err := store.RegisterIndex("from", nil); !errors.Is(err, ErrIndexExists) {
+		t.Fatal("expected ErrIndexExists, got", err)
+	}
+
+	store.Set("tx/0", []byte("alice"))
+	store.Set("tx/1", []byte("alice"))
+	errs := store.SetBatch([]string{"tx/2", "tx/3"}, [][]byte{[]byte("bob"), []byte("bob")})
+	if errs[0] != nil || !errors.Is(errs[1], faulty.ErrInjected) {
+		t.Fatal("unexpected errors", errs)
+	}
+
+	if keys, _ := store.Lookup("from", "bob"); !reflect.DeepEqual(keys, []string{"tx/2"}) {
+		t.Fatal("the failed write shouldn't be indexed", keys)
+	}
+
+	if store.Has("tx/3") {
+		t.Fatal("the failed write shouldn't stay in the cache")
+	}
+
+	// Updates move the primary key to the new index key.
+	store.Set("tx/1", []byte("bob"))
+	if err := store.Set("tx/3", []byte("carol")); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the injected failure, got", err)
+	}
+	store.Delete("tx/0")
+
+	if keys, _ := store.Lookup("from", "alice"); len(keys) != 0 {
+		t.Fatal("expected nothing from alice", keys)
+	}
+
+	if keys, _ := store.Lookup("from", "bob"); !reflect.DeepEqual(keys, []string{"tx/1", "tx/2"}) {
+		t.Fatal("unexpected keys from bob", keys)
+	}
+
+	if keys, _ := store.Lookup("from", "carol"); len(keys) != 0 {
+		t.Fatal("expected nothing from carol", keys)
+	}
+
+	if _, err := store.Lookup("to", "bob"); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatal("expected ErrUnknownIndex, got", err)
+	}
+
+	// A new store over the same backend rebuilds the index from it.
+	reopened := newIndexedStore(t, backend)
+	if err := reopened.RebuildIndexes(""); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := reopened.Lookup("from", "bob"); !reflect.DeepEqual(keys, []string{"tx/1", "tx/2"}) {
+		t.Fatal("unexpected keys after the rebuild", keys)
+	}
+}
+
+func TestStoreRebuildIndexesUnderPrefix(t *testing.T) {
+	db := badgerdb.NewBadgerDB(filepath.Join(t.TempDir(), "badger"))
+	defer db.Close()
+	codec := stgcodec.NewStorageCodec[string, []byte, string, []byte](
+		func(k string, v []byte) (string, []byte, error) { return k, v, nil },
+		func(k string, v []byte) (string, []byte, error) { return k, v, nil },
+	)
+
+	store := NewCachedStore[string, []byte, string, []byte](db, codec, 1024, func([]byte) uint64 { return 1 })
+	store.SetBatch([]string{"acct/alice", "tx/0", "tx/1", "tx/2"}, [][]byte{[]byte("alice"), []byte("alice"), []byte("bob"), []byte("alice")})
+
+	// Registered on a store that isn't empty, the indexes are populated from the indexed records only.
+	store.RegisterIndex("from", func(_ string, v []byte) []string { return []string{string(v)} })
+	store.RegisterIndex("to", func(_ string, v []byte) []string { return []string{string(v)} })
+	if err := store.RebuildIndexes("tx/", "from"); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys, _ := store.Lookup("from", "alice"); !reflect.DeepEqual(keys, []string{"tx/0", "tx/2"}) {
+		t.Fatal("unexpected keys from alice", keys)
+	}
+
+	if keys, _ := store.Lookup("to", "alice"); len(keys) != 0 {
+		t.Fatal("only the named index should be rebuilt", keys)
+	}
+
+	if err := store.RebuildIndexes("tx/", "from", "unknown"); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatal("expected ErrUnknownIndex, got", err)
+	}
+}
+
+func TestStoreConversionFailure(t *testing.T) {
+	db := memdb.NewMemoryDB()
+	errBadKey := errors.New("bad key")
+	codec := stgcodec.NewStorageCodec[string, []byte, string, []byte](
+		func(k string, v []byte) (string, []byte, error) {
+			if k == "tx/bad" || string(v) == "mallory" {
+				return "", nil, errBadKey
+			}
+			return k, v, nil
+		},
+		func(k string, v []byte) (string, []byte, error) { return k, v, nil },
+	)
+
+	store := NewCachedStore[string, []byte, string, []byte](db, codec, 1024, func([]byte) uint64 { return 1 })
+	store.RegisterIndex("from", func(_ string, v []byte) []string { return []string{string(v)} })
+
+	// The value that can't be converted is neither cached nor indexed, the old one stays.
+	store.Set("tx/0", []byte("alice"))
+	if err := store.Set("tx/0", []byte("mallory")); !errors.Is(err, errBadKey) {
+		t.Fatal("expected the conversion error, got", err)
+	}
+
+	if value, err := store.Get("tx/0"); err != nil || !reflect.DeepEqual(value, []byte("alice")) {
+		t.Fatal("expected the old value, got", value, err)
+	}
+
+	if keys, _ := store.Lookup("from", "mallory"); len(keys) != 0 {
+		t.Fatal("the failed write shouldn't be indexed", keys)
+	}
+
+	// A key that can't be converted can't be deleted from the backend either, so it stays indexed.
+	db.Set("tx/bad", []byte("bob"))
+	store.RebuildIndexes("")
+	if err := store.Delete("tx/bad"); !errors.Is(err, errBadKey) {
+		t.Fatal("expected the conversion error, got", err)
+	}
+
+	if keys, _ := store.Lookup("from", "bob"); !reflect.DeepEqual(keys, []string{"tx/bad"}) || !db.Has("tx/bad") {
+		t.Fatal("the failed delete shouldn't be unindexed", keys)
+	}
+
+	if errs := store.DeleteBatch([]string{"tx/0", "tx/bad"}); errs[0] != nil || !errors.Is(errs[1], errBadKey) {
+		t.Fatal("unexpected errors", errs)
+	}
+}
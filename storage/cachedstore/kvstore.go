@@ -19,6 +19,8 @@ package cachedstore
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	cache "github.com/arcology-network/common-lib/storage/cache"
 	stgcodec "github.com/arcology-network/common-lib/storage/codec"
@@ -34,6 +36,10 @@ type CachedStore[K0 stgintf.Key, V0 any, K1 stgintf.Key, V1 any] struct {
 	converter *stgcodec.StorageCodec[K0, V0, K1, V1]
 	decoder   func(K0, any, any) (any, error)
 	zero      V0
+
+	indexLock sync.RWMutex
+	indexes   []*secondaryIndex[K0, V0]
+	indexed   atomic.Bool // If any index is registered
 }
 
 func NewCachedStore[K0 stgintf.Key, V0 any, K1 stgintf.Key, V1 any](
@@ -165,75 +171,116 @@ func (this *CachedStore[K0, V0, K1, V1]) GetBatch(keys []K0) ([]any, []error) {
 	return values, errs
 }
 
+// Set writes the record through to the backend. If the record can't be converted, nothing is written,
+// if the backend fails, the record is evicted from the cache, so the cache doesn't serve a value the
+// backend doesn't have.
 func (this *CachedStore[K0, V0, K1, V1]) Set(key K0, value V0) error {
+	defer this.lockIndexes()()
+
+	var backendKey K1
+	var backendValue V1
+	if this.backend != nil {
+		var err error
+		if backendKey, backendValue, err = this.converter.ForwardConvert(key, value); err != nil {
+			return err
+		}
+	}
+
 	if err := this.cache.Set(key, value); err != nil {
 		return err
 	}
 
-	backendKey, backendValue, err := this.converter.ForwardConvert(key, value)
-	if err == nil && this.backend != nil {
-		return this.backend.Set(backendKey, backendValue)
+	if this.backend != nil {
+		if err := this.backend.Set(backendKey, backendValue); err != nil {
+			this.cache.Delete(key)
+			return err
+		}
 	}
+	this.indexSet(key, value)
 	return nil
 }
 
+// SetBatch writes the records through to the backend, the records failed to write are evicted
+// from the cache.
 func (this *CachedStore[K0, V0, K1, V1]) SetBatch(keys []K0, values []V0) []error {
+	defer this.lockIndexes()()
+
 	this.cache.SetBatch(keys, values)
 	errs := make([]error, len(keys))
-	if this.backend == nil {
-		return errs
-	}
+	if this.backend != nil {
+		backendKeys := make([]K1, 0, len(keys))
+		backendVals := make([]V1, 0, len(keys))
+		idxMap := make([]int, 0, len(keys))
+		for i := 0; i < len(keys); i++ {
+			backendKey, backendVal, err := this.converter.ForwardConvert(keys[i], values[i])
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+			backendKeys = append(backendKeys, backendKey)
+			backendVals = append(backendVals, backendVal)
+			idxMap = append(idxMap, i)
+		}
 
-	backendKeys := make([]K1, 0, len(keys))
-	backendVals := make([]V1, 0, len(keys))
-	idxMap := make([]int, 0, len(keys))
-	for i := 0; i < len(keys); i++ {
-		backendKey, backendVal, err := this.converter.ForwardConvert(keys[i], values[i])
-		if err != nil {
-			errs[i] = err
-			continue
+		if len(backendKeys) > 0 {
+			backendErrs := this.backend.SetBatch(backendKeys, backendVals)
+			for j, i := range idxMap {
+				if j < len(backendErrs) {
+					errs[i] = backendErrs[j]
+				}
+			}
 		}
-		backendKeys = append(backendKeys, backendKey)
-		backendVals = append(backendVals, backendVal)
-		idxMap = append(idxMap, i)
 	}
 
-	if len(backendKeys) == 0 {
-		return errs
-	}
-	backendErrs := this.backend.SetBatch(backendKeys, backendVals)
-	for j, i := range idxMap {
-		if j < len(backendErrs) {
-			errs[i] = backendErrs[j]
+	for i := range keys {
+		if errs[i] != nil {
+			this.cache.Delete(keys[i])
+			continue
 		}
+		this.indexSet(keys[i], values[i])
 	}
 	return errs
 }
 
+// Delete deletes the record from the cache and the backend, nothing is deleted if the key can't be
+// converted, and the indexes are only updated once the backend has deleted it.
 func (this *CachedStore[K0, V0, K1, V1]) Delete(key K0) error {
-	if err := this.cache.Delete(key); err != nil {
-		return err
-	}
-
-	backendKey, _, err := this.converter.ForwardConvert(key, this.zero)
-	if err == nil && this.backend != nil {
-		return this.backend.Delete(backendKey)
-	}
-	return nil
+	defer this.lockIndexes()()
+	return this.delete(key)
 }
 
 func (this *CachedStore[K0, V0, K1, V1]) DeleteBatch(keys []K0) []error {
+	defer this.lockIndexes()()
+
 	errs := make([]error, len(keys))
 	for i, key := range keys {
-		errs[i] = this.cache.Delete(key)
-		backendKey, _, err := this.converter.ForwardConvert(key, this.zero)
-		if err == nil && this.backend != nil {
-			errs[i] = this.backend.Delete(backendKey)
-		}
+		errs[i] = this.delete(key)
 	}
 	return errs
 }
 
+func (this *CachedStore[K0, V0, K1, V1]) delete(key K0) error {
+	var backendKey K1
+	if this.backend != nil {
+		var err error
+		if backendKey, _, err = this.converter.ForwardConvert(key, this.zero); err != nil {
+			return err
+		}
+	}
+
+	if err := this.cache.Delete(key); err != nil {
+		return err
+	}
+
+	if this.backend != nil {
+		if err := this.backend.Delete(backendKey); err != nil {
+			return err
+		}
+	}
+	this.indexRemove(key)
+	return nil
+}
+
 func (this *CachedStore[K0, V0, K1, V1]) Query(target K0, predicate func(K0, V0) bool) ([]K0, []V0, []error) {
 	cacheKeys, cacheValues, cacheErrs := this.cache.Query(target, predicate)
 
@@ -1205,3 +1205,29 @@ func TestStoreWithParaBadgerDBBackend(t *testing.T) {
 	})
 	runStoreWithByteBackend(t, wrapParaBadgerByteBackend(db), "ParaBadgerDB")
 }
+
+func TestStoreStructValues(t *testing.T) {
+	type record struct {
+		Owner  string
+		Amount uint64
+	}
+
+	backend := memdb.NewMemoryDB()
+	codec := stgcodec.NewStorageCodec[string, record, string, []byte](nil, nil)
+	store := NewCachedStore[string, record, string, []byte](backend, codec, 1024, func(record) uint64 { return 1 })
+
+	if err := store.Set("r1", record{"alice", 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read back from the backend, not the cache.
+	store.Cache().Clear()
+	value, err := store.Get("r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value.(record) != (record{"alice", 10}) {
+		t.Fatalf("expected the record back, got %+v", value)
+	}
+}
@@ -0,0 +1,300 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package changefeed provides a store decorator that publishes the changes made through it,
+// so the indexers and caches built on top of a store can follow it instead of polling.
+//
+// The writes are buffered as pending events and published together when the block is committed
+// with Commit(height). The published events are retained in a bounded log, and every event has a
+// sequence number that subscribers can use as a cursor to resume from after they lag or resubscribe.
+// The log is only kept in memory, so a new feed starts over from seq 1, and the cursors of an earlier
+// feed aren't valid with it. A subscriber outliving the feed has to rebuild its state from the store.
+package changefeed
+
+import (
+	"errors"
+	"sync"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	DEFAULT_RETENTION    = 65536 // The number of published events kept for the subscribers to catch up.
+	DEFAULT_WATCH_BUFFER = 256   // The capacity of the subscription channels.
+	OP_SET               = OpType(0)
+	OP_DELETE            = OpType(1)
+)
+
+var (
+	ErrCursorExpired = errors.New("changefeed: the cursor is older than the retained events")
+	ErrUnknownCursor = errors.New("changefeed: the cursor is ahead of the published events")
+	ErrLagged        = errors.New("changefeed: the subscriber fell behind the retained events")
+	ErrFeedClosed    = errors.New("changefeed: the feed is closed")
+)
+
+type OpType uint8
+
+// Event is a change made to a key. Old is only valid if Existed is true.
+type Event[V any] struct {
+	Seq     uint64 // The sequence number of the event, starting from 1, which is also the cursor to resume from.
+	Height  uint64 // The height of the commit the event belongs to.
+	Op      OpType
+	Key     string
+	Old     V
+	Existed bool
+	New     V // The zero value for the deletions.
+}
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Feed[[]byte])(nil)
+
+// Feed is a ReadWriteStore decorator that publishes the changes made through it. All the writes
+// to the underlying store need to go through the feed for the events to be complete.
+type Feed[V any] struct {
+	inner stgintf.ReadWriteStore[string, V]
+
+	lock    sync.Mutex // Serializes the writes, so the old values in the events are accurate.
+	pending []Event[V] // Written but not committed yet
+
+	logLock sync.RWMutex
+	log     []Event[V] // A ring buffer of the published events, the event with seq n is at n % len(log).
+	first   uint64     // The seq of the oldest retained event.
+	next    uint64     // The seq of the next event to publish.
+	height  uint64     // The last committed height.
+	notify  chan struct{}
+	closed  bool
+}
+
+// NewFeed creates a feed over the store, retaining the given number of published events.
+func NewFeed[V any](inner stgintf.ReadWriteStore[string, V], retention ...int) *Feed[V] {
+	size := DEFAULT_RETENTION
+	if len(retention) > 0 && retention[0] > 0 {
+		size = retention[0]
+	}
+
+	return &Feed[V]{
+		inner:  inner,
+		log:    make([]Event[V], size),
+		first:  1,
+		next:   1,
+		notify: make(chan struct{}),
+	}
+}
+
+func (this *Feed[V]) Has(key string) bool                     { return this.inner.Has(key) }
+func (this *Feed[V]) Get(key string) (any, error)             { return this.inner.Get(key) }
+func (this *Feed[V]) GetAs(key string, hint any) (any, error) { return this.inner.GetAs(key, hint) }
+func (this *Feed[V]) GetBatch(keys []string) ([]any, []error) { return this.inner.GetBatch(keys) }
+
+func (this *Feed[V]) Query(pattern string, checker func(string, V) bool) ([]string, []V, []error) {
+	return this.inner.Query(pattern, checker)
+}
+
+func (this *Feed[V]) Set(key string, value V) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old, existed, err := stgintf.Lookup(this.inner, key)
+	if err != nil {
+		return err
+	}
+
+	if err := this.inner.Set(key, value); err != nil {
+		return err
+	}
+	this.pending = append(this.pending, Event[V]{Op: OP_SET, Key: key, Old: old, Existed: existed, New: value})
+	return nil
+}
+
+// SetBatch writes the keys, nothing is written if the old values can't be read. A key written more than
+// once in the batch has the value of its previous write as the old one.
+func (this *Feed[V]) SetBatch(keys []string, values []V) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	olds, existed, err := stgintf.LookupBatch(this.inner, keys)
+	if err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	errs := this.inner.SetBatch(keys, values)
+	written := map[string]int{} // The index of the last write of the keys in the batch
+	for i, key := range keys {
+		if len(errs) > 0 && errs[i] != nil {
+			continue
+		}
+
+		if j, ok := written[key]; ok {
+			olds[i], existed[i] = values[j], true
+		}
+		written[key] = i
+		this.pending = append(this.pending, Event[V]{Op: OP_SET, Key: key, Old: olds[i], Existed: existed[i], New: values[i]})
+	}
+	return errs
+}
+
+func (this *Feed[V]) Delete(key string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old, existed, err := stgintf.Lookup(this.inner, key)
+	if err != nil {
+		return err
+	}
+
+	if err := this.inner.Delete(key); err != nil {
+		return err
+	}
+
+	if existed { // Deleting a missing key changes nothing.
+		this.pending = append(this.pending, Event[V]{Op: OP_DELETE, Key: key, Old: old, Existed: true})
+	}
+	return nil
+}
+
+// DeleteBatch deletes the keys, nothing is deleted if the old values can't be read.
+func (this *Feed[V]) DeleteBatch(keys []string) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	olds, existed, err := stgintf.LookupBatch(this.inner, keys)
+	if err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	errs := this.inner.DeleteBatch(keys)
+	deleted := map[string]bool{} // A key deleted more than once in the batch only changes once.
+	for i, key := range keys {
+		if (len(errs) > 0 && errs[i] != nil) || !existed[i] || deleted[key] {
+			continue
+		}
+		deleted[key] = true
+		this.pending = append(this.pending, Event[V]{Op: OP_DELETE, Key: key, Old: olds[i], Existed: true})
+	}
+	return errs
+}
+
+// Commit publishes the pending events with the height. A key changed more than once in the
+// same block produces one event per change.
+func (this *Feed[V]) Commit(height uint64) error {
+	this.lock.Lock()
+	pending := this.pending
+	this.pending = nil
+	this.lock.Unlock()
+
+	this.logLock.Lock()
+	defer this.logLock.Unlock()
+
+	if this.closed {
+		return ErrFeedClosed
+	}
+
+	for i := range pending {
+		pending[i].Seq, pending[i].Height = this.next, height
+		this.log[this.next%uint64(len(this.log))] = pending[i]
+		this.next++
+	}
+
+	if this.next-this.first > uint64(len(this.log)) { // Evicted the oldest ones.
+		this.first = this.next - uint64(len(this.log))
+	}
+	this.height = height
+
+	close(this.notify) // Wake up all the subscribers.
+	this.notify = make(chan struct{})
+	return nil
+}
+
+// Height returns the last committed height.
+func (this *Feed[V]) Height() uint64 {
+	this.logLock.RLock()
+	defer this.logLock.RUnlock()
+	return this.height
+}
+
+// Cursor returns the seq of the last published event.
+func (this *Feed[V]) Cursor() uint64 {
+	this.logLock.RLock()
+	defer this.logLock.RUnlock()
+	return this.next - 1
+}
+
+// Watch subscribes to the events of the keys with the prefix published from now on.
+// An empty prefix matches all the keys.
+func (this *Feed[V]) Watch(prefix string, buffer ...int) (*Subscription[V], error) {
+	return this.WatchFrom(prefix, this.Cursor(), buffer...)
+}
+
+// WatchFrom subscribes to the events of the keys with the prefix published after the cursor,
+// which is normally the Cursor() of an earlier subscription. It fails with ErrCursorExpired if
+// some of the events after the cursor are no longer retained.
+func (this *Feed[V]) WatchFrom(prefix string, cursor uint64, buffer ...int) (*Subscription[V], error) {
+	size := DEFAULT_WATCH_BUFFER
+	if len(buffer) > 0 && buffer[0] > 0 {
+		size = buffer[0]
+	}
+
+	this.logLock.RLock()
+	defer this.logLock.RUnlock()
+
+	if this.closed {
+		return nil, ErrFeedClosed
+	}
+
+	if cursor+1 < this.first {
+		return nil, ErrCursorExpired
+	}
+
+	if cursor >= this.next {
+		return nil, ErrUnknownCursor
+	}
+	return newSubscription(this, prefix, cursor, size), nil
+}
+
+// Close stops all the subscriptions, the underlying store isn't closed.
+func (this *Feed[V]) Close() error {
+	this.logLock.Lock()
+	defer this.logLock.Unlock()
+
+	if !this.closed {
+		this.closed = true
+		close(this.notify)
+	}
+	return nil
+}
+
+// read returns the retained events after the cursor, and the channel to wait on if there are none.
+func (this *Feed[V]) read(cursor uint64, limit int) ([]Event[V], <-chan struct{}, error) {
+	this.logLock.RLock()
+	defer this.logLock.RUnlock()
+
+	if cursor+1 < this.first {
+		return nil, nil, ErrLagged
+	}
+
+	if cursor+1 == this.next {
+		if this.closed {
+			return nil, nil, ErrFeedClosed
+		}
+		return nil, this.notify, nil
+	}
+
+	events := make([]Event[V], 0, min(uint64(limit), this.next-cursor-1))
+	for seq := cursor + 1; seq < this.next && len(events) < limit; seq++ {
+		events = append(events, this.log[seq%uint64(len(this.log))])
+	}
+	return events, nil, nil
+}
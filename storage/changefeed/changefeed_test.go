@@ -0,0 +1,299 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package changefeed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+	"github.com/arcology-network/common-lib/storage/indexer"
+	"github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+)
+
+func receive[V any](t *testing.T, sub *Subscription[V], n int) []Event[V] {
+	t.Helper()
+	events := make([]Event[V], 0, n)
+	for len(events) < n {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription ended after %d events: %v", len(events), sub.Err())
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d events", len(events))
+		}
+	}
+	return events
+}
+
+func TestFeedEvents(t *testing.T) {
+	feed := NewFeed[[]byte](memdb.NewMemoryDB())
+	defer feed.Close()
+
+	all, _ := feed.Watch("")
+	accounts, _ := feed.Watch("acct/")
+
+	feed.Set("acct/1", []byte{1})
+	feed.SetBatch([]string{"acct/2", "blk/1"}, [][]byte{{2}, {10}})
+	feed.Delete("missing") // No event for a missing key
+	feed.Commit(1)
+
+	feed.Set("acct/1", []byte{11})
+	feed.DeleteBatch([]string{"acct/2", "missing"})
+	feed.Commit(2)
+
+	events := receive(t, all, 5)
+	if events[0].Key != "acct/1" || events[0].Existed || !bytes.Equal(events[0].New, []byte{1}) || events[0].Height != 1 {
+		t.Fatal("unexpected event", events[0])
+	}
+	if events[3].Key != "acct/1" || !events[3].Existed || !bytes.Equal(events[3].Old, []byte{1}) || events[3].Height != 2 {
+		t.Fatal("unexpected event", events[3])
+	}
+	if events[4].Op != OP_DELETE || events[4].Key != "acct/2" || !bytes.Equal(events[4].Old, []byte{2}) {
+		t.Fatal("unexpected event", events[4])
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+1) {
+			t.Fatal("unexpected seq", event)
+		}
+	}
+
+	filtered := receive(t, accounts, 4)
+	for _, event := range filtered {
+		if event.Key == "blk/1" {
+			t.Fatal("the prefix wasn't applied")
+		}
+	}
+	if feed.Height() != 2 || feed.Cursor() != 5 {
+		t.Fatal("unexpected feed position", feed.Height(), feed.Cursor())
+	}
+
+	all.Close()
+	for range all.Events() {
+	}
+	if all.Err() != nil {
+		t.Fatal("expected no error after Close", all.Err())
+	}
+}
+
+func TestFeedOldValues(t *testing.T) {
+	failing := faulty.NewStore[string, []byte](memdb.NewMemoryDB(), faulty.Config{
+		Rules: []faulty.Rule{{Ops: []string{faulty.OP_GET, faulty.OP_GET_BATCH}, Keys: regexp.MustCompile("^bad"), Probability: 1}},
+	})
+	feed := NewFeed[[]byte](failing)
+	defer feed.Close()
+	all, _ := feed.Watch("")
+
+	// A failed read of the old value isn't taken for a missing key, and nothing is written.
+	if err := feed.Set("bad/1", []byte{1}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the read error, got", err)
+	}
+	if errs := feed.SetBatch([]string{"ok/1", "bad/2"}, [][]byte{{1}, {2}}); !errors.Is(errs[0], faulty.ErrInjected) {
+		t.Fatal("expected the read error, got", errs)
+	}
+	if errs := feed.DeleteBatch([]string{"bad/3"}); !errors.Is(errs[0], faulty.ErrInjected) {
+		t.Fatal("expected the read error, got", errs)
+	}
+	if failing.Has("ok/1") {
+		t.Fatal("the batch was written without its old values")
+	}
+
+	// A key repeated in a batch has the value of its previous write as the old one.
+	feed.SetBatch([]string{"ok/1", "ok/1", "ok/1"}, [][]byte{{1}, {2}, {3}})
+	feed.DeleteBatch([]string{"ok/1", "ok/1"})
+	feed.Commit(1)
+
+	events := receive(t, all, 4)
+	if events[0].Existed || !events[1].Existed || !bytes.Equal(events[1].Old, []byte{1}) || !bytes.Equal(events[2].Old, []byte{2}) {
+		t.Fatal("unexpected events", events)
+	}
+	if events[3].Op != OP_DELETE || !bytes.Equal(events[3].Old, []byte{3}) || feed.Cursor() != 4 {
+		t.Fatal("unexpected events", events)
+	}
+
+	// The stores reporting the missing keys of a batch with their own errors.
+	db, err := pebbledb.NewPebbleDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	pebbleFeed := NewFeed[[]byte](db)
+	if errs := pebbleFeed.SetBatch([]string{"a", "b"}, [][]byte{{1}, {2}}); errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if len(pebbleFeed.pending) != 2 || pebbleFeed.pending[0].Existed {
+		t.Fatal("unexpected events", pebbleFeed.pending)
+	}
+}
+
+func TestFeedResumeAndLag(t *testing.T) {
+	feed := NewFeed[[]byte](memdb.NewMemoryDB(), 8)
+
+	for i := 0; i < 6; i++ {
+		feed.Set(fmt.Sprint(i), []byte{byte(i)})
+	}
+	feed.Commit(1)
+
+	// Resume from the middle of the retained events.
+	sub, err := feed.WatchFrom("", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events := receive(t, sub, 3); events[0].Key != "3" || events[2].Key != "5" {
+		t.Fatal("unexpected events", events)
+	}
+	sub.Close()
+
+	// A subscriber that doesn't read gets lagged once its events are evicted.
+	slow, _ := feed.Watch("", 1)
+	for i := 0; i < 20; i++ {
+		feed.Set(fmt.Sprint(i), []byte{byte(i)})
+		feed.Commit(uint64(i + 2))
+	}
+	for range slow.Events() {
+	}
+	if slow.Err() != ErrLagged {
+		t.Fatal("expected ErrLagged, got", slow.Err())
+	}
+
+	if _, err := feed.WatchFrom("", 1); err != ErrCursorExpired {
+		t.Fatal("expected ErrCursorExpired, got", err)
+	}
+	if _, err := feed.WatchFrom("", 100); err != ErrUnknownCursor {
+		t.Fatal("expected ErrUnknownCursor, got", err)
+	}
+
+	live, _ := feed.Watch("")
+	feed.Close()
+	for range live.Events() {
+	}
+	if live.Err() != ErrFeedClosed {
+		t.Fatal("expected ErrFeedClosed, got", live.Err())
+	}
+}
+
+func TestFeedReopen(t *testing.T) {
+	store := memdb.NewMemoryDB()
+	feed := NewFeed[[]byte](store)
+	feed.SetBatch([]string{"a", "b", "c"}, [][]byte{{1}, {2}, {3}})
+	feed.Commit(1)
+	cursor := feed.Cursor()
+	feed.Close()
+
+	// The events aren't persisted, the reopened feed starts over and rejects the earlier cursors.
+	reopened := NewFeed[[]byte](store)
+	defer reopened.Close()
+	if reopened.Cursor() != 0 || reopened.Height() != 0 {
+		t.Fatal("expected an empty log, got", reopened.Cursor(), reopened.Height())
+	}
+	if _, err := reopened.WatchFrom("", cursor); err != ErrUnknownCursor {
+		t.Fatal("expected ErrUnknownCursor, got", err)
+	}
+
+	// The old values still come from the store.
+	sub, _ := reopened.Watch("")
+	reopened.Set("a", []byte{11})
+	reopened.Commit(2)
+	if events := receive(t, sub, 1); events[0].Seq != 1 || !events[0].Existed || !bytes.Equal(events[0].Old, []byte{1}) {
+		t.Fatal("unexpected event", events[0])
+	}
+}
+
+type balance struct {
+	Account string
+	Amount  uint64
+}
+
+func TestFeedSyncIndexers(t *testing.T) {
+	feed := NewFeed[[]byte](memdb.NewMemoryDB())
+	toBalance := func(key string, v []byte) *balance { return &balance{Account: key, Amount: uint64(v[0])} }
+
+	// Sorted index by amount
+	var lock sync.Mutex
+	sorted := indexer.NewSortedIndex("amount", func(a, b *balance) bool {
+		if a.Amount == b.Amount {
+			return a.Account < b.Account
+		}
+		return a.Amount < b.Amount
+	})
+	sortedSub, _ := feed.Watch("")
+	sortedErrs := Follow(sortedSub, SyncSortedIndex(sorted, &lock, toBalance))
+
+	// Queryable table
+	db, err := memdb.NewQueryableCache(nil, memdb.NewTable("balance",
+		memdb.NewIndex("Account", true, true, new(string)),
+		memdb.NewIndex("Amount", false, false, new(uint64)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	querySub, _ := feed.Watch("")
+	queryErrs := Follow(querySub, SyncQueryable(db, "balance", func(k string, v []byte) any { return toBalance(k, v) }))
+
+	// Unordered indexer counting the changes per account
+	var counterLock sync.Mutex
+	counter := indexer.NewUnorderedIndexer(0,
+		func(e Event[[]byte]) (string, bool) { return e.Key, true },
+		func(string, Event[[]byte]) int { return 1 },
+		func(_ string, _ Event[[]byte], v *int) { *v++ },
+	)
+	counterSub, _ := feed.Watch("")
+	counterErrs := Follow(counterSub, SyncUnorderedIndexer(counter, &counterLock, func(e Event[[]byte]) (Event[[]byte], bool) { return e, true }))
+
+	feed.SetBatch([]string{"alice", "bob", "carol"}, [][]byte{{30}, {10}, {20}})
+	feed.Commit(1)
+	feed.Set("bob", []byte{40})
+	feed.Delete("carol")
+	feed.Commit(2)
+
+	feed.Close()
+	for _, errc := range []<-chan error{sortedErrs, queryErrs, counterErrs} {
+		if err := <-errc; err != ErrFeedClosed {
+			t.Fatal("expected ErrFeedClosed, got", err)
+		}
+	}
+
+	got := []string{}
+	for _, b := range sorted.Export() {
+		got = append(got, fmt.Sprintf("%s:%d", b.Account, b.Amount))
+	}
+	if !reflect.DeepEqual(got, []string{"alice:30", "bob:40"}) {
+		t.Fatal("unexpected sorted index", got)
+	}
+
+	if rows, err := db.FindLessThan("balance", "Amount", uint64(100)); err != nil || len(rows) != 2 {
+		t.Fatal("unexpected rows", rows, err)
+	}
+	if row, err := db.FindFirst("balance", "id", "bob"); err != nil || row.(*balance).Amount != 40 {
+		t.Fatal("unexpected row", row, err)
+	}
+
+	if count, _, _ := counter.Get("bob"); count != 2 {
+		t.Fatal("expected 2 changes of bob, got", count)
+	}
+}
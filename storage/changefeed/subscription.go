@@ -0,0 +1,102 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package changefeed
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Subscription delivers the events of a feed through a bounded channel. A slow subscriber
+// never blocks the writers of the feed, it reads the events from the retained log at its own
+// pace instead. If it falls so far behind that the events it hasn't received are evicted, the
+// channel is closed and Err returns ErrLagged, and the subscriber can then resync its state and
+// watch again, or resume from Cursor with a feed that retains more events.
+type Subscription[V any] struct {
+	feed   *Feed[V]
+	prefix string
+	events chan Event[V]
+	cursor atomic.Uint64 // The seq of the last event processed, delivered or filtered out.
+
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // Only valid after the events channel is closed.
+}
+
+func newSubscription[V any](feed *Feed[V], prefix string, cursor uint64, buffer int) *Subscription[V] {
+	sub := &Subscription[V]{
+		feed:   feed,
+		prefix: prefix,
+		events: make(chan Event[V], buffer),
+		done:   make(chan struct{}),
+	}
+	sub.cursor.Store(cursor)
+	go sub.run()
+	return sub
+}
+
+// Events returns the channel of the events, which is closed when the subscription ends.
+func (this *Subscription[V]) Events() <-chan Event[V] { return this.events }
+
+// Cursor returns the seq of the last event processed by the subscription, which can be passed
+// to WatchFrom to resume from where the subscription has stopped. The events still buffered in
+// the channel count as processed, so a subscriber persisting its progress should record the Seq
+// of the last event it has handled instead.
+func (this *Subscription[V]) Cursor() uint64 { return this.cursor.Load() }
+
+// Err returns the reason why the subscription ended, nil if it was closed by the subscriber.
+// It should only be called after the events channel is closed.
+func (this *Subscription[V]) Err() error { return this.err }
+
+// Close ends the subscription. The events still in the channel are discarded.
+func (this *Subscription[V]) Close() {
+	this.closeOnce.Do(func() { close(this.done) })
+}
+
+func (this *Subscription[V]) run() {
+	defer close(this.events)
+
+	for {
+		events, wait, err := this.feed.read(this.cursor.Load(), cap(this.events))
+		if err != nil {
+			this.err = err
+			return
+		}
+
+		if wait != nil {
+			select {
+			case <-wait:
+				continue
+			case <-this.done:
+				return
+			}
+		}
+
+		for _, event := range events {
+			if strings.HasPrefix(event.Key, this.prefix) {
+				select {
+				case this.events <- event: // Blocks if the subscriber is slow.
+				case <-this.done:
+					return
+				}
+			}
+			this.cursor.Store(event.Seq)
+		}
+	}
+}
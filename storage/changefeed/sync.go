@@ -0,0 +1,100 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package changefeed
+
+import (
+	"sync"
+
+	"github.com/arcology-network/common-lib/storage/indexer"
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+// Follow applies the events of the subscription in the background, until the subscription ends
+// or the function fails. The returned channel receives the reason why it has stopped, which is
+// nil if the subscription was closed by the subscriber.
+func Follow[V any](sub *Subscription[V], apply func(Event[V]) error) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		for event := range sub.Events() {
+			if err := apply(event); err != nil {
+				sub.Close()
+				for range sub.Events() { // Drain to let the subscription exit.
+				}
+				errc <- err
+				return
+			}
+		}
+		errc <- sub.Err()
+	}()
+	return errc
+}
+
+// SyncSortedIndex returns a function for Follow that keeps a sorted index in sync with the feed.
+// The entry of the old value is removed from the index and the entry of the new one is added. The
+// index isn't thread safe, so the readers need to share the lock with the function.
+func SyncSortedIndex[V, T any](index *indexer.SortedIndex[T], lock sync.Locker, toEntry func(string, V) T) func(Event[V]) error {
+	return func(event Event[V]) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if event.Existed {
+			index.Remove([]T{toEntry(event.Key, event.Old)})
+		}
+
+		if event.Op == OP_SET {
+			index.Add([]T{toEntry(event.Key, event.New)})
+		}
+		return nil
+	}
+}
+
+// SyncQueryable returns a function for Follow that keeps a table of a QueryableMemoryDB in sync
+// with the feed, with the rows converted from the values. The old rows are looked up by their
+// primary keys, so a key must always map to the same primary key.
+func SyncQueryable[V any](db *memdb.QueryableMemoryDB, table string, toRow func(string, V) any) func(Event[V]) error {
+	return func(event Event[V]) error {
+		if event.Existed {
+			if err := db.Remove(table, toRow(event.Key, event.Old)); err != nil {
+				return err
+			}
+		}
+
+		if event.Op == OP_SET {
+			return db.Add(table, toRow(event.Key, event.New))
+		}
+		return nil
+	}
+}
+
+// SyncUnorderedIndexer returns a function for Follow that imports the transitions converted
+// from the events into an unordered indexer. The events with no corresponding transitions are
+// skipped. The indexer isn't thread safe, so the readers need to share the lock with the function.
+func SyncUnorderedIndexer[V any, K comparable, T, W any](
+	index *indexer.UnorderedIndexer[K, T, W],
+	lock sync.Locker,
+	toTransition func(Event[V]) (T, bool),
+) func(Event[V]) error {
+	return func(event Event[V]) error {
+		if transition, ok := toTransition(event); ok {
+			lock.Lock()
+			defer lock.Unlock()
+			index.Import([]T{transition})
+		}
+		return nil
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"reflect"
 	"unsafe"
 
+	"github.com/arcology-network/common-lib/storage/codec/orderedkey"
 	stgintf "github.com/arcology-network/common-lib/storage/interface"
 )
 
@@ -32,6 +33,14 @@ type Convertible interface {
 	stgintf.Key | []byte
 }
 
+// KeyEncoding is how the integer keys are converted to bytes and strings.
+type KeyEncoding uint8
+
+const (
+	KEY_ENCODING_LITTLE_ENDIAN = KeyEncoding(0) // Little endian in the widths of the keys, the encoding of the earlier versions.
+	KEY_ENCODING_ORDERED       = KeyEncoding(1) // orderedkey, the default, the byte ordered backends scan the keys in order.
+)
+
 type StorageCodec[K0 Convertible, V0 any, K1 Convertible, V1 any] struct {
 	// Converts the key and value from the cached  format to the backend format.
 	ForwardConvert func(K0, V0) (K1, V1, error)
@@ -40,27 +49,50 @@ type StorageCodec[K0 Convertible, V0 any, K1 Convertible, V1 any] struct {
 	BackwardConvert func(K1, V1) (K0, V0, error)
 }
 
+// NewStorageCodec creates a codec with the converters, the default ones are used for the nil ones.
+// The optional encoding is how the default converters encode the integer keys, ordered by default
+// so the byte ordered backends scan them in order. The stores written with the little endian keys
+// of the earlier versions need to be migrated with stgmigrate -rekey, or opened with a codec of
+// KEY_ENCODING_LITTLE_ENDIAN until they are.
 func NewStorageCodec[K0 Convertible, V0 any, K1 Convertible, V1 any](
 	forwardConvert func(K0, V0) (K1, V1, error),
 	backwardConvert func(K1, V1) (K0, V0, error),
+	encoding ...KeyEncoding,
 ) *StorageCodec[K0, V0, K1, V1] {
 	stgCodec := &StorageCodec[K0, V0, K1, V1]{
 		ForwardConvert:  forwardConvert,
 		BackwardConvert: backwardConvert,
 	}
 
+	keyEncoding := KEY_ENCODING_ORDERED
+	if len(encoding) > 0 {
+		keyEncoding = encoding[0]
+	}
+
 	if stgCodec.ForwardConvert == nil {
-		stgCodec.ForwardConvert = DefaultForwardConvert[K0, V0, K1, V1]
+		stgCodec.ForwardConvert = func(key K0, value V0) (K1, V1, error) {
+			return convert[K0, V0, K1, V1](key, value, keyEncoding)
+		}
 	}
 
 	if stgCodec.BackwardConvert == nil {
-		stgCodec.BackwardConvert = DefaultBackwardConvert[K0, V0, K1, V1]
+		stgCodec.BackwardConvert = func(key K1, value V1) (K0, V0, error) {
+			return convert[K1, V1, K0, V0](key, value, keyEncoding)
+		}
 	}
 	return stgCodec
 }
 
 func DefaultForwardConvert[K0 Convertible, V0 any, K1 Convertible, V1 any](key K0, value V0) (K1, V1, error) {
-	convertedKey, err := DefaultForwardConvertKey[K0, K1](key)
+	return convert[K0, V0, K1, V1](key, value, KEY_ENCODING_ORDERED)
+}
+
+func DefaultBackwardConvert[K0 Convertible, V0 any, K1 Convertible, V1 any](key K1, value V1) (K0, V0, error) {
+	return convert[K1, V1, K0, V0](key, value, KEY_ENCODING_ORDERED)
+}
+
+func convert[K0 Convertible, V0 any, K1 Convertible, V1 any](key K0, value V0, encoding KeyEncoding) (K1, V1, error) {
+	convertedKey, err := ConvertKey[K0, K1](key, encoding)
 	if err != nil {
 		return zero[K1](), zero[V1](), err
 	}
@@ -72,20 +104,13 @@ func DefaultForwardConvert[K0 Convertible, V0 any, K1 Convertible, V1 any](key K
 	return convertedKey, convertedValue, nil
 }
 
-func DefaultBackwardConvert[K0 Convertible, V0 any, K1 Convertible, V1 any](key K1, value V1) (K0, V0, error) {
-	convertedKey, err := DefaultForwardConvertKey[K1, K0](key)
-	if err != nil {
-		return zero[K0](), zero[V0](), err
-	}
-
-	convertedValue, err := DefaultForwardConvertValue[V1, V0](value)
-	if err != nil {
-		return zero[K0](), zero[V0](), err
-	}
-	return convertedKey, convertedValue, nil
+func DefaultForwardConvertKey[K0, K1 Convertible](key K0) (K1, error) {
+	return ConvertKey[K0, K1](key, KEY_ENCODING_ORDERED)
 }
 
-func DefaultForwardConvertKey[K0, K1 Convertible](key K0) (K1, error) {
+// ConvertKey converts the key to another type, with the integer keys encoded in the encoding if the
+// target is a string or bytes.
+func ConvertKey[K0, K1 Convertible](key K0, encoding KeyEncoding) (K1, error) {
 	if converted, ok := any(key).(K1); ok {
 		return converted, nil
 	}
@@ -95,12 +120,26 @@ func DefaultForwardConvertKey[K0, K1 Convertible](key K0) (K1, error) {
 		return converted, nil
 	}
 
-	raw, err := keyToBytes(source)
+	raw, err := keyToBytes(source, encoding)
 	if err != nil {
 		return zero[K1](), err
 	}
 
-	return bytesToKey[K1](raw)
+	return bytesToKey[K1](raw, encoding)
+}
+
+// ReencodeKey converts a key of K stored in one encoding to another, for migrating the stores.
+func ReencodeKey[K Convertible](key string, from, to KeyEncoding) (string, error) {
+	decoded, err := bytesToKey[K]([]byte(key), from)
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := keyToBytes(decoded, to)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
 }
 
 func convertNumericToTarget[K Convertible](value any) (K, bool) {
@@ -209,7 +248,7 @@ func asUnsignedUint64(value any) (uint64, bool) {
 	}
 }
 
-func keyToBytes(value any) ([]byte, error) {
+func keyToBytes(value any, encoding KeyEncoding) ([]byte, error) {
 	switch v := value.(type) {
 	case string:
 		if len(v) == 0 {
@@ -219,33 +258,33 @@ func keyToBytes(value any) ([]byte, error) {
 	case []byte:
 		return v, nil
 	case int:
-		return encodeSigned(int64(v), bits.UintSize), nil
+		return encodeKeySigned(int64(v), bits.UintSize, encoding), nil
 	case int8:
-		return encodeSigned(int64(v), 8), nil
+		return encodeKeySigned(int64(v), 8, encoding), nil
 	case int16:
-		return encodeSigned(int64(v), 16), nil
+		return encodeKeySigned(int64(v), 16, encoding), nil
 	case int32:
-		return encodeSigned(int64(v), 32), nil
+		return encodeKeySigned(int64(v), 32, encoding), nil
 	case int64:
-		return encodeSigned(v, 64), nil
+		return encodeKeySigned(v, 64, encoding), nil
 	case uint:
-		return encodeUnsigned(uint64(v), bits.UintSize), nil
+		return encodeKeyUnsigned(uint64(v), bits.UintSize, encoding), nil
 	case uint8:
-		return encodeUnsigned(uint64(v), 8), nil
+		return encodeKeyUnsigned(uint64(v), 8, encoding), nil
 	case uint16:
-		return encodeUnsigned(uint64(v), 16), nil
+		return encodeKeyUnsigned(uint64(v), 16, encoding), nil
 	case uint32:
-		return encodeUnsigned(uint64(v), 32), nil
+		return encodeKeyUnsigned(uint64(v), 32, encoding), nil
 	case uint64:
-		return encodeUnsigned(v, 64), nil
+		return encodeKeyUnsigned(v, 64, encoding), nil
 	case uintptr:
-		return encodeUnsigned(uint64(v), bits.UintSize), nil
+		return encodeKeyUnsigned(uint64(v), bits.UintSize, encoding), nil
 	default:
 		return nil, fmt.Errorf("unsupported key type %T", value)
 	}
 }
 
-func bytesToKey[K Convertible](raw []byte) (K, error) {
+func bytesToKey[K Convertible](raw []byte, encoding KeyEncoding) (K, error) {
 	var target K
 	switch any(target).(type) {
 	case string:
@@ -256,37 +295,37 @@ func bytesToKey[K Convertible](raw []byte) (K, error) {
 	case []byte:
 		return any(bytes.Clone(raw)).(K), nil
 	case int:
-		decoded, err := decodeSigned(raw, bits.UintSize)
+		decoded, err := decodeKeySigned(raw, bits.UintSize, encoding)
 		return any(int(decoded)).(K), err
 	case int8:
-		decoded, err := decodeSigned(raw, 8)
+		decoded, err := decodeKeySigned(raw, 8, encoding)
 		return any(int8(decoded)).(K), err
 	case int16:
-		decoded, err := decodeSigned(raw, 16)
+		decoded, err := decodeKeySigned(raw, 16, encoding)
 		return any(int16(decoded)).(K), err
 	case int32:
-		decoded, err := decodeSigned(raw, 32)
+		decoded, err := decodeKeySigned(raw, 32, encoding)
 		return any(int32(decoded)).(K), err
 	case int64:
-		decoded, err := decodeSigned(raw, 64)
+		decoded, err := decodeKeySigned(raw, 64, encoding)
 		return any(decoded).(K), err
 	case uint:
-		decoded, err := decodeUnsigned(raw, bits.UintSize)
+		decoded, err := decodeKeyUnsigned(raw, bits.UintSize, encoding)
 		return any(uint(decoded)).(K), err
 	case uint8:
-		decoded, err := decodeUnsigned(raw, 8)
+		decoded, err := decodeKeyUnsigned(raw, 8, encoding)
 		return any(uint8(decoded)).(K), err
 	case uint16:
-		decoded, err := decodeUnsigned(raw, 16)
+		decoded, err := decodeKeyUnsigned(raw, 16, encoding)
 		return any(uint16(decoded)).(K), err
 	case uint32:
-		decoded, err := decodeUnsigned(raw, 32)
+		decoded, err := decodeKeyUnsigned(raw, 32, encoding)
 		return any(uint32(decoded)).(K), err
 	case uint64:
-		decoded, err := decodeUnsigned(raw, 64)
+		decoded, err := decodeKeyUnsigned(raw, 64, encoding)
 		return any(decoded).(K), err
 	case uintptr:
-		decoded, err := decodeUnsigned(raw, bits.UintSize)
+		decoded, err := decodeKeyUnsigned(raw, bits.UintSize, encoding)
 		return any(uintptr(decoded)).(K), err
 	default:
 		return zero[K](), fmt.Errorf("unsupported key conversion target %T", target)
@@ -343,6 +382,11 @@ func valueToBytes(value reflect.Value) ([]byte, error) {
 		return encodeUnsigned(uint64(math.Float32bits(float32(value.Float()))), 32), nil
 	case reflect.Float64:
 		return encodeUnsigned(math.Float64bits(value.Float()), 64), nil
+	case reflect.Struct, reflect.Pointer:
+		if encoded, ok, err := structToBytes(value); ok {
+			return encoded, err
+		}
+		return nil, fmt.Errorf("unsupported value conversion from %T to []byte", value.Interface())
 	default:
 		return nil, fmt.Errorf("unsupported value conversion from %T to []byte", value.Interface())
 	}
@@ -393,6 +437,16 @@ func bytesToValue[T any](raw []byte) (T, error) {
 		} else {
 			return zero[T](), fmt.Errorf("unsupported []byte conversion target %T", target)
 		}
+	case reflect.Struct, reflect.Pointer:
+		decoded, ok, err := bytesToStruct(raw, targetType)
+		if !ok {
+			return zero[T](), fmt.Errorf("unsupported []byte conversion target %T", target)
+		}
+
+		if err != nil {
+			return zero[T](), err
+		}
+		value = decoded
 	default:
 		return zero[T](), fmt.Errorf("unsupported []byte conversion target %T", target)
 	}
@@ -458,6 +512,44 @@ func decodeUnsigned(raw []byte, bits int) (uint64, error) {
 	}
 }
 
+func encodeKeySigned(value int64, bits int, encoding KeyEncoding) []byte {
+	if encoding == KEY_ENCODING_ORDERED {
+		return orderedkey.AppendInt(nil, value, bits)
+	}
+	return encodeSigned(value, bits)
+}
+
+func encodeKeyUnsigned(value uint64, bits int, encoding KeyEncoding) []byte {
+	if encoding == KEY_ENCODING_ORDERED {
+		return orderedkey.AppendUint(nil, value, bits)
+	}
+	return encodeUnsigned(value, bits)
+}
+
+func decodeKeySigned(raw []byte, bits int, encoding KeyEncoding) (int64, error) {
+	if encoding != KEY_ENCODING_ORDERED {
+		return decodeSigned(raw, bits)
+	}
+
+	decoded, rest, err := orderedkey.ReadInt(raw, bits)
+	if err == nil && len(rest) != 0 {
+		err = fmt.Errorf("invalid numeric encoding length %d for %d-bit value", len(raw), bits)
+	}
+	return decoded, err
+}
+
+func decodeKeyUnsigned(raw []byte, bits int, encoding KeyEncoding) (uint64, error) {
+	if encoding != KEY_ENCODING_ORDERED {
+		return decodeUnsigned(raw, bits)
+	}
+
+	decoded, rest, err := orderedkey.ReadUint(raw, bits)
+	if err == nil && len(rest) != 0 {
+		err = fmt.Errorf("invalid numeric encoding length %d for %d-bit value", len(raw), bits)
+	}
+	return decoded, err
+}
+
 func zero[T any]() T {
 	var v T
 	return v
@@ -81,38 +81,65 @@ func TestAsSignedInt64AndUnsignedUint64(t *testing.T) {
 
 func TestKeyToBytesAndBytesToKey(t *testing.T) {
 	// string
-	b, err := keyToBytes("abc")
+	b, err := keyToBytes("abc", KEY_ENCODING_LITTLE_ENDIAN)
 	if err != nil || string(b) != "abc" {
 		t.Fatalf("keyToBytes string: %v %v", b, err)
 	}
 	// []byte
-	b2, err := keyToBytes([]byte{1, 2})
+	b2, err := keyToBytes([]byte{1, 2}, KEY_ENCODING_LITTLE_ENDIAN)
 	if err != nil || b2[0] != 1 {
 		t.Fatalf("keyToBytes []byte: %v %v", b2, err)
 	}
 	// int
-	b3, err := keyToBytes(int16(-2))
+	b3, err := keyToBytes(int16(-2), KEY_ENCODING_LITTLE_ENDIAN)
 	if err != nil || len(b3) == 0 {
 		t.Fatalf("keyToBytes int: %v %v", b3, err)
 	}
 	// error path
-	_, err = keyToBytes(3.14)
+	_, err = keyToBytes(3.14, KEY_ENCODING_LITTLE_ENDIAN)
 	if err == nil {
 		t.Fatal("expected error for float")
 	}
 
 	// bytesToKey
-	k, err := bytesToKey[string]([]byte("abc"))
+	k, err := bytesToKey[string]([]byte("abc"), KEY_ENCODING_LITTLE_ENDIAN)
 	if err != nil || k != "abc" {
 		t.Fatalf("bytesToKey string: %v %v", k, err)
 	}
-	k3, err := bytesToKey[int16]([]byte{0xfe, 0xff}) // -2 in little endian
+	k3, err := bytesToKey[int16]([]byte{0xfe, 0xff}, KEY_ENCODING_LITTLE_ENDIAN) // -2 in little endian
 	if err != nil || k3 != -2 {
 		t.Fatalf("bytesToKey int16: %v %v", k3, err)
 	}
 	// error path: float32 is not a valid Key type, so skip this test
 }
 
+func TestOrderedKeyEncoding(t *testing.T) {
+	ordered := NewStorageCodec[int16, []byte, string, []byte](nil, nil) // Ordered by default
+	key, _, err := ordered.ForwardConvert(-2, nil)
+	if err != nil || key != "\x7f\xfe" {
+		t.Fatalf("ordered key: %x %v", key, err)
+	}
+	if decoded, _, err := ordered.BackwardConvert(key, nil); err != nil || decoded != -2 {
+		t.Fatalf("ordered key back: %v %v", decoded, err)
+	}
+
+	if key, err := DefaultForwardConvertKey[int16, string](-2); err != nil || key != "\x7f\xfe" {
+		t.Fatalf("default key: %x %v", key, err)
+	}
+
+	// The keys of the earlier versions are still readable, and can be migrated.
+	legacy, _, _ := NewStorageCodec[int16, []byte, string, []byte](nil, nil, KEY_ENCODING_LITTLE_ENDIAN).ForwardConvert(-2, nil)
+	if legacy != "\xfe\xff" {
+		t.Fatalf("legacy key: %x", legacy)
+	}
+	if migrated, err := ReencodeKey[int16](legacy, KEY_ENCODING_LITTLE_ENDIAN, KEY_ENCODING_ORDERED); err != nil || migrated != key {
+		t.Fatalf("migrated key: %x %v", migrated, err)
+	}
+	if _, err := ReencodeKey[int16]("abc", KEY_ENCODING_LITTLE_ENDIAN, KEY_ENCODING_ORDERED); err == nil {
+		t.Fatal("expected an error for a key of another width")
+	}
+}
+
 func TestDefaultConvertValuesAndValueToBytes(t *testing.T) {
 	// direct cast
 	v, err := DefaultForwardConvertKey[int, int](7)
@@ -0,0 +1,342 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package orderedkey encodes keys into bytes sorting in the same order as the keys, so the range
+// scans of the byte ordered stores like Pebble and Badger follow the order of the keys.
+//
+// A composite key is the concatenation of its encoded fields, compared field by field:
+//
+//	integers          big endian in their widths, with the sign bit flipped for the signed ones
+//	strings, []byte   0x00 escaped as 0x00 0xff, terminated by 0x00 0x00
+//	byte arrays       as is, since they have a fixed size
+//	uint256           32 bytes big endian
+//	bool              0x00 or 0x01
+//
+// A descending field is encoded with all the bits inverted.
+package orderedkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+
+	"github.com/holiman/uint256"
+)
+
+const UINT256_LEN = 32
+
+var (
+	ErrShortKey    = errors.New("orderedkey: key too short")
+	ErrInvalidKey  = errors.New("orderedkey: invalid key encoding")
+	ErrUnsupported = errors.New("orderedkey: unsupported type")
+)
+
+// Descending wraps a field to sort it in the descending order, the value is a field to encode or a
+// pointer to decode into.
+type Descending struct {
+	Value any
+}
+
+// Desc marks the field as descending in Encode and Decode.
+func Desc(v any) Descending { return Descending{v} }
+
+// Encode encodes the fields into a composite key.
+func Encode(fields ...any) ([]byte, error) {
+	var key []byte
+	for i, field := range fields {
+		var err error
+		if key, err = Append(key, field); err != nil {
+			return nil, fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+	return key, nil
+}
+
+// Append appends the encoded field to the key.
+func Append(dst []byte, field any) ([]byte, error) {
+	switch v := field.(type) {
+	case Descending:
+		start := len(dst)
+		dst, err := Append(dst, v.Value)
+		if err != nil {
+			return nil, err
+		}
+		invert(dst[start:])
+		return dst, nil
+	case string:
+		return AppendString(dst, v), nil
+	case []byte:
+		return AppendBytes(dst, v), nil
+	case bool:
+		if v {
+			return append(dst, 1), nil
+		}
+		return append(dst, 0), nil
+	case int:
+		return AppendInt(dst, int64(v), bits.UintSize), nil
+	case int8:
+		return AppendInt(dst, int64(v), 8), nil
+	case int16:
+		return AppendInt(dst, int64(v), 16), nil
+	case int32:
+		return AppendInt(dst, int64(v), 32), nil
+	case int64:
+		return AppendInt(dst, v, 64), nil
+	case uint:
+		return AppendUint(dst, uint64(v), bits.UintSize), nil
+	case uint8:
+		return AppendUint(dst, uint64(v), 8), nil
+	case uint16:
+		return AppendUint(dst, uint64(v), 16), nil
+	case uint32:
+		return AppendUint(dst, uint64(v), 32), nil
+	case uint64:
+		return AppendUint(dst, v, 64), nil
+	case uintptr:
+		return AppendUint(dst, uint64(v), bits.UintSize), nil
+	case *uint256.Int:
+		return AppendUint256(dst, v), nil
+	case uint256.Int:
+		return AppendUint256(dst, &v), nil
+	}
+
+	if value := reflect.ValueOf(field); value.Kind() == reflect.Array && value.Type().Elem().Kind() == reflect.Uint8 {
+		start := len(dst)
+		dst = append(dst, make([]byte, value.Len())...)
+		reflect.Copy(reflect.ValueOf(dst[start:]), value)
+		return dst, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupported, field)
+}
+
+// Decode decodes a composite key into the pointers to the fields, wrapped by Desc for the descending
+// ones. The key must be fully consumed.
+func Decode(key []byte, targets ...any) error {
+	for i, target := range targets {
+		var err error
+		if key, err = Read(key, target); err != nil {
+			return fmt.Errorf("field %d: %w", i, err)
+		}
+	}
+
+	if len(key) != 0 {
+		return fmt.Errorf("%w: %d bytes left", ErrInvalidKey, len(key))
+	}
+	return nil
+}
+
+// Read decodes the first field of the key into the target pointer, and returns the rest of the key.
+func Read(src []byte, target any) ([]byte, error) {
+	desc := false
+	if d, ok := target.(Descending); ok {
+		desc, target = true, d.Value
+	}
+
+	switch v := target.(type) {
+	case *string:
+		raw, rest, err := readEscaped(src, desc)
+		*v = string(raw)
+		return rest, err
+	case *[]byte:
+		raw, rest, err := readEscaped(src, desc)
+		*v = raw
+		return rest, err
+	case *bool:
+		raw, rest, err := readFixed(src, 1, desc)
+		if err == nil && raw[0] > 1 {
+			err = fmt.Errorf("%w: bool %d", ErrInvalidKey, raw[0])
+		}
+		if err == nil {
+			*v = raw[0] == 1
+		}
+		return rest, err
+	case *uint256.Int:
+		raw, rest, err := readFixed(src, UINT256_LEN, desc)
+		if err == nil {
+			v.SetBytes32(raw)
+		}
+		return rest, err
+	case *int, *int8, *int16, *int32, *int64:
+		width := int(reflect.TypeOf(target).Elem().Size())
+		raw, rest, err := readFixed(src, width, desc)
+		if err == nil {
+			reflect.ValueOf(target).Elem().SetInt(decodeInt(raw))
+		}
+		return rest, err
+	case *uint, *uint8, *uint16, *uint32, *uint64, *uintptr:
+		width := int(reflect.TypeOf(target).Elem().Size())
+		raw, rest, err := readFixed(src, width, desc)
+		if err == nil {
+			reflect.ValueOf(target).Elem().SetUint(decodeUint(raw))
+		}
+		return rest, err
+	}
+
+	if value := reflect.ValueOf(target); value.Kind() == reflect.Pointer && !value.IsNil() &&
+		value.Elem().Kind() == reflect.Array && value.Elem().Type().Elem().Kind() == reflect.Uint8 {
+		raw, rest, err := readFixed(src, value.Elem().Len(), desc)
+		if err == nil {
+			reflect.Copy(value.Elem(), reflect.ValueOf(raw))
+		}
+		return rest, err
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupported, target)
+}
+
+// AppendUint appends an unsigned integer in the width of the bits, which is 8, 16, 32 or 64.
+func AppendUint(dst []byte, v uint64, bits int) []byte {
+	switch bits {
+	case 8:
+		return append(dst, byte(v))
+	case 16:
+		return binary.BigEndian.AppendUint16(dst, uint16(v))
+	case 32:
+		return binary.BigEndian.AppendUint32(dst, uint32(v))
+	default:
+		return binary.BigEndian.AppendUint64(dst, v)
+	}
+}
+
+// AppendInt appends a signed integer in the width of the bits, the negatives sort first.
+func AppendInt(dst []byte, v int64, bits int) []byte {
+	bits = widthOf(bits) * 8
+	return AppendUint(dst, uint64(v)^(1<<(bits-1)), bits)
+}
+
+// ReadUint reads an unsigned integer in the width of the bits.
+func ReadUint(src []byte, bits int) (uint64, []byte, error) {
+	raw, rest, err := readFixed(src, widthOf(bits), false)
+	if err != nil {
+		return 0, nil, err
+	}
+	return decodeUint(raw), rest, nil
+}
+
+// ReadInt reads a signed integer in the width of the bits.
+func ReadInt(src []byte, bits int) (int64, []byte, error) {
+	raw, rest, err := readFixed(src, widthOf(bits), false)
+	if err != nil {
+		return 0, nil, err
+	}
+	return decodeInt(raw), rest, nil
+}
+
+// AppendString appends an escaped and terminated string.
+func AppendString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		dst = append(dst, s[i])
+		if s[i] == 0 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0, 0)
+}
+
+// AppendBytes appends escaped and terminated bytes.
+func AppendBytes(dst []byte, b []byte) []byte {
+	for _, c := range b {
+		dst = append(dst, c)
+		if c == 0 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0, 0)
+}
+
+// ReadBytes reads escaped and terminated bytes, the returned bytes are a copy.
+func ReadBytes(src []byte) ([]byte, []byte, error) { return readEscaped(src, false) }
+
+// AppendUint256 appends a 256 bit unsigned integer.
+func AppendUint256(dst []byte, v *uint256.Int) []byte {
+	b := v.Bytes32()
+	return append(dst, b[:]...)
+}
+
+// widthOf returns the number of bytes of an integer, the unknown widths are taken as 64 bits.
+func widthOf(bits int) int {
+	switch bits {
+	case 8, 16, 32:
+		return bits / 8
+	}
+	return 8
+}
+
+func decodeUint(raw []byte) uint64 {
+	var v uint64
+	for _, b := range raw {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// decodeInt decodes a signed integer in the width of the raw bytes.
+func decodeInt(raw []byte) int64 {
+	shift := 64 - 8*len(raw)
+	v := decodeUint(raw) ^ (1 << (8*len(raw) - 1))
+	return int64(v<<shift) >> shift // Sign extended
+}
+
+// readFixed returns a copy of the first n bytes, inverted back if descending.
+func readFixed(src []byte, n int, desc bool) ([]byte, []byte, error) {
+	if len(src) < n {
+		return nil, nil, fmt.Errorf("%w: %d bytes needed, %d left", ErrShortKey, n, len(src))
+	}
+
+	raw := append([]byte{}, src[:n]...)
+	if desc {
+		invert(raw)
+	}
+	return raw, src[n:], nil
+}
+
+func readEscaped(src []byte, desc bool) ([]byte, []byte, error) {
+	mask := byte(0)
+	if desc {
+		mask = 0xff
+	}
+
+	raw := []byte{}
+	for i := 0; i < len(src); i++ {
+		c := src[i] ^ mask
+		if c != 0 {
+			raw = append(raw, c)
+			continue
+		}
+
+		if i+1 >= len(src) {
+			break
+		}
+
+		switch src[i+1] ^ mask {
+		case 0:
+			return raw, src[i+2:], nil
+		case 0xff:
+			raw, i = append(raw, 0), i+1
+		default:
+			return nil, nil, fmt.Errorf("%w: bad escape 0x00 0x%02x", ErrInvalidKey, src[i+1]^mask)
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unterminated bytes", ErrShortKey)
+}
+
+func invert(b []byte) {
+	for i := range b {
+		b[i] = ^b[i]
+	}
+}
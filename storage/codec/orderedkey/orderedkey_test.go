@@ -0,0 +1,162 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package orderedkey_test
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	badgerdb "github.com/arcology-network/common-lib/storage/badger"
+	stgcodec "github.com/arcology-network/common-lib/storage/codec"
+	"github.com/arcology-network/common-lib/storage/codec/orderedkey"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+	"github.com/holiman/uint256"
+)
+
+type row struct {
+	from   string
+	height int64
+	nonce  uint32 // Descending
+	amount *uint256.Int
+	hash   [4]byte
+}
+
+func (this row) less(other row) bool {
+	switch {
+	case this.from != other.from:
+		return this.from < other.from
+	case this.height != other.height:
+		return this.height < other.height
+	case this.nonce != other.nonce:
+		return this.nonce > other.nonce
+	case !this.amount.Eq(other.amount):
+		return this.amount.Lt(other.amount)
+	}
+	return bytes.Compare(this.hash[:], other.hash[:]) < 0
+}
+
+func (this row) encode(t *testing.T) []byte {
+	key, err := orderedkey.Encode(this.from, this.height, orderedkey.Desc(this.nonce), this.amount, this.hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestCompositeKeyOrder(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	froms := []string{"", "a", "a\x00", "a\x00b", "a\x01", "ab", "b", "\xff"}
+	heights := []int64{math.MinInt64, -300, -1, 0, 1, 255, 256, math.MaxInt64}
+
+	rows := make([]row, 1000)
+	for i := range rows {
+		rows[i] = row{
+			from:   froms[random.Intn(len(froms))],
+			height: heights[random.Intn(len(heights))],
+			nonce:  uint32(random.Intn(3)),
+			amount: new(uint256.Int).Lsh(uint256.NewInt(uint64(random.Intn(3))), uint(random.Intn(200))),
+			hash:   [4]byte{byte(random.Intn(2)), 0, 0, byte(random.Intn(256))},
+		}
+	}
+
+	sorted := append([]row{}, rows...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+
+	keys := make([][]byte, len(rows))
+	for i := range rows {
+		keys[i] = rows[i].encode(t)
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+
+	for i := range sorted {
+		var decoded row
+		decoded.amount = new(uint256.Int)
+		err := orderedkey.Decode(keys[i], &decoded.from, &decoded.height, orderedkey.Desc(&decoded.nonce), decoded.amount, &decoded.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.less(sorted[i]) || sorted[i].less(decoded) {
+			t.Fatalf("unexpected row at %d: %+v, expected %+v", i, decoded, sorted[i])
+		}
+	}
+
+	if _, err := orderedkey.Encode(3.14); !errors.Is(err, orderedkey.ErrUnsupported) {
+		t.Fatal("expected ErrUnsupported, got", err)
+	}
+
+	var s string
+	if err := orderedkey.Decode([]byte("abc"), &s); !errors.Is(err, orderedkey.ErrShortKey) {
+		t.Fatal("expected ErrShortKey, got", err)
+	}
+
+	var n int32
+	if err := orderedkey.Decode([]byte{0, 0, 0, 0, 0}, &n); !errors.Is(err, orderedkey.ErrInvalidKey) {
+		t.Fatal("expected ErrInvalidKey, got", err)
+	}
+}
+
+// The ordered key encoding scans the integer keys in order on the byte ordered backends.
+func TestOrderedKeyScanOrder(t *testing.T) {
+	dir := t.TempDir()
+	pebble, err := pebbledb.NewPebbleDB(filepath.Join(dir, "pebble"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pebble.Close()
+
+	badger := badgerdb.NewBadgerDB(filepath.Join(dir, "badger"))
+	defer badger.Close()
+
+	codec := stgcodec.NewStorageCodec[int64, []byte, string, []byte](nil, nil, stgcodec.KEY_ENCODING_ORDERED)
+	expected := []int64{math.MinInt64, -70000, -256, -1, 0, 1, 255, 256, 70000, math.MaxInt64}
+	for name, store := range map[string]stgintf.ReadWriteStore[string, []byte]{"pebble": pebble, "badger": badger} {
+		for _, i := range rand.Perm(len(expected)) {
+			key, value, err := codec.ForwardConvert(expected[i], []byte{1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.Set(key, value)
+		}
+
+		keys, values, _ := store.Query("", func(string, []byte) bool { return true })
+		if len(keys) != len(expected) {
+			t.Fatalf("%s: expected %d keys, got %d", name, len(expected), len(keys))
+		}
+
+		got := make([]string, len(keys))
+		for i := range keys {
+			decoded, _, err := codec.BackwardConvert(keys[i], values[i])
+			if err != nil || decoded != expected[i] {
+				t.Fatalf("%s: expected %d at %d, got %d %v", name, expected[i], i, decoded, err)
+			}
+			got[i] = keys[i]
+		}
+
+		if !sort.StringsAreSorted(got) || strings.Compare(got[0], got[1]) >= 0 {
+			t.Fatalf("%s: keys not in order", name)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+/*
+*   Copyright (c) 2026 Arcology Network
+
+*   This program is free software: you can redistribute it and/or modify
+*   it under the terms of the GNU General Public License as published by
+*   the Free Software Foundation, either version 3 of the License, or
+*   (at your option) any later version.
+
+*   This program is distributed in the hope that it will be useful,
+*   but WITHOUT ANY WARRANTY; without even the implied warranty of
+*   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*   GNU General Public License for more details.
+
+*   You should have received a copy of the GNU General Public License
+*   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+)
+
+var (
+	ErrUnsupportedField = errors.New("codec: the type can't be encoded in a schema payload")
+	ErrBadPayload       = errors.New("codec: malformed schema payload")
+)
+
+// The payloads of the schemas are encoded field by field in the order of the struct, with nothing
+// but the values, so the same value always encodes to the same bytes:
+//
+//	bool                   1 byte
+//	int, uint kinds        varint, uvarint
+//	float kinds            big endian IEEE 754 bits, 4 or 8 bytes
+//	string, slice, map     uvarint length, then the bytes or the elements
+//	array, struct          the elements, or the fields in order
+//	pointer                0 for nil, or 1 and the value
+//	big.Int                the sign, 0 or 1, then the uvarint length and the magnitude in big endian
+//	binary marshalers      uvarint length, then MarshalBinary(), time.Time is one of them
+//	codec types            uvarint length, then Encode(), decoded with Decode()
+//
+// The map entries are sorted by their encoded keys. The structs with unexported fields, other than
+// the ones above, the interfaces, channels and functions aren't supported.
+
+var (
+	bigIntType            = reflect.TypeOf(big.Int{})
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	encoderType           = reflect.TypeOf((*encoder)(nil)).Elem()
+	decoderType           = reflect.TypeOf((*decoder)(nil)).Elem()
+)
+
+// encoder and decoder are the Encode() and Decode() pair of the types in the codec package.
+type encoder interface{ Encode() []byte }
+type decoder interface{ Decode([]byte) any }
+
+// encodePayload encodes the value without the schema header.
+func encodePayload(value reflect.Value) ([]byte, error) {
+	return appendValue(nil, value)
+}
+
+// decodePayload decodes a payload into a new value of the type.
+func decodePayload(payload []byte, typ reflect.Type) (reflect.Value, error) {
+	value := reflect.New(typ).Elem()
+	rest, err := readValue(payload, value)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if len(rest) != 0 {
+		return reflect.Value{}, fmt.Errorf("%w: %d trailing bytes", ErrBadPayload, len(rest))
+	}
+	return value, nil
+}
+
+func appendValue(buffer []byte, value reflect.Value) ([]byte, error) {
+	if value.Kind() != reflect.Pointer {
+		if buffer, ok, err := appendOpaque(buffer, value); ok {
+			return buffer, err
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		if value.Bool() {
+			return append(buffer, 1), nil
+		}
+		return append(buffer, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buffer, value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(buffer, value.Uint()), nil
+	case reflect.Float32:
+		return binary.BigEndian.AppendUint32(buffer, math.Float32bits(float32(value.Float()))), nil
+	case reflect.Float64:
+		return binary.BigEndian.AppendUint64(buffer, math.Float64bits(value.Float())), nil
+	case reflect.String:
+		return append(binary.AppendUvarint(buffer, uint64(value.Len())), value.String()...), nil
+	case reflect.Slice:
+		buffer = binary.AppendUvarint(buffer, uint64(value.Len()))
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return append(buffer, value.Bytes()...), nil
+		}
+		return appendElements(buffer, value)
+	case reflect.Array:
+		return appendElements(buffer, value)
+	case reflect.Struct:
+		if err := exportedOnly(value.Type()); err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < value.NumField(); i++ {
+			var err error
+			if buffer, err = appendValue(buffer, value.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buffer, nil
+	case reflect.Pointer:
+		if value.IsNil() {
+			return append(buffer, 0), nil
+		}
+		return appendValue(append(buffer, 1), value.Elem())
+	case reflect.Map:
+		return appendMap(buffer, value)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedField, value.Type())
+}
+
+// appendOpaque encodes the types with their own encodings, the bool is false for the other types.
+func appendOpaque(buffer []byte, value reflect.Value) ([]byte, bool, error) {
+	pointer := reflect.PointerTo(value.Type())
+	switch {
+	case value.Type() == bigIntType:
+		v := addressOf(value).Interface().(*big.Int)
+		sign := byte(0)
+		if v.Sign() < 0 {
+			sign = 1
+		}
+		return appendBytes(append(buffer, sign), v.Bytes()), true, nil
+	case pointer.Implements(binaryMarshalerType) && pointer.Implements(binaryUnmarshalerType):
+		encoded, err := addressOf(value).Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, true, err
+		}
+		return appendBytes(buffer, encoded), true, nil
+	case pointer.Implements(encoderType) && pointer.Implements(decoderType):
+		return appendBytes(buffer, addressOf(value).Interface().(encoder).Encode()), true, nil
+	}
+	return buffer, false, nil
+}
+
+// addressOf returns a pointer to the value, or to a copy of it if it isn't addressable.
+func addressOf(value reflect.Value) reflect.Value {
+	if value.CanAddr() {
+		return value.Addr()
+	}
+
+	pointer := reflect.New(value.Type())
+	pointer.Elem().Set(value)
+	return pointer
+}
+
+func appendBytes(buffer []byte, data []byte) []byte {
+	return append(binary.AppendUvarint(buffer, uint64(len(data))), data...)
+}
+
+// exportedOnly rejects the structs with unexported fields, they would be lost in the payload.
+func exportedOnly(typ reflect.Type) error {
+	for i := 0; i < typ.NumField(); i++ {
+		if !typ.Field(i).IsExported() {
+			return fmt.Errorf("%w: %s has the unexported field %s", ErrUnsupportedField, typ, typ.Field(i).Name)
+		}
+	}
+	return nil
+}
+
+func appendElements(buffer []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < value.Len(); i++ {
+		if buffer, err = appendValue(buffer, value.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return buffer, nil
+}
+
+// appendMap appends the entries sorted by the encoded keys, the iteration order of the maps is random.
+func appendMap(buffer []byte, value reflect.Value) ([]byte, error) {
+	entries := make([][2][]byte, 0, value.Len())
+	for iter := value.MapRange(); iter.Next(); {
+		key, err := appendValue(nil, iter.Key())
+		if err != nil {
+			return nil, err
+		}
+
+		element, err := appendValue(nil, iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, [2][]byte{key, element})
+	}
+	slices.SortFunc(entries, func(a, b [2][]byte) int { return bytes.Compare(a[0], b[0]) })
+
+	buffer = binary.AppendUvarint(buffer, uint64(len(entries)))
+	for _, entry := range entries {
+		buffer = append(append(buffer, entry[0]...), entry[1]...)
+	}
+	return buffer, nil
+}
+
+// readValue decodes the value from the payload and returns the rest of it.
+func readValue(payload []byte, value reflect.Value) ([]byte, error) {
+	if value.Kind() != reflect.Pointer {
+		if rest, ok, err := readOpaque(payload, value); ok {
+			return rest, err
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Bool:
+		if len(payload) < 1 || payload[0] > 1 {
+			return nil, ErrBadPayload
+		}
+		value.SetBool(payload[0] == 1)
+		return payload[1:], nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, n := binary.Varint(payload)
+		if n <= 0 || value.OverflowInt(v) {
+			return nil, ErrBadPayload
+		}
+		value.SetInt(v)
+		return payload[n:], nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v, n := binary.Uvarint(payload)
+		if n <= 0 || value.OverflowUint(v) {
+			return nil, ErrBadPayload
+		}
+		value.SetUint(v)
+		return payload[n:], nil
+	case reflect.Float32:
+		if len(payload) < 4 {
+			return nil, ErrBadPayload
+		}
+		value.SetFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(payload))))
+		return payload[4:], nil
+	case reflect.Float64:
+		if len(payload) < 8 {
+			return nil, ErrBadPayload
+		}
+		value.SetFloat(math.Float64frombits(binary.BigEndian.Uint64(payload)))
+		return payload[8:], nil
+	case reflect.String:
+		length, rest, err := readLength(payload)
+		if err != nil || uint64(len(rest)) < length {
+			return nil, ErrBadPayload
+		}
+		value.SetString(string(rest[:length]))
+		return rest[length:], nil
+	case reflect.Slice:
+		return readSlice(payload, value)
+	case reflect.Array:
+		return readElements(payload, value)
+	case reflect.Struct:
+		if err := exportedOnly(value.Type()); err != nil {
+			return nil, err
+		}
+
+		var err error
+		for i := 0; i < value.NumField(); i++ {
+			if payload, err = readValue(payload, value.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return payload, nil
+	case reflect.Pointer:
+		if len(payload) < 1 || payload[0] > 1 {
+			return nil, ErrBadPayload
+		}
+
+		if payload[0] == 0 {
+			return payload[1:], nil
+		}
+		value.Set(reflect.New(value.Type().Elem()))
+		return readValue(payload[1:], value.Elem())
+	case reflect.Map:
+		return readMap(payload, value)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedField, value.Type())
+}
+
+// readOpaque decodes the types encoded by appendOpaque, the bool is false for the other types.
+func readOpaque(payload []byte, value reflect.Value) ([]byte, bool, error) {
+	pointer := reflect.PointerTo(value.Type())
+	switch {
+	case value.Type() == bigIntType:
+		if len(payload) < 1 || payload[0] > 1 {
+			return nil, true, ErrBadPayload
+		}
+
+		// The magnitude is minimal and a zero is never negative, so every value has one encoding.
+		magnitude, rest, err := readBytes(payload[1:])
+		if err != nil || (len(magnitude) > 0 && magnitude[0] == 0) || (payload[0] == 1 && len(magnitude) == 0) {
+			return nil, true, ErrBadPayload
+		}
+
+		v := value.Addr().Interface().(*big.Int).SetBytes(magnitude)
+		if payload[0] == 1 {
+			v.Neg(v)
+		}
+		return rest, true, nil
+	case pointer.Implements(binaryMarshalerType) && pointer.Implements(binaryUnmarshalerType):
+		encoded, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, true, err
+		}
+
+		if err := value.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(encoded); err != nil {
+			return nil, true, fmt.Errorf("%w: %w", ErrBadPayload, err)
+		}
+		return rest, true, nil
+	case pointer.Implements(encoderType) && pointer.Implements(decoderType):
+		encoded, rest, err := readBytes(payload)
+		if err != nil {
+			return nil, true, err
+		}
+
+		// Decode returns the decoded value, either by value or by pointer.
+		decoded := reflect.ValueOf(value.Addr().Interface().(decoder).Decode(encoded))
+		switch {
+		case decoded.IsValid() && decoded.Type() == value.Type():
+			value.Set(decoded)
+		case decoded.IsValid() && decoded.Type() == pointer && !decoded.IsNil():
+			value.Set(decoded.Elem())
+		default:
+			return nil, true, ErrBadPayload
+		}
+		return rest, true, nil
+	}
+	return payload, false, nil
+}
+
+func readBytes(payload []byte) ([]byte, []byte, error) {
+	length, rest, err := readLength(payload)
+	if err != nil || uint64(len(rest)) < length {
+		return nil, nil, ErrBadPayload
+	}
+	return rest[:length], rest[length:], nil
+}
+
+func readLength(payload []byte) (uint64, []byte, error) {
+	length, n := binary.Uvarint(payload)
+	if n <= 0 {
+		return 0, nil, ErrBadPayload
+	}
+	return length, payload[n:], nil
+}
+
+// readSlice decodes a slice, an empty one is left nil.
+func readSlice(payload []byte, value reflect.Value) ([]byte, error) {
+	length, rest, err := readLength(payload)
+	if err != nil || length == 0 {
+		return rest, err
+	}
+
+	if value.Type().Elem().Kind() == reflect.Uint8 {
+		if uint64(len(rest)) < length {
+			return nil, ErrBadPayload
+		}
+		value.SetBytes(bytes.Clone(rest[:length]))
+		return rest[length:], nil
+	}
+
+	// The elements are appended, so a corrupted length fails on the payload running out first.
+	elements := reflect.MakeSlice(value.Type(), 0, int(min(length, uint64(len(rest)))))
+	for i := uint64(0); i < length; i++ {
+		element := reflect.New(value.Type().Elem()).Elem()
+		if rest, err = readValue(rest, element); err != nil {
+			return nil, err
+		}
+		elements = reflect.Append(elements, element)
+	}
+	value.Set(elements)
+	return rest, nil
+}
+
+func readElements(payload []byte, value reflect.Value) ([]byte, error) {
+	var err error
+	for i := 0; i < value.Len(); i++ {
+		if payload, err = readValue(payload, value.Index(i)); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// readMap decodes a map, an empty one is left nil.
+func readMap(payload []byte, value reflect.Value) ([]byte, error) {
+	length, rest, err := readLength(payload)
+	if err != nil || length == 0 {
+		return rest, err
+	}
+
+	entries := reflect.MakeMapWithSize(value.Type(), int(min(length, uint64(len(rest)))))
+	for i := uint64(0); i < length; i++ {
+		key, element := reflect.New(value.Type().Key()).Elem(), reflect.New(value.Type().Elem()).Elem()
+		if rest, err = readValue(rest, key); err != nil {
+			return nil, err
+		}
+
+		if rest, err = readValue(rest, element); err != nil {
+			return nil, err
+		}
+		entries.SetMapIndex(key, element)
+	}
+	value.Set(entries)
+	return rest, nil
+}
@@ -0,0 +1,248 @@
+/*
+*   Copyright (c) 2026 Arcology Network
+
+*   This program is free software: you can redistribute it and/or modify
+*   it under the terms of the GNU General Public License as published by
+*   the Free Software Foundation, either version 3 of the License, or
+*   (at your option) any later version.
+
+*   This program is distributed in the hope that it will be useful,
+*   but WITHOUT ANY WARRANTY; without even the implied warranty of
+*   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*   GNU General Public License for more details.
+
+*   You should have received a copy of the GNU General Public License
+*   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+const (
+	SCHEMA_MAGIC      = "\xa7\x5c" // Marks a schema versioned struct encoding.
+	SCHEMA_HEADER_LEN = len(SCHEMA_MAGIC) + 2
+)
+
+var (
+	ErrNotVersioned   = errors.New("codec: not a schema versioned encoding")
+	ErrNewerSchema    = errors.New("codec: stored by a newer schema version")
+	ErrMissingUpgrade = errors.New("codec: no migration from the stored schema version")
+)
+
+// The schemas registered by the struct types, used by the default value conversions.
+var schemas sync.Map // reflect.Type -> structSchema
+
+type structSchema interface {
+	encode(value reflect.Value) ([]byte, error)
+	decode(data []byte) (reflect.Value, error)
+}
+
+// Schema encodes the values of a struct type as
+//
+//	[magic 2 bytes][version u16][payload]
+//
+// The payload is the deterministic encoding of payload.go, so the equal values are stored as the
+// same bytes, which the hashes and digests over the stores rely on. The map fields are sorted and
+// the fields of the unsupported kinds, like the interfaces, fail to encode.
+//
+// The values stored by the older versions are upgraded on decoding, by the migrations from each
+// older version to the next.
+type Schema[T any] struct {
+	version    uint16
+	lock       sync.RWMutex
+	migrations map[uint16]func([]byte) ([]byte, error) // From version -> the payload of the next version
+}
+
+// NewSchema creates a schema of T at the current version.
+func NewSchema[T any](version uint16) *Schema[T] {
+	return &Schema[T]{version: version, migrations: map[uint16]func([]byte) ([]byte, error){}}
+}
+
+// Version returns the current version of the schema.
+func (this *Schema[T]) Version() uint16 { return this.version }
+
+// Migrate registers the migration of the payload from a version to the next one, see Upgrade for
+// building one from a conversion between the struct types.
+func (this *Schema[T]) Migrate(from uint16, migrate func([]byte) ([]byte, error)) *Schema[T] {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.migrations[from] = migrate
+	return this
+}
+
+// Encode encodes the value at the current version.
+func (this *Schema[T]) Encode(value T) ([]byte, error) {
+	return this.encode(reflect.ValueOf(&value).Elem())
+}
+
+// Decode decodes the value, upgrading it to the current version first if stored by an older one.
+func (this *Schema[T]) Decode(data []byte) (T, error) {
+	value, err := this.decode(data)
+	if err != nil {
+		return *new(T), err
+	}
+	return value.Interface().(T), nil
+}
+
+func (this *Schema[T]) encode(value reflect.Value) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(SCHEMA_MAGIC)
+	buffer.Write(binary.BigEndian.AppendUint16(nil, this.version))
+	payload, err := encodePayload(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(buffer.Bytes(), payload...), nil
+}
+
+func (this *Schema[T]) decode(data []byte) (reflect.Value, error) {
+	version, payload, err := SchemaVersion(data)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if version > this.version {
+		return reflect.Value{}, fmt.Errorf("%w: %d, the current is %d", ErrNewerSchema, version, this.version)
+	}
+
+	this.lock.RLock()
+	for ; version < this.version; version++ {
+		migrate, ok := this.migrations[version]
+		if !ok {
+			this.lock.RUnlock()
+			return reflect.Value{}, fmt.Errorf("%w: %d", ErrMissingUpgrade, version)
+		}
+
+		if payload, err = migrate(payload); err != nil {
+			this.lock.RUnlock()
+			return reflect.Value{}, fmt.Errorf("codec: migrating from version %d: %w", version, err)
+		}
+	}
+	this.lock.RUnlock()
+
+	return decodePayload(payload, reflect.TypeOf((*T)(nil)).Elem())
+}
+
+// SchemaVersion returns the schema version of an encoded value and its payload.
+func SchemaVersion(data []byte) (uint16, []byte, error) {
+	if len(data) < SCHEMA_HEADER_LEN || string(data[:len(SCHEMA_MAGIC)]) != SCHEMA_MAGIC {
+		return 0, nil, ErrNotVersioned
+	}
+	return binary.BigEndian.Uint16(data[len(SCHEMA_MAGIC):]), data[SCHEMA_HEADER_LEN:], nil
+}
+
+// Upgrade builds a migration from the conversion of a struct of the older version to the next one.
+// The older struct types are usually kept as unexported copies of the struct.
+func Upgrade[Old, New any](convert func(Old) (New, error)) func([]byte) ([]byte, error) {
+	return func(payload []byte) ([]byte, error) {
+		old, err := decodePayload(payload, reflect.TypeOf((*Old)(nil)).Elem())
+		if err != nil {
+			return nil, err
+		}
+
+		upgraded, err := convert(old.Interface().(Old))
+		if err != nil {
+			return nil, err
+		}
+		return encodePayload(reflect.ValueOf(&upgraded).Elem())
+	}
+}
+
+// RegisterSchema makes the default value conversions of the StorageCodec use the schema for T, and
+// *T too if T is a struct. The structs without a registered schema are encoded at version 0.
+func RegisterSchema[T any](schema *Schema[T]) {
+	schemas.Store(reflect.TypeOf((*T)(nil)).Elem(), structSchema(schema))
+}
+
+// schemaOf returns the schema registered for the type, or a version 0 one for the structs.
+func schemaOf(typ reflect.Type) (structSchema, bool) {
+	if schema, ok := schemas.Load(typ); ok {
+		return schema.(structSchema), true
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, false
+	}
+
+	schema, _ := schemas.LoadOrStore(typ, newReflectSchema(typ))
+	return schema.(structSchema), true
+}
+
+// reflectSchema is the version 0 schema of an unregistered struct type.
+type reflectSchema struct {
+	typ reflect.Type
+}
+
+func newReflectSchema(typ reflect.Type) structSchema { return &reflectSchema{typ} }
+
+func (this *reflectSchema) encode(value reflect.Value) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString(SCHEMA_MAGIC)
+	buffer.Write([]byte{0, 0})
+	payload, err := encodePayload(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(buffer.Bytes(), payload...), nil
+}
+
+func (this *reflectSchema) decode(data []byte) (reflect.Value, error) {
+	version, payload, err := SchemaVersion(data)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	if version != 0 {
+		return reflect.Value{}, fmt.Errorf("%w: %d, %s has no registered schema", ErrNewerSchema, version, this.typ)
+	}
+
+	return decodePayload(payload, this.typ)
+}
+
+// structToBytes encodes a struct or a pointer to a struct with its schema.
+func structToBytes(value reflect.Value) ([]byte, bool, error) {
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil, false, nil
+		}
+		value = value.Elem()
+	}
+
+	schema, ok := schemaOf(value.Type())
+	if !ok {
+		return nil, false, nil
+	}
+
+	encoded, err := schema.encode(value)
+	return encoded, true, err
+}
+
+// bytesToStruct decodes a struct or a pointer to a struct with its schema.
+func bytesToStruct(raw []byte, typ reflect.Type) (reflect.Value, bool, error) {
+	structType := typ
+	if typ.Kind() == reflect.Pointer {
+		structType = typ.Elem()
+	}
+
+	schema, ok := schemaOf(structType)
+	if !ok {
+		return reflect.Value{}, false, nil
+	}
+
+	value, err := schema.decode(raw)
+	if err != nil || typ.Kind() != reflect.Pointer {
+		return value, true, err
+	}
+
+	pointer := reflect.New(structType)
+	pointer.Elem().Set(value)
+	return pointer, true, nil
+}
@@ -0,0 +1,219 @@
+/*
+*   Copyright (c) 2026 Arcology Network
+
+*   This program is free software: you can redistribute it and/or modify
+*   it under the terms of the GNU General Public License as published by
+*   the Free Software Foundation, either version 3 of the License, or
+*   (at your option) any later version.
+
+*   This program is distributed in the hope that it will be useful,
+*   but WITHOUT ANY WARRANTY; without even the implied warranty of
+*   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+*   GNU General Public License for more details.
+
+*   You should have received a copy of the GNU General Public License
+*   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package common
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type accountV0 struct {
+	Name    string
+	Balance uint64
+}
+
+type accountV1 struct {
+	Name    string
+	Balance uint64
+	Nonce   uint64
+}
+
+type account struct {
+	Owner   string
+	Balance uint64
+	Nonce   uint64
+	Tags    []string
+}
+
+func TestSchemaMigratesOnRead(t *testing.T) {
+	old, err := NewSchema[accountV0](0).Encode(accountV0{Name: "alice", Balance: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := NewSchema[account](2).
+		Migrate(0, Upgrade(func(v accountV0) (accountV1, error) { return accountV1{v.Name, v.Balance, 1}, nil })).
+		Migrate(1, Upgrade(func(v accountV1) (account, error) {
+			return account{Owner: v.Name, Balance: v.Balance, Nonce: v.Nonce}, nil
+		}))
+
+	decoded, err := schema.Decode(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (account{Owner: "alice", Balance: 7, Nonce: 1}); !reflect.DeepEqual(decoded, want) {
+		t.Fatalf("expected %+v, got %+v", want, decoded)
+	}
+
+	encoded, _ := schema.Encode(decoded)
+	if version, _, err := SchemaVersion(encoded); err != nil || version != 2 {
+		t.Fatalf("expected version 2, got %d %v", version, err)
+	}
+
+	if _, err := NewSchema[account](1).Decode(encoded); !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("expected ErrNewerSchema, got %v", err)
+	}
+
+	if _, err := NewSchema[account](2).Decode(old); !errors.Is(err, ErrMissingUpgrade) {
+		t.Fatalf("expected ErrMissingUpgrade, got %v", err)
+	}
+
+	if _, err := schema.Decode([]byte("raw")); !errors.Is(err, ErrNotVersioned) {
+		t.Fatalf("expected ErrNotVersioned, got %v", err)
+	}
+}
+
+func TestStorageCodecStructValues(t *testing.T) {
+	type point struct {
+		X, Y int64
+	}
+
+	codec := NewStorageCodec[string, point, string, []byte](nil, nil)
+	_, raw, err := codec.ForwardConvert("p", point{3, -4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, _, err := SchemaVersion(raw); err != nil || version != 0 {
+		t.Fatalf("expected an unregistered struct at version 0, got %d %v", version, err)
+	}
+
+	if _, decoded, err := codec.BackwardConvert("p", raw); err != nil || decoded != (point{3, -4}) {
+		t.Fatalf("expected the point back, got %+v %v", decoded, err)
+	}
+
+	pointers := NewStorageCodec[string, *point, string, []byte](nil, nil)
+	if _, decoded, err := pointers.BackwardConvert("p", raw); err != nil || *decoded != (point{3, -4}) {
+		t.Fatalf("expected the point back by pointer, got %+v %v", decoded, err)
+	}
+}
+
+func TestStorageCodecRegisteredSchema(t *testing.T) {
+	type ledger struct {
+		Owner   string
+		Balance uint64
+		Nonce   uint64
+	}
+
+	// The rows stored before the Nonce field was added.
+	old, _ := NewSchema[accountV0](0).Encode(accountV0{Name: "bob", Balance: 9})
+
+	RegisterSchema(NewSchema[ledger](1).Migrate(0, Upgrade(func(v accountV0) (ledger, error) {
+		return ledger{Owner: v.Name, Balance: v.Balance}, nil
+	})))
+
+	codec := NewStorageCodec[string, ledger, string, []byte](nil, nil)
+	if _, decoded, err := codec.BackwardConvert("bob", old); err != nil || decoded != (ledger{"bob", 9, 0}) {
+		t.Fatalf("expected the migrated row, got %+v %v", decoded, err)
+	}
+
+	_, raw, err := codec.ForwardConvert("bob", ledger{"bob", 9, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if version, _, _ := SchemaVersion(raw); version != 1 {
+		t.Fatalf("expected the registered version 1, got %d", version)
+	}
+}
+
+func TestSchemaDeterministicPayload(t *testing.T) {
+	type entry struct {
+		Balances map[string]uint64
+		Parent   *entry
+		Scores   []float64
+		Hash     [4]byte
+		Active   bool
+	}
+
+	value := entry{
+		Balances: map[string]uint64{"alice": 1, "bob": 2, "carol": 3, "dave": 4, "erin": 5},
+		Parent:   &entry{Scores: []float64{-1.5}},
+		Hash:     [4]byte{1, 2, 3, 4},
+		Active:   true,
+	}
+
+	schema := NewSchema[entry](1)
+	first, err := schema.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The maps are sorted, and nothing like the gob type descriptors is repeated in every value.
+	for i := 0; i < 32; i++ {
+		if encoded, _ := schema.Encode(value); !bytes.Equal(encoded, first) {
+			t.Fatalf("the encoding isn't deterministic: %x != %x", encoded, first)
+		}
+	}
+
+	if decoded, err := schema.Decode(first); err != nil || !reflect.DeepEqual(decoded, value) {
+		t.Fatalf("expected %+v back, got %+v %v", value, decoded, err)
+	}
+
+	type point struct{ X, Y int64 }
+	if encoded, _ := NewSchema[point](0).Encode(point{3, -4}); !bytes.Equal(encoded, []byte(SCHEMA_MAGIC+"\x00\x00\x06\x07")) {
+		t.Fatalf("unexpected encoding %x", encoded)
+	}
+
+	type dynamic struct{ Any any }
+	if _, err := NewSchema[dynamic](0).Encode(dynamic{1}); !errors.Is(err, ErrUnsupportedField) {
+		t.Fatalf("expected ErrUnsupportedField, got %v", err)
+	}
+
+	if _, err := schema.Decode(first[:len(first)-1]); !errors.Is(err, ErrBadPayload) {
+		t.Fatalf("expected ErrBadPayload, got %v", err)
+	}
+}
+
+func TestSchemaPrivateState(t *testing.T) {
+	type account struct {
+		Balance *big.Int
+		Debt    big.Int
+		When    time.Time
+	}
+
+	value := account{Balance: big.NewInt(12345), When: time.Unix(1000, 0).UTC()}
+	value.Debt.SetString("-123456789012345678901234567890", 10)
+
+	schema := NewSchema[account](1)
+	encoded, err := schema.Encode(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := schema.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Balance.Cmp(value.Balance) != 0 || decoded.Debt.Cmp(&value.Debt) != 0 || !decoded.When.Equal(value.When) {
+		t.Fatalf("expected %v %v %v back, got %v %v %v", value.Balance, &value.Debt, value.When, decoded.Balance, &decoded.Debt, decoded.When)
+	}
+
+	// A struct with private state and no encoding of its own would lose it.
+	type private struct{ count int }
+	type wrapper struct{ Inner private }
+	if _, err := NewSchema[wrapper](0).Encode(wrapper{private{1}}); !errors.Is(err, ErrUnsupportedField) {
+		t.Fatalf("expected ErrUnsupportedField, got %v", err)
+	}
+}
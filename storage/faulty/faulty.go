@@ -0,0 +1,281 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package faulty provides a store decorator injecting failures for resilience testing.
+//
+// The failures are decided by a pseudo random generator seeded by the config, so the same
+// sequence of calls always fails in the same way. The calls made concurrently are decided
+// in the order they acquire the store, so a test needs to serialize its calls to be fully
+// reproducible.
+package faulty
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	OP_HAS          = "has"
+	OP_GET          = "get"
+	OP_GET_BATCH    = "getbatch"
+	OP_SET          = "set"
+	OP_SET_BATCH    = "setbatch"
+	OP_DELETE       = "delete"
+	OP_DELETE_BATCH = "deletebatch"
+	OP_QUERY        = "query"
+)
+
+var (
+	ErrInjected = errors.New("faulty: injected failure")
+	ErrCrashed  = errors.New("faulty: the store has crashed")
+)
+
+// Rule is a kind of failure to inject. A key of a batch fails on its own, so a batch is
+// partially applied if only some of its keys fail.
+type Rule struct {
+	Ops         []string       // The operations the rule applies to, all of them if empty.
+	Keys        *regexp.Regexp // The keys the rule applies to, all of them if nil. A query is matched by its pattern.
+	Probability float64        // The probability for a key to fail, between 0 and 1.
+	Err         error          // The error to return, ErrInjected if nil.
+
+	Latency            time.Duration // The delay added to a call,
+	LatencyProbability float64       // with the probability.
+}
+
+type Config struct {
+	Seed             int64
+	Rules            []Rule
+	CrashAfterWrites int // The store crashes after the number of keys written or deleted, never if 0.
+}
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Store[string, []byte])(nil)
+
+// Store is a ReadWriteStore decorator injecting failures into the calls to the store it wraps.
+// The failed keys never reach the underlying store. Once crashed, all the calls fail with
+// ErrCrashed until Recover is called.
+type Store[K stgintf.Key, V any] struct {
+	inner  stgintf.ReadWriteStore[K, V]
+	config Config
+
+	lock     sync.Mutex
+	random   *rand.Rand
+	writes   int
+	crashed  bool
+	injected int
+}
+
+func NewStore[K stgintf.Key, V any](inner stgintf.ReadWriteStore[K, V], config Config) *Store[K, V] {
+	return &Store[K, V]{
+		inner:  inner,
+		config: config,
+		random: rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Injected returns the number of failures injected so far, including the ones caused by the crash.
+func (this *Store[K, V]) Injected() int {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.injected
+}
+
+// Crashed tells if the store has crashed.
+func (this *Store[K, V]) Crashed() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.crashed
+}
+
+// Recover brings a crashed store back, with the write counter reset.
+func (this *Store[K, V]) Recover() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.crashed, this.writes = false, 0
+}
+
+func (this *Store[K, V]) Has(key K) bool {
+	if errs := this.decide(OP_HAS, []K{key}, false); errs[0] != nil {
+		return false
+	}
+	return this.inner.Has(key)
+}
+
+func (this *Store[K, V]) Get(key K) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *Store[K, V]) GetAs(key K, typeHint any) (any, error) {
+	if errs := this.decide(OP_GET, []K{key}, false); errs[0] != nil {
+		return nil, errs[0]
+	}
+	return this.inner.GetAs(key, typeHint)
+}
+
+func (this *Store[K, V]) GetBatch(keys []K) ([]any, []error) {
+	errs := this.decide(OP_GET_BATCH, keys, false)
+	passed, idx := this.passed(keys, errs)
+
+	values := make([]any, len(keys))
+	innerValues, innerErrs := this.inner.GetBatch(passed)
+	for i, orig := range idx {
+		values[orig] = innerValues[i]
+		if len(innerErrs) > 0 {
+			errs[orig] = innerErrs[i]
+		}
+	}
+	return values, errs
+}
+
+func (this *Store[K, V]) Set(key K, value V) error {
+	if errs := this.decide(OP_SET, []K{key}, true); errs[0] != nil {
+		return errs[0]
+	}
+	return this.inner.Set(key, value)
+}
+
+func (this *Store[K, V]) SetBatch(keys []K, values []V) []error {
+	errs := this.decide(OP_SET_BATCH, keys, true)
+	passed, idx := this.passed(keys, errs)
+
+	passedValues := make([]V, len(idx))
+	for i, orig := range idx {
+		passedValues[i] = values[orig]
+	}
+
+	innerErrs := this.inner.SetBatch(passed, passedValues)
+	for i, orig := range idx {
+		if len(innerErrs) > 0 {
+			errs[orig] = innerErrs[i]
+		}
+	}
+	return errs
+}
+
+func (this *Store[K, V]) Delete(key K) error {
+	if errs := this.decide(OP_DELETE, []K{key}, true); errs[0] != nil {
+		return errs[0]
+	}
+	return this.inner.Delete(key)
+}
+
+func (this *Store[K, V]) DeleteBatch(keys []K) []error {
+	errs := this.decide(OP_DELETE_BATCH, keys, true)
+	passed, idx := this.passed(keys, errs)
+
+	innerErrs := this.inner.DeleteBatch(passed)
+	for i, orig := range idx {
+		if len(innerErrs) > 0 {
+			errs[orig] = innerErrs[i]
+		}
+	}
+	return errs
+}
+
+func (this *Store[K, V]) Query(pattern K, checker func(K, V) bool) ([]K, []V, []error) {
+	if errs := this.decide(OP_QUERY, []K{pattern}, false); errs[0] != nil {
+		return nil, nil, errs
+	}
+	return this.inner.Query(pattern, checker)
+}
+
+// decide returns the injected errors of the keys, and sleeps for the latency spikes.
+func (this *Store[K, V]) decide(op string, keys []K, isWrite bool) []error {
+	errs := make([]error, len(keys))
+	delay := time.Duration(0)
+
+	this.lock.Lock()
+	for _, rule := range this.config.Rules {
+		if !rule.appliesTo(op) {
+			continue
+		}
+
+		if rule.Latency > 0 && this.random.Float64() < rule.LatencyProbability {
+			delay += rule.Latency
+		}
+
+		if rule.Probability <= 0 {
+			continue
+		}
+
+		for i, key := range keys {
+			if errs[i] != nil || (rule.Keys != nil && !rule.Keys.MatchString(fmt.Sprint(key))) {
+				continue
+			}
+
+			if this.random.Float64() < rule.Probability {
+				errs[i] = rule.Err
+				if errs[i] == nil {
+					errs[i] = ErrInjected
+				}
+			}
+		}
+	}
+
+	for i := range keys {
+		if this.crashed {
+			errs[i] = ErrCrashed
+		}
+
+		if errs[i] != nil {
+			this.injected++
+			continue
+		}
+
+		if isWrite && this.config.CrashAfterWrites > 0 {
+			if this.writes++; this.writes >= this.config.CrashAfterWrites {
+				this.crashed = true // The keys after this one in the batch are lost.
+			}
+		}
+	}
+	this.lock.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	return errs
+}
+
+// passed returns the keys without injected errors, and their indices in the original keys.
+func (this *Store[K, V]) passed(keys []K, errs []error) ([]K, []int) {
+	passed, idx := make([]K, 0, len(keys)), make([]int, 0, len(keys))
+	for i, key := range keys {
+		if errs[i] == nil {
+			passed = append(passed, key)
+			idx = append(idx, i)
+		}
+	}
+	return passed, idx
+}
+
+func (this *Rule) appliesTo(op string) bool {
+	if len(this.Ops) == 0 {
+		return true
+	}
+
+	for _, candidate := range this.Ops {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
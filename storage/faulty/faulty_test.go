@@ -0,0 +1,159 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package faulty
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/arcology-network/common-lib/storage/cachedstore"
+	stgcodec "github.com/arcology-network/common-lib/storage/codec"
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func testKeys(n int) ([]string, [][]byte) {
+	keys, values := make([]string, n), make([][]byte, n)
+	for i := range keys {
+		keys[i], values[i] = fmt.Sprintf("key-%03d", i), []byte{byte(i)}
+	}
+	return keys, values
+}
+
+func TestFaultyReproducible(t *testing.T) {
+	keys, values := testKeys(100)
+	run := func(seed int64) []bool {
+		store := NewStore(memdb.NewMemoryDB(), Config{Seed: seed, Rules: []Rule{{Ops: []string{OP_SET_BATCH}, Probability: 0.3}}})
+		errs := store.SetBatch(keys, values)
+
+		failed := make([]bool, len(keys))
+		for i, err := range errs {
+			failed[i] = err != nil
+			if store.inner.Has(keys[i]) == failed[i] {
+				t.Fatalf("%s: the failed keys must not reach the store, and the others must", keys[i])
+			}
+		}
+		return failed
+	}
+
+	first := run(42)
+	if !reflect.DeepEqual(first, run(42)) {
+		t.Fatal("the same seed produced different failures")
+	}
+	if reflect.DeepEqual(first, run(7)) {
+		t.Fatal("different seeds produced the same failures")
+	}
+
+	count := 0
+	for _, failed := range first {
+		if failed {
+			count++
+		}
+	}
+	if count == 0 || count == len(keys) {
+		t.Fatal("expected a partial batch failure, got", count, "failures")
+	}
+}
+
+func TestFaultyKeyPatternAndOps(t *testing.T) {
+	store := NewStore(memdb.NewMemoryDB(), Config{Rules: []Rule{{
+		Ops:         []string{OP_GET, OP_GET_BATCH},
+		Keys:        regexp.MustCompile("^acct/"),
+		Probability: 1,
+	}}})
+
+	store.SetBatch([]string{"acct/1", "blk/1"}, [][]byte{{1}, {2}})
+	if _, err := store.Get("acct/1"); err != ErrInjected {
+		t.Fatal("expected ErrInjected, got", err)
+	}
+	if _, err := store.Get("blk/1"); err != nil {
+		t.Fatal("expected no error, got", err)
+	}
+
+	values, errs := store.GetBatch([]string{"blk/1", "acct/1"})
+	if errs[0] != nil || values[0] == nil || errs[1] != ErrInjected {
+		t.Fatal("unexpected GetBatch result", values, errs)
+	}
+	if store.Injected() != 2 {
+		t.Fatal("expected 2 injected failures, got", store.Injected())
+	}
+}
+
+func TestFaultyCrashAfterWrites(t *testing.T) {
+	store := NewStore(memdb.NewMemoryDB(), Config{CrashAfterWrites: 5})
+	keys, values := testKeys(8)
+
+	store.SetBatch(keys[:3], values[:3])
+	errs := store.SetBatch(keys[3:], values[3:])
+	if errs[0] != nil || errs[1] != nil || errs[2] != ErrCrashed {
+		t.Fatal("expected the batch to be cut at the crash", errs)
+	}
+	if !store.Crashed() {
+		t.Fatal("expected the store to have crashed")
+	}
+	if _, err := store.Get(keys[0]); err != ErrCrashed {
+		t.Fatal("expected the reads to fail after the crash, got", err)
+	}
+
+	store.Recover()
+	if v, err := store.Get(keys[4]); err != nil || v.([]byte)[0] != 4 {
+		t.Fatal("expected the writes before the crash to survive", v, err)
+	}
+	if store.inner.Has(keys[5]) {
+		t.Fatal("the write after the crash must be lost")
+	}
+}
+
+func TestFaultyLatency(t *testing.T) {
+	store := NewStore(memdb.NewMemoryDB(), Config{Rules: []Rule{{Ops: []string{OP_HAS}, Latency: 20 * time.Millisecond, LatencyProbability: 1}}})
+
+	t0 := time.Now()
+	store.Has("a")
+	if time.Since(t0) < 20*time.Millisecond {
+		t.Fatal("expected a latency spike")
+	}
+
+	t0 = time.Now()
+	store.Get("a")
+	if time.Since(t0) >= 20*time.Millisecond {
+		t.Fatal("the latency spike applies to Has only")
+	}
+}
+
+func TestFaultyCachedStoreBackend(t *testing.T) {
+	backend := NewStore(memdb.NewMemoryDB(), Config{Seed: 1, Rules: []Rule{{Ops: []string{OP_SET}, Probability: 0.5}}})
+	codec := stgcodec.NewStorageCodec[string, []byte, string, []byte](nil, nil)
+	store := cachedstore.NewCachedStore[string, []byte, string, []byte](backend, codec, 1<<20, func(v []byte) uint64 { return uint64(len(v)) })
+
+	keys, values := testKeys(50)
+	failures := 0
+	for i, key := range keys {
+		if err := store.Set(key, values[i]); err != nil {
+			failures++
+			if backend.inner.Has(key) {
+				t.Fatal("the failed write reached the backend", key)
+			}
+		}
+	}
+
+	if failures == 0 || failures != backend.Injected() {
+		t.Fatal("the failures of the backend weren't surfaced by the cached store", failures, backend.Injected())
+	}
+}
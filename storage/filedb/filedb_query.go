@@ -23,7 +23,10 @@ import (
 )
 
 func (this *FileDB) Query(pattern string, condition func(string, []byte) bool) ([]string, [][]byte, []error) {
-	parentPath := this.findPath(pattern) // match file parent path first
+	parentPath := this.rootpath // An empty pattern matches everything
+	if len(pattern) > 0 {
+		parentPath = this.findPath(pattern) // match file parent path first
+	}
 	if files, err := this.getFilesUnder(parentPath); err == nil {
 		keyset := make([][]string, len(files))
 		valSet := make([][][]byte, len(files))
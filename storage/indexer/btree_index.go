@@ -35,6 +35,9 @@ func newSortable[T any](v T, compare *func(T, T) bool) *sortable[T] {
 }
 
 func (this *sortable[T]) Less(other btree.Item) bool {
+	if probe, ok := other.(*probe[T]); ok {
+		return !probe.Less(this) // A probe never equals an entry.
+	}
 	return (*this.compare)(this.v, other.(*sortable[T]).v)
 }
 
@@ -42,6 +45,9 @@ type SortedIndex[T any] struct {
 	Name      string
 	indexTree *btree.BTree
 	compare   func(T, T) bool
+	fields    []func(T, T) int // The fields the entries are sorted by, in order. Used by the predicates.
+
+	persistence *persistence[T] // Nil unless EnablePersistence is called.
 }
 
 func NewSortedIndex[T any](name string, compare func(T, T) bool) *SortedIndex[T] {
@@ -49,11 +55,73 @@ func NewSortedIndex[T any](name string, compare func(T, T) bool) *SortedIndex[T]
 		Name:      name,
 		indexTree: btree.New(4),
 		compare:   compare,
+		fields: []func(T, T) int{func(a, b T) int {
+			if compare(a, b) {
+				return -1
+			}
+			if compare(b, a) {
+				return 1
+			}
+			return 0
+		}},
+	}
+}
+
+// NewCompositeIndex creates an index sorting the entries by the fields in order, each field compares
+// two entries like cmp.Compare. Entries equal in all the fields replace each other, so the last field
+// is usually a unique one like the hash.
+func NewCompositeIndex[T any](name string, fields ...func(T, T) int) *SortedIndex[T] {
+	return &SortedIndex[T]{
+		Name:      name,
+		indexTree: btree.New(4),
+		compare:   func(a, b T) bool { return compareFields(fields, len(fields), a, b) < 0 },
+		fields:    fields,
+	}
+}
+
+// compareFields compares the entries by the first n fields.
+func compareFields[T any](fields []func(T, T) int, n int, a, b T) int {
+	for _, field := range fields[:n] {
+		if c := field(a, b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// probe is a search bound comparing entries by the first fields only, it is placed before or after
+// all the entries equal to it in these fields, depending on the bias.
+type probe[T any] struct {
+	v      T
+	fields []func(T, T) int
+	depth  int
+	after  bool
+}
+
+func (this *probe[T]) Less(other btree.Item) bool {
+	if c := compareFields(this.fields, this.depth, this.v, other.(*sortable[T]).v); c != 0 {
+		return c < 0
 	}
+	return !this.after
+}
+
+// scan calls do on the entries between lower and upper inclusive, in the first depth fields, until
+// do returns false.
+func (this *SortedIndex[T]) scan(lower, upper T, depth int, do func(T) bool) {
+	this.indexTree.AscendRange(
+		&probe[T]{v: lower, fields: this.fields, depth: depth},
+		&probe[T]{v: upper, fields: this.fields, depth: depth, after: true},
+		func(node btree.Item) bool { return do(node.(*sortable[T]).v) },
+	)
 }
 
 // Add new values to the index.
 func (this *SortedIndex[T]) Add(vals []T) {
+	this.record(CHANGE_ADD, vals)
+	this.insert(vals)
+}
+
+func (this *SortedIndex[T]) insert(vals []T) {
 	sortables := slice.Transform(vals, func(_ int, v T) *sortable[T] { return newSortable[T](v, &this.compare) })
 	for _, v := range sortables {
 		this.indexTree.ReplaceOrInsert(v)
@@ -62,12 +130,16 @@ func (this *SortedIndex[T]) Add(vals []T) {
 
 // Remove the values from the index.
 func (this *SortedIndex[T]) Remove(vals []T) {
+	this.record(CHANGE_REMOVE, vals)
 	sortables := slice.Transform(vals, func(_ int, v T) *sortable[T] { return newSortable[T](v, &this.compare) })
 	for _, v := range sortables {
 		this.indexTree.Delete(v)
 	}
 }
 
+// Len returns the number of values in the index.
+func (this *SortedIndex[T]) Len() int { return this.indexTree.Len() }
+
 // Export the index, return a slice of all the values in the index.
 func (this *SortedIndex[T]) Export() []T {
 	vals := make([]T, 0, this.indexTree.Len())
@@ -81,6 +153,7 @@ func (this *SortedIndex[T]) Export() []T {
 // Clear the index, return the number of items cleared.
 func (this *SortedIndex[T]) Clear() int {
 	size := this.indexTree.Len()
+	this.record(CHANGE_CLEAR, nil)
 	this.indexTree.Clear(false)
 	return size
 }
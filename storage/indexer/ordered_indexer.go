@@ -18,13 +18,16 @@
 package indexer
 
 import (
+	"errors"
 	"fmt"
 
 	slice "github.com/arcology-network/common-lib/exp/slice"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
 )
 
 // OrderedIndexer is a collection of indexes that need to be updated together,
-// it is memory only, and is used to speed up the query process.
+// it is kept in memory, and is used to speed up the query process. The indexes
+// can be persisted to a store to avoid rebuilding them on restart.
 //
 // It is either used with a database, which is used to store the actual data,
 // or used alone as a memory database that supports indexing.
@@ -35,7 +38,6 @@ type OrderedIndexer[T any] struct {
 
 // NewTable creates a new table with the given indexes.
 func NewOrderedIndexer[T any](indice ...*SortedIndex[T]) *OrderedIndexer[T] {
-	fmt.Println("1")
 	table := &OrderedIndexer[T]{
 		dict: map[string]int{},
 	}
@@ -69,3 +71,45 @@ func (this *OrderedIndexer[T]) Column(name string) *SortedIndex[T] {
 	}
 	return nil
 }
+
+// EnablePersistence makes all the indexes persistent in the store, see SortedIndex.EnablePersistence.
+func (this *OrderedIndexer[T]) EnablePersistence(store stgintf.ReadWriteStore[string, []byte], codec Codec[T], prefix ...string) {
+	for _, index := range this.indexes {
+		index.EnablePersistence(store, codec, prefix...)
+	}
+}
+
+// Flush writes the changes of all the indexes made since the last flush.
+func (this *OrderedIndexer[T]) Flush() error {
+	return this.forEach((*SortedIndex[T]).Flush)
+}
+
+// Save writes full snapshots of all the indexes.
+func (this *OrderedIndexer[T]) Save() error {
+	return this.forEach((*SortedIndex[T]).Save)
+}
+
+// Load loads all the indexes from their snapshots and change records.
+func (this *OrderedIndexer[T]) Load() error {
+	return this.forEach((*SortedIndex[T]).Load)
+}
+
+// forEach calls the function on all the indexes in parallel, the errors are prefixed with the index names.
+func (this *OrderedIndexer[T]) forEach(f func(*SortedIndex[T]) error) error {
+	errs := make([]error, len(this.indexes))
+	slice.ParallelForeach(this.indexes, 4, func(i int, index **SortedIndex[T]) {
+		if err := f(*index); err != nil {
+			errs[i] = fmt.Errorf("%s: %w", (*index).Name, err)
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// VerifyIndexer checks all the indexes of the indexer against the source store, see VerifyIndex.
+func VerifyIndexer[V, T any](indexer *OrderedIndexer[T], source stgintf.ReadWriteStore[string, V], prefix string, toEntry func(string, V) (T, bool)) error {
+	errs := make([]error, len(indexer.indexes))
+	for i, index := range indexer.indexes {
+		errs[i] = VerifyIndex(index, source, prefix, toEntry)
+	}
+	return errors.Join(errs...)
+}
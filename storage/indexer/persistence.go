@@ -0,0 +1,342 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	DEFAULT_PERSIST_PREFIX = "index/"
+	SNAPSHOT_CHUNK_SIZE    = 4096 // The max number of entries saved under one key in a snapshot.
+
+	CHANGE_ADD    = uint8(0)
+	CHANGE_REMOVE = uint8(1)
+	CHANGE_CLEAR  = uint8(2)
+)
+
+var (
+	ErrNotPersistent = errors.New("indexer: persistence isn't enabled")
+	ErrCorrupted     = errors.New("indexer: corrupted index data")
+	ErrInconsistent  = errors.New("indexer: the index doesn't match the source store")
+)
+
+// Codec converts the entries of an index to bytes and back for persisting the index.
+type Codec[T any] struct {
+	Encode func(T) []byte
+	Decode func([]byte) (T, error)
+}
+
+// persistence saves an index to a store as full snapshots with the change records made in between.
+// Under the prefix of the index, the keys are
+//
+//	meta                 the generation, the number of chunks and entries of the snapshot, and the seq of the last change in it
+//	snap/<gen>/<chunk>   the entries of the snapshot, in order
+//	log/<seq>            the changes flushed after the snapshot
+type persistence[T any] struct {
+	store   stgintf.ReadWriteStore[string, []byte]
+	prefix  string
+	codec   Codec[T]
+	gen     uint64
+	seq     uint64   // The seq of the last change record flushed
+	pending [][]byte // The changes not flushed yet, each is [op u8][encoded entry]
+}
+
+// EnablePersistence makes the index persistent in the store, under the prefix followed by the index
+// name. The changes made from now on are logged, and written to the store by Flush.
+func (this *SortedIndex[T]) EnablePersistence(store stgintf.ReadWriteStore[string, []byte], codec Codec[T], prefix ...string) {
+	root := DEFAULT_PERSIST_PREFIX
+	if len(prefix) > 0 {
+		root = prefix[0]
+	}
+	this.persistence = &persistence[T]{store: store, prefix: root + this.Name + "/", codec: codec}
+}
+
+// Flush writes the changes made since the last flush to the store as one change record. It is
+// normally called once per block.
+func (this *SortedIndex[T]) Flush() error {
+	if this.persistence == nil {
+		return ErrNotPersistent
+	}
+
+	p := this.persistence
+	if len(p.pending) == 0 {
+		return nil
+	}
+
+	if err := p.store.Set(p.logKey(p.seq+1), codec.Byteset(p.pending).Encode()); err != nil {
+		return err
+	}
+	p.seq, p.pending = p.seq+1, nil
+	return nil
+}
+
+// Save writes a full snapshot of the index, and removes the snapshot and the change records it
+// replaces. The pending changes are included in the snapshot.
+func (this *SortedIndex[T]) Save() error {
+	if this.persistence == nil {
+		return ErrNotPersistent
+	}
+
+	p := this.persistence
+	entries := this.Export()
+	chunks := (len(entries) + SNAPSHOT_CHUNK_SIZE - 1) / SNAPSHOT_CHUNK_SIZE
+	gen := p.gen + 1
+	for i := 0; i < chunks; i++ {
+		chunk := entries[i*SNAPSHOT_CHUNK_SIZE : min((i+1)*SNAPSHOT_CHUNK_SIZE, len(entries))]
+		encoded := make([][]byte, len(chunk))
+		for j, v := range chunk {
+			encoded[j] = p.codec.Encode(v)
+		}
+
+		if err := p.store.Set(p.chunkKey(gen, i), codec.Byteset(encoded).Encode()); err != nil {
+			return err
+		}
+	}
+
+	// Switching the meta makes the new snapshot effective at once, the rest is clean up.
+	meta := codec.Uint64s{gen, uint64(chunks), uint64(len(entries)), p.seq}.Encode()
+	if err := p.store.Set(p.prefix+"meta", meta); err != nil {
+		return err
+	}
+	p.gen, p.pending = gen, nil
+
+	stale, err := p.keys("snap/", func(key string) bool { return !strings.HasPrefix(key, p.prefix+fmt.Sprintf("snap/%020d/", gen)) })
+	if err != nil {
+		return err
+	}
+
+	logs, err := p.keys("log/", func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+	return errors.Join(p.store.DeleteBatch(append(stale, logs...))...)
+}
+
+// Load replaces the entries of the index with the last snapshot saved in the store, followed by the
+// changes flushed after it. The index is left empty if nothing has been saved yet.
+func (this *SortedIndex[T]) Load() error {
+	if this.persistence == nil {
+		return ErrNotPersistent
+	}
+
+	p := this.persistence
+	this.indexTree.Clear(false)
+	p.gen, p.seq, p.pending = 0, 0, nil
+
+	v, err := p.store.Get(p.prefix + "meta")
+	if err != nil && !errors.Is(err, stgintf.ErrNotFound) {
+		return err
+	}
+
+	if v != nil {
+		meta := codec.Uint64s{}.Decode(v.([]byte)).(codec.Uint64s)
+		if len(meta) != 4 {
+			return fmt.Errorf("%w: invalid meta of %s", ErrCorrupted, this.Name)
+		}
+		p.gen, p.seq = meta[0], meta[3]
+
+		for i := 0; i < int(meta[1]); i++ {
+			chunk, err := p.store.Get(p.chunkKey(p.gen, i))
+			if err != nil {
+				return fmt.Errorf("%w: missing chunk %d of %s: %v", ErrCorrupted, i, this.Name, err)
+			}
+
+			entries, err := p.decode(codec.Byteset{}.Decode(chunk.([]byte)).(codec.Byteset))
+			if err != nil {
+				return err
+			}
+			this.insert(entries)
+		}
+
+		if this.indexTree.Len() != int(meta[2]) {
+			return fmt.Errorf("%w: %s has %d entries instead of %d", ErrCorrupted, this.Name, this.indexTree.Len(), meta[2])
+		}
+	}
+	return this.replay()
+}
+
+// replay applies the change records flushed after the snapshot, in order.
+func (this *SortedIndex[T]) replay() error {
+	p := this.persistence
+	keys, err := p.keys("log/", func(string) bool { return true })
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys) // Zero padded, so in the order of the seqs.
+
+	for _, key := range keys {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(key, p.prefix+"log/"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid key %s", ErrCorrupted, key)
+		}
+
+		if seq <= p.seq { // Already in the snapshot, left by an interrupted Save.
+			continue
+		}
+
+		record, err := p.store.Get(key)
+		if err != nil {
+			return err
+		}
+
+		for _, change := range (codec.Byteset{}).Decode(record.([]byte)).(codec.Byteset) {
+			if len(change) == 0 {
+				return fmt.Errorf("%w: empty change in %s", ErrCorrupted, key)
+			}
+
+			if change[0] == CHANGE_CLEAR {
+				this.indexTree.Clear(false)
+				continue
+			}
+
+			entry, err := p.codec.Decode(change[1:])
+			if err != nil {
+				return err
+			}
+
+			if change[0] == CHANGE_ADD {
+				this.insert([]T{entry})
+			} else {
+				this.indexTree.Delete(newSortable[T](entry, &this.compare))
+			}
+		}
+		p.seq = seq
+	}
+	return nil
+}
+
+// record logs a change if persistence is enabled.
+func (this *SortedIndex[T]) record(op uint8, vals []T) {
+	if this.persistence == nil {
+		return
+	}
+
+	if op == CHANGE_CLEAR {
+		this.persistence.pending = append(this.persistence.pending, []byte{CHANGE_CLEAR})
+		return
+	}
+
+	for _, v := range vals {
+		this.persistence.pending = append(this.persistence.pending, append([]byte{op}, this.persistence.codec.Encode(v)...))
+	}
+}
+
+func (this *persistence[T]) decode(encoded [][]byte) ([]T, error) {
+	entries := make([]T, len(encoded))
+	for i := range encoded {
+		var err error
+		if entries[i], err = this.codec.Decode(encoded[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// keys returns the keys under the sub prefix of the index accepted by the filter.
+func (this *persistence[T]) keys(sub string, filter func(string) bool) ([]string, error) {
+	prefix := this.prefix + sub
+	keys, _, errs := this.store.Query(prefix, func(key string, _ []byte) bool {
+		return strings.HasPrefix(key, prefix) && filter(key)
+	})
+	return keys, errors.Join(errs...)
+}
+
+func (this *persistence[T]) chunkKey(gen uint64, chunk int) string {
+	return fmt.Sprintf("%ssnap/%020d/%08d", this.prefix, gen, chunk)
+}
+
+func (this *persistence[T]) logKey(seq uint64) string {
+	return fmt.Sprintf("%slog/%020d", this.prefix, seq)
+}
+
+// VerifyIndex checks the index against the entries derived from the rows of the source store under
+// the prefix, the rows toEntry rejects aren't indexed. It returns ErrInconsistent with the numbers of
+// the missing and unexpected entries if they don't match.
+func VerifyIndex[V, T any](index *SortedIndex[T], source stgintf.ReadWriteStore[string, V], prefix string, toEntry func(string, V) (T, bool)) error {
+	expected, err := deriveEntries(index, source, prefix, toEntry)
+	if err != nil {
+		return err
+	}
+
+	actual := index.Export()
+	missing, unexpected := 0, 0
+	i, j := 0, 0
+	for i < len(expected) && j < len(actual) {
+		switch {
+		case index.compare(expected[i], actual[j]):
+			missing, i = missing+1, i+1
+		case index.compare(actual[j], expected[i]):
+			unexpected, j = unexpected+1, j+1
+		default:
+			i, j = i+1, j+1
+		}
+	}
+	missing, unexpected = missing+len(expected)-i, unexpected+len(actual)-j
+
+	if missing > 0 || unexpected > 0 {
+		return fmt.Errorf("%w: %s has %d entries missing and %d unexpected", ErrInconsistent, index.Name, missing, unexpected)
+	}
+	return nil
+}
+
+// RebuildIndex replaces the entries of the index with the ones derived from the rows of the source
+// store. The rebuild is logged as a change, so it is persisted by the next Flush or Save.
+func RebuildIndex[V, T any](index *SortedIndex[T], source stgintf.ReadWriteStore[string, V], prefix string, toEntry func(string, V) (T, bool)) error {
+	expected, err := deriveEntries(index, source, prefix, toEntry)
+	if err != nil {
+		return err
+	}
+
+	index.Clear()
+	index.Add(expected)
+	return nil
+}
+
+// deriveEntries returns the entries of the source rows in the order of the index.
+func deriveEntries[V, T any](index *SortedIndex[T], source stgintf.ReadWriteStore[string, V], prefix string, toEntry func(string, V) (T, bool)) ([]T, error) {
+	keys, values, errs := source.Query(prefix, func(key string, _ V) bool { return strings.HasPrefix(key, prefix) })
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	entries := make([]T, 0, len(keys))
+	for i, key := range keys {
+		if entry, ok := toEntry(key, values[i]); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return index.compare(entries[i], entries[j]) })
+	unique := entries[:0] // The index keeps one of the equal entries, like ReplaceOrInsert.
+	for i := range entries {
+		if len(unique) > 0 && !index.compare(unique[len(unique)-1], entries[i]) {
+			unique[len(unique)-1] = entries[i]
+			continue
+		}
+		unique = append(unique, entries[i])
+	}
+	return unique, nil
+}
@@ -0,0 +1,178 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package indexer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/arcology-network/common-lib/codec"
+	"github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+)
+
+var uint64Codec = Codec[uint64]{
+	Encode: func(v uint64) []byte { return codec.Uint64(v).Encode() },
+	Decode: func(buffer []byte) (uint64, error) {
+		if len(buffer) != codec.UINT64_LEN {
+			return 0, errors.New("invalid length")
+		}
+		return binary.LittleEndian.Uint64(buffer), nil
+	},
+}
+
+func less(a, b uint64) bool { return a < b }
+
+func TestSortedIndexPersistence(t *testing.T) {
+	store, err := pebbledb.NewPebbleDB(filepath.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	index := NewSortedIndex("height", less)
+	index.EnablePersistence(store, uint64Codec)
+
+	vals := make([]uint64, SNAPSHOT_CHUNK_SIZE+10) // More than one chunk
+	for i := range vals {
+		vals[i] = uint64(i * 2)
+	}
+	index.Add(vals)
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	index.Add([]uint64{1, 3})
+	index.Remove([]uint64{0})
+	if err := index.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	index.Remove([]uint64{3})
+	if err := index.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	index.Add([]uint64{5}) // Never flushed, so lost on restart.
+
+	reloaded := NewSortedIndex("height", less)
+	reloaded.EnablePersistence(store, uint64Codec)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	index.Remove([]uint64{5})
+	if !reflect.DeepEqual(index.Export(), reloaded.Export()) {
+		t.Fatal("the reloaded index doesn't match", index.Len(), reloaded.Len())
+	}
+
+	// A new snapshot replaces the old one and the change records.
+	reloaded.Clear()
+	reloaded.Add([]uint64{7, 8})
+	if err := reloaded.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, _, _ := store.Query("index/height/", func(string, []byte) bool { return true })
+	if len(keys) != 2 { // The meta and one chunk
+		t.Fatal("stale keys are left", keys)
+	}
+
+	if err := index.Load(); err != nil || !reflect.DeepEqual(index.Export(), []uint64{7, 8}) {
+		t.Fatal("unexpected index", index.Export(), err)
+	}
+
+	if err := NewSortedIndex("empty", less).Flush(); !errors.Is(err, ErrNotPersistent) {
+		t.Fatal("expected ErrNotPersistent, got", err)
+	}
+}
+
+func TestOrderedIndexerPersistence(t *testing.T) {
+	type Receipt struct {
+		Height  uint64
+		GasUsed uint64
+	}
+
+	receiptCodec := Codec[*Receipt]{
+		Encode: func(r *Receipt) []byte { return codec.Uint64s{r.Height, r.GasUsed}.Encode() },
+		Decode: func(buffer []byte) (*Receipt, error) {
+			vals := codec.Uint64s{}.Decode(buffer).(codec.Uint64s)
+			return &Receipt{vals[0], vals[1]}, nil
+		},
+	}
+
+	newIndexer := func() *OrderedIndexer[*Receipt] {
+		return NewOrderedIndexer(
+			NewSortedIndex("height", func(a, b *Receipt) bool { return a.Height < b.Height }),
+			NewSortedIndex("gas", func(a, b *Receipt) bool {
+				return a.GasUsed < b.GasUsed || (a.GasUsed == b.GasUsed && a.Height < b.Height)
+			}),
+		)
+	}
+
+	// The source store keeps the receipts by key, the indexes are derived from it.
+	source := memdb.NewMemoryDB()
+	toEntry := func(_ string, v []byte) (*Receipt, bool) {
+		r, err := receiptCodec.Decode(v)
+		return r, err == nil
+	}
+
+	store := memdb.NewMemoryDB()
+	indexer := newIndexer()
+	indexer.EnablePersistence(store, receiptCodec)
+	for i := uint64(0); i < 10; i++ {
+		r := &Receipt{Height: i, GasUsed: 100 - i%3}
+		source.Set(fmt.Sprintf("receipt/%d", i), receiptCodec.Encode(r))
+		indexer.Update([]*Receipt{r})
+		if err := indexer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded := newIndexer()
+	reloaded.EnablePersistence(store, receiptCodec)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyIndexer(reloaded, source, "receipt/", toEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	// The source moves on without the index.
+	source.Set("receipt/10", receiptCodec.Encode(&Receipt{Height: 10, GasUsed: 1}))
+	source.Delete("receipt/0")
+	gas := reloaded.Column("gas")
+	if err := VerifyIndex(gas, source, "receipt/", toEntry); !errors.Is(err, ErrInconsistent) {
+		t.Fatal("expected ErrInconsistent, got", err)
+	}
+
+	if err := RebuildIndex(gas, source, "receipt/", toEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyIndex(gas, source, "receipt/", toEntry); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gas.Export(); got[0].Height != 10 || len(got) != 10 {
+		t.Fatal("unexpected gas index", len(got))
+	}
+}
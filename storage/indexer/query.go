@@ -0,0 +1,297 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	PREDICATE_RANGE = uint8(iota)
+	PREDICATE_AND
+	PREDICATE_OR
+)
+
+// PLAN_SAMPLE is the max number of entries the planner counts in a range, the larger ranges are
+// estimated to be as large as their whole indexes.
+const PLAN_SAMPLE = 1024
+
+var (
+	ErrUnknownColumn    = errors.New("indexer: unknown column")
+	ErrInvalidPredicate = errors.New("indexer: invalid predicate")
+)
+
+// Predicate selects the entries of an OrderedIndexer, it is either a range on a column or a
+// combination of other predicates.
+type Predicate[T any] struct {
+	op           uint8
+	column       string
+	lower, upper T
+	depth        int // The number of leading fields compared, 0 for all the fields of the column.
+	children     []*Predicate[T]
+}
+
+// Eq selects the entries equal to v in the column. On a composite index, the optional fields
+// limits the comparison to the first fields, so Eq("from_height", tx, 1) selects all the entries
+// from the same address.
+func Eq[T any](column string, v T, fields ...int) *Predicate[T] {
+	return Range(column, v, v, fields...)
+}
+
+// Range selects the entries between lower and upper inclusive in the column, the optional fields
+// works the same way as in Eq.
+func Range[T any](column string, lower, upper T, fields ...int) *Predicate[T] {
+	predicate := &Predicate[T]{op: PREDICATE_RANGE, column: column, lower: lower, upper: upper}
+	if len(fields) > 0 {
+		predicate.depth = fields[0]
+	}
+	return predicate
+}
+
+// And selects the entries matching all the predicates.
+func And[T any](predicates ...*Predicate[T]) *Predicate[T] {
+	return &Predicate[T]{op: PREDICATE_AND, children: predicates}
+}
+
+// Or selects the entries matching any of the predicates.
+func Or[T any](predicates ...*Predicate[T]) *Predicate[T] {
+	return &Predicate[T]{op: PREDICATE_OR, children: predicates}
+}
+
+// plan is how a predicate is executed. An AND runs its most selective child, the driver, and filters
+// the entries with the others. An OR runs all its children, skipping the entries already selected.
+type plan[T any] struct {
+	predicate *Predicate[T]
+	index     *SortedIndex[T] // For the ranges only
+	depth     int
+	cost      int // The estimated number of entries scanned
+	children  []*plan[T]
+	driver    int
+}
+
+// Select returns the entries matching the predicate, skipping the first offset ones and returning at
+// most limit entries, or all of them if the limit isn't positive. The entries of an AND are in the
+// order of the index picked by the planner, and the entries of an OR are in the order of its predicates.
+func (this *OrderedIndexer[T]) Select(where *Predicate[T], offset, limit int) ([]T, error) {
+	plan, err := this.plan(where, math.MaxInt)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []T{}
+	plan.run(func(v T) bool {
+		if offset > 0 {
+			offset--
+			return true
+		}
+		entries = append(entries, v)
+		return limit <= 0 || len(entries) < limit
+	})
+	return entries, nil
+}
+
+// Count returns the number of the entries matching the predicate.
+func (this *OrderedIndexer[T]) Count(where *Predicate[T]) (int, error) {
+	plan, err := this.plan(where, math.MaxInt)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	plan.run(func(T) bool { count++; return true })
+	return count, nil
+}
+
+// Explain describes the plan of the predicate, for example "and(scan height ~10; filter from)".
+func (this *OrderedIndexer[T]) Explain(where *Predicate[T]) (string, error) {
+	plan, err := this.plan(where, math.MaxInt)
+	if err != nil {
+		return "", err
+	}
+	return plan.String(), nil
+}
+
+// plan builds the plan of the predicate, the entries are counted up to the bound only, since a
+// range more expensive than the bound won't be picked anyway, and never beyond PLAN_SAMPLE.
+func (this *OrderedIndexer[T]) plan(predicate *Predicate[T], bound int) (*plan[T], error) {
+	if predicate == nil {
+		return nil, fmt.Errorf("%w: nil", ErrInvalidPredicate)
+	}
+
+	current := &plan[T]{predicate: predicate}
+	switch predicate.op {
+	case PREDICATE_RANGE:
+		if current.index = this.Column(predicate.column); current.index == nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, predicate.column)
+		}
+
+		if current.depth = predicate.depth; current.depth == 0 {
+			current.depth = len(current.index.fields)
+		}
+
+		if current.depth < 0 || current.depth > len(current.index.fields) {
+			return nil, fmt.Errorf("%w: %s has %d fields, not %d", ErrInvalidPredicate, predicate.column, len(current.index.fields), current.depth)
+		}
+
+		limit := min(bound, PLAN_SAMPLE)
+		current.index.scan(predicate.lower, predicate.upper, current.depth, func(T) bool {
+			current.cost++
+			return current.cost < limit
+		})
+
+		if current.cost >= PLAN_SAMPLE { // Too many to count
+			current.cost = max(current.cost, current.index.Len())
+		}
+
+	case PREDICATE_AND, PREDICATE_OR:
+		if len(predicate.children) == 0 {
+			return nil, fmt.Errorf("%w: no predicates to combine", ErrInvalidPredicate)
+		}
+
+		if predicate.op == PREDICATE_AND {
+			current.cost = bound
+		}
+
+		for i, child := range predicate.children {
+			childBound := bound
+			if predicate.op == PREDICATE_AND {
+				childBound = current.cost // Only a cheaper child can be the driver.
+			}
+
+			childPlan, err := this.plan(child, childBound)
+			if err != nil {
+				return nil, err
+			}
+			current.children = append(current.children, childPlan)
+
+			if predicate.op == PREDICATE_OR {
+				current.cost = min(current.cost+childPlan.cost, bound)
+			} else if childPlan.cost < current.cost || i == 0 {
+				current.cost, current.driver = childPlan.cost, i
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %d", ErrInvalidPredicate, predicate.op)
+	}
+	return current, nil
+}
+
+// run calls do on the selected entries until it returns false, it returns false if stopped.
+func (this *plan[T]) run(do func(T) bool) bool {
+	switch this.predicate.op {
+	case PREDICATE_RANGE:
+		stopped := false
+		this.index.scan(this.predicate.lower, this.predicate.upper, this.depth, func(v T) bool {
+			stopped = !do(v)
+			return !stopped
+		})
+		return !stopped
+
+	case PREDICATE_AND:
+		return this.children[this.driver].run(func(v T) bool {
+			for i, child := range this.children {
+				if i != this.driver && !child.match(v) {
+					return true
+				}
+			}
+			return do(v)
+		})
+
+	default:
+		for i, child := range this.children {
+			ok := child.run(func(v T) bool {
+				for _, previous := range this.children[:i] {
+					if previous.match(v) {
+						return true // Already selected
+					}
+				}
+				return do(v)
+			})
+
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// match checks an entry against the predicate without using the indexes.
+func (this *plan[T]) match(v T) bool {
+	switch this.predicate.op {
+	case PREDICATE_RANGE:
+		fields := this.index.fields
+		return compareFields(fields, this.depth, this.predicate.lower, v) <= 0 &&
+			compareFields(fields, this.depth, v, this.predicate.upper) <= 0
+
+	case PREDICATE_AND:
+		for _, child := range this.children {
+			if !child.match(v) {
+				return false
+			}
+		}
+		return true
+
+	default:
+		for _, child := range this.children {
+			if child.match(v) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (this *plan[T]) String() string {
+	switch this.predicate.op {
+	case PREDICATE_RANGE:
+		return fmt.Sprintf("scan %s ~%d", this.predicate.column, this.cost)
+
+	case PREDICATE_AND:
+		filters := make([]string, 0, len(this.children)-1)
+		for i, child := range this.children {
+			if i != this.driver {
+				filters = append(filters, child.filterString())
+			}
+		}
+
+		if len(filters) == 0 {
+			return "and(" + this.children[this.driver].String() + ")"
+		}
+		return "and(" + this.children[this.driver].String() + "; filter " + strings.Join(filters, ", ") + ")"
+
+	default:
+		children := make([]string, len(this.children))
+		for i, child := range this.children {
+			children[i] = child.String()
+		}
+		return "or(" + strings.Join(children, ", ") + ")"
+	}
+}
+
+// filterString describes the predicate used as a filter.
+func (this *plan[T]) filterString() string {
+	if this.predicate.op == PREDICATE_RANGE {
+		return this.predicate.column
+	}
+	return this.String()
+}
@@ -0,0 +1,137 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package indexer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIndexerQuery(t *testing.T) {
+	type Tx struct {
+		Hash   string
+		From   string
+		Height uint64
+	}
+
+	byHash := func(a, b *Tx) int { return cmp.Compare(a.Hash, b.Hash) }
+	byFrom := func(a, b *Tx) int { return cmp.Compare(a.From, b.From) }
+	byHeight := func(a, b *Tx) int { return cmp.Compare(a.Height, b.Height) }
+
+	indexer := NewOrderedIndexer(
+		NewCompositeIndex("height", byHeight, byHash),
+		NewCompositeIndex("from", byFrom, byHash),
+		NewCompositeIndex("from_height", byFrom, byHeight, byHash),
+	)
+
+	txs := make([]*Tx, 100)
+	for i := range txs {
+		txs[i] = &Tx{Hash: fmt.Sprintf("%03d", i), From: fmt.Sprintf("addr%d", i%4), Height: uint64(i / 2)}
+	}
+	indexer.Update(txs)
+
+	// 20 txs between the heights, and 25 txs from the address.
+	where := And(Range("height", &Tx{Height: 10}, &Tx{Height: 19}, 1), Eq("from", &Tx{From: "addr1"}, 1))
+	if plan, _ := indexer.Explain(where); !strings.HasPrefix(plan, "and(scan height") {
+		t.Fatal("the height index should drive the query", plan)
+	}
+
+	got, err := indexer.Select(where, 0, 0)
+	if err != nil || len(got) != 5 {
+		t.Fatal("expected 5 txs", len(got), err)
+	}
+	for i, tx := range got {
+		if tx.From != "addr1" || tx.Height < 10 || tx.Height > 19 || (i > 0 && tx.Height < got[i-1].Height) {
+			t.Fatal("unexpected tx", tx)
+		}
+	}
+
+	// The address is more selective than a wide range.
+	where = And(Range("height", &Tx{Height: 0}, &Tx{Height: 39}, 1), Eq("from", &Tx{From: "addr1"}, 1))
+	if plan, _ := indexer.Explain(where); plan != "and(scan from ~25; filter height)" {
+		t.Fatal("the from index should drive the query", plan)
+	}
+
+	// Paginated
+	page, _ := indexer.Select(where, 5, 7)
+	all, _ := indexer.Select(where, 0, 0)
+	if len(all) != 20 || len(page) != 7 || page[0] != all[5] || page[6] != all[11] {
+		t.Fatal("unexpected page", len(all), len(page))
+	}
+
+	if rest, _ := indexer.Select(where, 15, 10); len(rest) != 5 {
+		t.Fatal("expected the last 5 txs", len(rest))
+	}
+
+	// The composite index answers the same query with a single scan.
+	composite := Range("from_height", &Tx{From: "addr1", Height: 0}, &Tx{From: "addr1", Height: 39}, 2)
+	if got, _ := indexer.Select(composite, 0, 0); len(got) != 20 || got[0] != all[0] || got[19] != all[19] {
+		t.Fatal("the composite index doesn't match", len(got))
+	}
+
+	// Overlapping predicates are deduplicated.
+	where = Or(Eq("from", &Tx{From: "addr0"}, 1), Range("height", &Tx{Height: 0}, &Tx{Height: 4}, 1))
+	if count, err := indexer.Count(where); err != nil || count != 25+10-3 {
+		t.Fatal("expected 32 txs", count, err)
+	}
+
+	if _, err := indexer.Select(Eq("unknown", &Tx{}), 0, 0); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatal("expected ErrUnknownColumn, got", err)
+	}
+
+	if _, err := indexer.Select(Eq("from", &Tx{}, 3), 0, 0); !errors.Is(err, ErrInvalidPredicate) {
+		t.Fatal("expected ErrInvalidPredicate, got", err)
+	}
+
+	if _, err := indexer.Select(And[*Tx](), 0, 0); !errors.Is(err, ErrInvalidPredicate) {
+		t.Fatal("expected ErrInvalidPredicate, got", err)
+	}
+}
+
+func TestIndexerQueryPlanSample(t *testing.T) {
+	compared := 0
+	byValue := func(a, b int) int { compared++; return cmp.Compare(a, b) }
+	indexer := NewOrderedIndexer(NewCompositeIndex("value", byValue))
+
+	values := make([]int, 10*PLAN_SAMPLE)
+	for i := range values {
+		values[i] = i
+	}
+	indexer.Update(values)
+
+	// The wide range is estimated from the size of the index rather than counted entry by entry.
+	compared = 0
+	where := And(Range("value", 0, len(values)), Range("value", 10, 19))
+	if plan, _ := indexer.Explain(where); plan != "and(scan value ~10; filter value)" {
+		t.Fatal("the narrow range should drive the query", plan)
+	}
+	if compared > 4*PLAN_SAMPLE {
+		t.Fatal("the planner compared too many entries", compared)
+	}
+
+	if plan, _ := indexer.Explain(Range("value", 0, len(values))); plan != fmt.Sprintf("scan value ~%d", len(values)) {
+		t.Fatal("unexpected estimate", plan)
+	}
+
+	if count, _ := indexer.Count(where); count != 10 {
+		t.Fatal("expected 10 entries, got", count)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package instrumented provides a store decorator recording the latency, the throughput and
+// the errors of every operation, so the stores can be compared without modifying any of them.
+//
+// The metrics are named <name>.<op>.<metric>, for example "pebble.getbatch.latency", where
+//
+//	latency  is a histogram of the latencies in seconds
+//	size     is a histogram of the numbers of keys in the batches and the query results
+//	bytes    is a counter of the bytes read or written
+//	errors   is a counter of the failed keys
+//	notfound is a counter of the keys not found by the reads
+package instrumented
+
+import (
+	"errors"
+	"time"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/metrics"
+)
+
+const (
+	OP_HAS          = "has"
+	OP_GET          = "get"
+	OP_GET_BATCH    = "getbatch"
+	OP_SET          = "set"
+	OP_SET_BATCH    = "setbatch"
+	OP_DELETE       = "delete"
+	OP_DELETE_BATCH = "deletebatch"
+	OP_QUERY        = "query"
+)
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Store[string, []byte])(nil)
+
+// Store is a ReadWriteStore decorator reporting the metrics of the store it wraps.
+type Store[K stgintf.Key, V any] struct {
+	inner    stgintf.ReadWriteStore[K, V]
+	name     string
+	recorder metrics.Recorder
+	sizeOf   func(any) int // The number of bytes of a value, for the throughput.
+}
+
+// NewStore wraps the store, reporting its metrics with the name as the prefix. The sizes of the values
+// are measured with sizeOf if given, otherwise only the []byte and string values are measured.
+func NewStore[K stgintf.Key, V any](inner stgintf.ReadWriteStore[K, V], name string, recorder metrics.Recorder, sizeOf ...func(any) int) *Store[K, V] {
+	store := &Store[K, V]{
+		inner:    inner,
+		name:     name,
+		recorder: recorder,
+		sizeOf:   SizeOf,
+	}
+
+	if len(sizeOf) > 0 && sizeOf[0] != nil {
+		store.sizeOf = sizeOf[0]
+	}
+	return store
+}
+
+// SizeOf returns the length of a []byte or a string, 0 for the other types.
+func SizeOf(v any) int {
+	switch v := v.(type) {
+	case []byte:
+		return len(v)
+	case string:
+		return len(v)
+	}
+	return 0
+}
+
+// Inner returns the store being instrumented.
+func (this *Store[K, V]) Inner() stgintf.ReadWriteStore[K, V] { return this.inner }
+
+func (this *Store[K, V]) Has(key K) bool {
+	defer this.latency(OP_HAS, time.Now())
+	return this.inner.Has(key)
+}
+
+func (this *Store[K, V]) Get(key K) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *Store[K, V]) GetAs(key K, typeHint any) (any, error) {
+	defer this.latency(OP_GET, time.Now())
+
+	v, err := this.inner.GetAs(key, typeHint)
+	this.errors(OP_GET, err)
+	if err == nil {
+		this.add(OP_GET, "bytes", int64(this.sizeOf(v)))
+	}
+	return v, err
+}
+
+func (this *Store[K, V]) GetBatch(keys []K) ([]any, []error) {
+	defer this.latency(OP_GET_BATCH, time.Now())
+	this.recorder.Observe(this.name+"."+OP_GET_BATCH+".size", float64(len(keys)))
+
+	values, errs := this.inner.GetBatch(keys)
+	this.errors(OP_GET_BATCH, errs...)
+
+	total := 0
+	for i, v := range values {
+		if len(errs) == 0 || errs[i] == nil {
+			total += this.sizeOf(v)
+		}
+	}
+	this.add(OP_GET_BATCH, "bytes", int64(total))
+	return values, errs
+}
+
+func (this *Store[K, V]) Set(key K, value V) error {
+	defer this.latency(OP_SET, time.Now())
+
+	err := this.inner.Set(key, value)
+	this.errors(OP_SET, err)
+	if err == nil {
+		this.add(OP_SET, "bytes", int64(this.sizeOf(value)))
+	}
+	return err
+}
+
+func (this *Store[K, V]) SetBatch(keys []K, values []V) []error {
+	defer this.latency(OP_SET_BATCH, time.Now())
+	this.recorder.Observe(this.name+"."+OP_SET_BATCH+".size", float64(len(keys)))
+
+	errs := this.inner.SetBatch(keys, values)
+	this.errors(OP_SET_BATCH, errs...)
+
+	total := 0
+	for i, v := range values {
+		if len(errs) == 0 || errs[i] == nil {
+			total += this.sizeOf(v)
+		}
+	}
+	this.add(OP_SET_BATCH, "bytes", int64(total))
+	return errs
+}
+
+func (this *Store[K, V]) Delete(key K) error {
+	defer this.latency(OP_DELETE, time.Now())
+
+	err := this.inner.Delete(key)
+	this.errors(OP_DELETE, err)
+	return err
+}
+
+func (this *Store[K, V]) DeleteBatch(keys []K) []error {
+	defer this.latency(OP_DELETE_BATCH, time.Now())
+	this.recorder.Observe(this.name+"."+OP_DELETE_BATCH+".size", float64(len(keys)))
+
+	errs := this.inner.DeleteBatch(keys)
+	this.errors(OP_DELETE_BATCH, errs...)
+	return errs
+}
+
+func (this *Store[K, V]) Query(pattern K, checker func(K, V) bool) ([]K, []V, []error) {
+	defer this.latency(OP_QUERY, time.Now())
+
+	keys, values, errs := this.inner.Query(pattern, checker)
+	this.errors(OP_QUERY, errs...)
+	this.recorder.Observe(this.name+"."+OP_QUERY+".size", float64(len(keys)))
+
+	total := 0
+	for _, v := range values {
+		total += this.sizeOf(v)
+	}
+	this.add(OP_QUERY, "bytes", int64(total))
+	return keys, values, errs
+}
+
+func (this *Store[K, V]) latency(op string, t0 time.Time) {
+	this.recorder.Observe(this.name+"."+op+".latency", time.Since(t0).Seconds())
+}
+
+// errors counts the errors, the keys not found are counted separately since they are
+// expected results rather than failures.
+func (this *Store[K, V]) errors(op string, errs ...error) {
+	failed, missing := int64(0), int64(0)
+	for _, err := range errs {
+		switch {
+		case err == nil:
+		case errors.Is(err, stgintf.ErrNotFound):
+			missing++
+		default:
+			failed++
+		}
+	}
+
+	this.add(op, "errors", failed)
+	this.add(op, "notfound", missing)
+}
+
+func (this *Store[K, V]) add(op, metric string, delta int64) {
+	if delta != 0 {
+		this.recorder.Add(this.name+"."+op+"."+metric, delta)
+	}
+}
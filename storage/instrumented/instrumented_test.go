@@ -0,0 +1,119 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package instrumented
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/arcology-network/common-lib/storage/memdb"
+	"github.com/arcology-network/common-lib/storage/metrics"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	registry := metrics.NewRegistry()
+	store := NewStore(memdb.NewMemoryDB(), "memdb", registry)
+
+	store.Set("a", []byte{1, 2, 3})
+	store.SetBatch([]string{"b", "c"}, [][]byte{{4}, {5, 6}})
+	store.Get("a")
+	store.Get("missing")
+	store.GetBatch([]string{"a", "b", "missing"})
+	store.Delete("a")
+	store.Query("b", nil)
+
+	counters := map[string]int64{
+		"memdb.set.bytes":         3,
+		"memdb.setbatch.bytes":    3,
+		"memdb.get.bytes":         3,
+		"memdb.get.notfound":      1,
+		"memdb.getbatch.bytes":    4,
+		"memdb.getbatch.notfound": 1,
+		"memdb.query.bytes":       1,
+		"memdb.get.errors":        0,
+	}
+	for name, expected := range counters {
+		if got := registry.Counter(name); got != expected {
+			t.Errorf("%s: expected %d, got %d", name, expected, got)
+		}
+	}
+
+	for name, count := range map[string]uint64{
+		"memdb.get.latency":      2,
+		"memdb.getbatch.latency": 1,
+		"memdb.getbatch.size":    1,
+		"memdb.delete.latency":   1,
+		"memdb.query.size":       1,
+	} {
+		if histogram := registry.Histogram(name); histogram == nil || histogram.Count != count {
+			t.Errorf("%s: expected %d samples, got %v", name, count, histogram)
+		}
+	}
+
+	if size := registry.Histogram("memdb.setbatch.size"); size.Max != 2 {
+		t.Error("unexpected batch size", size.Max)
+	}
+}
+
+type failingStore struct{ *memdb.MemoryDB }
+
+func (failingStore) Set(string, []byte) error { return errFailed }
+
+var errFailed = errors.New("failed")
+
+func TestInstrumentedErrors(t *testing.T) {
+	registry := metrics.NewRegistry()
+	store := NewStore[string, []byte](failingStore{memdb.NewMemoryDB()}, "failing", registry)
+
+	if err := store.Set("a", []byte{1}); err != errFailed {
+		t.Fatal("expected the error of the inner store, got", err)
+	}
+	if registry.Counter("failing.set.errors") != 1 || registry.Counter("failing.set.bytes") != 0 {
+		t.Error("the failed write wasn't recorded")
+	}
+	if registry.Histogram("failing.set.latency").Count != 1 {
+		t.Error("the latency of the failed write wasn't recorded")
+	}
+}
+
+func TestInstrumentedPebbleNotFound(t *testing.T) {
+	db, err := pebbledb.NewPebbleDB(filepath.Join(t.TempDir(), "pebble"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	registry := metrics.NewRegistry()
+	store := NewStore[string, []byte](db, "pebble", registry)
+	store.Set("a", []byte{1})
+	store.Get("missing")
+	store.GetBatch([]string{"a", "missing"})
+
+	for name, expected := range map[string]int64{
+		"pebble.get.notfound":      1,
+		"pebble.get.errors":        0,
+		"pebble.getbatch.notfound": 1,
+		"pebble.getbatch.errors":   0,
+	} {
+		if got := registry.Counter(name); got != expected {
+			t.Errorf("%s: expected %d, got %d", name, expected, got)
+		}
+	}
+}
@@ -61,6 +61,17 @@ type ReadWriteStore[K Key, V any] interface {
 	Query(K, func(K, V) bool) ([]K, []V, []error)
 }
 
+// Iterable is implemented by the stores that can visit their entries in the ascending order of the keys
+// from a given key on, so a large store can be walked without loading all of it or scanning it again.
+type Iterable[K Key, V any] interface {
+	Iterate(K, func(K, V) bool) error // Stops at the first entry the visitor returns false for.
+}
+
+// Syncable is implemented by the stores whose writes may not be durable until they are synced.
+type Syncable interface {
+	Sync() error // Makes all the writes made so far durable.
+}
+
 type StoreWriter[T any] interface {
 	Import([]T)
 	Precommit(bool) error //should return a error
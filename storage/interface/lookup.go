@@ -0,0 +1,68 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package interfaces
+
+import "errors"
+
+// Lookup reads the value of the key as V, and whether the key exists. Only ErrNotFound means the key
+// is missing, the other errors of the store are returned.
+func Lookup[K Key, V any](store ReadWriteStore[K, V], key K) (V, bool, error) {
+	v, err := store.Get(key)
+	if errors.Is(err, ErrNotFound) || (err == nil && v == nil) {
+		return *new(V), false, nil
+	}
+
+	if err != nil {
+		return *new(V), false, err
+	}
+	typed, _ := v.(V)
+	return typed, true, nil
+}
+
+// LookupBatch reads the values of the keys as V, and whether the keys exist. The stores may return no
+// errors at all if every key is found, and not all of them report the missing keys in a batch with
+// ErrNotFound, so the keys failing the batch read are looked up again one by one to tell them apart.
+func LookupBatch[K Key, V any](store ReadWriteStore[K, V], keys []K) ([]V, []bool, error) {
+	olds, existed := make([]V, len(keys)), make([]bool, len(keys))
+	values, errs := store.GetBatch(keys)
+	for i := range keys {
+		if len(errs) > 0 && errs[i] != nil && !errors.Is(errs[i], ErrNotFound) {
+			var err error
+			if olds[i], existed[i], err = Lookup(store, keys[i]); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if (len(errs) > 0 && errs[i] != nil) || i >= len(values) || values[i] == nil {
+			continue
+		}
+		olds[i], _ = values[i].(V)
+		existed[i] = true
+	}
+	return olds, existed, nil
+}
+
+// BatchErrors returns the same error for every key of a batch.
+func BatchErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
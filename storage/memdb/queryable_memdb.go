@@ -19,6 +19,8 @@ package memdb
 
 import (
 	"errors"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-memdb"
 	hashicorpmemdb "github.com/hashicorp/go-memdb"
@@ -38,6 +40,11 @@ type QueryableMemoryDB struct {
 	dbReader    interface {
 		Get(string) (any, error) // dbReader is used to query the database.
 	}
+
+	stateLock sync.Mutex
+	tables    map[string]*tableState // The tables with persistence or retention configured.
+	stop      chan struct{}
+	pruning   sync.WaitGroup
 }
 
 func NewIndex(fieldName string, isPrimaryKey, Unique bool, T any) *hashicorpmemdb.IndexSchema {
@@ -122,25 +129,69 @@ func (this *QueryableMemoryDB) InsertTable(name string, indexers ...*hashicorpme
 }
 
 // Inserts multiple objects into the database. The size of the cache will be increase by 1.
+// If the table is configured with a store, the objects are persisted before the insertion is
+// committed, and nothing is inserted if they fail to be.
 func (this *QueryableMemoryDB) Add(table string, args ...any) error {
+	state, now := this.state(table), time.Now()
+	if state != nil {
+		state.lock.Lock()
+		defer state.lock.Unlock()
+	}
+
 	txn := this.cache.Txn(true)
+	defer txn.Abort()
 	for _, arg := range args {
 		if err := txn.Insert(table, arg); err != nil {
-			panic(err)
+			return err
+		}
+	}
+
+	var keys []string
+	if state != nil {
+		var err error
+		if keys, err = this.persist(state, args, now); err != nil {
+			return err
 		}
 	}
 	txn.Commit()
+
+	if state != nil {
+		for i := range args {
+			state.track(keys[i], args[i], now)
+		}
+	}
 	return nil
 }
 
+// Remove deletes the objects from the database, and from the store of the table if configured,
+// before the deletion is committed.
 func (this *QueryableMemoryDB) Remove(table string, args ...any) error {
-	tx := this.cache.Txn(true)
+	state := this.state(table)
+	if state != nil {
+		state.lock.Lock()
+		defer state.lock.Unlock()
+	}
+
+	txn := this.cache.Txn(true)
+	defer txn.Abort()
 	for _, arg := range args {
-		if err := tx.Delete(table, arg); err != nil {
+		if err := txn.Delete(table, arg); err != nil {
+			return err
+		}
+	}
+
+	var keys []string
+	if state != nil {
+		var err error
+		if keys, err = this.unpersist(state, args); err != nil {
 			return err
 		}
 	}
-	tx.Commit()
+	txn.Commit()
+
+	for _, key := range keys {
+		state.untrack(key)
+	}
 	return nil
 }
 
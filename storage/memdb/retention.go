@@ -0,0 +1,368 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package memdb
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	hashicorpmemdb "github.com/hashicorp/go-memdb"
+)
+
+var (
+	ErrUnknownTable  = errors.New("memdb: unknown table")
+	ErrInvalidConfig = errors.New("memdb: invalid table config")
+)
+
+// Retention limits the rows kept in a table, the zero values mean no limit. The rows beyond the
+// limits are removed by Prune, not by Add, so a table can exceed them until the next prune.
+type Retention struct {
+	KeepLastBlocks uint64           // Keeps the rows of the last blocks only, needs HeightOf.
+	HeightOf       func(any) uint64 // Returns the block height of a row.
+	TTL            time.Duration    // Removes the rows added longer than this ago.
+	MaxRows        int              // Removes the rows added first beyond the count.
+}
+
+// TableConfig configures the persistence and the retention of a table. The rows are persisted if the
+// store is set, in which case Encode and Decode are needed.
+type TableConfig struct {
+	Store     stgintf.ReadWriteStore[string, []byte]
+	Encode    func(any) ([]byte, error)
+	Decode    func([]byte) (any, error)
+	Retention Retention
+}
+
+// tableState tracks the rows of a configured table in the order they were added.
+type tableState struct {
+	name      string
+	config    TableConfig
+	lock      sync.Mutex               // Serializes the writes to the table, the readers aren't blocked.
+	order     *list.List               // *rowMeta, the earliest added first
+	rows      map[string]*list.Element // Primary key -> element in the order
+	maxHeight uint64
+}
+
+type rowMeta struct {
+	key   string
+	obj   any
+	added time.Time
+}
+
+// ConfigureTable sets the persistence and the retention of the table. If the store is set, the rows
+// persisted in it, for example before a restart, are loaded into the table.
+func (this *QueryableMemoryDB) ConfigureTable(table string, config TableConfig) error {
+	if this.cache.DBSchema().Tables[table] == nil {
+		return fmt.Errorf("%w: %s", ErrUnknownTable, table)
+	}
+
+	if config.Store != nil && (config.Encode == nil || config.Decode == nil) {
+		return fmt.Errorf("%w: %s needs Encode and Decode to persist", ErrInvalidConfig, table)
+	}
+
+	if config.Retention.KeepLastBlocks > 0 && config.Retention.HeightOf == nil {
+		return fmt.Errorf("%w: %s needs HeightOf to keep the last blocks", ErrInvalidConfig, table)
+	}
+
+	state := &tableState{name: table, config: config, order: list.New(), rows: map[string]*list.Element{}}
+	if err := this.load(state); err != nil {
+		return err
+	}
+
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	if this.tables == nil {
+		this.tables = map[string]*tableState{}
+	}
+	this.tables[table] = state
+	return nil
+}
+
+// load inserts the rows persisted in the store into the table, in the order they were added.
+func (this *QueryableMemoryDB) load(state *tableState) error {
+	if state.config.Store == nil {
+		return nil
+	}
+
+	prefix := state.name + "/"
+	keys, values, errs := state.config.Store.Query(prefix, func(key string, _ []byte) bool { return strings.HasPrefix(key, prefix) })
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	type loaded struct {
+		obj   any
+		added time.Time
+	}
+
+	rows := make([]loaded, 0, len(keys))
+	for i := range keys {
+		if len(values[i]) < 8 {
+			return fmt.Errorf("memdb: corrupted row %s", keys[i])
+		}
+
+		obj, err := state.config.Decode(values[i][8:])
+		if err != nil {
+			return err
+		}
+		rows = append(rows, loaded{obj, time.Unix(0, int64(binary.BigEndian.Uint64(values[i]))).UTC()})
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].added.Before(rows[j].added) })
+
+	txn := this.cache.Txn(true)
+	defer txn.Abort()
+	for _, row := range rows {
+		if err := txn.Insert(state.name, row.obj); err != nil {
+			return err
+		}
+
+		key, err := this.primaryKey(state.name, row.obj)
+		if err != nil {
+			return err
+		}
+		state.track(key, row.obj, row.added)
+	}
+	txn.Commit()
+	return nil
+}
+
+// Prune removes the rows beyond the retention limits of all the configured tables, and returns the
+// number of rows removed. The readers see the table before or after the pruning, never in between.
+func (this *QueryableMemoryDB) Prune() (int, error) {
+	this.stateLock.Lock()
+	states := make([]*tableState, 0, len(this.tables))
+	for _, state := range this.tables {
+		states = append(states, state)
+	}
+	this.stateLock.Unlock()
+
+	total, errs := 0, []error{}
+	for _, state := range states {
+		removed, err := this.prune(state, time.Now())
+		total += removed
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", state.name, err))
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// StartPruning runs Prune in the background at the interval until Close is called. The errors are
+// ignored, the pruning is retried at the next interval.
+func (this *QueryableMemoryDB) StartPruning(interval time.Duration) {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	if this.stop != nil {
+		return
+	}
+
+	this.stop = make(chan struct{})
+	this.pruning.Add(1)
+	go func(stop chan struct{}) {
+		defer this.pruning.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				this.Prune()
+			}
+		}
+	}(this.stop)
+}
+
+// Close stops the background pruning.
+func (this *QueryableMemoryDB) Close() error {
+	this.stateLock.Lock()
+	if this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+	this.stateLock.Unlock()
+	this.pruning.Wait()
+	return nil
+}
+
+func (this *QueryableMemoryDB) prune(state *tableState, now time.Time) (int, error) {
+	state.lock.Lock()
+	defer state.lock.Unlock()
+
+	retention := state.config.Retention
+	victims := map[string]any{}
+
+	// The victims are collected from a snapshot, the writers are only blocked to delete them.
+	if retention.KeepLastBlocks > 0 && state.maxHeight+1 > retention.KeepLastBlocks {
+		cutoff := state.maxHeight + 1 - retention.KeepLastBlocks
+		it, err := this.cache.Txn(false).Get(state.name, "id")
+		if err != nil {
+			return 0, err
+		}
+
+		for obj := it.Next(); obj != nil; obj = it.Next() {
+			if retention.HeightOf(obj) < cutoff {
+				key, err := this.primaryKey(state.name, obj)
+				if err != nil {
+					return 0, err
+				}
+				victims[key] = obj
+			}
+		}
+	}
+
+	// The earliest added rows, until the rest are within the TTL and the count.
+	remaining := state.order.Len() - len(victims)
+	for e := state.order.Front(); e != nil; e = e.Next() {
+		meta := e.Value.(*rowMeta)
+		expired := retention.TTL > 0 && now.Sub(meta.added) > retention.TTL
+		if !expired && (retention.MaxRows <= 0 || remaining <= retention.MaxRows) {
+			break
+		}
+
+		if _, ok := victims[meta.key]; !ok {
+			victims[meta.key] = meta.obj
+			remaining--
+		}
+	}
+
+	if len(victims) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, 0, len(victims))
+	for key := range victims {
+		keys = append(keys, key)
+	}
+
+	if state.config.Store != nil {
+		storeKeys := make([]string, len(keys))
+		for i, key := range keys {
+			storeKeys[i] = state.name + "/" + key
+		}
+
+		if err := errors.Join(state.config.Store.DeleteBatch(storeKeys)...); err != nil {
+			return 0, err // The rows are kept, the pruning is retried next time.
+		}
+	}
+
+	txn := this.cache.Txn(true)
+	defer txn.Abort()
+	for _, key := range keys {
+		if err := txn.Delete(state.name, victims[key]); err != nil && !errors.Is(err, hashicorpmemdb.ErrNotFound) {
+			return 0, err
+		}
+	}
+	txn.Commit()
+
+	for _, key := range keys {
+		state.untrack(key)
+	}
+	return len(keys), nil
+}
+
+// persist writes the rows to the store of the table, before they are inserted.
+func (this *QueryableMemoryDB) persist(state *tableState, args []any, now time.Time) ([]string, error) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		key, err := this.primaryKey(state.name, arg)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+	}
+
+	if state.config.Store == nil {
+		return keys, nil
+	}
+
+	storeKeys, values := make([]string, len(args)), make([][]byte, len(args))
+	for i, arg := range args {
+		encoded, err := state.config.Encode(arg)
+		if err != nil {
+			return nil, err
+		}
+		storeKeys[i] = state.name + "/" + keys[i]
+		values[i] = append(binary.BigEndian.AppendUint64(nil, uint64(now.UnixNano())), encoded...)
+	}
+	return keys, errors.Join(state.config.Store.SetBatch(storeKeys, values)...)
+}
+
+// unpersist removes the rows from the store of the table.
+func (this *QueryableMemoryDB) unpersist(state *tableState, args []any) ([]string, error) {
+	keys := make([]string, len(args))
+	storeKeys := make([]string, len(args))
+	for i, arg := range args {
+		key, err := this.primaryKey(state.name, arg)
+		if err != nil {
+			return nil, err
+		}
+		keys[i], storeKeys[i] = key, state.name+"/"+key
+	}
+
+	if state.config.Store == nil {
+		return keys, nil
+	}
+	return keys, errors.Join(state.config.Store.DeleteBatch(storeKeys)...)
+}
+
+// primaryKey returns the primary key of the row encoded by the id index of the table.
+func (this *QueryableMemoryDB) primaryKey(table string, obj any) (string, error) {
+	indexer, ok := this.cache.DBSchema().Tables[table].Indexes["id"].Indexer.(hashicorpmemdb.SingleIndexer)
+	if !ok {
+		return "", fmt.Errorf("%w: the id index of %s isn't a single indexer", ErrInvalidConfig, table)
+	}
+
+	ok, key, err := indexer.FromObject(obj)
+	if err != nil {
+		return "", err
+	}
+
+	if !ok {
+		return "", fmt.Errorf("memdb: missing the primary key of %s", table)
+	}
+	return string(key), nil
+}
+
+func (this *QueryableMemoryDB) state(table string) *tableState {
+	this.stateLock.Lock()
+	defer this.stateLock.Unlock()
+	return this.tables[table]
+}
+
+// track moves the row to the end of the order.
+func (this *tableState) track(key string, obj any, added time.Time) {
+	this.untrack(key)
+	this.rows[key] = this.order.PushBack(&rowMeta{key: key, obj: obj, added: added})
+	if this.config.Retention.HeightOf != nil {
+		this.maxHeight = max(this.maxHeight, this.config.Retention.HeightOf(obj))
+	}
+}
+
+func (this *tableState) untrack(key string) {
+	if e, ok := this.rows[key]; ok {
+		this.order.Remove(e)
+		delete(this.rows, key)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package memdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+)
+
+func newRetentionCache(t *testing.T, store *MemoryDB, retention Retention) *QueryableMemoryDB {
+	t.Helper()
+	cache, err := NewQueryableCache(nil, NewTable("tx",
+		NewIndex("Hash", true, true, new(string)),
+		NewIndex("Height", false, false, new(uint64)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cache.ConfigureTable("tx", TableConfig{
+		Store:  store,
+		Encode: func(obj any) ([]byte, error) { return json.Marshal(obj) },
+		Decode: func(buffer []byte) (any, error) {
+			tx := &CachedTx{}
+			return tx, json.Unmarshal(buffer, tx)
+		},
+		Retention: retention,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func addTxs(t *testing.T, cache *QueryableMemoryDB, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := cache.Add("tx", &CachedTx{Hash: fmt.Sprintf("0x%d", i), Height: uint64(i / 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func countTxs(cache *QueryableMemoryDB) int {
+	txs, _ := cache.FindGreaterThan("tx", "Height", uint64(0))
+	return len(txs)
+}
+
+func TestQueryableCachePersistence(t *testing.T) {
+	store := NewMemoryDB()
+	cache := newRetentionCache(t, store, Retention{
+		KeepLastBlocks: 3,
+		HeightOf:       func(obj any) uint64 { return obj.(*CachedTx).Height },
+	})
+	addTxs(t, cache, 0, 50) // Blocks 0 to 4
+	cache.Remove("tx", &CachedTx{Hash: "0x49", Height: 4})
+
+	// Rehydrated after a restart, then pruned to the last 3 blocks.
+	cache = newRetentionCache(t, store, Retention{
+		KeepLastBlocks: 3,
+		HeightOf:       func(obj any) uint64 { return obj.(*CachedTx).Height },
+	})
+	if count := countTxs(cache); count != 49 {
+		t.Fatal("expected 49 txs after the restart, got", count)
+	}
+
+	if removed, err := cache.Prune(); err != nil || removed != 20 {
+		t.Fatal("expected 20 txs removed", removed, err)
+	}
+
+	if low, _ := cache.FindLessThan("tx", "Height", uint64(1)); len(low) != 0 {
+		t.Fatal("the old blocks are left", len(low))
+	}
+
+	if keys, _, _ := store.Query("", func(string, []byte) bool { return true }); len(keys) != 29 {
+		t.Fatal("expected the pruned txs removed from the store", len(keys))
+	}
+
+	if err := cache.ConfigureTable("block", TableConfig{}); !errors.Is(err, ErrUnknownTable) {
+		t.Fatal("expected ErrUnknownTable, got", err)
+	}
+
+	if err := cache.ConfigureTable("tx", TableConfig{Store: store}); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatal("expected ErrInvalidConfig, got", err)
+	}
+}
+
+func TestQueryableCacheRetention(t *testing.T) {
+	cache := newRetentionCache(t, NewMemoryDB(), Retention{MaxRows: 15})
+	addTxs(t, cache, 0, 20)
+	addTxs(t, cache, 0, 1) // Added again, so it is the latest now.
+
+	if removed, _ := cache.Prune(); removed != 5 {
+		t.Fatal("expected 5 txs removed, got", removed)
+	}
+
+	if tx, _ := cache.FindFirst("tx", "id", "0x0"); tx == nil {
+		t.Fatal("the latest tx shouldn't be removed")
+	}
+
+	if tx, _ := cache.FindFirst("tx", "id", "0x5"); tx != nil {
+		t.Fatal("the earliest txs should be removed")
+	}
+
+	// TTL in the background, while reading.
+	cache = newRetentionCache(t, NewMemoryDB(), Retention{TTL: 50 * time.Millisecond})
+	defer cache.Close()
+	addTxs(t, cache, 0, 10)
+	cache.StartPruning(10 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for countTxs(cache) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the expired txs aren't removed", countTxs(cache))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestQueryableCacheWriteFailures(t *testing.T) {
+	store := NewMemoryDB()
+	cache := newRetentionCache(t, store, Retention{})
+	addTxs(t, cache, 0, 2)
+
+	// A row the table can't index is rejected without being persisted.
+	if err := cache.Add("tx", &CachedTx{Hash: "0x9"}, struct{ Other int }{}); err == nil {
+		t.Fatal("expected the invalid row to be rejected")
+	}
+	if keys, _, _ := store.Query("", func(string, []byte) bool { return true }); len(keys) != 2 || countTxs(cache) != 2 {
+		t.Fatal("the failed insertion is partially applied")
+	}
+
+	// The rows the store fails to persist or delete are left as they were.
+	failing := faulty.NewStore[string, []byte](store, faulty.Config{Rules: []faulty.Rule{{Probability: 1}}})
+	cache.tables["tx"].config.Store = failing
+	if err := cache.Add("tx", &CachedTx{Hash: "0x9", Height: 1}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the injected failure, got", err)
+	}
+	if tx, _ := cache.FindFirst("tx", "id", "0x9"); tx != nil {
+		t.Fatal("the row is inserted without being persisted")
+	}
+
+	if err := cache.Remove("tx", &CachedTx{Hash: "0x1", Height: 0}); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the injected failure, got", err)
+	}
+	if tx, _ := cache.FindFirst("tx", "id", "0x1"); tx == nil {
+		t.Fatal("the row is removed although the store failed")
+	}
+}
@@ -0,0 +1,502 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package memdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"github.com/arcology-network/common-lib/storage/codec/orderedkey"
+	hashicorpmemdb "github.com/hashicorp/go-memdb"
+)
+
+const (
+	TABLE_TAG        = "memdb"
+	PRIMARY_KEY_NAME = "id"
+)
+
+var (
+	ErrNoPrimaryKey     = errors.New("memdb: no field is tagged as the primary key")
+	ErrUnsupportedField = errors.New("memdb: unsupported field type")
+	ErrUnknownIndex     = errors.New("memdb: unknown index")
+	ErrInvalidArg       = errors.New("memdb: invalid index argument")
+)
+
+// The field types an index supports, the values of each are encoded into bytes in the same order.
+const (
+	fieldUint = iota
+	fieldInt
+	fieldBool
+	fieldBytes // string and []byte
+	fieldArray // Fixed size byte arrays, like hashes and addresses
+	fieldBig   // big.Int
+)
+
+var bigIntType = reflect.TypeOf(big.Int{})
+
+// Table is a typed table in its own go-memdb database. The indexes are derived from the `memdb`
+// tags of the struct fields, which are comma separated lists of
+//
+//	id             the field is the primary key, or a part of it if more fields are tagged
+//	index          the field has a non-unique index named after it
+//	unique         the field has a unique index named after it
+//	index:<name>   the field is a part of the non-unique compound index, in the order of the fields
+//	unique:<name>  the field is a part of the unique compound index
+//
+// The indexed fields can be integers, bools, strings, []byte, byte arrays and big.Int, or pointers to
+// them, nil pointers aren't indexed. The rows are ordered by the indexed values in the queries.
+type Table[T any] struct {
+	name    string
+	db      *hashicorpmemdb.MemDB
+	indexes map[string]*fieldIndexer
+	count   atomic.Int64 // The number of rows, go-memdb doesn't count them.
+}
+
+// NewTypedTable creates a table of T, which is a struct or a pointer to a struct.
+func NewTypedTable[T any](name string) (*Table[T], error) {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s isn't a struct", ErrUnsupportedField, typ)
+	}
+
+	schemas := map[string]*hashicorpmemdb.IndexSchema{}
+	indexes := map[string]*fieldIndexer{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag, ok := field.Tag.Lookup(TABLE_TAG)
+		if !ok || tag == "" {
+			continue
+		}
+
+		if !field.IsExported() {
+			return nil, fmt.Errorf("%w: %s isn't exported", ErrUnsupportedField, field.Name)
+		}
+
+		class, err := classOf(field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, field.Name)
+		}
+
+		for _, option := range strings.Split(tag, ",") {
+			kind, indexName, _ := strings.Cut(strings.TrimSpace(option), ":")
+			if indexName == "" {
+				indexName = field.Name
+			}
+
+			switch kind {
+			case "id":
+				indexName = PRIMARY_KEY_NAME
+			case "index", "unique":
+			default:
+				return nil, fmt.Errorf("%w: unknown option %q of %s", ErrUnsupportedField, option, field.Name)
+			}
+
+			schema, ok := schemas[indexName]
+			if !ok {
+				indexes[indexName] = &fieldIndexer{}
+				schema = &hashicorpmemdb.IndexSchema{
+					Name:         indexName,
+					Unique:       kind != "index",
+					AllowMissing: kind != "id",
+					Indexer:      indexes[indexName],
+				}
+				schemas[indexName] = schema
+			}
+			indexes[indexName].fields = append(indexes[indexName].fields, indexedField{name: field.Name, index: i, class: class})
+		}
+	}
+
+	if schemas[PRIMARY_KEY_NAME] == nil {
+		return nil, ErrNoPrimaryKey
+	}
+
+	db, err := hashicorpmemdb.NewMemDB(&hashicorpmemdb.DBSchema{
+		Tables: map[string]*hashicorpmemdb.TableSchema{name: {Name: name, Indexes: schemas}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Table[T]{name: name, db: db, indexes: indexes}, nil
+}
+
+// Insert adds the rows to the table, replacing the rows with the same primary keys. Either all the
+// rows are inserted or none.
+func (this *Table[T]) Insert(rows ...T) error {
+	txn := this.db.Txn(true)
+	defer txn.Abort()
+	txn.TrackChanges()
+	for _, row := range rows {
+		if err := txn.Insert(this.name, row); err != nil {
+			return err
+		}
+	}
+	this.commit(txn)
+	return nil
+}
+
+// Delete removes the rows with the same primary keys as the given ones, the rows not in the table
+// are ignored.
+func (this *Table[T]) Delete(rows ...T) error {
+	txn := this.db.Txn(true)
+	defer txn.Abort()
+	txn.TrackChanges()
+	for _, row := range rows {
+		if err := txn.Delete(this.name, row); err != nil && !errors.Is(err, hashicorpmemdb.ErrNotFound) {
+			return err
+		}
+	}
+	this.commit(txn)
+	return nil
+}
+
+// Len returns the number of rows in the table.
+func (this *Table[T]) Len() int {
+	return int(this.count.Load())
+}
+
+// commit commits the write transaction and counts the rows it has added and removed, the changes
+// need to be tracked from the start of the transaction.
+func (this *Table[T]) commit(txn *hashicorpmemdb.Txn) {
+	delta := int64(0)
+	for _, change := range txn.Changes() {
+		if change.Created() {
+			delta++
+		} else if change.Deleted() {
+			delta--
+		}
+	}
+	txn.Commit()
+	this.count.Add(delta)
+}
+
+// Get returns the row with the primary key.
+func (this *Table[T]) Get(key ...any) (T, bool, error) {
+	obj, err := this.db.Txn(false).First(this.name, PRIMARY_KEY_NAME, key...)
+	if err != nil || obj == nil {
+		return *new(T), false, err
+	}
+	return obj.(T), true, nil
+}
+
+// Find returns the rows with the values in the index. Fewer values than the fields of a compound
+// index match the rows by the first fields only.
+func (this *Table[T]) Find(index string, values ...any) ([]T, error) {
+	rows, _, err := this.find(index, values...)
+	return rows, err
+}
+
+// Watch returns a channel closed when the rows Find returns with the same arguments change. Without
+// values, it watches the whole index.
+func (this *Table[T]) Watch(index string, values ...any) (<-chan struct{}, error) {
+	_, watch, err := this.find(index, values...)
+	return watch, err
+}
+
+func (this *Table[T]) find(index string, values ...any) ([]T, <-chan struct{}, error) {
+	if this.indexes[index] == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownIndex, index)
+	}
+
+	it, err := this.db.Txn(false).Get(this.name, index, values...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := []T{}
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		rows = append(rows, obj.(T))
+	}
+	return rows, it.WatchCh(), nil
+}
+
+// Bound is a bound of a range query. It has the values of the first fields of the index, or all of
+// them.
+type Bound struct {
+	Values    []any
+	Exclusive bool
+}
+
+func Inclusive(values ...any) *Bound { return &Bound{Values: values} }
+func Exclusive(values ...any) *Bound { return &Bound{Values: values, Exclusive: true} }
+
+// Query selects the rows in a range of an index, in the order of the index. A nil bound is
+// unbounded, and a non-positive limit returns all the rows after the offset.
+type Query struct {
+	Index        string // The primary key if empty
+	Lower, Upper *Bound
+	Offset       int
+	Limit        int
+}
+
+// Select returns the rows in the range of the query.
+func (this *Table[T]) Select(query Query) ([]T, error) {
+	if query.Index == "" {
+		query.Index = PRIMARY_KEY_NAME
+	}
+
+	indexer := this.indexes[query.Index]
+	if indexer == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownIndex, query.Index)
+	}
+
+	var lower, upper []byte
+	var err error
+	if query.Lower != nil {
+		if lower, err = indexer.FromArgs(query.Lower.Values...); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Upper != nil {
+		if upper, err = indexer.FromArgs(query.Upper.Values...); err != nil {
+			return nil, err
+		}
+	}
+
+	txn := this.db.Txn(false)
+	var it hashicorpmemdb.ResultIterator
+	if query.Lower == nil {
+		it, err = txn.Get(this.name, query.Index)
+	} else {
+		it, err = txn.LowerBound(this.name, query.Index, query.Lower.Values...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []T{}
+	offset := query.Offset
+	for obj := it.Next(); obj != nil; obj = it.Next() {
+		_, key, err := indexer.FromObject(obj)
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Lower != nil && query.Lower.Exclusive && bytes.HasPrefix(key, lower) {
+			continue
+		}
+
+		if query.Upper != nil {
+			if c := compareToBound(key, upper); c > 0 || (c == 0 && query.Upper.Exclusive) {
+				break
+			}
+		}
+
+		if offset > 0 {
+			offset--
+			continue
+		}
+
+		rows = append(rows, obj.(T))
+		if query.Limit > 0 && len(rows) == query.Limit {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// compareToBound compares a key to a bound on the fields the bound has, which are a prefix of the
+// key since the encoded fields are self delimiting.
+func compareToBound(key, bound []byte) int {
+	if bytes.HasPrefix(key, bound) {
+		return 0
+	}
+	return bytes.Compare(key, bound)
+}
+
+type indexedField struct {
+	name  string
+	index int
+	class int
+}
+
+// fieldIndexer indexes the fields of a struct, the values are encoded into bytes sorting in the same
+// order as the values, field by field.
+type fieldIndexer struct {
+	fields []indexedField
+}
+
+func (this *fieldIndexer) FromObject(obj any) (bool, []byte, error) {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return false, nil, nil
+		}
+		v = v.Elem()
+	}
+
+	key := []byte{}
+	for _, field := range this.fields {
+		value := v.Field(field.index)
+		if value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return false, nil, nil
+			}
+			value = value.Elem()
+		}
+
+		encoded, err := encodeField(field.class, value)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s: %w", field.name, err)
+		}
+		key = append(key, encoded...)
+	}
+	return true, key, nil
+}
+
+// FromArgs encodes the values of the first fields, at least one is needed.
+func (this *fieldIndexer) FromArgs(args ...any) ([]byte, error) {
+	if len(args) == 0 || len(args) > len(this.fields) {
+		return nil, fmt.Errorf("%w: expected 1 to %d values, got %d", ErrInvalidArg, len(this.fields), len(args))
+	}
+
+	key := []byte{}
+	for i, arg := range args {
+		value := reflect.ValueOf(arg)
+		for value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return nil, fmt.Errorf("%w: nil value for %s", ErrInvalidArg, this.fields[i].name)
+			}
+			value = value.Elem()
+		}
+
+		encoded, err := encodeField(this.fields[i].class, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", this.fields[i].name, err)
+		}
+		key = append(key, encoded...)
+	}
+	return key, nil
+}
+
+func classOf(typ reflect.Type) (int, error) {
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fieldUint, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fieldInt, nil
+	case reflect.Bool:
+		return fieldBool, nil
+	case reflect.String:
+		return fieldBytes, nil
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return fieldBytes, nil
+		}
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return fieldArray, nil
+		}
+	case reflect.Struct:
+		if typ == bigIntType {
+			return fieldBig, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrUnsupportedField, typ)
+}
+
+// encodeField encodes a field value or an argument of the field, the arguments can be of any type of
+// the same class, like an int for a uint64 field.
+func encodeField(class int, v reflect.Value) ([]byte, error) {
+	argClass, err := classOf(v.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	switch class {
+	case fieldUint, fieldInt, fieldBig:
+		if argClass != fieldUint && argClass != fieldInt && argClass != fieldBig {
+			break
+		}
+
+		n := toBig(argClass, v)
+		switch {
+		case class == fieldBig:
+			return encodeBig(n), nil
+		case class == fieldUint && n.Sign() >= 0 && n.IsUint64():
+			return orderedkey.AppendUint(nil, n.Uint64(), 64), nil
+		case class == fieldInt && n.IsInt64():
+			return orderedkey.AppendInt(nil, n.Int64(), 64), nil
+		}
+		return nil, fmt.Errorf("%w: %s is out of range", ErrInvalidArg, n)
+
+	case fieldBool:
+		if argClass == fieldBool {
+			if v.Bool() {
+				return []byte{1}, nil
+			}
+			return []byte{0}, nil
+		}
+
+	case fieldBytes:
+		if argClass == fieldBytes && v.Kind() == reflect.String {
+			return orderedkey.AppendString(nil, v.String()), nil
+		}
+
+		if argClass == fieldBytes {
+			return orderedkey.AppendBytes(nil, v.Bytes()), nil
+		}
+
+	case fieldArray:
+		if argClass == fieldArray || argClass == fieldBytes {
+			encoded := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(encoded), v)
+			return encoded, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unexpected %s", ErrInvalidArg, v.Type())
+}
+
+func toBig(class int, v reflect.Value) *big.Int {
+	switch class {
+	case fieldUint:
+		return new(big.Int).SetUint64(v.Uint())
+	case fieldInt:
+		return big.NewInt(v.Int())
+	}
+	n := v.Interface().(big.Int)
+	return &n
+}
+
+// encodeBig encodes a big integer as the sign, the length and the magnitude, with the length and the
+// magnitude inverted for the negatives, so the larger magnitudes come first.
+func encodeBig(n *big.Int) []byte {
+	magnitude := n.Bytes()
+	encoded := orderedkey.AppendUint(nil, uint64(n.Sign()+1), 8)
+	encoded = orderedkey.AppendUint(encoded, uint64(len(magnitude)), 32)
+	encoded = append(encoded, magnitude...)
+	if n.Sign() < 0 {
+		for i := 1; i < len(encoded); i++ {
+			encoded[i] = ^encoded[i]
+		}
+	}
+	return encoded
+}
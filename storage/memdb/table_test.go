@@ -0,0 +1,155 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package memdb
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type TableTx struct {
+	Hash   [4]byte  `memdb:"id"`
+	From   string   `memdb:"index,index:from_height"`
+	Height uint64   `memdb:"index,index:from_height"`
+	Nonce  int64    `memdb:"index"`
+	Data   []byte   `memdb:"index"`
+	Value  *big.Int `memdb:"index"`
+	Memo   string
+}
+
+func TestTypedTable(t *testing.T) {
+	table, err := NewTypedTable[*TableTx]("tx")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows := make([]*TableTx, 20)
+	for i := range rows {
+		rows[i] = &TableTx{
+			Hash:   [4]byte{0, 0, 0, byte(i)},
+			From:   []string{"alice", "bob"}[i%2],
+			Height: uint64(i / 4),
+			Nonce:  int64(i - 10),
+			Data:   []byte{byte(i % 3), 0, byte(i)},
+			Value:  new(big.Int).Mul(big.NewInt(int64(i-10)), big.NewInt(1e18)),
+		}
+	}
+	rows[5].Value = nil // Not indexed by value
+	if err := table.Insert(rows...); err != nil {
+		t.Fatal(err)
+	}
+
+	if row, ok, err := table.Get([4]byte{0, 0, 0, 7}); err != nil || !ok || row != rows[7] {
+		t.Fatal("unexpected row", row, ok, err)
+	}
+
+	watch, _ := table.Watch("from_height", "bob", 2)
+	if got, _ := table.Find("from_height", "bob", 2); len(got) != 2 || got[0] != rows[9] || got[1] != rows[11] {
+		t.Fatal("unexpected rows", got)
+	}
+
+	if got, _ := table.Find("from_height", "bob"); len(got) != 10 {
+		t.Fatal("expected all the rows from bob", len(got))
+	}
+
+	check := func(query Query, expected ...int) {
+		t.Helper()
+		got, err := table.Select(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(got) != len(expected) {
+			t.Fatalf("expected %v, got %d rows", expected, len(got))
+		}
+
+		for i := range expected {
+			if got[i] != rows[expected[i]] {
+				t.Fatalf("expected %v, got %v at %d", expected, got[i].Hash, i)
+			}
+		}
+	}
+
+	// Negative integers come first.
+	check(Query{Index: "Nonce", Lower: Inclusive(-2), Upper: Exclusive(2)}, 8, 9, 10, 11)
+	check(Query{Index: "Nonce", Lower: Exclusive(-2), Upper: Inclusive(2)}, 9, 10, 11, 12)
+	check(Query{Index: "Nonce", Upper: Exclusive(-8)}, 0, 1)
+
+	// big.Int, in any sign, and without the nil one.
+	check(Query{Index: "Value", Lower: Inclusive(big.NewInt(-6e18)), Upper: Inclusive(big.NewInt(-3e18))}, 4, 6, 7)
+	check(Query{Index: "Value", Lower: Exclusive(big.NewInt(8e18))}, 19)
+
+	// []byte, the zero bytes are in order.
+	check(Query{Index: "Data", Lower: Inclusive([]byte{2}), Upper: Inclusive([]byte{2, 0, 5})}, 2, 5)
+
+	// Compound ranges on the first fields, paginated.
+	check(Query{Index: "from_height", Lower: Inclusive("alice", 1), Upper: Inclusive("alice", 3)}, 4, 6, 8, 10, 12, 14)
+	check(Query{Index: "from_height", Lower: Exclusive("alice"), Offset: 2, Limit: 3}, 5, 7, 9)
+	check(Query{Index: "from_height", Lower: Inclusive("alice", 4), Upper: Exclusive("bob", 1)}, 16, 18, 1, 3)
+	check(Query{Lower: Inclusive([4]byte{0, 0, 0, 18})}, 18, 19)
+
+	// The watch is notified on a change of the rows.
+	select {
+	case <-watch:
+		t.Fatal("notified without a change")
+	default:
+	}
+
+	if err := table.Delete(rows[9], &TableTx{Hash: [4]byte{9, 9, 9, 9}}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-watch:
+	case <-time.After(time.Second):
+		t.Fatal("not notified")
+	}
+
+	if table.Len() != 19 {
+		t.Fatal("expected 19 rows, got", table.Len())
+	}
+
+	// Replacing a row, or inserting the same one twice, doesn't change the count.
+	added := &TableTx{Hash: [4]byte{1, 0, 0, 0}, From: "carol"}
+	if err := table.Insert(rows[0], added, added); err != nil || table.Len() != 20 {
+		t.Fatal("expected 20 rows, got", table.Len(), err)
+	}
+	if err := table.Delete(added, added); err != nil || table.Len() != 19 {
+		t.Fatal("expected 19 rows, got", table.Len(), err)
+	}
+
+	if _, err := table.Select(Query{Index: "Memo"}); !errors.Is(err, ErrUnknownIndex) {
+		t.Fatal("expected ErrUnknownIndex, got", err)
+	}
+
+	if _, err := table.Select(Query{Index: "Nonce", Lower: Inclusive("x")}); !errors.Is(err, ErrInvalidArg) {
+		t.Fatal("expected ErrInvalidArg, got", err)
+	}
+
+	if _, err := NewTypedTable[struct{ A float64 }]("bad"); !errors.Is(err, ErrNoPrimaryKey) {
+		t.Fatal("expected ErrNoPrimaryKey, got", err)
+	}
+
+	if _, err := NewTypedTable[struct {
+		A float64 `memdb:"id"`
+	}]("bad"); !errors.Is(err, ErrUnsupportedField) {
+		t.Fatal("expected ErrUnsupportedField, got", err)
+	}
+}
@@ -0,0 +1,557 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package merkleized provides a store decorator maintaining a hash tree over the contents of the
+// store it wraps, so the state root is available at every commit without exporting the store.
+//
+// The keys are spread into Branches^Depth buckets by the hashes of the keys. The leaf of a key is the
+// hash of the key and the value, a bucket is hashed from the leaves of its keys in the key order, and
+// every other node from its Branches children, the empty ones being all zeros. The leaves of a bucket
+// are hashed in groups of Branches up to a single hash, so a proof carries the groups on the path of
+// the key rather than the whole bucket. A write only marks its bucket, the marked paths are rehashed
+// on the next Root, Commit or Prove.
+//
+// The tree is kept in Config.Nodes when it is set, and saved to it on every Commit. A store reopened
+// after a commit loads the buckets and the nodes on demand, instead of rebuilding the tree from all
+// the contents of the underlying store, which it only does if written after the last commit.
+//
+// The committed roots are kept in Config.Roots when it is set, under ROOT_PREFIX followed by the
+// height in hex, so RootAt answers for the heights committed before a restart.
+package merkleized
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arcology-network/common-lib/merkle"
+	stgcodec "github.com/arcology-network/common-lib/storage/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	DEFAULT_BRANCHES = 16
+	DEFAULT_DEPTH    = 4 // 65536 buckets with the default branches
+
+	ROOT_PREFIX = "merkleized/root/"
+)
+
+var (
+	ErrInvalidConfig = errors.New("merkleized: invalid config")
+	ErrUnknownHeight = errors.New("merkleized: no root committed at the height")
+	ErrStaleProof    = errors.New("merkleized: the store changed underneath the tree")
+	ErrNoEncoder     = errors.New("merkleized: values other than bytes need an explicit encoder")
+	ErrBrokenTree    = errors.New("merkleized: the tree failed to follow a write and must be rebuilt")
+)
+
+type Config struct {
+	Branches  int                                  // The children of a node, DEFAULT_BRANCHES if 0.
+	Depth     int                                  // The levels above the buckets, DEFAULT_DEPTH if 0.
+	Hasher    interface{ Hash([]byte) []byte }     // merkle.Sha256 if nil.
+	Encoder   interface{ Encode([][]byte) []byte } // merkle.Concatenator if nil.
+	KeepRoots int                                  // The committed roots to keep, all of them if 0.
+
+	// Roots persists the committed roots, they are only kept in memory if nil.
+	Roots stgintf.ReadWriteStore[string, []byte]
+
+	// Nodes persists the tree under TREE_PREFIX, it is only kept in memory and rebuilt on every open
+	// if nil. It may be the same store as Roots.
+	Nodes stgintf.ReadWriteStore[string, []byte]
+
+	// EncodeValue returns the bytes of a value to hash. It must be deterministic, since the same
+	// contents have to hash to the same root everywhere. Only []byte and string values may leave it nil.
+	EncodeValue func(any) ([]byte, error)
+}
+
+// Proof proves the inclusion of the key with the value, the path is in the form of merkle.Merkle.Verify.
+type Proof struct {
+	Key   []byte
+	Value []byte
+	Path  [][][]byte
+}
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Store[string, []byte])(nil)
+
+// Store is a ReadWriteStore decorator keeping a hash tree over the keys written through it. The
+// writes made to the underlying store directly aren't seen until Rebuild is called.
+type Store[K stgintf.Key, V any] struct {
+	inner  stgintf.ReadWriteStore[K, V]
+	config Config
+
+	lock    sync.Mutex // Serializes the writes, so the tree follows the order the store is written.
+	tree    *tree
+	roots   map[uint64][]byte
+	heights []uint64 // The committed heights in order
+	written bool     // If the saved tree is marked as behind the store
+	broken  error    // The failure of the tree to follow a write, until rebuilt
+}
+
+// NewStore wraps the store and opens the tree saved in Config.Nodes if it is up to date, or builds
+// the tree from the current contents of the store otherwise.
+func NewStore[K stgintf.Key, V any](inner stgintf.ReadWriteStore[K, V], config Config) (*Store[K, V], error) {
+	if config.Branches == 0 {
+		config.Branches = DEFAULT_BRANCHES
+	}
+
+	if config.Depth == 0 {
+		config.Depth = DEFAULT_DEPTH
+	}
+
+	if config.Branches < 2 || config.Depth < 1 || config.Depth*bitsOf(config.Branches) > 64 {
+		return nil, fmt.Errorf("%w: %d branches in %d levels", ErrInvalidConfig, config.Branches, config.Depth)
+	}
+
+	if config.Hasher == nil {
+		config.Hasher = merkle.Sha256{}
+	}
+
+	if config.Encoder == nil {
+		config.Encoder = merkle.Concatenator{}
+	}
+
+	if config.EncodeValue == nil {
+		switch any(*new(V)).(type) {
+		case []byte, string:
+			config.EncodeValue = stgcodec.DefaultForwardConvertValue[any, []byte]
+		default:
+			return nil, fmt.Errorf("%w: %T", ErrNoEncoder, *new(V))
+		}
+	}
+
+	store := &Store[K, V]{
+		inner:  inner,
+		config: config,
+		roots:  map[uint64][]byte{},
+	}
+
+	if err := store.loadRoots(); err != nil {
+		return nil, err
+	}
+
+	saved, err := store.savedTree()
+	if err != nil || saved {
+		return store, err
+	}
+	return store, store.Rebuild()
+}
+
+// savedTree opens the tree in the node store, if saved by the last commit with the same shape.
+func (this *Store[K, V]) savedTree() (bool, error) {
+	if this.config.Nodes == nil {
+		return false, nil
+	}
+
+	state, err := this.config.Nodes.Get(STATE_KEY)
+	if errors.Is(err, stgintf.ErrNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if !bytes.Equal(state.([]byte), this.treeState(true)) {
+		return false, nil
+	}
+
+	this.tree = newTree(this.config.Branches, this.config.Depth, this.config.Hasher, this.config.Encoder, this.config.Nodes)
+	this.tree.stored = true
+	return true, nil
+}
+
+// treeState encodes the shape of the tree and if it is saved up to the store contents.
+func (this *Store[K, V]) treeState(saved bool) []byte {
+	state := binary.BigEndian.AppendUint32(nil, uint32(this.config.Branches))
+	state = binary.BigEndian.AppendUint32(state, uint32(this.config.Depth))
+	if saved {
+		return append(state, 1)
+	}
+	return append(state, 0)
+}
+
+// markWritten marks the saved tree as behind the store before the first write after a save, so the
+// tree is rebuilt on reopening if the store is written but not committed.
+func (this *Store[K, V]) markWritten() error {
+	if this.config.Nodes == nil || this.written {
+		return nil
+	}
+
+	if err := this.config.Nodes.Set(STATE_KEY, this.treeState(false)); err != nil {
+		return err
+	}
+	this.written = true
+	return nil
+}
+
+// follow applies a write to the tree, the tree is broken if it fails, since the store is written.
+func (this *Store[K, V]) follow(err error) {
+	if err != nil && this.broken == nil {
+		this.broken = fmt.Errorf("%w: %w", ErrBrokenTree, err)
+	}
+}
+
+// loadRoots reads the roots committed before from the root store.
+func (this *Store[K, V]) loadRoots() error {
+	if this.config.Roots == nil {
+		return nil
+	}
+
+	keys, values, errs := this.config.Roots.Query(ROOT_PREFIX, func(key string, _ []byte) bool { return strings.HasPrefix(key, ROOT_PREFIX) })
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		height, err := strconv.ParseUint(strings.TrimPrefix(key, ROOT_PREFIX), 16, 64)
+		if err != nil {
+			return fmt.Errorf("merkleized: corrupted root %s: %w", key, err)
+		}
+		this.roots[height] = values[i]
+		this.heights = append(this.heights, height)
+	}
+	sort.Slice(this.heights, func(i, j int) bool { return this.heights[i] < this.heights[j] })
+	return nil
+}
+
+func rootKey(height uint64) string { return fmt.Sprintf("%s%016x", ROOT_PREFIX, height) }
+
+// Inner returns the store being merkleized.
+func (this *Store[K, V]) Inner() stgintf.ReadWriteStore[K, V] { return this.inner }
+
+// Rebuild rebuilds the tree from all the contents of the underlying store, queried with the zero key
+// as the prefix, and saves it to the node store replacing the saved one. The committed roots are kept.
+func (this *Store[K, V]) Rebuild() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	keys, values, errs := this.inner.Query(*new(K), func(K, V) bool { return true })
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	if err := this.markWritten(); err != nil {
+		return err
+	}
+
+	tree := newTree(this.config.Branches, this.config.Depth, this.config.Hasher, this.config.Encoder, this.config.Nodes)
+	if this.config.Nodes != nil {
+		if err := tree.clear(); err != nil {
+			return err
+		}
+	}
+
+	for i := range keys {
+		key, value, err := this.encode(keys[i], values[i])
+		if err != nil {
+			return err
+		}
+
+		if err := tree.set(key, value); err != nil {
+			return err
+		}
+	}
+	this.tree, this.broken = tree, nil
+	return this.save()
+}
+
+// save saves the tree to the node store and marks it up to date with the store.
+func (this *Store[K, V]) save() error {
+	if this.config.Nodes == nil {
+		return nil
+	}
+
+	if err := this.tree.save(); err != nil {
+		return err
+	}
+
+	if err := this.config.Nodes.Set(STATE_KEY, this.treeState(true)); err != nil {
+		return err
+	}
+	this.written = false
+	return nil
+}
+
+func (this *Store[K, V]) Has(key K) bool                     { return this.inner.Has(key) }
+func (this *Store[K, V]) Get(key K) (any, error)             { return this.inner.Get(key) }
+func (this *Store[K, V]) GetAs(key K, hint any) (any, error) { return this.inner.GetAs(key, hint) }
+func (this *Store[K, V]) GetBatch(keys []K) ([]any, []error) { return this.inner.GetBatch(keys) }
+
+func (this *Store[K, V]) Query(pattern K, checker func(K, V) bool) ([]K, []V, []error) {
+	return this.inner.Query(pattern, checker)
+}
+
+func (this *Store[K, V]) Set(key K, value V) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	encodedKey, encodedValue, err := this.encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := this.markWritten(); err != nil {
+		return err
+	}
+
+	if err := this.inner.Set(key, value); err != nil {
+		return err
+	}
+	this.follow(this.tree.set(encodedKey, encodedValue))
+	return nil
+}
+
+// SetBatch writes the records, only the records written successfully are added to the tree. The
+// records failed to encode aren't written.
+func (this *Store[K, V]) SetBatch(keys []K, values []V) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	errs := make([]error, len(keys))
+	encodedKeys, encodedValues := make([][]byte, 0, len(keys)), make([][]byte, 0, len(keys))
+	innerKeys, innerValues, positions := make([]K, 0, len(keys)), make([]V, 0, len(keys)), make([]int, 0, len(keys))
+	for i := range keys {
+		key, value, err := this.encode(keys[i], values[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		encodedKeys, encodedValues = append(encodedKeys, key), append(encodedValues, value)
+		innerKeys, innerValues, positions = append(innerKeys, keys[i]), append(innerValues, values[i]), append(positions, i)
+	}
+
+	if err := this.markWritten(); err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	innerErrs := this.inner.SetBatch(innerKeys, innerValues)
+	for j, i := range positions {
+		if j < len(innerErrs) && innerErrs[j] != nil {
+			errs[i] = innerErrs[j]
+			continue
+		}
+		this.follow(this.tree.set(encodedKeys[j], encodedValues[j]))
+	}
+	return errs
+}
+
+func (this *Store[K, V]) Delete(key K) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	encodedKey, err := this.encodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := this.markWritten(); err != nil {
+		return err
+	}
+
+	if err := this.inner.Delete(key); err != nil {
+		return err
+	}
+	this.follow(this.tree.delete(encodedKey))
+	return nil
+}
+
+func (this *Store[K, V]) DeleteBatch(keys []K) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	errs := make([]error, len(keys))
+	encodedKeys := make([][]byte, len(keys))
+	for i, key := range keys {
+		encodedKeys[i], errs[i] = this.encodeKey(key)
+	}
+
+	if err := this.markWritten(); err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	innerErrs := this.inner.DeleteBatch(keys)
+	for i := range keys {
+		if i < len(innerErrs) && innerErrs[i] != nil {
+			errs[i] = innerErrs[i]
+		}
+
+		if errs[i] == nil {
+			this.follow(this.tree.delete(encodedKeys[i]))
+		}
+	}
+	return errs
+}
+
+// Root returns the root of the current contents, empty if the store is empty or the tree can't be
+// read, see Commit for the error.
+func (this *Store[K, V]) Root() []byte {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.broken != nil {
+		return []byte{}
+	}
+
+	root, err := this.tree.root()
+	if err != nil {
+		return []byte{}
+	}
+	return root
+}
+
+// Commit records the root of the current contents as the root of the height, saves the tree and
+// returns the root. The root isn't recorded if it fails to persist.
+func (this *Store[K, V]) Commit(height uint64) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.broken != nil {
+		return nil, this.broken
+	}
+
+	root, err := this.tree.root()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := this.save(); err != nil {
+		return nil, err
+	}
+
+	if this.config.Roots != nil {
+		if err := this.config.Roots.Set(rootKey(height), root); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := this.roots[height]; !ok {
+		this.heights = append(this.heights, height)
+		sort.Slice(this.heights, func(i, j int) bool { return this.heights[i] < this.heights[j] })
+	}
+	this.roots[height] = root
+
+	for this.config.KeepRoots > 0 && len(this.heights) > this.config.KeepRoots {
+		if this.config.Roots != nil {
+			if err := this.config.Roots.Delete(rootKey(this.heights[0])); err != nil {
+				return root, err
+			}
+		}
+		delete(this.roots, this.heights[0])
+		this.heights = this.heights[1:]
+	}
+	return root, nil
+}
+
+// RootAt returns the root committed at the height.
+func (this *Store[K, V]) RootAt(height uint64) ([]byte, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	root, ok := this.roots[height]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownHeight, height)
+	}
+	return root, nil
+}
+
+// Prove returns the proof of the key and its current value against the current root.
+func (this *Store[K, V]) Prove(key K) (*Proof, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.broken != nil {
+		return nil, this.broken
+	}
+
+	encodedKey, err := this.encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := this.tree.has(encodedKey); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, stgintf.ErrNotFound
+	}
+
+	value, err := this.inner.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedValue, err := this.config.EncodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := this.tree.path(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := this.tree.root()
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &Proof{Key: encodedKey, Value: encodedValue, Path: path}
+	if !VerifyProof(root, proof, this.config.Hasher, this.config.Encoder) {
+		return nil, fmt.Errorf("%w: %v", ErrStaleProof, key)
+	}
+	return proof, nil
+}
+
+// VerifyProof checks the proof against the root, with the hasher and the encoder of the store.
+func VerifyProof(root []byte, proof *Proof, hasher interface{ Hash([]byte) []byte }, encoder interface{ Encode([][]byte) []byte }) bool {
+	if proof == nil || len(proof.Path) == 0 || len(root) == 0 {
+		return false
+	}
+
+	seed := leafHash(hasher, proof.Key, proof.Value)
+	return merkle.NewMerkle(len(proof.Path[len(proof.Path)-1]), encoder, hasher).Verify(proof.Path, root, seed)
+}
+
+func (this *Store[K, V]) encode(key K, value V) ([]byte, []byte, error) {
+	encodedKey, err := this.encodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encodedValue, err := this.config.EncodeValue(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodedKey, encodedValue, nil
+}
+
+func (this *Store[K, V]) encodeKey(key K) ([]byte, error) {
+	return stgcodec.DefaultForwardConvertKey[K, []byte](key)
+}
+
+// bitsOf returns the bits needed to index the branches.
+func bitsOf(branches int) int {
+	bits := 0
+	for n := branches - 1; n > 0; n >>= 1 {
+		bits++
+	}
+	return bits
+}
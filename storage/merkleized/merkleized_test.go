@@ -0,0 +1,248 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkleized
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/arcology-network/common-lib/merkle"
+	"github.com/arcology-network/common-lib/storage/faulty"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func records(n int, version string) ([]string, [][]byte) {
+	keys, values := make([]string, n), make([][]byte, n)
+	for i := range keys {
+		keys[i], values[i] = fmt.Sprintf("key-%04d", i), []byte(fmt.Sprintf("value-%d-%s", i, version))
+	}
+	return keys, values
+}
+
+func TestIncrementalRoot(t *testing.T) {
+	store, err := NewStore[string, []byte](memdb.NewMemoryDB(), Config{Branches: 4, Depth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.Root()) != 0 {
+		t.Fatal("expected an empty root for an empty store")
+	}
+
+	keys, values := records(200, "v1")
+	store.SetBatch(keys, values)
+	root1, _ := store.Commit(1)
+
+	// The same contents written in another order by another store.
+	other, _ := NewStore[string, []byte](memdb.NewMemoryDB(), Config{Branches: 4, Depth: 3})
+	for i := len(keys) - 1; i >= 0; i-- {
+		other.Set(keys[i], values[i])
+	}
+
+	if !bytes.Equal(root1, other.Root()) {
+		t.Fatal("expected the same root for the same contents")
+	}
+
+	store.Set(keys[7], []byte("changed"))
+	store.DeleteBatch(keys[100:])
+	root2, _ := store.Commit(2)
+	if bytes.Equal(root1, root2) {
+		t.Fatal("expected the root to change")
+	}
+
+	// A fresh tree over the same store.
+	rebuilt, _ := NewStore[string, []byte](store.Inner(), Config{Branches: 4, Depth: 3})
+	if !bytes.Equal(root2, rebuilt.Root()) {
+		t.Fatal("expected the rebuilt root to match the incremental one")
+	}
+
+	store.Set(keys[7], values[7])
+	store.SetBatch(keys[100:], values[100:])
+	if root3, _ := store.Commit(3); !bytes.Equal(root1, root3) {
+		t.Fatal("expected the root of the original contents back")
+	}
+
+	if root, err := store.RootAt(2); err != nil || !bytes.Equal(root, root2) {
+		t.Fatalf("expected the root at height 2, got %x %v", root, err)
+	}
+
+	if _, err := store.RootAt(9); !errors.Is(err, ErrUnknownHeight) {
+		t.Fatalf("expected ErrUnknownHeight, got %v", err)
+	}
+}
+
+func TestKeepRoots(t *testing.T) {
+	store, _ := NewStore[string, []byte](memdb.NewMemoryDB(), Config{KeepRoots: 2})
+	for height := uint64(1); height <= 4; height++ {
+		store.Set(fmt.Sprint(height), []byte{byte(height)})
+		store.Commit(height)
+	}
+
+	if _, err := store.RootAt(2); !errors.Is(err, ErrUnknownHeight) {
+		t.Fatalf("expected the root at height 2 dropped, got %v", err)
+	}
+
+	if _, err := store.RootAt(3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProve(t *testing.T) {
+	config := Config{Branches: 2, Depth: 6, Hasher: merkle.Keccak256{}}
+	store, _ := NewStore[string, []byte](memdb.NewMemoryDB(), config)
+	keys, values := records(300, "v1")
+	store.SetBatch(keys, values)
+	root, _ := store.Commit(1)
+
+	for i, key := range keys {
+		proof, err := store.Prove(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(proof.Value, values[i]) || !VerifyProof(root, proof, merkle.Keccak256{}, merkle.Concatenator{}) {
+			t.Fatalf("expected a valid proof of %s", key)
+		}
+	}
+
+	proof, _ := store.Prove(keys[0])
+	proof.Value = []byte("forged")
+	if VerifyProof(root, proof, merkle.Keccak256{}, merkle.Concatenator{}) {
+		t.Fatal("expected a forged value to fail")
+	}
+
+	if _, err := store.Prove("missing"); !errors.Is(err, stgintf.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFailedWritesStayOutOfTree(t *testing.T) {
+	backend := faulty.NewStore[string, []byte](memdb.NewMemoryDB(), faulty.Config{
+		Rules: []faulty.Rule{{Ops: []string{faulty.OP_SET_BATCH}, Keys: regexp.MustCompile(`^key-000[0-4]$`), Probability: 1}},
+	})
+
+	store, _ := NewStore[string, []byte](backend, Config{})
+	keys, values := records(10, "v1")
+	errs := store.SetBatch(keys, values)
+	for i, err := range errs {
+		if (i < 5) != errors.Is(err, faulty.ErrInjected) {
+			t.Fatalf("%s: unexpected error %v", keys[i], err)
+		}
+	}
+
+	expected, _ := NewStore[string, []byte](memdb.NewMemoryDB(), Config{})
+	expected.SetBatch(keys[5:], values[5:])
+	if !bytes.Equal(store.Root(), expected.Root()) {
+		t.Fatal("expected the failed keys to be left out of the tree")
+	}
+}
+
+func TestRootsAfterReopen(t *testing.T) {
+	backend, roots := memdb.NewMemoryDB(), memdb.NewMemoryDB()
+	store, _ := NewStore[string, []byte](backend, Config{Roots: roots, KeepRoots: 2})
+	committed := map[uint64][]byte{}
+	for height := uint64(1); height <= 3; height++ {
+		store.Set(fmt.Sprint(height), []byte{byte(height)})
+		committed[height], _ = store.Commit(height)
+	}
+
+	reopened, err := NewStore[string, []byte](backend, Config{Roots: roots, KeepRoots: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for height := uint64(2); height <= 3; height++ {
+		if root, err := reopened.RootAt(height); err != nil || !bytes.Equal(root, committed[height]) {
+			t.Fatalf("expected the root at height %d, got %x %v", height, root, err)
+		}
+	}
+
+	if _, err := reopened.RootAt(1); !errors.Is(err, ErrUnknownHeight) {
+		t.Fatalf("expected the root at height 1 dropped, got %v", err)
+	}
+
+	if _, err := NewStore[string, int64](nil, Config{}); !errors.Is(err, ErrNoEncoder) {
+		t.Fatalf("expected ErrNoEncoder, got %v", err)
+	}
+}
+
+func TestTreeAfterReopen(t *testing.T) {
+	backend, nodes := memdb.NewMemoryDB(), memdb.NewMemoryDB()
+	config := Config{Branches: 4, Depth: 2, Roots: nodes, Nodes: nodes}
+	store, _ := NewStore[string, []byte](backend, config)
+	keys, values := records(300, "v1")
+	store.SetBatch(keys, values)
+	root1, err := store.Commit(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Opening a committed tree doesn't read the contents of the store.
+	unqueryable := faulty.NewStore[string, []byte](backend, faulty.Config{Rules: []faulty.Rule{{Ops: []string{faulty.OP_QUERY}, Probability: 1}}})
+	reopened, err := NewStore[string, []byte](unqueryable, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(reopened.Root(), root1) {
+		t.Fatal("expected the committed root after reopening")
+	}
+
+	// The leaves of a bucket are hashed in groups, so the proofs don't carry the whole buckets.
+	proof, err := reopened.Prove(keys[42])
+	if err != nil || !VerifyProof(root1, proof, merkle.Sha256{}, merkle.Concatenator{}) {
+		t.Fatal("expected a valid proof after reopening", err)
+	}
+
+	for _, group := range proof.Path {
+		if len(group) > config.Branches {
+			t.Fatalf("expected groups of %d hashes at most, got %d", config.Branches, len(group))
+		}
+	}
+
+	expected, _ := NewStore[string, []byte](memdb.NewMemoryDB(), Config{Branches: 4, Depth: 2})
+	expected.SetBatch(keys, values)
+	expected.Set(keys[7], []byte("changed"))
+	expected.Delete(keys[8])
+
+	reopened.Set(keys[7], []byte("changed"))
+	reopened.Delete(keys[8])
+	if root2, err := reopened.Commit(2); err != nil || !bytes.Equal(root2, expected.Root()) {
+		t.Fatalf("expected the root of the updated contents, got %x %v", root2, err)
+	}
+
+	// Written but not committed, the saved tree is behind the store, so it is rebuilt.
+	expected.Set("uncommitted", []byte{1})
+	reopened.Set("uncommitted", []byte{1})
+	if _, err := NewStore[string, []byte](unqueryable, config); !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the tree to be rebuilt from the store, got", err)
+	}
+
+	rebuilt, err := NewStore[string, []byte](backend, config)
+	if err != nil || !bytes.Equal(rebuilt.Root(), expected.Root()) {
+		t.Fatal("expected the root of the uncommitted contents after the rebuild", err)
+	}
+
+	if reopened, err := NewStore[string, []byte](unqueryable, config); err != nil || !bytes.Equal(reopened.Root(), expected.Root()) {
+		t.Fatal("expected the rebuilt tree to be saved", err)
+	}
+}
@@ -0,0 +1,428 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package merkleized
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	TREE_PREFIX = "merkleized/tree/"
+	LEAF_PREFIX = TREE_PREFIX + "leaf/" // Followed by the bucket in hex, a slash and the encoded key
+	NODE_PREFIX = TREE_PREFIX + "node/" // Followed by the level and the index in hex
+	STATE_KEY   = TREE_PREFIX + "state"
+)
+
+// tree is a sparse tree of a fixed shape, the keys are spread into branches^depth buckets by their
+// hashes. Only the buckets and the nodes above them with keys are kept, and only the paths of the
+// buckets changed since the last hashing are rehashed.
+//
+// With a node store, the leaf and node hashes are saved to it and the tree only holds the ones read
+// or changed since the last save, the others are loaded on demand.
+type tree struct {
+	branches uint64
+	depth    int
+	hasher   interface{ Hash([]byte) []byte }
+	encoder  interface{ Encode([][]byte) []byte }
+	empty    []byte // The hash of an empty bucket or node, all zeros.
+
+	nodes   stgintf.ReadWriteStore[string, []byte] // Nil to keep the tree in memory only
+	stored  bool                                   // If the node store has hashes not loaded yet
+	buckets map[uint64]map[string][]byte           // Bucket -> encoded key -> leaf hash
+	levels  []map[uint64][]byte                    // The node hashes, levels[0] are the buckets and levels[depth] the root, nil for the empty ones.
+	dirty   map[uint64]struct{}                    // The buckets changed since the last hashing
+	unsaved map[string][]byte                      // Node store key -> the hash to save, nil to delete
+}
+
+func newTree(branches, depth int, hasher interface{ Hash([]byte) []byte }, encoder interface{ Encode([][]byte) []byte }, nodes stgintf.ReadWriteStore[string, []byte]) *tree {
+	tree := &tree{
+		branches: uint64(branches),
+		depth:    depth,
+		hasher:   hasher,
+		encoder:  encoder,
+		empty:    make([]byte, len(hasher.Hash([]byte{0}))),
+		nodes:    nodes,
+	}
+	tree.reset()
+	return tree
+}
+
+// reset drops everything held in memory, the saved hashes are loaded again when needed.
+func (this *tree) reset() {
+	this.buckets = map[uint64]map[string][]byte{}
+	this.levels = make([]map[uint64][]byte, this.depth+1)
+	for i := range this.levels {
+		this.levels[i] = map[uint64][]byte{}
+	}
+	this.dirty = map[uint64]struct{}{}
+	this.unsaved = map[string][]byte{}
+}
+
+// leafHash binds the key to the value, the fields are length prefixed so they can't be shifted.
+func leafHash(hasher interface{ Hash([]byte) []byte }, key, value []byte) []byte {
+	return hasher.Hash(codec.Byteset{key, value}.Encode())
+}
+
+func (this *tree) bucketOf(key []byte) uint64 {
+	hash := this.hasher.Hash(key)
+	var prefix [8]byte
+	copy(prefix[:], hash)
+
+	total := uint64(1)
+	for i := 0; i < this.depth; i++ {
+		total *= this.branches
+	}
+	return binary.BigEndian.Uint64(prefix[:]) % total
+}
+
+func leafKey(bucket uint64, key []byte) string {
+	return fmt.Sprintf("%s%016x/%s", LEAF_PREFIX, bucket, key)
+}
+
+func nodeKey(level int, index uint64) string {
+	return fmt.Sprintf("%s%02x%016x", NODE_PREFIX, level, index)
+}
+
+// bucket returns the leaf hashes of the bucket by the encoded keys, loading them if not in memory.
+func (this *tree) bucket(index uint64) (map[string][]byte, error) {
+	if entries, ok := this.buckets[index]; ok || !this.stored {
+		return entries, nil
+	}
+
+	prefix := fmt.Sprintf("%s%016x/", LEAF_PREFIX, index)
+	entries := map[string][]byte{}
+	if err := scanPrefix(this.nodes, prefix, func(key string, hash []byte) {
+		entries[strings.TrimPrefix(key, prefix)] = hash
+	}); err != nil {
+		return nil, err
+	}
+	this.buckets[index] = entries
+	return entries, nil
+}
+
+// node returns the hash of the node, loading it if not in memory.
+func (this *tree) node(level int, index uint64) ([]byte, error) {
+	if hash, ok := this.levels[level][index]; ok || !this.stored {
+		return this.orEmpty(hash), nil
+	}
+
+	hash, err := this.nodes.Get(nodeKey(level, index))
+	if err != nil && !errors.Is(err, stgintf.ErrNotFound) {
+		return nil, err
+	}
+
+	stored, _ := hash.([]byte)
+	this.levels[level][index] = stored
+	return this.orEmpty(stored), nil
+}
+
+func (this *tree) orEmpty(hash []byte) []byte {
+	if hash == nil {
+		return this.empty
+	}
+	return hash
+}
+
+func (this *tree) setNode(level int, index uint64, hash []byte) {
+	if this.nodes == nil {
+		if hash == nil {
+			delete(this.levels[level], index)
+		} else {
+			this.levels[level][index] = hash
+		}
+		return
+	}
+	this.levels[level][index] = hash
+	this.unsaved[nodeKey(level, index)] = hash
+}
+
+func (this *tree) set(key, value []byte) error {
+	index := this.bucketOf(key)
+	entries, err := this.bucket(index)
+	if err != nil {
+		return err
+	}
+
+	if entries == nil {
+		entries = map[string][]byte{}
+		this.buckets[index] = entries
+	}
+
+	hash := leafHash(this.hasher, key, value)
+	entries[string(key)] = hash
+	this.dirty[index] = struct{}{}
+	if this.nodes != nil {
+		this.unsaved[leafKey(index, key)] = hash
+	}
+	return nil
+}
+
+func (this *tree) delete(key []byte) error {
+	index := this.bucketOf(key)
+	entries, err := this.bucket(index)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := entries[string(key)]; !ok {
+		return nil
+	}
+
+	delete(entries, string(key))
+	if len(entries) == 0 && this.nodes == nil {
+		delete(this.buckets, index)
+	}
+
+	this.dirty[index] = struct{}{}
+	if this.nodes != nil {
+		this.unsaved[leafKey(index, key)] = nil
+	}
+	return nil
+}
+
+func (this *tree) has(key []byte) (bool, error) {
+	entries, err := this.bucket(this.bucketOf(key))
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := entries[string(key)]
+	return ok, nil
+}
+
+// leaves returns the keys of the bucket in order and their leaf hashes.
+func (this *tree) leaves(index uint64) ([]string, [][]byte, error) {
+	entries, err := this.bucket(index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hashes := make([][]byte, len(keys))
+	for i, key := range keys {
+		hashes[i] = entries[key]
+	}
+	return keys, hashes, nil
+}
+
+// bucketLevels hashes the leaves of a bucket in groups of the branches up to a single hash, so a
+// proof only carries the groups on the path of its key. It returns the groups on the path of the
+// leaf at the position from the leaves up, none for a negative position, and the bucket hash.
+func (this *tree) bucketLevels(leaves [][]byte, at int) (groups [][][]byte, hash []byte) {
+	level := leaves
+	for {
+		parents := make([][]byte, 0, (uint64(len(level))+this.branches-1)/this.branches)
+		for start := 0; start < len(level); start += int(this.branches) {
+			group := level[start:min(start+int(this.branches), len(level))]
+			if at >= start && at < start+len(group) {
+				groups = append(groups, group)
+			}
+			parents = append(parents, this.hasher.Hash(this.encoder.Encode(group)))
+		}
+
+		if at >= 0 {
+			at /= int(this.branches)
+		}
+
+		if level = parents; len(level) == 1 {
+			return groups, level[0]
+		}
+	}
+}
+
+// children returns the hashes of the children of the node, with the empty ones.
+func (this *tree) children(level int, index uint64) ([][]byte, error) {
+	hashes := make([][]byte, this.branches)
+	for i := range hashes {
+		hash, err := this.node(level-1, index*this.branches+uint64(i))
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+// rehash updates the hashes on the paths of the dirty buckets.
+func (this *tree) rehash() error {
+	if len(this.dirty) == 0 {
+		return nil
+	}
+
+	for bucket := range this.dirty {
+		_, leaves, err := this.leaves(bucket)
+		if err != nil {
+			return err
+		}
+
+		if len(leaves) == 0 {
+			this.setNode(0, bucket, nil)
+			continue
+		}
+		_, hash := this.bucketLevels(leaves, -1)
+		this.setNode(0, bucket, hash)
+	}
+
+	dirty := this.dirty
+	for level := 1; level <= this.depth; level++ {
+		parents := make(map[uint64]struct{}, len(dirty))
+		for index := range dirty {
+			parents[index/this.branches] = struct{}{}
+		}
+
+		for parent := range parents {
+			children, err := this.children(level, parent)
+			if err != nil {
+				return err
+			}
+
+			if allEmpty(children, this.empty) {
+				this.setNode(level, parent, nil)
+				continue
+			}
+			this.setNode(level, parent, this.hasher.Hash(this.encoder.Encode(children)))
+		}
+		dirty = parents
+	}
+	this.dirty = map[uint64]struct{}{}
+	return nil
+}
+
+func (this *tree) root() ([]byte, error) {
+	if err := this.rehash(); err != nil {
+		return nil, err
+	}
+
+	root, err := this.node(this.depth, 0)
+	if err != nil || bytes.Equal(root, this.empty) {
+		return []byte{}, err
+	}
+	return root, nil
+}
+
+// path returns the hashes hashed into each node on the way from the leaf of the key to the root, in
+// the form of merkle.Merkle.Verify.
+func (this *tree) path(key []byte) ([][][]byte, error) {
+	if err := this.rehash(); err != nil {
+		return nil, err
+	}
+
+	index := this.bucketOf(key)
+	keys, leaves, err := this.leaves(index)
+	if err != nil {
+		return nil, err
+	}
+
+	path, _ := this.bucketLevels(leaves, sort.SearchStrings(keys, string(key)))
+	for level := 1; level <= this.depth; level++ {
+		index /= this.branches
+		children, err := this.children(level, index)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, children)
+	}
+	return path, nil
+}
+
+// save writes the hashes changed since the last save to the node store, and drops the tree from
+// memory. The hashes are rehashed first.
+func (this *tree) save() error {
+	if this.nodes == nil {
+		return nil
+	}
+
+	if err := this.rehash(); err != nil {
+		return err
+	}
+
+	var setKeys, deleteKeys []string
+	var setValues [][]byte
+	for key, hash := range this.unsaved {
+		if hash == nil {
+			deleteKeys = append(deleteKeys, key)
+		} else {
+			setKeys, setValues = append(setKeys, key), append(setValues, hash)
+		}
+	}
+
+	if err := errors.Join(this.nodes.SetBatch(setKeys, setValues)...); err != nil {
+		return err
+	}
+
+	if err := errors.Join(this.nodes.DeleteBatch(deleteKeys)...); err != nil {
+		return err
+	}
+	this.reset()
+	this.stored = true
+	return nil
+}
+
+// clear deletes all the saved hashes from the node store.
+func (this *tree) clear() error {
+	var keys []string
+	if err := scanPrefix(this.nodes, TREE_PREFIX, func(key string, _ []byte) { keys = append(keys, key) }); err != nil {
+		return err
+	}
+	return errors.Join(this.nodes.DeleteBatch(keys)...)
+}
+
+// scanPrefix visits the entries of the store under the prefix, the iterable stores are seeked to it.
+func scanPrefix(store stgintf.ReadWriteStore[string, []byte], prefix string, visitor func(string, []byte)) error {
+	if iterable, ok := store.(stgintf.Iterable[string, []byte]); ok {
+		return iterable.Iterate(prefix, func(key string, value []byte) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			visitor(key, value)
+			return true
+		})
+	}
+
+	keys, values, errs := store.Query(prefix, func(key string, _ []byte) bool { return strings.HasPrefix(key, prefix) })
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		visitor(key, values[i])
+	}
+	return nil
+}
+
+func allEmpty(hashes [][]byte, empty []byte) bool {
+	for _, hash := range hashes {
+		if !bytes.Equal(hash, empty) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package metrics defines the interface the storage components report their metrics through,
+// and an in-memory registry implementing it. Exporting the metrics to a monitoring system only
+// takes an adapter implementing the Recorder interface.
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Recorder receives the metrics. The implementations must be thread safe.
+type Recorder interface {
+	Observe(name string, value float64) // Records a sample of a distribution, like a latency or a size.
+	Add(name string, delta int64)       // Increments a counter.
+}
+
+// Nop discards all the metrics.
+type Nop struct{}
+
+func (Nop) Observe(string, float64) {}
+func (Nop) Add(string, int64)       {}
+
+// DEFAULT_BOUNDS are the upper bounds of the histogram buckets, which cover the latencies in
+// seconds from 1 microsecond to about 10 seconds, as well as the sizes from 1 to about 10 million.
+var DEFAULT_BOUNDS = func() []float64 {
+	bounds := []float64{}
+	for exp := -6; exp <= 7; exp++ {
+		for _, m := range []float64{1, 2.5, 5} {
+			bounds = append(bounds, m*math.Pow10(exp))
+		}
+	}
+	return bounds
+}()
+
+// Histogram is the distribution of the samples of a metric.
+type Histogram struct {
+	Count   uint64
+	Sum     float64
+	Min     float64
+	Max     float64
+	Bounds  []float64 // The upper bounds of the buckets.
+	Buckets []uint64  // The number of samples in each bucket, the last one is for the samples above all the bounds.
+}
+
+// Mean returns the average of the samples.
+func (this *Histogram) Mean() float64 {
+	if this.Count == 0 {
+		return 0
+	}
+	return this.Sum / float64(this.Count)
+}
+
+// Quantile returns an estimation of the q-quantile, which is the upper bound of the bucket it falls in.
+func (this *Histogram) Quantile(q float64) float64 {
+	if this.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(this.Count)))
+	total := uint64(0)
+	for i, n := range this.Buckets {
+		if total += n; total >= rank && i < len(this.Bounds) {
+			return math.Min(this.Bounds[i], this.Max)
+		}
+	}
+	return this.Max
+}
+
+func (this *Histogram) observe(value float64) {
+	if this.Count == 0 || value < this.Min {
+		this.Min = value
+	}
+	if this.Count == 0 || value > this.Max {
+		this.Max = value
+	}
+	this.Count++
+	this.Sum += value
+	this.Buckets[sort.SearchFloat64s(this.Bounds, value)]++
+}
+
+// Registry is a Recorder keeping all the metrics in memory.
+type Registry struct {
+	lock       sync.Mutex
+	bounds     []float64
+	counters   map[string]int64
+	histograms map[string]*Histogram
+}
+
+// NewRegistry creates a registry, the histograms use the given bucket bounds or DEFAULT_BOUNDS.
+func NewRegistry(bounds ...float64) *Registry {
+	if len(bounds) == 0 {
+		bounds = DEFAULT_BOUNDS
+	}
+
+	return &Registry{
+		bounds:     append([]float64{}, bounds...),
+		counters:   map[string]int64{},
+		histograms: map[string]*Histogram{},
+	}
+}
+
+func (this *Registry) Observe(name string, value float64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	histogram, ok := this.histograms[name]
+	if !ok {
+		histogram = &Histogram{Bounds: this.bounds, Buckets: make([]uint64, len(this.bounds)+1)}
+		this.histograms[name] = histogram
+	}
+	histogram.observe(value)
+}
+
+func (this *Registry) Add(name string, delta int64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.counters[name] += delta
+}
+
+// Counter returns the value of a counter, 0 if it doesn't exist.
+func (this *Registry) Counter(name string) int64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.counters[name]
+}
+
+// Histogram returns a copy of a histogram, nil if it doesn't exist.
+func (this *Registry) Histogram(name string) *Histogram {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	histogram, ok := this.histograms[name]
+	if !ok {
+		return nil
+	}
+	snapshot := *histogram
+	snapshot.Buckets = append([]uint64{}, histogram.Buckets...)
+	return &snapshot
+}
+
+// Names returns the sorted names of all the counters and histograms.
+func (this *Registry) Names() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	names := make([]string, 0, len(this.counters)+len(this.histograms))
+	for name := range this.counters {
+		names = append(names, name)
+	}
+	for name := range this.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Reset removes all the metrics.
+func (this *Registry) Reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.counters = map[string]int64{}
+	this.histograms = map[string]*Histogram{}
+}
@@ -0,0 +1,64 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry(1, 10, 100)
+	for _, v := range []float64{0.5, 2, 3, 50, 500} {
+		registry.Observe("size", v)
+	}
+	registry.Add("errors", 2)
+	registry.Add("errors", 1)
+
+	if registry.Counter("errors") != 3 || registry.Counter("missing") != 0 {
+		t.Fatal("unexpected counters")
+	}
+
+	histogram := registry.Histogram("size")
+	if histogram.Count != 5 || histogram.Min != 0.5 || histogram.Max != 500 || histogram.Mean() != 111.1 {
+		t.Fatal("unexpected histogram", histogram)
+	}
+	if !reflect.DeepEqual(histogram.Buckets, []uint64{1, 2, 1, 1}) {
+		t.Fatal("unexpected buckets", histogram.Buckets)
+	}
+	if q := histogram.Quantile(0.5); q != 10 {
+		t.Fatal("expected the median in the bucket up to 10, got", q)
+	}
+	if q := histogram.Quantile(1); q != 500 {
+		t.Fatal("expected the max, got", q)
+	}
+
+	// The returned histogram is a copy.
+	registry.Observe("size", 1)
+	if histogram.Count != 5 {
+		t.Fatal("the histogram isn't a copy")
+	}
+
+	if names := registry.Names(); !reflect.DeepEqual(names, []string{"errors", "size"}) {
+		t.Fatal("unexpected names", names)
+	}
+	registry.Reset()
+	if registry.Histogram("size") != nil {
+		t.Fatal("expected no histogram after Reset")
+	}
+}
@@ -25,13 +25,20 @@ import (
 	"github.com/cockroachdb/pebble"
 )
 
+var _ stgintf.Syncable = (*PebbleDB)(nil)
+
 type PebbleDB struct {
 	impl    *pebble.DB
 	decoder func(string, any, any) (any, error)
 }
 
 func NewPebbleDB(path string, decoder ...func(string, any, any) (any, error)) (*PebbleDB, error) {
-	db, err := pebble.Open(path, &pebble.Options{})
+	return OpenPebbleDB(path, &pebble.Options{}, decoder...)
+}
+
+// OpenPebbleDB opens a PebbleDB with the given options.
+func OpenPebbleDB(path string, options *pebble.Options, decoder ...func(string, any, any) (any, error)) (*PebbleDB, error) {
+	db, err := pebble.Open(path, options)
 	if err != nil {
 		return nil, err
 	}
@@ -72,6 +79,11 @@ func (this *PebbleDB) GetBatch(keys []string) ([]any, []error) {
 	errs := make([]error, len(keys))
 	for i, key := range keys {
 		stored, closer, err := this.impl.Get(unsafe.Slice(unsafe.StringData(key), len(key)))
+		if err == pebble.ErrNotFound {
+			errs[i] = stgintf.ErrNotFound
+			continue
+		}
+
 		if err != nil {
 			errs[i] = err
 			continue
@@ -170,6 +182,36 @@ func (this *PebbleDB) Query(prefix string, checker func(string, []byte) bool) ([
 	return keys, values, nil
 }
 
+// Sync makes the writes made so far durable, the writes of the DB are made without syncing the WAL.
+func (this *PebbleDB) Sync() error {
+	return this.impl.LogData(nil, pebble.Sync)
+}
+
+// Iterate calls the visitor for the entries from the key on, in the ascending order of the keys, until
+// the visitor returns false. The entries are read from a consistent view of the DB.
+func (this *PebbleDB) Iterate(from string, visitor func(string, []byte) bool) error {
+	iter, err := this.impl.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	return iterate(iter, from, visitor)
+}
+
+// iterate seeks the iterator to the key and walks it forward, the iterator is closed when done.
+func iterate(iter *pebble.Iterator, from string, visitor func(string, []byte) bool) error {
+	for iter.SeekGE([]byte(from)); iter.Valid(); iter.Next() {
+		if !visitor(string(iter.Key()), bytes.Clone(iter.Value())) {
+			break
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return err
+	}
+	return iter.Close()
+}
+
 func (this *PebbleDB) Close() error {
 	return this.impl.Close()
 }
@@ -18,8 +18,11 @@
 package pebbledb
 
 import (
+	"bytes"
 	"path/filepath"
 	"testing"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
 )
 
 func tempPebblePath(tb testing.TB) string {
@@ -115,3 +118,79 @@ func TestPebbleDBFunctions(t *testing.T) {
 	t.Log(qkeys)
 	t.Log(qvalues)
 }
+
+func TestPebbleDBSnapshotAndCheckpoint(t *testing.T) {
+	db, err := NewPebbleDB(tempPebblePath(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	db.Set("a01", []byte{1})
+	db.Set("a02", []byte{2})
+
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes after the snapshot and the checkpoint aren't visible to them.
+	db.Set("a01", []byte{11})
+	db.Delete("a02")
+	db.Set("a03", []byte{3})
+
+	if v, err := snapshot.Get("a01"); err != nil || !bytes.Equal(v.([]byte), []byte{1}) {
+		t.Fatal("expected the value as of the snapshot", v, err)
+	}
+	if !snapshot.Has("a02") || snapshot.Has("a03") {
+		t.Fatal("the snapshot sees the later writes")
+	}
+	if _, err := snapshot.Get("a03"); err != stgintf.ErrNotFound {
+		t.Fatal("expected ErrNotFound", err)
+	}
+	if keys, _, _ := snapshot.Query("a", nil); len(keys) != 2 {
+		t.Fatal("expected 2 keys, got", keys)
+	}
+
+	if err := db.Checkpoint(dir); err == nil {
+		t.Fatal("expected an error checkpointing into an existing directory")
+	}
+
+	copied, err := NewPebbleDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+
+	keys, values, errs := copied.Query("", nil)
+	if len(errs) > 0 || len(keys) != 2 || !bytes.Equal(values[0], []byte{1}) || !bytes.Equal(values[1], []byte{2}) {
+		t.Fatal("unexpected checkpoint content", keys, values, errs)
+	}
+}
+
+func TestPebbleSnapshotDecoder(t *testing.T) {
+	decoder := func(key string, value any, typeHint any) (any, error) {
+		return string(value.([]byte)), nil
+	}
+
+	db, err := NewPebbleDB(tempPebblePath(t), decoder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.Set("a01", []byte("one"))
+
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+
+	values, errs := snapshot.GetBatch([]string{"a01", "a02"})
+	if errs[0] != nil || values[0] != "one" {
+		t.Fatal("expected the decoded value", values[0], errs[0])
+	}
+	if errs[1] != stgintf.ErrNotFound {
+		t.Fatal("expected ErrNotFound", errs[1])
+	}
+}
@@ -18,25 +18,46 @@
 package pebbledb
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	common "github.com/arcology-network/common-lib/common"
-	slice "github.com/arcology-network/common-lib/exp/slice"
 	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/sharding"
+)
+
+var (
+	_ stgintf.ReadWriteStore[string, []byte] = (*ParaPebbleDB)(nil)
+	_ stgintf.Syncable                       = (*ParaPebbleDB)(nil)
 )
 
 type ParaPebbleDB struct {
-	impls      [16]*PebbleDB
-	shardLocks [16]sync.RWMutex
+	impls      []*PebbleDB // Indexed by the shard ID, nil for the shards that have left the ring.
+	shardLocks []*sync.RWMutex
 	shardFunc  func(int, string) int
+
+	// Only used by the DBs created with NewParaPebbleDBWithRing.
+	root         string
+	decoder      func(string, any, any) (any, error)
+	topology     sync.RWMutex
+	ring         *sharding.Ring
+	previous     *sharding.Ring // The ring before the ongoing rebalance, nil if there is none.
+	manifest     *sharding.Manifest
+	rebalancing  sync.WaitGroup
+	rebalanceErr error
+	closed       atomic.Bool
 }
 
 func NewParaPebbleDB(root string, shardFunc func(numOfShard int, key string) int, decoder ...func(string, any, any) (any, error)) (*ParaPebbleDB, error) {
-	var paraPebbleDB ParaPebbleDB
+	paraPebbleDB := ParaPebbleDB{
+		impls:      make([]*PebbleDB, 16),
+		shardLocks: make([]*sync.RWMutex, 16),
+	}
 	if _, err := os.Stat(root); os.IsNotExist(err) {
 		if err := os.MkdirAll(root, fs.ModePerm); err != nil {
 			return nil, err
@@ -55,6 +76,7 @@ func NewParaPebbleDB(root string, shardFunc func(numOfShard int, key string) int
 			return nil, err
 		}
 		paraPebbleDB.impls[i] = db
+		paraPebbleDB.shardLocks[i] = &sync.RWMutex{}
 	}
 
 	if shardFunc != nil {
@@ -71,11 +93,46 @@ func NewParaPebbleDB(root string, shardFunc func(numOfShard int, key string) int
 	return &paraPebbleDB, nil
 }
 
+// NewParaPebbleDBWithRing creates a sharded DB whose keys are distributed by a consistent hashing ring
+// with the given number of virtual nodes per shard. The ring membership is persisted in a manifest under
+// the root directory, the initial number of shards is only used when the manifest doesn't exist yet.
+// An unfinished rebalance found in the manifest is resumed in the background.
+func NewParaPebbleDBWithRing(root string, initialShards, vnodes int, decoder ...func(string, any, any) (any, error)) (*ParaPebbleDB, error) {
+	if err := os.MkdirAll(root, fs.ModePerm); err != nil {
+		return nil, err
+	}
+
+	manifest, err := sharding.LoadManifest(root, vnodes, initialShards)
+	if err != nil {
+		return nil, err
+	}
+
+	paraPebbleDB := &ParaPebbleDB{
+		root:     root,
+		manifest: manifest,
+		ring:     manifest.Ring(),
+		previous: manifest.PreviousRing(),
+	}
+
+	if len(decoder) > 0 {
+		paraPebbleDB.decoder = decoder[0]
+	}
+
+	for _, id := range manifest.AllShards() {
+		if err := paraPebbleDB.openShard(id); err != nil {
+			paraPebbleDB.Close()
+			return nil, err
+		}
+	}
+
+	if paraPebbleDB.previous != nil {
+		paraPebbleDB.startRebalance(paraPebbleDB.previous.Shards())
+	}
+	return paraPebbleDB, nil
+}
+
 func (this *ParaPebbleDB) Get(key string) (any, error) {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.Get(key)
+	return this.GetAs(key, nil)
 }
 
 func (this *ParaPebbleDB) GetAs(key string, typeHint any) (any, error) {
@@ -83,63 +140,61 @@ func (this *ParaPebbleDB) GetAs(key string, typeHint any) (any, error) {
 		return nil, stgintf.ErrNotFound
 	}
 
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	idx, db := this.getShard(key)
 	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.GetAs(key, typeHint)
+	v, err := db.GetAs(key, typeHint)
+	this.shardLocks[idx].RUnlock()
+
+	if err == stgintf.ErrNotFound {
+		if prevIdx, prevDB, ok := this.getPreviousShard(key); ok { // Not moved yet
+			this.shardLocks[prevIdx].RLock()
+			defer this.shardLocks[prevIdx].RUnlock()
+			return prevDB.GetAs(key, typeHint)
+		}
+	}
+	return v, err
 }
 
 func (this *ParaPebbleDB) Has(key string) bool {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	idx, db := this.getShard(key)
 	this.shardLocks[idx].RLock()
-	defer this.shardLocks[idx].RUnlock()
-	return db.Has(key)
+	found := db.Has(key)
+	this.shardLocks[idx].RUnlock()
+
+	if !found {
+		if prevIdx, prevDB, ok := this.getPreviousShard(key); ok {
+			this.shardLocks[prevIdx].RLock()
+			defer this.shardLocks[prevIdx].RUnlock()
+			return prevDB.Has(key)
+		}
+	}
+	return found
 }
 
 func (this *ParaPebbleDB) Set(key string, value []byte) error {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].Lock()
-	defer this.shardLocks[idx].Unlock()
-	return db.Set(key, value)
+	return this.SetBatch([]string{key}, [][]byte{value})[0]
 }
 
 func (this *ParaPebbleDB) Delete(key string) error {
-	idx, db := this.getShard(key)
-	this.shardLocks[idx].Lock()
-	defer this.shardLocks[idx].Unlock()
-	return db.Delete(key)
+	return this.DeleteBatch([]string{key})[0]
 }
 
 func (this *ParaPebbleDB) DeleteBatch(keys []string) []error {
-	categorized := make([][]string, len(this.impls))
-	origIdx := make([][]int, len(this.impls))
-	for i := range categorized {
-		categorized[i] = make([]string, 0, len(keys)/len(this.impls)+1)
-		origIdx[i] = make([]int, 0, len(keys)/len(this.impls)+1)
-	}
-
-	for i, key := range keys {
-		idx, _ := this.getShard(key)
-		categorized[idx] = append(categorized[idx], key)
-		origIdx[idx] = append(origIdx[idx], i)
-	}
-
-	errs := make([]error, len(keys))
-	for i := range categorized {
-		if len(categorized[i]) == 0 {
-			continue
-		}
-		this.shardLocks[i].Lock()
-		shardErrs := this.impls[i].DeleteBatch(categorized[i])
-		this.shardLocks[i].Unlock()
-		for j, err := range shardErrs {
-			errs[origIdx[i][j]] = err
-		}
-	}
-	return errs
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.router().DeleteBatch(keys)
 }
 
 func (this *ParaPebbleDB) GetBatch(keys []string) ([]any, []error) {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	categorizedKeys := make([][]string, len(this.impls))
 	categorizedIdx := make([][]int, len(this.impls))
 	for i := range categorizedKeys {
@@ -157,6 +212,9 @@ func (this *ParaPebbleDB) GetBatch(keys []string) ([]any, []error) {
 	shardErrSlices := make([][]error, len(this.impls))
 	finder := func(start, end, _ int, _ ...interface{}) {
 		for i := start; i < end; i++ {
+			if len(categorizedKeys[i]) == 0 {
+				continue
+			}
 			this.shardLocks[i].RLock()
 			shardResults[i], shardErrSlices[i] = this.impls[i].GetBatch(categorizedKeys[i])
 			this.shardLocks[i].RUnlock()
@@ -172,42 +230,31 @@ func (this *ParaPebbleDB) GetBatch(keys []string) ([]any, []error) {
 			errs[orig] = shardErrSlices[i][j]
 		}
 	}
+
+	for i, err := range errs { // Look up the keys that haven't been moved to their new shards yet.
+		if !errors.Is(err, stgintf.ErrNotFound) {
+			continue
+		}
+		if prevIdx, prevDB, ok := this.getPreviousShard(keys[i]); ok {
+			this.shardLocks[prevIdx].RLock()
+			prevValues, prevErrs := prevDB.GetBatch(keys[i : i+1])
+			this.shardLocks[prevIdx].RUnlock()
+			values[i], errs[i] = prevValues[0], prevErrs[0]
+		}
+	}
 	return values, errs
 }
 
 func (this *ParaPebbleDB) SetBatch(keys []string, values [][]byte) []error {
-	categorizedKeys := make([][]string, len(this.impls))
-	categorizedVals := make([][][]byte, len(this.impls))
-	origIdx := make([][]int, len(this.impls))
-	for i := range categorizedKeys {
-		categorizedKeys[i] = make([]string, 0, len(keys)/len(this.impls)+1)
-		categorizedVals[i] = make([][]byte, 0, len(keys)/len(this.impls)+1)
-		origIdx[i] = make([]int, 0, len(keys)/len(this.impls)+1)
-	}
-
-	for i, key := range keys {
-		idx, _ := this.getShard(key)
-		categorizedKeys[idx] = append(categorizedKeys[idx], key)
-		categorizedVals[idx] = append(categorizedVals[idx], values[i])
-		origIdx[idx] = append(origIdx[idx], i)
-	}
-
-	perShardErrs := slice.ParallelTransform(categorizedKeys, len(categorizedKeys), func(i int, _ []string) []error {
-		this.shardLocks[i].Lock()
-		defer this.shardLocks[i].Unlock()
-		return this.impls[i].SetBatch(categorizedKeys[i], categorizedVals[i])
-	})
-
-	errs := make([]error, len(keys))
-	for i, shardErrs := range perShardErrs {
-		for j, err := range shardErrs {
-			errs[origIdx[i][j]] = err
-		}
-	}
-	return errs
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.router().SetBatch(keys, values)
 }
 
 func (this *ParaPebbleDB) Query(prefix string, checker func(string, []byte) bool) ([]string, [][]byte, []error) {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
 	keys := make([]string, 0)
 	values := make([][]byte, 0)
 	errs := make([]error, 0)
@@ -231,7 +278,33 @@ func (this *ParaPebbleDB) Query(prefix string, checker func(string, []byte) bool
 	return keys, values, nil
 }
 
+// Iterate calls the visitor for the entries of all the shards from the key on, in the ascending order of
+// the keys, until the visitor returns false. The shards are walked through a snapshot, so the writers
+// aren't blocked while the entries are being visited.
+func (this *ParaPebbleDB) Iterate(from string, visitor func(string, []byte) bool) error {
+	snapshot := this.Snapshot()
+	defer snapshot.Close()
+	return snapshot.Iterate(from, visitor)
+}
+
+// Sync makes the writes made so far to all the shards durable.
+func (this *ParaPebbleDB) Sync() error {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	var errs []error
+	for _, db := range this.impls {
+		if db != nil {
+			errs = append(errs, db.Sync())
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (this *ParaPebbleDB) Close() error {
+	this.closed.Store(true)
+	this.rebalancing.Wait()
+
 	for _, db := range this.impls {
 		if db == nil {
 			continue
@@ -243,7 +316,170 @@ func (this *ParaPebbleDB) Close() error {
 	return nil
 }
 
+// AddShard adds a new shard to the ring, and moves the keys it takes over from the other shards
+// in the background. The DB stays fully readable and writable during the rebalance.
+func (this *ParaPebbleDB) AddShard() (int, error) {
+	this.topology.Lock()
+	defer this.topology.Unlock()
+
+	if this.ring == nil {
+		return -1, sharding.ErrNoRing
+	}
+
+	if this.previous != nil {
+		return -1, sharding.ErrRebalancing
+	}
+
+	id := this.manifest.NextID
+	if err := this.openShard(id); err != nil {
+		return -1, err
+	}
+
+	sources := this.ring.Shards() // Any existing shard may lose keys to the new one.
+	if err := this.manifest.Begin(this.ring, this.ring.With(id)); err != nil {
+		return -1, err
+	}
+
+	this.previous, this.ring = this.ring, this.ring.With(id)
+	this.startRebalance(sources)
+	return id, nil
+}
+
+// RemoveShard removes a shard from the ring, its keys are moved to the remaining shards in the
+// background. The shard is closed and deleted from the disk when the rebalance completes.
+func (this *ParaPebbleDB) RemoveShard(id int) error {
+	this.topology.Lock()
+	defer this.topology.Unlock()
+
+	if this.ring == nil {
+		return sharding.ErrNoRing
+	}
+
+	if this.previous != nil {
+		return sharding.ErrRebalancing
+	}
+
+	if !this.ring.Contains(id) {
+		return sharding.ErrUnknownShard
+	}
+
+	if len(this.ring.Shards()) == 1 {
+		return sharding.ErrLastShard
+	}
+
+	if err := this.manifest.Begin(this.ring, this.ring.Without(id)); err != nil {
+		return err
+	}
+
+	this.previous, this.ring = this.ring, this.ring.Without(id)
+	this.startRebalance([]int{id})
+	return nil
+}
+
+// Shards returns the IDs of the shards in the ring.
+func (this *ParaPebbleDB) Shards() []int {
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+
+	if this.ring == nil {
+		shards := make([]int, len(this.impls))
+		for i := range shards {
+			shards[i] = i
+		}
+		return shards
+	}
+	return this.ring.Shards()
+}
+
+// WaitRebalance blocks until the ongoing rebalance, if any, is done.
+func (this *ParaPebbleDB) WaitRebalance() error {
+	this.rebalancing.Wait()
+
+	this.topology.RLock()
+	defer this.topology.RUnlock()
+	return this.rebalanceErr
+}
+
+func (this *ParaPebbleDB) startRebalance(sources []int) {
+	ring := this.ring
+	shardOf := func(id int) stgintf.ReadWriteStore[string, []byte] { return this.impls[id] }
+
+	this.rebalanceErr = nil
+	this.rebalancing.Add(1)
+	go func() {
+		defer this.rebalancing.Done()
+		err := sharding.Rebalance(sources, shardOf, ring, &this.topology, this.closed.Load)
+
+		this.topology.Lock()
+		defer this.topology.Unlock()
+		if err != nil {
+			this.rebalanceErr = err // The rebalance will be resumed from the manifest on the next start.
+			return
+		}
+
+		removed, err := this.manifest.Complete()
+		if err != nil {
+			this.rebalanceErr = err
+			return
+		}
+
+		this.previous = nil
+		for _, id := range removed {
+			if err := this.impls[id].Close(); err != nil {
+				this.rebalanceErr = err
+			}
+			this.impls[id] = nil
+			os.RemoveAll(filepath.Join(this.root, fmt.Sprint(id)))
+		}
+	}()
+}
+
+func (this *ParaPebbleDB) openShard(id int) error {
+	db, err := NewPebbleDB(filepath.Join(this.root, fmt.Sprint(id)), this.decoder)
+	if err != nil {
+		return err
+	}
+
+	for len(this.impls) <= id {
+		this.impls = append(this.impls, nil)
+		this.shardLocks = append(this.shardLocks, &sync.RWMutex{})
+	}
+	this.impls[id] = db
+	return nil
+}
+
 func (this *ParaPebbleDB) getShard(key string) (int, *PebbleDB) {
+	if this.ring != nil {
+		shardIdx := this.ring.Locate(key)
+		return shardIdx, this.impls[shardIdx]
+	}
+
 	shardIdx := this.shardFunc(len(this.impls), key)
 	return shardIdx, this.impls[shardIdx]
 }
+
+// getPreviousShard returns the owner of the key before the ongoing rebalance, if it isn't the current owner.
+func (this *ParaPebbleDB) getPreviousShard(key string) (int, *PebbleDB, bool) {
+	if this.previous == nil {
+		return -1, nil, false
+	}
+
+	prevIdx := this.previous.Locate(key)
+	if prevIdx == this.ring.Locate(key) {
+		return -1, nil, false
+	}
+	return prevIdx, this.impls[prevIdx], true
+}
+
+// router returns the write path over the shards, the topology lock has to be held while it is used.
+func (this *ParaPebbleDB) router() sharding.Router {
+	return sharding.Router{
+		Shard:  func(id int) stgintf.ReadWriteStore[string, []byte] { return this.impls[id] },
+		Lock:   func(id int) *sync.RWMutex { return this.shardLocks[id] },
+		Locate: func(key string) int { idx, _ := this.getShard(key); return idx },
+		Previous: func(key string) (int, bool) {
+			idx, _, ok := this.getPreviousShard(key)
+			return idx, ok
+		},
+	}
+}
@@ -18,7 +18,13 @@
 package pebbledb
 
 import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
 	"testing"
+
+	"github.com/arcology-network/common-lib/storage/sharding"
 )
 
 func TestParaPebbleDBFunctions(t *testing.T) {
@@ -101,3 +107,172 @@ func TestParaPebbleDBFunctions(t *testing.T) {
 	t.Log(qkeys)
 	t.Log(qvalues)
 }
+
+func TestParaPebbleDBResharding(t *testing.T) {
+	root := tempParaPebbleRoot(t)
+	db, err := NewParaPebbleDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 2000)
+	values := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		values[i] = []byte(keys[i])
+	}
+	for i, err := range db.SetBatch(keys, values) {
+		if err != nil {
+			t.Fatalf("SetBatch[%d]: %v", i, err)
+		}
+	}
+
+	id, err := db.AddShard()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reads and writes stay correct while the keys are being moved.
+	for i, key := range keys {
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v.([]byte), values[i]) {
+			t.Fatalf("Get(%s) during the rebalance: %v", key, err)
+		}
+	}
+	if err := db.Set(keys[0], []byte("updated")); err != nil {
+		t.Fatal(err)
+	}
+	values[0] = []byte("updated")
+
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RemoveShard(-1); err != sharding.ErrUnknownShard {
+		t.Fatal("should have rejected an unknown shard")
+	}
+
+	if err := db.RemoveShard(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+
+	if shards := db.Shards(); slices.Contains(shards, 0) || !slices.Contains(shards, id) {
+		t.Fatalf("unexpected shards %v", shards)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The membership survives the restart.
+	db, err = NewParaPebbleDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	got, errs := db.GetBatch(keys)
+	for i := range keys {
+		if errs[i] != nil || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("GetBatch(%s) after the restart: %v", keys[i], errs[i])
+		}
+	}
+
+	if qkeys, _, errs := db.Query("key-", nil); len(errs) != 0 || len(qkeys) != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), len(qkeys))
+	}
+}
+
+func TestParaPebbleDBSnapshotAndCheckpoint(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "pebble-ring")
+	db, err := NewParaPebbleDBWithRing(root, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 300)
+	values := make([][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		values[i] = []byte(keys[i])
+	}
+	db.SetBatch(keys, values)
+
+	// The snapshot may be taken in the middle of the rebalance.
+	if _, err := db.AddShard(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := db.Snapshot()
+	defer snapshot.Close()
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Delete(keys[0])
+	db.Set(keys[1], []byte("updated"))
+	if err := db.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, errs := snapshot.GetBatch(keys)
+	for i := range keys {
+		if (errs != nil && errs[i] != nil) || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("snapshot GetBatch(%s) failed", keys[i])
+		}
+	}
+	if snapshotKeys, _, _ := snapshot.Query("", nil); len(snapshotKeys) != len(keys) {
+		t.Fatal("expected", len(keys), "keys in the snapshot, got", len(snapshotKeys))
+	}
+	db.Close()
+
+	// The checkpoint reopens with the ring it was taken with, and finishes the rebalance by itself.
+	copied, err := NewParaPebbleDBWithRing(dir, 2, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copied.WaitRebalance(); err != nil {
+		t.Fatal(err)
+	}
+	if shards := copied.Shards(); len(shards) != 3 {
+		t.Fatal("expected 3 shards, got", shards)
+	}
+	got, errs = copied.GetBatch(keys)
+	for i := range keys {
+		if (errs != nil && errs[i] != nil) || !bytes.Equal(got[i].([]byte), values[i]) {
+			t.Fatalf("checkpoint GetBatch(%s) failed", keys[i])
+		}
+	}
+	copied.Close()
+}
+
+func TestParaPebbleDBLegacyCheckpoint(t *testing.T) {
+	db, err := NewParaPebbleDB(filepath.Join(t.TempDir(), "legacy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	keys := []string{"a01", "b02", "c03", "d04"}
+	db.SetBatch(keys, [][]byte{{1}, {2}, {3}, {4}})
+
+	dir := filepath.Join(t.TempDir(), "checkpoint")
+	if err := db.Checkpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	db.Set("a01", []byte{11})
+
+	copied, err := NewParaPebbleDB(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+
+	for i, key := range keys {
+		if v, err := copied.Get(key); err != nil || !bytes.Equal(v.([]byte), []byte{byte(i + 1)}) {
+			t.Fatal("unexpected value of", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package pebbledb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	common "github.com/arcology-network/common-lib/common"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/sharding"
+	"github.com/cockroachdb/pebble"
+)
+
+// CHECKPOINT_BATCH_SIZE is the number of bytes written to a checkpoint before the batch is committed.
+const CHECKPOINT_BATCH_SIZE = 4 << 20
+
+var (
+	_ stgintf.ReadableStore[string, []byte] = (*PebbleSnapshot)(nil)
+	_ stgintf.ReadableStore[string, []byte] = (*ParaPebbleSnapshot)(nil)
+	_ stgintf.Iterable[string, []byte]      = (*PebbleDB)(nil)
+	_ stgintf.Iterable[string, []byte]      = (*ParaPebbleDB)(nil)
+)
+
+// PebbleSnapshot is a read-only view of a PebbleDB at the moment it was taken. The writes
+// made to the DB afterwards aren't visible. A snapshot pins the data it can see, so it
+// should be closed as soon as it is no longer needed.
+type PebbleSnapshot struct {
+	impl    *pebble.Snapshot
+	decoder func(string, any, any) (any, error)
+}
+
+// Snapshot returns a consistent read-only view of the DB, without blocking the writers.
+func (this *PebbleDB) Snapshot() *PebbleSnapshot {
+	return &PebbleSnapshot{impl: this.impl.NewSnapshot(), decoder: this.decoder}
+}
+
+// Checkpoint writes an openable copy of the DB to a new directory, the sstables are hard linked
+// whenever the file system allows. The directory must not exist yet.
+func (this *PebbleDB) Checkpoint(dir string) error {
+	return this.impl.Checkpoint(dir, pebble.WithFlushedWAL())
+}
+
+func (this *PebbleSnapshot) Get(key string) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *PebbleSnapshot) GetAs(key string, typeHint any) (any, error) {
+	stored, closer, err := this.impl.Get(unsafe.Slice(unsafe.StringData(key), len(key)))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return nil, stgintf.ErrNotFound
+		}
+		return nil, err
+	}
+	defer closer.Close()
+	value := bytes.Clone(stored)
+	if this.decoder != nil {
+		return this.decoder(key, value, typeHint)
+	}
+	return value, nil
+}
+
+func (this *PebbleSnapshot) GetBatch(keys []string) ([]any, []error) {
+	values := make([]any, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		values[i], errs[i] = this.GetAs(key, nil)
+	}
+	return values, errs
+}
+
+func (this *PebbleSnapshot) Has(key string) bool {
+	_, closer, err := this.impl.Get(unsafe.Slice(unsafe.StringData(key), len(key)))
+	if err != nil {
+		return false
+	}
+	defer closer.Close()
+	return true
+}
+
+// Query scans the keys with the prefix as of the snapshot.
+func (this *PebbleSnapshot) Query(prefix string, checker func(string, []byte) bool) ([]string, [][]byte, []error) {
+	iter, err := this.impl.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	defer iter.Close()
+
+	var keys []string
+	var values [][]byte
+	prefixBytes := unsafe.Slice(unsafe.StringData(prefix), len(prefix))
+	for iter.First(); iter.Valid(); iter.Next() {
+		if len(prefixBytes) > 0 && !bytes.HasPrefix(iter.Key(), prefixBytes) {
+			continue
+		}
+		k := string(iter.Key())
+		v := bytes.Clone(iter.Value())
+		if checker != nil && !checker(k, v) {
+			continue
+		}
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, nil, []error{err}
+	}
+	return keys, values, nil
+}
+
+// Iterate calls the visitor for the entries from the key on as of the snapshot, in the ascending order
+// of the keys, until the visitor returns false.
+func (this *PebbleSnapshot) Iterate(from string, visitor func(string, []byte) bool) error {
+	iter, err := this.impl.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return err
+	}
+	return iterate(iter, from, visitor)
+}
+
+// WriteTo copies all the entries visible to the snapshot into a new PebbleDB under the directory.
+func (this *PebbleSnapshot) WriteTo(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return &fs.PathError{Op: "checkpoint", Path: dir, Err: fs.ErrExist}
+	}
+
+	target, err := pebble.Open(dir, &pebble.Options{})
+	if err != nil {
+		return err
+	}
+
+	iter, err := this.impl.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		target.Close()
+		return err
+	}
+
+	batch := target.NewBatch()
+	for iter.First(); iter.Valid(); iter.Next() {
+		if err = batch.Set(iter.Key(), iter.Value(), nil); err == nil && batch.Len() >= CHECKPOINT_BATCH_SIZE {
+			err = batch.Commit(pebble.NoSync)
+			batch.Close()
+			batch = target.NewBatch()
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = iter.Error()
+	}
+	iter.Close()
+
+	if err == nil {
+		err = batch.Commit(pebble.Sync)
+	}
+	batch.Close()
+
+	if err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+func (this *PebbleSnapshot) Close() error {
+	return this.impl.Close()
+}
+
+// ParaPebbleSnapshot is a read-only view of all the shards of a ParaPebbleDB at the same instant.
+// Keys are located with the sharding of the DB at the time the snapshot was taken, including
+// the keys that were still waiting to be moved by an ongoing rebalance.
+type ParaPebbleSnapshot struct {
+	impls     []*PebbleSnapshot // Indexed by the shard ID
+	shardFunc func(int, string) int
+	ring      *sharding.Ring
+	previous  *sharding.Ring
+}
+
+// Snapshot returns a read-only view of the DB that is consistent across all the shards. The DB
+// is only paused while the shard snapshots are being created, which doesn't involve any I/O.
+func (this *ParaPebbleDB) Snapshot() *ParaPebbleSnapshot {
+	this.topology.Lock() // Holding the topology lock excludes all the readers, writers and key moves.
+	defer this.topology.Unlock()
+	return this.snapshot()
+}
+
+// Checkpoint writes an openable copy of the DB, which can be opened with the same constructor as
+// the DB. Each shard is checkpointed by Pebble, which hard links the sstables whenever the file system
+// allows instead of copying the entries. The shards are all checkpointed at the same instant, so the
+// writers are paused until the checkpoints are taken, the readers aren't.
+func (this *ParaPebbleDB) Checkpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return &fs.PathError{Op: "checkpoint", Path: dir, Err: fs.ErrExist}
+	}
+
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		return err
+	}
+
+	this.topology.RLock() // Excludes the key moves of a rebalance.
+	defer this.topology.RUnlock()
+
+	for i, db := range this.impls { // The shard locks exclude the writers.
+		if db != nil {
+			this.shardLocks[i].RLock()
+			defer this.shardLocks[i].RUnlock()
+		}
+	}
+
+	if this.manifest != nil {
+		if err := this.manifest.SaveTo(dir); err != nil {
+			return err
+		}
+	}
+
+	errs := make([]error, len(this.impls))
+	worker := func(start, end, index int, args ...interface{}) {
+		for i := start; i < end; i++ {
+			if this.impls[i] != nil {
+				errs[i] = this.impls[i].Checkpoint(filepath.Join(dir, fmt.Sprint(i)))
+			}
+		}
+	}
+	common.ParallelWorker(len(this.impls), len(this.impls), worker)
+	return errors.Join(errs...)
+}
+
+func (this *ParaPebbleDB) snapshot() *ParaPebbleSnapshot {
+	snapshot := &ParaPebbleSnapshot{
+		impls:     make([]*PebbleSnapshot, len(this.impls)),
+		shardFunc: this.shardFunc,
+		ring:      this.ring,
+		previous:  this.previous,
+	}
+
+	for i, db := range this.impls {
+		if db != nil {
+			snapshot.impls[i] = db.Snapshot()
+		}
+	}
+	return snapshot
+}
+
+func (this *ParaPebbleSnapshot) Get(key string) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *ParaPebbleSnapshot) GetAs(key string, typeHint any) (any, error) {
+	v, err := this.getShard(key).GetAs(key, typeHint)
+	if err == stgintf.ErrNotFound {
+		if prev := this.getPreviousShard(key); prev != nil {
+			return prev.GetAs(key, typeHint)
+		}
+	}
+	return v, err
+}
+
+func (this *ParaPebbleSnapshot) GetBatch(keys []string) ([]any, []error) {
+	values := make([]any, len(keys))
+	errs := make([]error, len(keys))
+	for i, key := range keys {
+		vals, getErrs := this.getShard(key).GetBatch(keys[i : i+1])
+		if getErrs[0] == stgintf.ErrNotFound {
+			if prev := this.getPreviousShard(key); prev != nil {
+				vals, getErrs = prev.GetBatch(keys[i : i+1])
+			}
+		}
+		values[i], errs[i] = vals[0], getErrs[0]
+	}
+	return values, errs
+}
+
+func (this *ParaPebbleSnapshot) Has(key string) bool {
+	if this.getShard(key).Has(key) {
+		return true
+	}
+
+	prev := this.getPreviousShard(key)
+	return prev != nil && prev.Has(key)
+}
+
+// Query scans the keys with the prefix in all the shards as of the snapshot.
+func (this *ParaPebbleSnapshot) Query(prefix string, checker func(string, []byte) bool) ([]string, [][]byte, []error) {
+	keys := make([]string, 0)
+	values := make([][]byte, 0)
+	for _, snapshot := range this.impls {
+		if snapshot == nil {
+			continue
+		}
+		shardKeys, shardValues, errs := snapshot.Query(prefix, checker)
+		if len(errs) > 0 {
+			return nil, nil, errs
+		}
+		keys = append(keys, shardKeys...)
+		values = append(values, shardValues...)
+	}
+	return keys, values, nil
+}
+
+// Iterate calls the visitor for the entries of all the shards from the key on as of the snapshot, in
+// the ascending order of the keys, until the visitor returns false.
+func (this *ParaPebbleSnapshot) Iterate(from string, visitor func(string, []byte) bool) error {
+	shards := make([]stgintf.Iterable[string, []byte], 0, len(this.impls))
+	for _, snapshot := range this.impls {
+		if snapshot != nil {
+			shards = append(shards, snapshot)
+		}
+	}
+	return sharding.Iterate(shards, from, visitor)
+}
+
+func (this *ParaPebbleSnapshot) Close() error {
+	var errs []error
+	for _, snapshot := range this.impls {
+		if snapshot != nil {
+			errs = append(errs, snapshot.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (this *ParaPebbleSnapshot) getShard(key string) *PebbleSnapshot {
+	if this.ring != nil {
+		return this.impls[this.ring.Locate(key)]
+	}
+	return this.impls[this.shardFunc(len(this.impls), key)]
+}
+
+func (this *ParaPebbleSnapshot) getPreviousShard(key string) *PebbleSnapshot {
+	if this.previous == nil {
+		return nil
+	}
+
+	if prevIdx := this.previous.Locate(key); prevIdx != this.ring.Locate(key) {
+		return this.impls[prevIdx]
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	DEFAULT_POOL_SIZE    = 4
+	DEFAULT_TIMEOUT      = 10 * time.Second
+	DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+	DEFAULT_BATCH_CHUNK  = 1024 // The number of keys per read request, the chunks of a read batch are sent in parallel.
+)
+
+type Config struct {
+	PoolSize    int
+	Timeout     time.Duration // The timeout of a request, including the time waiting for the response.
+	DialTimeout time.Duration
+	BatchChunk  int // The number of keys per request of GetBatch, the writes are sent in a single request.
+	Decoder     func(string, any, any) (any, error)
+}
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Client)(nil)
+
+// Client is a ReadWriteStore backed by a store served by a Server. The requests are spread over
+// a pool of connections, and many requests can be in flight on the same connection. A broken
+// connection is redialed by the next request using it.
+type Client struct {
+	network string
+	address string
+	config  Config
+
+	lock  sync.Mutex
+	pool  []*conn
+	next  atomic.Uint64
+	ended bool
+}
+
+// Dial connects to a server, the network is "tcp" or "unix".
+func Dial(network, address string, config ...Config) (*Client, error) {
+	client := &Client{network: network, address: address}
+	if len(config) > 0 {
+		client.config = config[0]
+	}
+
+	if client.config.PoolSize <= 0 {
+		client.config.PoolSize = DEFAULT_POOL_SIZE
+	}
+	if client.config.Timeout <= 0 {
+		client.config.Timeout = DEFAULT_TIMEOUT
+	}
+	if client.config.DialTimeout <= 0 {
+		client.config.DialTimeout = DEFAULT_DIAL_TIMEOUT
+	}
+	if client.config.BatchChunk <= 0 {
+		client.config.BatchChunk = DEFAULT_BATCH_CHUNK
+	}
+
+	client.pool = make([]*conn, client.config.PoolSize)
+	if _, err := client.conn(0); err != nil { // Fail early if the server isn't reachable.
+		return nil, err
+	}
+	return client, nil
+}
+
+func (this *Client) Has(key string) bool {
+	_, errs, err := this.call(OP_HAS, []byte(key), 1)
+	return err == nil && errs[0] == nil
+}
+
+func (this *Client) Get(key string) (any, error) {
+	return this.GetAs(key, nil)
+}
+
+func (this *Client) GetAs(key string, typeHint any) (any, error) {
+	values, errs, err := this.call(OP_GET, []byte(key), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if errs[0] != nil {
+		return nil, errs[0]
+	}
+
+	if this.config.Decoder != nil {
+		return this.config.Decoder(key, values[0], typeHint)
+	}
+	return values[0], nil
+}
+
+func (this *Client) GetBatch(keys []string) ([]any, []error) {
+	values := make([]any, len(keys))
+	errs := this.pipeline(len(keys), func(start, end int) ([]error, error) {
+		chunkValues, chunkErrs, err := this.call(OP_GET_BATCH, codec.Strings(keys[start:end]).Encode(), end-start)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, v := range chunkValues {
+			if chunkErrs[i] == nil {
+				values[start+i] = v
+			}
+		}
+		return chunkErrs, nil
+	})
+	return values, errs
+}
+
+func (this *Client) Set(key string, value []byte) error {
+	_, errs, err := this.call(OP_SET, codec.Byteset{[]byte(key), value}.Encode(), 1)
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+// SetBatch sends the batch in a single request, so it is applied as a whole by the SetBatch of the
+// store on the server side. A batch larger than a frame fails with ErrFrameTooLarge, and has to be
+// split by the caller.
+func (this *Client) SetBatch(keys []string, values [][]byte) []error {
+	payload := codec.Byteset{codec.Strings(keys).Encode(), codec.Byteset(values).Encode()}.Encode()
+	return this.write(OP_SET_BATCH, payload, len(keys))
+}
+
+func (this *Client) Delete(key string) error {
+	_, errs, err := this.call(OP_DELETE, []byte(key), 1)
+	if err != nil {
+		return err
+	}
+	return errs[0]
+}
+
+// DeleteBatch sends the batch in a single request, like SetBatch.
+func (this *Client) DeleteBatch(keys []string) []error {
+	return this.write(OP_DELETE_BATCH, codec.Strings(keys).Encode(), len(keys))
+}
+
+// Query runs the query on the server with the same pattern. The checker can't be sent over,
+// so the server returns all the candidates and the checker is applied on the client side.
+func (this *Client) Query(pattern string, checker func(string, []byte) bool) ([]string, [][]byte, []error) {
+	flag := []byte{0}
+	if checker != nil {
+		flag[0] = 1
+	}
+
+	payload, err := this.roundTrip(OP_QUERY, codec.Byteset{[]byte(pattern), flag}.Encode())
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	keys, values, errs, err := decodeQuery(payload)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	if len(errs) > 0 || checker == nil {
+		return keys, values, errs
+	}
+
+	matchedKeys, matchedValues := make([]string, 0, len(keys)), make([][]byte, 0, len(keys))
+	for i, key := range keys {
+		if checker(key, values[i]) {
+			matchedKeys = append(matchedKeys, key)
+			matchedValues = append(matchedValues, values[i])
+		}
+	}
+	return matchedKeys, matchedValues, nil
+}
+
+// Close closes all the connections, the requests in flight fail with ErrClosed.
+func (this *Client) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.ended = true
+	for _, c := range this.pool {
+		if c != nil {
+			c.close(ErrClosed)
+		}
+	}
+	return nil
+}
+
+// pipeline splits a read batch into chunks sent in parallel, the failed requests fail all their keys.
+func (this *Client) pipeline(n int, send func(start, end int) ([]error, error)) []error {
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += this.config.BatchChunk {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			chunkErrs, err := send(start, end)
+			for i := start; i < end; i++ {
+				if err != nil {
+					errs[i] = err
+				} else {
+					errs[i] = chunkErrs[i-start]
+				}
+			}
+		}(start, min(start+this.config.BatchChunk, n))
+	}
+	wg.Wait()
+	return errs
+}
+
+// write sends a write batch as a single request, the failed request fails all the keys.
+func (this *Client) write(op uint8, payload []byte, n int) []error {
+	if len(payload) > MAX_FRAME_SIZE-FRAME_HEADER_SIZE {
+		return stgintf.BatchErrors(n, ErrFrameTooLarge)
+	}
+
+	_, errs, err := this.call(op, payload, n)
+	if err != nil {
+		return stgintf.BatchErrors(n, err)
+	}
+	return errs
+}
+
+// call sends a request whose response holds the results of n keys.
+func (this *Client) call(op uint8, payload []byte, n int) ([][]byte, []error, error) {
+	response, err := this.roundTrip(op, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return decodeResults(response, n)
+}
+
+func (this *Client) roundTrip(op uint8, payload []byte) ([]byte, error) {
+	c, err := this.conn(int(this.next.Add(1) % uint64(len(this.pool))))
+	if err != nil {
+		return nil, err
+	}
+
+	status, response, err := c.roundTrip(op, payload, this.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if status != STATUS_OK {
+		return nil, &RemoteError{string(response)}
+	}
+	return response, nil
+}
+
+// conn returns the connection in the slot of the pool, which is dialed if needed.
+func (this *Client) conn(slot int) (*conn, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.ended {
+		return nil, ErrClosed
+	}
+
+	if c := this.pool[slot]; c != nil && !c.broken() {
+		return c, nil
+	}
+
+	netConn, err := net.DialTimeout(this.network, this.address, this.config.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	this.pool[slot] = newConn(netConn)
+	return this.pool[slot], nil
+}
+
+type response struct {
+	status  uint8
+	payload []byte
+}
+
+// conn is a connection with the requests pipelined on it.
+type conn struct {
+	netConn   net.Conn
+	writer    *bufio.Writer
+	writeLock sync.Mutex
+
+	lock    sync.Mutex
+	pending map[uint64]chan response
+	nextID  uint64
+	err     error // Why the connection is broken, nil if it isn't.
+	done    chan struct{}
+}
+
+func newConn(netConn net.Conn) *conn {
+	c := &conn{
+		netConn: netConn,
+		writer:  bufio.NewWriter(netConn),
+		pending: map[uint64]chan response{},
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (this *conn) roundTrip(op uint8, payload []byte, timeout time.Duration) (uint8, []byte, error) {
+	this.lock.Lock()
+	if this.err != nil {
+		this.lock.Unlock()
+		return 0, nil, this.err
+	}
+	this.nextID++
+	id, ch := this.nextID, make(chan response, 1)
+	this.pending[id] = ch
+	this.lock.Unlock()
+
+	defer func() {
+		this.lock.Lock()
+		delete(this.pending, id)
+		this.lock.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	this.writeLock.Lock()
+	this.netConn.SetWriteDeadline(time.Now().Add(timeout))
+	err := writeFrame(this.writer, id, op, payload)
+	if err == nil {
+		err = this.writer.Flush()
+	}
+	this.writeLock.Unlock()
+
+	if err != nil {
+		this.close(err) // A partially written frame leaves the connection unusable.
+		return 0, nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		return resp.status, resp.payload, nil
+	case <-this.done:
+		return 0, nil, this.err
+	case <-timer.C:
+		return 0, nil, ErrTimeout
+	}
+}
+
+func (this *conn) readLoop() {
+	reader := bufio.NewReader(this.netConn)
+	for {
+		id, status, payload, err := readFrame(reader)
+		if err != nil {
+			this.close(err)
+			return
+		}
+
+		this.lock.Lock()
+		if ch, ok := this.pending[id]; ok { // The request may have timed out.
+			ch <- response{status, payload}
+		}
+		this.lock.Unlock()
+	}
+}
+
+func (this *conn) broken() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.err != nil
+}
+
+func (this *conn) close(err error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.err == nil {
+		this.err = err
+		this.netConn.Close()
+		close(this.done)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package remote exposes a ReadWriteStore[string, []byte] to other local processes over TCP or
+// a Unix socket, so they can share one state DB.
+//
+// Every message is a frame of [len u32][id u64][kind u8][payload], where len counts the bytes
+// after itself, the kind is the operation of a request or the status of a response, and the id
+// matches the responses to the requests. The requests on a connection are pipelined, so the
+// responses may come back in any order. The payloads are encoded with the codec package.
+package remote
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	OP_HAS          = uint8(1)
+	OP_GET          = uint8(2)
+	OP_GET_BATCH    = uint8(3)
+	OP_SET          = uint8(4)
+	OP_SET_BATCH    = uint8(5)
+	OP_DELETE       = uint8(6)
+	OP_DELETE_BATCH = uint8(7)
+	OP_QUERY        = uint8(8)
+
+	STATUS_OK    = uint8(0)
+	STATUS_ERROR = uint8(1) // The request failed as a whole, the payload is the error message.
+
+	CODE_OK        = uint8(0)
+	CODE_NOT_FOUND = uint8(1)
+	CODE_ERROR     = uint8(2)
+
+	FRAME_HEADER_SIZE = 4 + 8 + 1
+	MAX_FRAME_SIZE    = 1 << 30
+)
+
+var (
+	ErrFrameTooLarge = errors.New("remote: frame too large")
+	ErrBadRequest    = errors.New("remote: bad request")
+	ErrTimeout       = errors.New("remote: request timed out")
+	ErrClosed        = errors.New("remote: connection closed")
+)
+
+// RemoteError is an error returned by the store on the server side.
+type RemoteError struct{ Msg string }
+
+func (this *RemoteError) Error() string { return "remote: " + this.Msg }
+
+func writeFrame(writer *bufio.Writer, id uint64, kind uint8, payload []byte) error {
+	if len(payload) > MAX_FRAME_SIZE-FRAME_HEADER_SIZE {
+		return ErrFrameTooLarge
+	}
+
+	var header [FRAME_HEADER_SIZE]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(8+1+len(payload)))
+	binary.LittleEndian.PutUint64(header[4:12], id)
+	header[12] = kind
+	if _, err := writer.Write(header[:]); err != nil {
+		return err
+	}
+
+	_, err := writer.Write(payload)
+	return err
+}
+
+func readFrame(reader *bufio.Reader) (uint64, uint8, []byte, error) {
+	var header [FRAME_HEADER_SIZE]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length < 8+1 || length > MAX_FRAME_SIZE {
+		return 0, 0, nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, length-8-1)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return binary.LittleEndian.Uint64(header[4:12]), header[12], payload, nil
+}
+
+// encodeResults encodes the per key results of an operation, the values are ignored for the keys with errors.
+func encodeResults(values [][]byte, errs []error) []byte {
+	codes := make([]byte, len(values))
+	messages := make([]string, len(values))
+	for i := range values {
+		if len(errs) == 0 || errs[i] == nil {
+			continue
+		}
+
+		values[i] = nil
+		if errors.Is(errs[i], stgintf.ErrNotFound) {
+			codes[i] = CODE_NOT_FOUND
+		} else {
+			codes[i], messages[i] = CODE_ERROR, errs[i].Error()
+		}
+	}
+	return codec.Byteset{codes, codec.Byteset(values).Encode(), codec.Strings(messages).Encode()}.Encode()
+}
+
+func decodeResults(payload []byte, n int) ([][]byte, []error, error) {
+	fields := codec.Byteset{}.Decode(payload).(codec.Byteset)
+	if len(fields) != 3 || len(fields[0]) != n {
+		return nil, nil, ErrBadRequest
+	}
+
+	values := [][]byte(codec.Byteset{}.Decode(fields[1]).(codec.Byteset))
+	messages := []string(codec.Strings{}.Decode(fields[2]).(codec.Strings))
+	if n > 0 && (len(values) != n || len(messages) != n) {
+		return nil, nil, ErrBadRequest
+	}
+
+	errs := make([]error, n)
+	for i, code := range fields[0] {
+		switch code {
+		case CODE_OK:
+		case CODE_NOT_FOUND:
+			errs[i], values[i] = stgintf.ErrNotFound, nil
+		default:
+			errs[i], values[i] = &RemoteError{messages[i]}, nil
+		}
+	}
+
+	if n == 0 {
+		values = [][]byte{}
+	}
+	return values, errs, nil
+}
+
+func encodeQuery(keys []string, values [][]byte, errs []error) []byte {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	return codec.Byteset{codec.Strings(keys).Encode(), codec.Byteset(values).Encode(), codec.Strings(messages).Encode()}.Encode()
+}
+
+func decodeQuery(payload []byte) ([]string, [][]byte, []error, error) {
+	fields := codec.Byteset{}.Decode(payload).(codec.Byteset)
+	if len(fields) != 3 {
+		return nil, nil, nil, ErrBadRequest
+	}
+
+	keys := []string(codec.Strings{}.Decode(fields[0]).(codec.Strings))
+	values := [][]byte(codec.Byteset{}.Decode(fields[1]).(codec.Byteset))
+	if len(keys) != len(values) {
+		return nil, nil, nil, fmt.Errorf("%w: %d keys and %d values", ErrBadRequest, len(keys), len(values))
+	}
+
+	var errs []error
+	for _, msg := range (codec.Strings{}).Decode(fields[2]).(codec.Strings) {
+		errs = append(errs, &RemoteError{msg})
+	}
+	return keys, values, errs, nil
+}
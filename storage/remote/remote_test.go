@@ -0,0 +1,262 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+)
+
+func startServer(t *testing.T, store stgintf.ReadWriteStore[string, []byte], network string) (*Server, string) {
+	t.Helper()
+
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(t.TempDir(), "store.sock")
+	}
+
+	server := NewServer(store)
+	addr, err := server.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server, addr.String()
+}
+
+func TestRemoteStore(t *testing.T) {
+	db, err := pebbledb.NewPebbleDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			_, address := startServer(t, db, network)
+			client, err := Dial(network, address, Config{BatchChunk: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			keys, values := make([]string, 95), make([][]byte, 95)
+			for i := range keys {
+				keys[i], values[i] = fmt.Sprintf("%s/%03d", network, i), []byte(fmt.Sprint(i))
+			}
+
+			for i, err := range client.SetBatch(keys, values) {
+				if err != nil {
+					t.Fatal(i, err)
+				}
+			}
+
+			got, errs := client.GetBatch(append(keys, network+"/missing"))
+			for i := range keys {
+				if errs[i] != nil || !bytes.Equal(got[i].([]byte), values[i]) {
+					t.Fatal("unexpected value of", keys[i], got[i], errs[i])
+				}
+			}
+			if errs[len(keys)] != stgintf.ErrNotFound {
+				t.Fatal("expected ErrNotFound, got", errs[len(keys)])
+			}
+
+			if err := client.Set(network+"/x", []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+			if v, err := client.Get(network + "/x"); err != nil || string(v.([]byte)) != "x" {
+				t.Fatal("unexpected value", v, err)
+			}
+			if !client.Has(network+"/x") || client.Has(network+"/y") {
+				t.Fatal("unexpected Has")
+			}
+			if err := client.Delete(network + "/x"); err != nil || client.Has(network+"/x") {
+				t.Fatal("Delete failed", err)
+			}
+			if _, err := client.Get(network + "/x"); err != stgintf.ErrNotFound {
+				t.Fatal("expected ErrNotFound, got", err)
+			}
+
+			// The prefix is applied by the server and the checker by the client.
+			queried, _, errs := client.Query(network+"/00", nil)
+			if len(errs) > 0 || len(queried) != 10 {
+				t.Fatal("unexpected query result", queried, errs)
+			}
+			queried, _, _ = client.Query(network+"/", func(k string, _ []byte) bool { return strings.HasSuffix(k, "5") })
+			if len(queried) != 9 {
+				t.Fatal("unexpected query result", queried)
+			}
+
+			for i, err := range client.DeleteBatch(keys[:50]) {
+				if err != nil {
+					t.Fatal(i, err)
+				}
+			}
+			if queried, _, _ := client.Query(network+"/", nil); len(queried) != 45 {
+				t.Fatal("expected 45 keys left, got", len(queried))
+			}
+		})
+	}
+}
+
+func TestRemoteRepeatedKeys(t *testing.T) {
+	_, address := startServer(t, memdb.NewMemoryDB(), "tcp")
+	client, err := Dial("tcp", address, Config{BatchChunk: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The writes of a repeated key are applied in the batch order.
+	for round := 0; round < 20; round++ {
+		keys, values := make([]string, 40), make([][]byte, 40)
+		for i := range keys {
+			keys[i], values[i] = fmt.Sprint(i%4), []byte(fmt.Sprint(round, i))
+		}
+		client.SetBatch(keys, values)
+
+		for i := len(keys) - 4; i < len(keys); i++ {
+			if v, err := client.Get(keys[i]); err != nil || !bytes.Equal(v.([]byte), values[i]) {
+				t.Fatal("expected the last write of", keys[i], "got", v, err)
+			}
+		}
+	}
+}
+
+// batchCounter counts the write batches applied to the store.
+type batchCounter struct {
+	*memdb.MemoryDB
+	batches atomic.Int32
+}
+
+func (this *batchCounter) SetBatch(keys []string, values [][]byte) []error {
+	this.batches.Add(1)
+	return this.MemoryDB.SetBatch(keys, values)
+}
+
+func (this *batchCounter) DeleteBatch(keys []string) []error {
+	this.batches.Add(1)
+	return this.MemoryDB.DeleteBatch(keys)
+}
+
+func TestRemoteBatchInOneRequest(t *testing.T) {
+	store := &batchCounter{MemoryDB: memdb.NewMemoryDB()}
+	_, address := startServer(t, store, "tcp")
+	client, err := Dial("tcp", address, Config{BatchChunk: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The write batches are applied by a single SetBatch on the server, whatever the read chunk size.
+	keys, values := make([]string, 25), make([][]byte, 25)
+	for i := range keys {
+		keys[i], values[i] = fmt.Sprint(i), []byte{byte(i)}
+	}
+	client.SetBatch(keys, values)
+	client.DeleteBatch(keys[:10])
+	if n := store.batches.Load(); n != 2 {
+		t.Fatal("expected 2 batches on the server, got", n)
+	}
+
+	got, errs := client.GetBatch(keys)
+	for i := range keys {
+		if i < 10 && errs[i] != stgintf.ErrNotFound || i >= 10 && (errs[i] != nil || !bytes.Equal(got[i].([]byte), values[i])) {
+			t.Fatal("unexpected value of", keys[i], got[i], errs[i])
+		}
+	}
+}
+
+func TestRemoteConcurrentClients(t *testing.T) {
+	_, address := startServer(t, memdb.NewMemoryDB(), "tcp")
+	client, err := Dial("tcp", address, Config{PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("%d-%d", w, i)
+				if err := client.Set(key, []byte(key)); err != nil {
+					t.Error(err)
+					return
+				}
+				if v, err := client.Get(key); err != nil || string(v.([]byte)) != key {
+					t.Error("unexpected value", key, v, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestRemoteTimeoutAndReconnect(t *testing.T) {
+	slow := faulty.NewStore[string, []byte](memdb.NewMemoryDB(), faulty.Config{Rules: []faulty.Rule{{
+		Ops:                []string{faulty.OP_GET},
+		Latency:            200 * time.Millisecond,
+		LatencyProbability: 1,
+	}}})
+	server, address := startServer(t, slow, "tcp")
+
+	client, err := Dial("tcp", address, Config{PoolSize: 1, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Set("a", []byte{1})
+	if _, err := client.Get("a"); err != ErrTimeout {
+		t.Fatal("expected ErrTimeout, got", err)
+	}
+	if !client.Has("a") { // The connection stays usable after a timeout.
+		t.Fatal("expected the key to exist")
+	}
+
+	// The client redials after the server restarts on the same address.
+	server.Close()
+	if err := client.Set("b", []byte{2}); err == nil {
+		t.Fatal("expected an error with the server down")
+	}
+
+	restarted := NewServer(slow)
+	if _, err := restarted.Listen("tcp", address); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	if err := client.Set("b", []byte{2}); err != nil {
+		t.Fatal("expected the client to reconnect, got", err)
+	}
+}
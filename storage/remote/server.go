@@ -0,0 +1,269 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package remote
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const DEFAULT_MAX_INFLIGHT = 64 // The max number of requests processed concurrently per connection.
+
+// Server serves a store to the clients. The store should return the raw bytes, so it shouldn't
+// have a decoder, the clients can decode the values on their own.
+type Server struct {
+	store       stgintf.ReadWriteStore[string, []byte]
+	maxInflight int
+
+	lock      sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closed    atomic.Bool
+}
+
+func NewServer(store stgintf.ReadWriteStore[string, []byte], maxInflight ...int) *Server {
+	server := &Server{
+		store:       store,
+		maxInflight: DEFAULT_MAX_INFLIGHT,
+		conns:       map[net.Conn]struct{}{},
+	}
+
+	if len(maxInflight) > 0 && maxInflight[0] > 0 {
+		server.maxInflight = maxInflight[0]
+	}
+	return server
+}
+
+// Listen starts serving on the network address in the background, the network is "tcp" or "unix".
+func (this *Server) Listen(network, address string) (net.Addr, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	go this.Serve(listener)
+	return listener.Addr(), nil
+}
+
+// Serve accepts the connections from the listener until the listener or the server is closed.
+func (this *Server) Serve(listener net.Listener) error {
+	this.lock.Lock()
+	if this.closed.Load() {
+		this.lock.Unlock()
+		listener.Close()
+		return ErrClosed
+	}
+	this.listeners = append(this.listeners, listener)
+	this.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if this.closed.Load() {
+				return nil
+			}
+			return err
+		}
+
+		this.lock.Lock()
+		if this.closed.Load() {
+			this.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		this.conns[conn] = struct{}{}
+		this.wg.Add(1)
+		this.lock.Unlock()
+
+		go this.serveConn(conn)
+	}
+}
+
+// Close stops the listeners, closes all the connections and waits for the requests in progress.
+// The store isn't closed.
+func (this *Server) Close() error {
+	this.lock.Lock()
+	this.closed.Store(true)
+	for _, listener := range this.listeners {
+		listener.Close()
+	}
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.lock.Unlock()
+
+	this.wg.Wait()
+	return nil
+}
+
+func (this *Server) serveConn(conn net.Conn) {
+	defer this.wg.Done()
+
+	var handlers sync.WaitGroup
+	defer func() {
+		handlers.Wait()
+		conn.Close()
+
+		this.lock.Lock()
+		delete(this.conns, conn)
+		this.lock.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	var writeLock sync.Mutex
+	inflight := make(chan struct{}, this.maxInflight)
+
+	for {
+		id, op, payload, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+
+		inflight <- struct{}{}
+		handlers.Add(1)
+		go func() {
+			defer func() {
+				<-inflight
+				handlers.Done()
+			}()
+
+			status, response := this.handle(op, payload)
+
+			writeLock.Lock()
+			defer writeLock.Unlock()
+			err := writeFrame(writer, id, status, response)
+			if err == nil {
+				err = writer.Flush()
+			}
+
+			if err != nil { // The stream may be cut in the middle of a frame, so it can't be used any more.
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (this *Server) handle(op uint8, payload []byte) (status uint8, response []byte) {
+	defer func() {
+		if r := recover(); r != nil { // Malformed payloads may panic the decoders.
+			status, response = STATUS_ERROR, []byte(fmt.Sprintf("%v: %v", ErrBadRequest, r))
+		}
+	}()
+
+	switch op {
+	case OP_HAS:
+		if this.store.Has(string(payload)) {
+			return STATUS_OK, encodeResults([][]byte{nil}, nil)
+		}
+		return STATUS_OK, encodeResults([][]byte{nil}, []error{stgintf.ErrNotFound})
+
+	case OP_GET:
+		value, err := this.store.Get(string(payload))
+		values, errs := toBytes([]any{value}, []error{err})
+		return STATUS_OK, encodeResults(values, errs)
+
+	case OP_GET_BATCH:
+		keys := codec.Strings{}.Decode(payload).(codec.Strings)
+		found, errs := this.store.GetBatch(keys)
+		for i, err := range errs { // Not all the stores report the missing keys of a batch with ErrNotFound.
+			if err != nil && err != stgintf.ErrNotFound {
+				found[i], errs[i] = this.store.Get(keys[i])
+			}
+		}
+
+		values, errs := toBytes(found, errs)
+		if len(values) != len(keys) { // Some stores return nothing for an empty batch.
+			values, errs = make([][]byte, len(keys)), nil
+		}
+		return STATUS_OK, encodeResults(values, errs)
+
+	case OP_SET:
+		fields := codec.Byteset{}.Decode(payload).(codec.Byteset)
+		if len(fields) != 2 {
+			return STATUS_ERROR, []byte(ErrBadRequest.Error())
+		}
+		err := this.store.Set(string(fields[0]), fields[1])
+		return STATUS_OK, encodeResults([][]byte{nil}, []error{err})
+
+	case OP_SET_BATCH:
+		fields := codec.Byteset{}.Decode(payload).(codec.Byteset)
+		if len(fields) != 2 {
+			return STATUS_ERROR, []byte(ErrBadRequest.Error())
+		}
+
+		keys := codec.Strings{}.Decode(fields[0]).(codec.Strings)
+		values := codec.Byteset{}.Decode(fields[1]).(codec.Byteset)
+		if len(keys) != len(values) {
+			return STATUS_ERROR, []byte(ErrBadRequest.Error())
+		}
+		errs := this.store.SetBatch(keys, values)
+		return STATUS_OK, encodeResults(make([][]byte, len(keys)), errs)
+
+	case OP_DELETE:
+		err := this.store.Delete(string(payload))
+		return STATUS_OK, encodeResults([][]byte{nil}, []error{err})
+
+	case OP_DELETE_BATCH:
+		keys := codec.Strings{}.Decode(payload).(codec.Strings)
+		errs := this.store.DeleteBatch(keys)
+		return STATUS_OK, encodeResults(make([][]byte, len(keys)), errs)
+
+	case OP_QUERY:
+		// The checker can't be sent over, so the candidates are filtered on the client side.
+		fields := codec.Byteset{}.Decode(payload).(codec.Byteset)
+		if len(fields) != 2 || len(fields[1]) != 1 {
+			return STATUS_ERROR, []byte(ErrBadRequest.Error())
+		}
+
+		var checker func(string, []byte) bool
+		if fields[1][0] == 1 {
+			checker = func(string, []byte) bool { return true }
+		}
+		return STATUS_OK, encodeQuery(this.store.Query(string(fields[0]), checker))
+	}
+	return STATUS_ERROR, []byte(fmt.Sprintf("%v: unknown operation %d", ErrBadRequest, op))
+}
+
+// toBytes converts the values read from the store to bytes.
+func toBytes(values []any, errs []error) ([][]byte, []error) {
+	converted := make([][]byte, len(values))
+	for i, v := range values {
+		if len(errs) > 0 && errs[i] != nil {
+			continue
+		}
+
+		bytes, ok := v.([]byte)
+		if !ok {
+			if len(errs) == 0 {
+				errs = make([]error, len(values))
+			}
+			errs[i] = errors.New("the store returned a non-byte value")
+			continue
+		}
+		converted[i] = bytes
+	}
+	return converted, errs
+}
@@ -0,0 +1,473 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package replication
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SEGMENT_SUFFIX     = ".log"
+	RECORD_HEADER_SIZE = 4 + 4 // [len u32][crc32 u32], followed by the encoded record.
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	_ Sink   = (*FileLog)(nil)
+	_ Source = (*FileLog)(nil)
+)
+
+// FileLog is a replication log stored in a directory. The records are appended to segment files
+// named after the height of their first records, and a new segment is started once the current
+// one has grown over the segment size, so the old records can be pruned a segment at a time.
+//
+// A directory has only one writer, opened with NewFileLog, which fsyncs every record before
+// returning. The followers sharing the directory open it with OpenFileLog, and pick up the new
+// records by polling the directory.
+type FileLog struct {
+	dir         string
+	readOnly    bool
+	segmentSize int64
+
+	lock     sync.RWMutex
+	segments []*segment
+	file     *os.File // The last segment, only open for the writer.
+	notify   chan struct{}
+	closed   bool
+}
+
+type segment struct {
+	first   uint64 // The height of the first record.
+	path    string
+	offsets []int64 // The offsets of the records, the record of height first+i is at offsets[i].
+	size    int64   // The size of the valid records.
+}
+
+// NewFileLog opens the log in the directory for writing, creating it if it doesn't exist. A record
+// only partially written before a crash is truncated.
+func NewFileLog(dir string, segmentSize ...int64) (*FileLog, error) {
+	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
+		return nil, err
+	}
+
+	log := &FileLog{dir: dir, segmentSize: DEFAULT_SEGMENT_SIZE, notify: make(chan struct{})}
+	if len(segmentSize) > 0 && segmentSize[0] > 0 {
+		log.segmentSize = segmentSize[0]
+	}
+
+	if err := log.refresh(); err != nil {
+		return nil, err
+	}
+
+	if len(log.segments) > 0 {
+		last := log.segments[len(log.segments)-1]
+		file, err := os.OpenFile(last.path, os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := file.Truncate(last.size); err != nil { // Drop the torn record, if any.
+			file.Close()
+			return nil, err
+		}
+
+		if _, err := file.Seek(last.size, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		log.file = file
+	}
+	return log, nil
+}
+
+// OpenFileLog opens the log in the directory for reading only, the directory is normally shared
+// with the writer in another process.
+func OpenFileLog(dir string) (*FileLog, error) {
+	log := &FileLog{dir: dir, readOnly: true, notify: make(chan struct{})}
+	if err := log.refresh(); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+func (this *FileLog) Append(record *Record) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return ErrClosed
+	}
+
+	if this.readOnly {
+		return ErrReadOnly
+	}
+
+	if head, ok := this.head(); ok && record.Height != head+1 {
+		return fmt.Errorf("%w: %d after %d", ErrHeightOrder, record.Height, head)
+	}
+
+	if this.file == nil || this.needsRotation(record.Height) {
+		if err := this.rotate(record.Height); err != nil {
+			return err
+		}
+	}
+
+	payload := record.Encode()
+	buffer := make([]byte, RECORD_HEADER_SIZE+len(payload))
+	binary.LittleEndian.PutUint32(buffer[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buffer[4:8], crc32.Checksum(payload, crcTable))
+	copy(buffer[RECORD_HEADER_SIZE:], payload)
+
+	last := this.segments[len(this.segments)-1]
+	_, err := this.file.Write(buffer)
+	if err == nil {
+		err = this.file.Sync()
+	}
+
+	if err != nil {
+		this.file.Truncate(last.size) // Don't leave a partial or an unsynced record behind.
+		this.file.Seek(last.size, io.SeekStart)
+		return err
+	}
+
+	last.offsets = append(last.offsets, last.size)
+	last.size += int64(len(buffer))
+
+	close(this.notify) // Wake up the readers waiting for new records.
+	this.notify = make(chan struct{})
+	return nil
+}
+
+func (this *FileLog) Read(from uint64, limit int, wait time.Duration) ([]*Record, uint64, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		if this.readOnly {
+			this.lock.Lock()
+			err := this.refresh()
+			this.lock.Unlock()
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+
+		this.lock.RLock()
+		records, head, notify, err := this.read(from, limit)
+		this.lock.RUnlock()
+
+		if err != nil || len(records) > 0 || !time.Now().Before(deadline) {
+			return records, head, err
+		}
+
+		if this.readOnly { // Written by another process, nothing to wait on.
+			time.Sleep(min(POLL_INTERVAL, time.Until(deadline)))
+			continue
+		}
+
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Head returns the height of the last record, or 0 if the log is empty.
+func (this *FileLog) Head() uint64 {
+	if this.readOnly {
+		this.lock.Lock()
+		this.refresh()
+		this.lock.Unlock()
+	}
+
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	head, _ := this.head()
+	return head
+}
+
+// Base returns the height of the first record still in the log, or 0 if the log is empty.
+func (this *FileLog) Base() uint64 {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+
+	if len(this.segments) == 0 {
+		return 0
+	}
+	return this.segments[0].first
+}
+
+// Prune removes the segments only containing the records below the height. The last segment
+// is always kept, so the log isn't pruned to empty.
+func (this *FileLog) Prune(below uint64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.readOnly {
+		return ErrReadOnly
+	}
+
+	for len(this.segments) > 1 && this.segments[1].first <= below {
+		if err := os.Remove(this.segments[0].path); err != nil {
+			return err
+		}
+		this.segments = this.segments[1:]
+	}
+	return nil
+}
+
+func (this *FileLog) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil
+	}
+
+	this.closed = true
+	close(this.notify)
+	if this.file != nil {
+		return this.file.Close()
+	}
+	return nil
+}
+
+// read returns the records from the height, and the channel to wait on if there are none yet.
+func (this *FileLog) read(from uint64, limit int) ([]*Record, uint64, <-chan struct{}, error) {
+	if this.closed {
+		return nil, 0, nil, ErrClosed
+	}
+
+	head, ok := this.head()
+	if !ok || from > head {
+		return nil, head, this.notify, nil
+	}
+
+	if from < this.segments[0].first {
+		return nil, head, nil, fmt.Errorf("%w: %d is below %d", ErrPruned, from, this.segments[0].first)
+	}
+
+	idx := sort.Search(len(this.segments), func(i int) bool { return this.segments[i].first > from }) - 1
+	records := make([]*Record, 0, min(uint64(limit), head-from+1))
+	for ; idx < len(this.segments) && len(records) < limit; idx++ {
+		seg := this.segments[idx]
+		file, err := os.Open(seg.path)
+		if err != nil {
+			return nil, head, nil, err
+		}
+
+		for i := from - seg.first; i < uint64(len(seg.offsets)) && len(records) < limit; i++ {
+			record, err := this.readRecord(file, seg.offsets[i])
+			if err != nil {
+				file.Close()
+				return nil, head, nil, err
+			}
+			records = append(records, record)
+			from++
+		}
+		file.Close()
+	}
+	return records, head, nil, nil
+}
+
+func (this *FileLog) readRecord(file *os.File, offset int64) (*Record, error) {
+	var header [RECORD_HEADER_SIZE]byte
+	if _, err := file.ReadAt(header[:], offset); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, binary.LittleEndian.Uint32(header[:4]))
+	if _, err := file.ReadAt(payload, offset+RECORD_HEADER_SIZE); err != nil {
+		return nil, err
+	}
+
+	record := &Record{}
+	if err := record.Decode(payload); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (this *FileLog) head() (uint64, bool) {
+	for i := len(this.segments) - 1; i >= 0; i-- {
+		if n := len(this.segments[i].offsets); n > 0 {
+			return this.segments[i].first + uint64(n) - 1, true
+		}
+	}
+	return 0, false
+}
+
+func (this *FileLog) needsRotation(height uint64) bool {
+	last := this.segments[len(this.segments)-1]
+	if len(last.offsets) == 0 {
+		return last.first != height // Left empty by a crash before the first record was written.
+	}
+	return last.size >= this.segmentSize
+}
+
+// rotate starts a new segment for the records from the height.
+func (this *FileLog) rotate(height uint64) error {
+	if this.file != nil {
+		if err := this.file.Close(); err != nil {
+			return err
+		}
+		this.file = nil
+	}
+
+	if len(this.segments) > 0 && len(this.segments[len(this.segments)-1].offsets) == 0 {
+		last := this.segments[len(this.segments)-1]
+		if err := os.Remove(last.path); err != nil {
+			return err
+		}
+		this.segments = this.segments[:len(this.segments)-1]
+	}
+
+	seg := &segment{first: height, path: filepath.Join(this.dir, fmt.Sprintf("%020d%s", height, SEGMENT_SUFFIX))}
+	file, err := os.OpenFile(seg.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if err := syncDir(this.dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	this.file = file
+	this.segments = append(this.segments, seg)
+	return nil
+}
+
+// refresh picks up the segments created and the records appended since the last refresh, and
+// drops the segments that have been pruned.
+func (this *FileLog) refresh() error {
+	entries, err := os.ReadDir(this.dir)
+	if err != nil {
+		return err
+	}
+
+	known := map[uint64]*segment{}
+	for _, seg := range this.segments {
+		known[seg.first] = seg
+	}
+
+	segments := make([]*segment, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, SEGMENT_SUFFIX) {
+			continue
+		}
+
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, SEGMENT_SUFFIX), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seg, ok := known[first]
+		if !ok {
+			seg = &segment{first: first, path: filepath.Join(this.dir, name)}
+		}
+		segments = append(segments, seg)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].first < segments[j].first })
+
+	for i, seg := range segments {
+		complete, err := seg.scan()
+		if err != nil {
+			return err
+		}
+
+		if i < len(segments)-1 {
+			if !complete {
+				return fmt.Errorf("%w: %s has a torn record", ErrCorrupted, seg.path)
+			}
+
+			if next := seg.first + uint64(len(seg.offsets)); next != segments[i+1].first {
+				return fmt.Errorf("%w: %s ends at %d but the next segment starts at %d", ErrCorrupted, seg.path, next, segments[i+1].first)
+			}
+		}
+	}
+	this.segments = segments
+	return nil
+}
+
+// scan indexes the records appended to the segment since the last scan. It returns false if
+// the file ends with a partial or corrupted record, which is either being written or torn.
+func (this *segment) scan() (bool, error) {
+	file, err := os.Open(this.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	var header [RECORD_HEADER_SIZE]byte
+	for this.size < info.Size() {
+		if info.Size()-this.size < RECORD_HEADER_SIZE {
+			return false, nil
+		}
+
+		if _, err := file.ReadAt(header[:], this.size); err != nil {
+			return false, err
+		}
+
+		length := int64(binary.LittleEndian.Uint32(header[:4]))
+		if info.Size()-this.size-RECORD_HEADER_SIZE < length {
+			return false, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := file.ReadAt(payload, this.size+RECORD_HEADER_SIZE); err != nil {
+			return false, err
+		}
+
+		if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+			return false, nil
+		}
+
+		this.offsets = append(this.offsets, this.size)
+		this.size += RECORD_HEADER_SIZE + length
+	}
+	return true, nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
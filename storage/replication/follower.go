@@ -0,0 +1,479 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+type Config struct {
+	StateFile     string        // Where the applied height and the digest are persisted for resuming, nothing is persisted if empty.
+	StartHeight   uint64        // The height the store is at if there is no state file yet, normally the height of the snapshot it was restored from.
+	BatchSize     int           // The max number of records read at a time.
+	Wait          time.Duration // How long a read waits for new records.
+	RetryInterval time.Duration // The delay before retrying after a failed read or write.
+	VerifyEvery   uint64        // Also verify the whole store with a full scan every this many heights, never if 0.
+	Sync          func() error  // Makes the writes to the store durable, the Sync method of the store is used if nil.
+}
+
+// Follower tails a replication log and applies the records to a store. The store should only be
+// written by the follower, otherwise it will be reported as diverged. The digest is maintained from
+// the old values of the keys changed by the records, so a change made to a key bypassing the follower
+// is detected when a record changes the key again, or by a full verification.
+//
+// The state file holds the applied height with the digest at that height, and the last height of the
+// batch being applied, which is persisted before the batch is written to the store. After a crash in
+// the middle of a batch, the store may already have some of the changes of the batch, so the old values
+// in the store can't be used for the digest. Applying a record is idempotent, so the records of the batch
+// are applied again without checking the digest, which is then recomputed with a full scan and checked
+// against the record at the end of the batch. The store is synced before the applied height moves in the
+// state file, so a persistent state file needs a store that can be synced.
+type Follower struct {
+	store  stgintf.ReadWriteStore[string, []byte]
+	source Source
+	config Config
+
+	lock    sync.Mutex // Protects the digest.
+	digest  Digest
+	stale   bool   // Set if a record has been partially applied, the digest needs to be recomputed.
+	replay  uint64 // The records up to the height are applied again without the digest after a crash.
+	synced  bool   // Cleared if the applied records may not be durable yet.
+	applied atomic.Uint64
+	head    atomic.Uint64
+
+	errLock sync.Mutex
+	err     error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFollower starts following the source from the height after the one in the state file, or after
+// the start height if there is no state file yet. The digest of the store is loaded from the state file,
+// it is only computed with a full scan if there is no state file yet.
+func NewFollower(store stgintf.ReadWriteStore[string, []byte], source Source, config ...Config) (*Follower, error) {
+	follower := &Follower{
+		store:  store,
+		source: source,
+		synced: true,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if len(config) > 0 {
+		follower.config = config[0]
+	}
+
+	if follower.config.BatchSize <= 0 {
+		follower.config.BatchSize = DEFAULT_BATCH_SIZE
+	}
+
+	if follower.config.Wait <= 0 {
+		follower.config.Wait = DEFAULT_WAIT
+	}
+
+	if follower.config.RetryInterval <= 0 {
+		follower.config.RetryInterval = DEFAULT_RETRY_INTERVAL
+	}
+
+	if follower.config.Sync == nil {
+		if syncable, ok := store.(stgintf.Syncable); ok {
+			follower.config.Sync = syncable.Sync
+		}
+	}
+
+	// The applied height in the state file can't be trusted if the writes before it may be lost.
+	if follower.config.StateFile != "" && follower.config.Sync == nil {
+		return nil, ErrNotSyncable
+	}
+
+	state, found, err := follower.loadState()
+	if err != nil {
+		return nil, err
+	}
+	follower.applied.Store(state.applied)
+	follower.head.Store(state.applied)
+
+	switch {
+	case state.pending > state.applied: // Crashed in the middle of a batch
+		follower.replay = state.pending
+	case found:
+		follower.digest = state.digest
+	default:
+		if follower.digest, err = StateDigest(store); err != nil {
+			return nil, err
+		}
+	}
+
+	go follower.run()
+	return follower, nil
+}
+
+// Applied returns the height of the last record applied to the store.
+func (this *Follower) Applied() uint64 { return this.applied.Load() }
+
+// Head returns the height of the last record in the log, as of the last read.
+func (this *Follower) Head() uint64 { return this.head.Load() }
+
+// Lag returns the number of records in the log not applied yet, as of the last read.
+func (this *Follower) Lag() uint64 {
+	applied, head := this.applied.Load(), this.head.Load()
+	if head > applied {
+		return head - applied
+	}
+	return 0
+}
+
+// Err returns the error of the last attempt, or the error that has stopped the follower, which is
+// either ErrDiverged or ErrPruned. A stopped follower needs to be rebuilt from a snapshot.
+func (this *Follower) Err() error {
+	this.errLock.Lock()
+	defer this.errLock.Unlock()
+	return this.err
+}
+
+// Digest returns the state digest of the store after the last applied record.
+func (this *Follower) Digest() Digest {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.digest
+}
+
+// Verify recomputes the digest of the store with a full scan, and compares it with the one
+// maintained by the follower, to detect the changes made to the store bypassing the follower.
+func (this *Follower) Verify() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.replay > 0 { // The digest is unknown until the batch interrupted by a crash is applied again.
+		return nil
+	}
+
+	digest, err := StateDigest(this.store)
+	if err != nil {
+		return err
+	}
+
+	if digest != this.digest {
+		return fmt.Errorf("%w: the store doesn't match the digest at height %d", ErrDiverged, this.applied.Load())
+	}
+	return nil
+}
+
+// Close stops the follower, the store and the source aren't closed.
+func (this *Follower) Close() error {
+	select {
+	case <-this.stop:
+	default:
+		close(this.stop)
+	}
+	<-this.done
+	return nil
+}
+
+func (this *Follower) run() {
+	defer close(this.done)
+
+	for {
+		select {
+		case <-this.stop:
+			return
+		default:
+		}
+
+		err := this.poll()
+		this.setErr(err)
+		if errors.Is(err, ErrDiverged) || errors.Is(err, ErrPruned) {
+			return
+		}
+
+		if err != nil {
+			select {
+			case <-this.stop:
+				return
+			case <-time.After(this.config.RetryInterval):
+			}
+		}
+	}
+}
+
+// poll reads and applies the next batch of records.
+func (this *Follower) poll() error {
+	records, head, err := this.source.Read(this.applied.Load()+1, this.config.BatchSize, this.config.Wait)
+	if err != nil {
+		return err
+	}
+
+	if head > this.head.Load() {
+		this.head.Store(head)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	// A failed sync is retried first, the applied height is saved again below.
+	if err := this.sync(); err != nil {
+		return err
+	}
+
+	// The store may have any of the changes up to the end of the batch from now on.
+	if err := this.saveState(this.state(max(this.replay, records[len(records)-1].Height))); err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.Height != this.applied.Load()+1 {
+			return fmt.Errorf("%w: got %d after %d", ErrHeightOrder, record.Height, this.applied.Load())
+		}
+
+		if err := this.apply(record); err != nil {
+			return err
+		}
+		this.applied.Store(record.Height)
+	}
+
+	// The batch has to be durable before the state file says it is applied.
+	this.synced = false
+	if err := this.sync(); err != nil {
+		return err
+	}
+	return this.saveState(this.state(this.replay))
+}
+
+func (this *Follower) apply(record *Record) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	// Only the last change of every key matters, the keys are unique after this.
+	last := make(map[string]int, len(record.Keys))
+	for i, key := range record.Keys {
+		last[key] = i
+	}
+
+	keys := make([]string, 0, len(last))
+	for i, key := range record.Keys {
+		if last[key] == i {
+			keys = append(keys, key)
+		}
+	}
+
+	if record.Height <= this.replay {
+		return this.reapply(record, keys, last)
+	}
+
+	if this.stale {
+		digest, err := StateDigest(this.store)
+		if err != nil {
+			return err
+		}
+		this.digest, this.stale = digest, false
+	}
+
+	olds, existed, err := stgintf.LookupBatch(this.store, keys)
+	if err != nil {
+		return err
+	}
+
+	digest := this.digest
+	for i, key := range keys {
+		if existed[i] {
+			digest.toggle(key, olds[i])
+		}
+
+		if idx := last[key]; record.Ops[idx] == OP_SET {
+			digest.toggle(key, record.Values[idx])
+		}
+	}
+
+	this.stale = true
+	if err := this.write(record, keys, last); err != nil {
+		return err
+	}
+	this.digest, this.stale = digest, false
+
+	if digest != record.Digest {
+		return fmt.Errorf("%w: the digest doesn't match at height %d", ErrDiverged, record.Height)
+	}
+
+	if this.config.VerifyEvery > 0 && record.Height%this.config.VerifyEvery == 0 {
+		scanned, err := StateDigest(this.store)
+		if err != nil {
+			return err
+		}
+
+		if scanned != digest {
+			return fmt.Errorf("%w: the store doesn't match the digest at height %d", ErrDiverged, record.Height)
+		}
+	}
+	return nil
+}
+
+// reapply applies a record of the batch interrupted by a crash. The digest is recomputed and checked
+// once the whole batch has been applied again.
+func (this *Follower) reapply(record *Record, keys []string, last map[string]int) error {
+	if err := this.write(record, keys, last); err != nil {
+		return err
+	}
+
+	if record.Height < this.replay {
+		return nil
+	}
+
+	digest, err := StateDigest(this.store)
+	if err != nil {
+		return err
+	}
+	this.digest, this.stale, this.replay = digest, false, 0
+
+	if digest != record.Digest {
+		return fmt.Errorf("%w: the digest doesn't match at height %d", ErrDiverged, record.Height)
+	}
+	return nil
+}
+
+// write writes the last changes of the keys in the record to the store.
+func (this *Follower) write(record *Record, keys []string, last map[string]int) error {
+	var setKeys, deleteKeys []string
+	var setValues [][]byte
+	for _, key := range keys {
+		if idx := last[key]; record.Ops[idx] == OP_DELETE {
+			deleteKeys = append(deleteKeys, key)
+		} else {
+			setKeys, setValues = append(setKeys, key), append(setValues, record.Values[idx])
+		}
+	}
+
+	if len(setKeys) > 0 {
+		if err := errors.Join(this.store.SetBatch(setKeys, setValues)...); err != nil {
+			return err
+		}
+	}
+
+	if len(deleteKeys) > 0 {
+		if err := errors.Join(this.store.DeleteBatch(deleteKeys)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sync syncs the store if the applied records may not be durable, nothing needs to be if there is no state file.
+func (this *Follower) sync() error {
+	if this.synced || this.config.StateFile == "" {
+		return nil
+	}
+
+	if err := this.config.Sync(); err != nil {
+		return err
+	}
+	this.synced = true
+	return nil
+}
+
+func (this *Follower) setErr(err error) {
+	this.errLock.Lock()
+	defer this.errLock.Unlock()
+	this.err = err
+}
+
+// state is what the follower persists in the state file.
+type state struct {
+	applied uint64 // The height of the last record applied to the store.
+	digest  Digest // The digest of the store at the applied height.
+	pending uint64 // The last height of the batch being applied, 0 if there is none.
+}
+
+const stateSize = 8 + len(Digest{}) + 8
+
+// state returns the current state of the follower with the pending height.
+func (this *Follower) state(pending uint64) state {
+	return state{applied: this.applied.Load(), digest: this.Digest(), pending: pending}
+}
+
+// loadState reads the state file, and tells if the state was found. The state files only holding the
+// applied height are still accepted, the digest is unknown then.
+func (this *Follower) loadState() (state, bool, error) {
+	if this.config.StateFile == "" {
+		return state{applied: this.config.StartHeight}, false, nil
+	}
+
+	data, err := os.ReadFile(this.config.StateFile)
+	if os.IsNotExist(err) {
+		return state{applied: this.config.StartHeight}, false, nil
+	}
+
+	if err != nil {
+		return state{}, false, err
+	}
+
+	switch len(data) {
+	case 8:
+		return state{applied: binary.LittleEndian.Uint64(data)}, false, nil
+	case stateSize:
+		loaded := state{applied: binary.LittleEndian.Uint64(data), pending: binary.LittleEndian.Uint64(data[stateSize-8:])}
+		copy(loaded.digest[:], data[8:])
+		return loaded, true, nil
+	}
+	return state{}, false, fmt.Errorf("%w: invalid state file %s", ErrCorrupted, this.config.StateFile)
+}
+
+// saveState replaces the state file atomically, so it is never seen half written. The new content
+// is synced before the rename and the rename is synced after it, so a crash can't lose the state.
+func (this *Follower) saveState(current state) error {
+	if this.config.StateFile == "" {
+		return nil
+	}
+
+	var data [stateSize]byte
+	binary.LittleEndian.PutUint64(data[:], current.applied)
+	copy(data[8:], current.digest[:])
+	binary.LittleEndian.PutUint64(data[stateSize-8:], current.pending)
+
+	tmp := this.config.StateFile + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data[:]); err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, this.config.StateFile); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(this.config.StateFile))
+}
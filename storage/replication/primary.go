@@ -0,0 +1,175 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package replication
+
+import (
+	"sync"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+var _ stgintf.ReadWriteStore[string, []byte] = (*Primary)(nil)
+
+// Primary is a ReadWriteStore decorator that ships the changes made through it to a log. All the
+// writes to the underlying store need to go through the primary for the replicas to stay in sync.
+// The store should return the raw bytes, so it shouldn't have a decoder.
+type Primary struct {
+	inner stgintf.ReadWriteStore[string, []byte]
+	sink  Sink
+
+	lock    sync.Mutex // Serializes the writes, so the digest follows the order of the changes.
+	pending Record
+	digest  Digest
+}
+
+// NewPrimary creates a primary over the store, the digest of the existing entries is computed with
+// a full scan of the store, which is streamed if the store implements stgintf.Iterable.
+func NewPrimary(inner stgintf.ReadWriteStore[string, []byte], sink Sink) (*Primary, error) {
+	digest, err := StateDigest(inner)
+	if err != nil {
+		return nil, err
+	}
+	return &Primary{inner: inner, sink: sink, digest: digest}, nil
+}
+
+func (this *Primary) Has(key string) bool                     { return this.inner.Has(key) }
+func (this *Primary) Get(key string) (any, error)             { return this.inner.Get(key) }
+func (this *Primary) GetAs(key string, hint any) (any, error) { return this.inner.GetAs(key, hint) }
+func (this *Primary) GetBatch(keys []string) ([]any, []error) { return this.inner.GetBatch(keys) }
+
+func (this *Primary) Query(pattern string, checker func(string, []byte) bool) ([]string, [][]byte, []error) {
+	return this.inner.Query(pattern, checker)
+}
+
+func (this *Primary) Set(key string, value []byte) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old, existed, err := stgintf.Lookup(this.inner, key)
+	if err != nil {
+		return err
+	}
+
+	if err := this.inner.Set(key, value); err != nil {
+		return err
+	}
+	this.record(OP_SET, key, old, existed, value)
+	return nil
+}
+
+func (this *Primary) SetBatch(keys []string, values [][]byte) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	olds, existed, err := stgintf.LookupBatch(this.inner, keys)
+	if err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	errs := this.inner.SetBatch(keys, values)
+	written := map[string][]byte{} // For the keys appearing more than once in the batch
+	for i, key := range keys {
+		if len(errs) > 0 && errs[i] != nil {
+			continue
+		}
+
+		if latest, ok := written[key]; ok {
+			olds[i], existed[i] = latest, true
+		}
+		written[key] = values[i]
+		this.record(OP_SET, key, olds[i], existed[i], values[i])
+	}
+	return errs
+}
+
+func (this *Primary) Delete(key string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	old, existed, err := stgintf.Lookup(this.inner, key)
+	if err != nil {
+		return err
+	}
+
+	if err := this.inner.Delete(key); err != nil {
+		return err
+	}
+
+	if existed { // Deleting a missing key changes nothing.
+		this.record(OP_DELETE, key, old, true, nil)
+	}
+	return nil
+}
+
+func (this *Primary) DeleteBatch(keys []string) []error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	olds, existed, err := stgintf.LookupBatch(this.inner, keys)
+	if err != nil {
+		return stgintf.BatchErrors(len(keys), err)
+	}
+
+	errs := this.inner.DeleteBatch(keys)
+	deleted := map[string]bool{}
+	for i, key := range keys {
+		if (len(errs) > 0 && errs[i] != nil) || !existed[i] || deleted[key] {
+			continue
+		}
+		deleted[key] = true
+		this.record(OP_DELETE, key, olds[i], true, nil)
+	}
+	return errs
+}
+
+// Commit appends the changes made since the last commit to the log as the record of the height.
+// A record is appended even if nothing has changed, so the followers can tell how far they are
+// behind. If the append fails, the changes are kept for the next commit.
+func (this *Primary) Commit(height uint64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	record := this.pending
+	record.Height, record.Digest = height, this.digest
+	if err := this.sink.Append(&record); err != nil {
+		return err
+	}
+	this.pending = Record{}
+	return nil
+}
+
+// Digest returns the state digest of the store, including the changes not committed yet.
+func (this *Primary) Digest() Digest {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.digest
+}
+
+func (this *Primary) record(op uint8, key string, old []byte, existed bool, value []byte) {
+	if existed {
+		this.digest.toggle(key, old)
+	}
+
+	if op == OP_SET {
+		this.digest.toggle(key, value)
+	}
+
+	this.pending.Ops = append(this.pending.Ops, op)
+	this.pending.Keys = append(this.pending.Keys, key)
+	this.pending.Values = append(this.pending.Values, value)
+}
@@ -0,0 +1,164 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package replication keeps read replicas of a store up to date by shipping the committed batches
+// of the primary to the followers, instead of rebuilding the replicas from snapshots.
+//
+// The Primary decorates the store of the primary node. The writes made through it are collected
+// and appended to a log as one record per committed height. The followers tail the log, either
+// from a shared directory with OpenFileLog or over a connection with DialLog, and apply the records
+// to their own stores in height order.
+//
+// Every record carries the state digest of the primary after the record, which is an order
+// independent checksum of all the entries in the store. The followers maintain the same digest
+// for their stores, so a replica that has diverged from the primary is detected at the first
+// record applied after the divergence.
+package replication
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	OP_SET    = uint8(0)
+	OP_DELETE = uint8(1)
+
+	DEFAULT_SEGMENT_SIZE   = 64 << 20 // The size after which the file log starts a new segment.
+	DEFAULT_BATCH_SIZE     = 64       // The max number of records a follower reads at a time.
+	DEFAULT_WAIT           = time.Second
+	DEFAULT_RETRY_INTERVAL = time.Second
+	POLL_INTERVAL          = 50 * time.Millisecond // How often a read only file log checks for new records.
+)
+
+var (
+	ErrHeightOrder = errors.New("replication: the height doesn't follow the last one in the log")
+	ErrPruned      = errors.New("replication: the height has been pruned from the log")
+	ErrDiverged    = errors.New("replication: the replica diverged from the primary")
+	ErrCorrupted   = errors.New("replication: corrupted log")
+	ErrReadOnly    = errors.New("replication: the log is read only")
+	ErrClosed      = errors.New("replication: closed")
+	ErrNotSyncable = errors.New("replication: the replica store can't be synced")
+)
+
+// Sink is where the primary appends the records to.
+type Sink interface {
+	// Append adds a record to the end of the log, the height of the record must be the height of
+	// the last record plus one, unless the log is empty.
+	Append(record *Record) error
+}
+
+// Source is where the followers read the records from.
+type Source interface {
+	// Read returns up to limit records starting from the height, in height order, and the height
+	// of the last record in the log. If there are no records from the height yet, it waits for up
+	// to the given duration for some to arrive, and returns none if nothing has arrived.
+	Read(from uint64, limit int, wait time.Duration) ([]*Record, uint64, error)
+	Close() error
+}
+
+// Record contains all the changes committed at a height, in the order they were made.
+type Record struct {
+	Height uint64
+	Ops    []uint8
+	Keys   []string
+	Values [][]byte // Nil for the deletions
+	Digest Digest   // The state digest of the primary after the changes.
+}
+
+func (this *Record) Encode() []byte {
+	return codec.Byteset{
+		codec.Uint64(this.Height).Encode(),
+		this.Ops,
+		codec.Strings(this.Keys).Encode(),
+		codec.Byteset(this.Values).Encode(),
+		this.Digest[:],
+	}.Encode()
+}
+
+// Decode decodes a record from the buffer, the record refers to the buffer instead of copying it.
+func (this *Record) Decode(buffer []byte) error {
+	fields := codec.Byteset{}.Decode(buffer).(codec.Byteset)
+	if len(fields) != 5 || len(fields[0]) != codec.UINT64_LEN || len(fields[4]) != len(this.Digest) {
+		return ErrCorrupted
+	}
+
+	this.Height = uint64(codec.Uint64(0).Decode(fields[0]).(codec.Uint64))
+	this.Ops = fields[1]
+	this.Keys = codec.Strings{}.Decode(fields[2]).(codec.Strings)
+	this.Values = codec.Byteset{}.Decode(fields[3]).(codec.Byteset)
+	copy(this.Digest[:], fields[4])
+
+	if len(this.Keys) != len(this.Ops) || (len(this.Ops) > 0 && len(this.Values) != len(this.Ops)) {
+		return ErrCorrupted
+	}
+
+	if len(this.Ops) == 0 {
+		this.Keys, this.Values = nil, nil
+	}
+	return nil
+}
+
+// Digest is an order independent checksum of the entries of a store. Each entry is hashed on
+// its own and the hashes are XORed together, so the digest can be updated entry by entry as the
+// store changes, and two stores with the same entries always have the same digest.
+type Digest [32]byte
+
+// toggle adds the entry to the digest if it isn't in yet, or removes it otherwise.
+func (this *Digest) toggle(key string, value []byte) {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(key)))
+
+	hasher := sha256.New()
+	hasher.Write(size[:])
+	hasher.Write([]byte(key))
+	hasher.Write(value)
+
+	var sum Digest
+	hasher.Sum(sum[:0])
+	for i := range this {
+		this[i] ^= sum[i]
+	}
+}
+
+// StateDigest computes the digest of all the entries in the store with a full scan. The stores
+// implementing stgintf.Iterable are streamed, the others are loaded with a query.
+func StateDigest(store stgintf.ReadWriteStore[string, []byte]) (Digest, error) {
+	var digest Digest
+	if iterable, ok := store.(stgintf.Iterable[string, []byte]); ok {
+		err := iterable.Iterate("", func(key string, value []byte) bool {
+			digest.toggle(key, value)
+			return true
+		})
+		return digest, err
+	}
+
+	keys, values, errs := store.Query("", func(string, []byte) bool { return true })
+	if err := errors.Join(errs...); err != nil {
+		return Digest{}, err
+	}
+
+	for i := range keys {
+		digest.toggle(keys[i], values[i])
+	}
+	return digest, nil
+}
@@ -0,0 +1,369 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+)
+
+// commitBlocks writes a few keys per block and deletes some of the ones written by the earlier blocks.
+func commitBlocks(t *testing.T, primary *Primary, from, to uint64) {
+	t.Helper()
+	for height := from; height <= to; height++ {
+		keys := []string{fmt.Sprintf("k%d", height), fmt.Sprintf("k%d", height), fmt.Sprintf("x%d", height%3)}
+		values := [][]byte{{1}, []byte(fmt.Sprint(height)), []byte(fmt.Sprint(height))}
+		if err := errors.Join(primary.SetBatch(keys, values)...); err != nil {
+			t.Fatal(err)
+		}
+
+		if height%4 == 0 {
+			primary.Delete(fmt.Sprintf("k%d", height-2))
+		}
+
+		if err := primary.Commit(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func waitFor(t *testing.T, follower *Follower, height uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for follower.Applied() < height {
+		if time.Now().After(deadline) {
+			t.Fatal("the follower is stuck at", follower.Applied(), follower.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func checkSame(t *testing.T, primary, replica stgintf.ReadWriteStore[string, []byte]) {
+	t.Helper()
+	expected, err := StateDigest(primary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual, err := StateDigest(replica); err != nil || actual != expected {
+		t.Fatal("the replica doesn't match the primary", err)
+	}
+}
+
+func TestReplicationSharedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewFileLog(filepath.Join(dir, "log"), 256) // Small segments to span a few files
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	store, _ := pebbledb.NewPebbleDB(filepath.Join(dir, "primary"))
+	defer store.Close()
+	primary, err := NewPrimary(store, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitBlocks(t, primary, 1, 10)
+
+	shared, err := OpenFileLog(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shared.Close()
+
+	config := Config{StateFile: filepath.Join(dir, "follower.state"), BatchSize: 3, Wait: 100 * time.Millisecond, Sync: func() error { return nil }}
+	replica := memdb.NewMemoryDB()
+	follower, err := NewFollower(replica, shared, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, follower, 10)
+	checkSame(t, store, replica)
+	if follower.Digest() != primary.Digest() || follower.Lag() != 0 || follower.Verify() != nil {
+		t.Fatal("unexpected follower state", follower.Lag(), follower.Verify())
+	}
+	follower.Close()
+
+	// The follower resumes from the persisted height after a restart.
+	commitBlocks(t, primary, 11, 20)
+	follower, err = NewFollower(replica, shared, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	if follower.Applied() != 10 {
+		t.Fatal("expected to resume from 10, got", follower.Applied())
+	}
+	waitFor(t, follower, 20)
+	checkSame(t, store, replica)
+
+	if err := primary.Commit(25); !errors.Is(err, ErrHeightOrder) {
+		t.Fatal("expected ErrHeightOrder, got", err)
+	}
+}
+
+func TestReplicationCrashInBatch(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewFileLog(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	store := memdb.NewMemoryDB()
+	primary, _ := NewPrimary(store, log)
+	commitBlocks(t, primary, 1, 10)
+
+	// The replica crashes in the middle of the fourth record, after the first three of the batch have been written.
+	replica := memdb.NewMemoryDB()
+	crashing := faulty.NewStore[string, []byte](replica, faulty.Config{CrashAfterWrites: 7})
+	config := Config{StateFile: filepath.Join(dir, "follower.state"), BatchSize: 5, Wait: 100 * time.Millisecond, RetryInterval: time.Hour, Sync: func() error { return nil }}
+	follower, err := NewFollower(crashing, log, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for !crashing.Crashed() {
+		time.Sleep(time.Millisecond)
+	}
+	follower.Close()
+	if follower.Applied() != 3 {
+		t.Fatal("expected to crash at 3, got", follower.Applied())
+	}
+
+	// The restarted follower applies the batch again over the changes of the later records already in the store.
+	if follower, err = NewFollower(replica, log, config); err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	if follower.Applied() != 0 {
+		t.Fatal("expected to resume from 0, got", follower.Applied())
+	}
+	waitFor(t, follower, 10)
+	checkSame(t, store, replica)
+	if follower.Digest() != primary.Digest() || follower.Verify() != nil {
+		t.Fatal("unexpected follower state", follower.Err(), follower.Verify())
+	}
+	follower.Close()
+
+	// The digest is loaded with the height on the next restart instead of being recomputed.
+	replica.Set("bypassed", []byte{1})
+	if follower, err = NewFollower(replica, log, config); err != nil {
+		t.Fatal(err)
+	}
+	if follower.Digest() != primary.Digest() || !errors.Is(follower.Verify(), ErrDiverged) {
+		t.Fatal("expected the persisted digest")
+	}
+}
+
+func TestReplicationSyncBeforeState(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewFileLog(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	store := memdb.NewMemoryDB()
+	primary, _ := NewPrimary(store, log)
+	commitBlocks(t, primary, 1, 10)
+
+	// A memory store can't be synced, so the applied height in the state file would mean nothing.
+	replica := memdb.NewMemoryDB()
+	stateFile := filepath.Join(dir, "follower.state")
+	if _, err := NewFollower(replica, log, Config{StateFile: stateFile}); !errors.Is(err, ErrNotSyncable) {
+		t.Fatal("expected ErrNotSyncable, got", err)
+	}
+
+	// The durable copy only has what was in the replica when it was synced, like a store losing the unsynced writes.
+	durable := memdb.NewMemoryDB()
+	sync := func() error {
+		keys, values, _ := replica.Query("", func(string, []byte) bool { return true })
+		return errors.Join(durable.SetBatch(keys, values)...)
+	}
+
+	config := Config{StateFile: stateFile, BatchSize: 4, Wait: 50 * time.Millisecond, Sync: sync}
+	follower, err := NewFollower(replica, log, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, follower, 10)
+	follower.Close()
+
+	// The store restarted from the synced state matches the height in the state file.
+	config.Sync = func() error { return nil }
+	if follower, err = NewFollower(durable, log, config); err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	if follower.Applied() != 10 || follower.Verify() != nil {
+		t.Fatal("the synced store doesn't match the state file", follower.Applied(), follower.Verify())
+	}
+	checkSame(t, store, durable)
+}
+
+func TestReplicationOverNetwork(t *testing.T) {
+	log, err := NewFileLog(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	store := memdb.NewMemoryDB()
+	primary, _ := NewPrimary(store, log)
+
+	server := NewLogServer(log)
+	addr, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := DialLog("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	replica := memdb.NewMemoryDB()
+	follower, err := NewFollower(replica, client, Config{Wait: 50 * time.Millisecond, RetryInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	for height := uint64(1); height <= 30; height += 10 { // Committed while the follower is tailing
+		commitBlocks(t, primary, height, height+9)
+		waitFor(t, follower, height+9)
+		checkSame(t, store, replica)
+	}
+
+	if follower.Head() != 30 || follower.Lag() != 0 {
+		t.Fatal("unexpected head and lag", follower.Head(), follower.Lag())
+	}
+}
+
+func TestReplicationDivergence(t *testing.T) {
+	log, _ := NewFileLog(t.TempDir())
+	defer log.Close()
+
+	primary, _ := NewPrimary(memdb.NewMemoryDB(), log)
+	commitBlocks(t, primary, 1, 5)
+
+	replica := memdb.NewMemoryDB()
+	follower, err := NewFollower(replica, log, Config{Wait: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+	waitFor(t, follower, 5)
+
+	replica.Set("x0", []byte("tampered")) // Bypassing the follower, x0 is written again at height 6.
+	if err := follower.Verify(); !errors.Is(err, ErrDiverged) {
+		t.Fatal("expected ErrDiverged, got", err)
+	}
+
+	commitBlocks(t, primary, 6, 6)
+	deadline := time.Now().Add(5 * time.Second)
+	for !errors.Is(follower.Err(), ErrDiverged) {
+		if time.Now().After(deadline) {
+			t.Fatal("the divergence isn't detected", follower.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if follower.Applied() != 5 {
+		t.Fatal("the follower should stop at the divergence, got", follower.Applied())
+	}
+
+	// The keys not written again are only caught by the full verification.
+	replica = memdb.NewMemoryDB()
+	verified, _ := NewFollower(replica, log, Config{Wait: 50 * time.Millisecond, VerifyEvery: 2})
+	defer verified.Close()
+	waitFor(t, verified, 6)
+
+	replica.Set("k1", []byte("tampered"))
+	commitBlocks(t, primary, 7, 8)
+	for !errors.Is(verified.Err(), ErrDiverged) {
+		if time.Now().After(deadline) {
+			t.Fatal("the divergence isn't detected", verified.Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileLogPruneAndRecovery(t *testing.T) {
+	dir := t.TempDir()
+	log, err := NewFileLog(dir, 1) // One record per segment
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for height := uint64(100); height < 110; height++ {
+		if err := log.Append(&Record{Height: height}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := log.Prune(105); err != nil || log.Base() != 105 {
+		t.Fatal("unexpected base after pruning", log.Base(), err)
+	}
+
+	if _, _, err := log.Read(104, 10, 0); !errors.Is(err, ErrPruned) {
+		t.Fatal("expected ErrPruned, got", err)
+	}
+
+	if records, head, err := log.Read(107, 10, 0); err != nil || len(records) != 3 || records[0].Height != 107 || head != 109 {
+		t.Fatal("unexpected records", len(records), head, err)
+	}
+	log.Close()
+
+	// A torn record at the end is dropped on reopening.
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+SEGMENT_SUFFIX))
+	file, _ := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte{200, 0, 0, 0, 1, 2})
+	file.Close()
+
+	log, err = NewFileLog(dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	if log.Head() != 109 {
+		t.Fatal("expected the head at 109, got", log.Head())
+	}
+
+	if err := log.Append(&Record{Height: 110, Ops: []uint8{OP_DELETE}, Keys: []string{"a"}, Values: [][]byte{nil}}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, _, err := log.Read(110, 1, 0)
+	if err != nil || len(records) != 1 || records[0].Keys[0] != "a" || records[0].Ops[0] != OP_DELETE {
+		t.Fatal("unexpected record", records, err)
+	}
+}
@@ -0,0 +1,326 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arcology-network/common-lib/codec"
+)
+
+// A request is [from u64][limit u32][wait ms u32], and the response is [len u32][status u8][head u64][payload],
+// where len counts the bytes after itself. The payload is the encoded records if the status is STATUS_OK,
+// or the error message otherwise.
+const (
+	REQUEST_SIZE         = 8 + 4 + 4
+	RESPONSE_HEADER_SIZE = 4 + 1 + 8
+	MAX_RESPONSE_SIZE    = 1 << 30
+	MAX_WAIT             = 30 * time.Second // The longest a request can wait for new records.
+	DEFAULT_DIAL_TIMEOUT = 5 * time.Second
+
+	STATUS_OK     = uint8(0)
+	STATUS_ERROR  = uint8(1)
+	STATUS_PRUNED = uint8(2)
+)
+
+// RemoteError is an error returned by the source on the server side.
+type RemoteError struct{ Msg string }
+
+func (this *RemoteError) Error() string { return "replication: remote: " + this.Msg }
+
+// LogServer serves a replication log to the followers on other nodes or processes.
+type LogServer struct {
+	source Source
+
+	lock      sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closed    atomic.Bool
+}
+
+func NewLogServer(source Source) *LogServer {
+	return &LogServer{source: source, conns: map[net.Conn]struct{}{}}
+}
+
+// Listen starts serving on the network address in the background, the network is "tcp" or "unix".
+func (this *LogServer) Listen(network, address string) (net.Addr, error) {
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	go this.Serve(listener)
+	return listener.Addr(), nil
+}
+
+// Serve accepts the connections from the listener until the listener or the server is closed.
+func (this *LogServer) Serve(listener net.Listener) error {
+	this.lock.Lock()
+	if this.closed.Load() {
+		this.lock.Unlock()
+		listener.Close()
+		return ErrClosed
+	}
+	this.listeners = append(this.listeners, listener)
+	this.lock.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if this.closed.Load() {
+				return nil
+			}
+			return err
+		}
+
+		this.lock.Lock()
+		if this.closed.Load() {
+			this.lock.Unlock()
+			conn.Close()
+			return nil
+		}
+		this.conns[conn] = struct{}{}
+		this.wg.Add(1)
+		this.lock.Unlock()
+
+		go this.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes all the connections, the source isn't closed.
+func (this *LogServer) Close() error {
+	this.lock.Lock()
+	this.closed.Store(true)
+	for _, listener := range this.listeners {
+		listener.Close()
+	}
+	for conn := range this.conns {
+		conn.Close()
+	}
+	this.lock.Unlock()
+
+	this.wg.Wait()
+	return nil
+}
+
+func (this *LogServer) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+
+		this.lock.Lock()
+		delete(this.conns, conn)
+		this.lock.Unlock()
+		this.wg.Done()
+	}()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	var request [REQUEST_SIZE]byte
+	for {
+		if _, err := io.ReadFull(reader, request[:]); err != nil {
+			return
+		}
+
+		from := binary.LittleEndian.Uint64(request[:8])
+		limit := int(binary.LittleEndian.Uint32(request[8:12]))
+		wait := min(time.Duration(binary.LittleEndian.Uint32(request[12:16]))*time.Millisecond, MAX_WAIT)
+
+		status, payload := STATUS_OK, []byte{}
+		records, head, err := this.source.Read(from, max(limit, 1), wait)
+		if err != nil {
+			status, payload = STATUS_ERROR, []byte(err.Error())
+			if errors.Is(err, ErrPruned) {
+				status = STATUS_PRUNED
+			}
+		} else {
+			encoded := make([][]byte, len(records))
+			for i, record := range records {
+				encoded[i] = record.Encode()
+			}
+			payload = codec.Byteset(encoded).Encode()
+		}
+
+		if err := writeResponse(writer, status, head, payload); err != nil {
+			return
+		}
+	}
+}
+
+var _ Source = (*LogClient)(nil)
+
+// LogClient reads a replication log from a LogServer. It has one connection, which is redialed
+// on the next read if it fails.
+type LogClient struct {
+	network     string
+	address     string
+	dialTimeout time.Duration
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	closed bool
+}
+
+// DialLog connects to the LogServer at the address.
+func DialLog(network, address string, dialTimeout ...time.Duration) (*LogClient, error) {
+	client := &LogClient{network: network, address: address, dialTimeout: DEFAULT_DIAL_TIMEOUT}
+	if len(dialTimeout) > 0 && dialTimeout[0] > 0 {
+		client.dialTimeout = dialTimeout[0]
+	}
+
+	if err := client.dial(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (this *LogClient) Read(from uint64, limit int, wait time.Duration) ([]*Record, uint64, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.closed {
+		return nil, 0, ErrClosed
+	}
+
+	if this.conn == nil {
+		if err := this.dial(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	wait = min(wait, MAX_WAIT)
+	status, head, payload, err := this.roundTrip(from, limit, wait)
+	if err != nil {
+		this.conn.Close()
+		this.conn = nil
+		return nil, 0, err
+	}
+
+	switch status {
+	case STATUS_OK:
+		records, err := decodeRecords(payload)
+		return records, head, err
+	case STATUS_PRUNED:
+		return nil, head, fmt.Errorf("%w: %w", ErrPruned, &RemoteError{string(payload)})
+	default:
+		return nil, head, &RemoteError{string(payload)}
+	}
+}
+
+func (this *LogClient) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.closed = true
+	if this.conn != nil {
+		err := this.conn.Close()
+		this.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (this *LogClient) dial() error {
+	conn, err := net.DialTimeout(this.network, this.address, this.dialTimeout)
+	if err != nil {
+		return err
+	}
+	this.conn, this.reader, this.writer = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
+	return nil
+}
+
+func (this *LogClient) roundTrip(from uint64, limit int, wait time.Duration) (uint8, uint64, []byte, error) {
+	// Allow the server the whole wait plus the dial timeout to respond.
+	if err := this.conn.SetDeadline(time.Now().Add(wait + this.dialTimeout)); err != nil {
+		return 0, 0, nil, err
+	}
+
+	var request [REQUEST_SIZE]byte
+	binary.LittleEndian.PutUint64(request[:8], from)
+	binary.LittleEndian.PutUint32(request[8:12], uint32(limit))
+	binary.LittleEndian.PutUint32(request[12:16], uint32(wait/time.Millisecond))
+	if _, err := this.writer.Write(request[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if err := this.writer.Flush(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	var header [RESPONSE_HEADER_SIZE]byte
+	if _, err := io.ReadFull(this.reader, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[:4])
+	if length < 1+8 || length > MAX_RESPONSE_SIZE {
+		return 0, 0, nil, ErrCorrupted
+	}
+
+	payload := make([]byte, length-1-8)
+	if _, err := io.ReadFull(this.reader, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[4], binary.LittleEndian.Uint64(header[5:13]), payload, nil
+}
+
+func writeResponse(writer *bufio.Writer, status uint8, head uint64, payload []byte) error {
+	if len(payload) > MAX_RESPONSE_SIZE-RESPONSE_HEADER_SIZE {
+		status, payload = STATUS_ERROR, []byte("response too large, read with a smaller limit")
+	}
+
+	var header [RESPONSE_HEADER_SIZE]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(1+8+len(payload)))
+	header[4] = status
+	binary.LittleEndian.PutUint64(header[5:13], head)
+	if _, err := writer.Write(header[:]); err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(payload); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func decodeRecords(payload []byte) (records []*Record, err error) {
+	defer func() {
+		if r := recover(); r != nil { // A malformed payload may panic the decoder.
+			records, err = nil, fmt.Errorf("%w: %v", ErrCorrupted, r)
+		}
+	}()
+
+	encoded := codec.Byteset{}.Decode(payload).(codec.Byteset)
+	records = make([]*Record, len(encoded))
+	for i := range encoded {
+		records[i] = &Record{}
+		if err := records[i].Decode(encoded[i]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,82 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package sharding
+
+import (
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+// ITERATE_WINDOW is the number of entries Iterate reads ahead from each shard.
+const ITERATE_WINDOW = 256
+
+// Iterate merges the entries of the shards from the key on into the ascending order of the keys, and
+// calls the visitor for them until it returns false. Each shard is read a window at a time, resuming
+// right after the last key of its previous window, so every entry is only read once.
+func Iterate(shards []stgintf.Iterable[string, []byte], from string, visitor func(string, []byte) bool) error {
+	windows := make([]*window, 0, len(shards))
+	for _, shard := range shards {
+		window := &window{shard: shard}
+		if err := window.fill(from); err != nil {
+			return err
+		}
+		windows = append(windows, window)
+	}
+
+	for {
+		next := -1 // The window with the smallest key at its head
+		for i, window := range windows {
+			if window.pos < len(window.keys) && (next < 0 || window.keys[window.pos] < windows[next].keys[windows[next].pos]) {
+				next = i
+			}
+		}
+
+		if next < 0 {
+			return nil
+		}
+
+		window := windows[next]
+		key, value := window.keys[window.pos], window.values[window.pos]
+		if !visitor(key, value) {
+			return nil
+		}
+
+		if window.pos++; window.pos == len(window.keys) && !window.done {
+			if err := window.fill(key + "\x00"); err != nil { // The smallest key after the last one
+				return err
+			}
+		}
+	}
+}
+
+// window holds the entries read ahead from a shard.
+type window struct {
+	shard  stgintf.Iterable[string, []byte]
+	keys   []string
+	values [][]byte
+	pos    int
+	done   bool // The shard has no more entries after the window.
+}
+
+func (this *window) fill(from string) error {
+	this.keys, this.values, this.pos = this.keys[:0], this.values[:0], 0
+	err := this.shard.Iterate(from, func(key string, value []byte) bool {
+		this.keys, this.values = append(this.keys, key), append(this.values, value)
+		return len(this.keys) < ITERATE_WINDOW
+	})
+	this.done = len(this.keys) < ITERATE_WINDOW
+	return err
+}
@@ -0,0 +1,138 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sharding
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/natefinch/atomic"
+)
+
+const MANIFEST_FILE = "MANIFEST.json"
+
+// Manifest is the persisted ring membership of a sharded store. If the store was shut down
+// in the middle of a rebalance, Previous holds the membership before the rebalance started,
+// so the rebalance can be resumed on the next start.
+type Manifest struct {
+	VirtualNodes int   `json:"virtualNodes"`
+	Shards       []int `json:"shards"`
+	Previous     []int `json:"previous,omitempty"`
+	NextID       int   `json:"nextId"`
+
+	path string
+}
+
+// LoadManifest loads the manifest under the root directory, a new manifest with the
+// given initial shards is created and saved if none exists yet.
+func LoadManifest(root string, vnodes int, initialShards int) (*Manifest, error) {
+	path := filepath.Join(root, MANIFEST_FILE)
+	buffer, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		if initialShards < 1 {
+			return nil, fmt.Errorf("%w: %d initial shards", ErrNoShards, initialShards)
+		}
+
+		manifest := &Manifest{VirtualNodes: vnodes, path: path}
+		for i := 0; i < initialShards; i++ {
+			manifest.Shards = append(manifest.Shards, i)
+		}
+		manifest.NextID = initialShards
+		return manifest, manifest.Save()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{path: path}
+	if err := json.Unmarshal(buffer, manifest); err != nil {
+		return nil, err
+	}
+
+	if len(manifest.Shards) == 0 {
+		return nil, fmt.Errorf("%w: none in %s", ErrNoShards, path)
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest to disk atomically.
+func (this *Manifest) Save() error { return this.write(this.path) }
+
+// SaveTo writes a copy of the manifest under another root directory, which is used
+// when checkpointing a sharded store.
+func (this *Manifest) SaveTo(root string) error {
+	return this.write(filepath.Join(root, MANIFEST_FILE))
+}
+
+func (this *Manifest) write(path string) error {
+	buffer, err := json.MarshalIndent(this, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(path, bytes.NewReader(buffer))
+}
+
+// Ring returns the current ring.
+func (this *Manifest) Ring() *Ring { return NewRing(this.VirtualNodes, this.Shards...) }
+
+// PreviousRing returns the ring before the unfinished rebalance, nil if there is none.
+func (this *Manifest) PreviousRing() *Ring {
+	if this.Previous == nil {
+		return nil
+	}
+	return NewRing(this.VirtualNodes, this.Previous...)
+}
+
+// AllShards returns the union of the current and the previous shards.
+func (this *Manifest) AllShards() []int {
+	shards := append([]int{}, this.Shards...)
+	current := this.Ring()
+	for _, id := range this.Previous {
+		if !current.Contains(id) {
+			shards = append(shards, id)
+		}
+	}
+	return shards
+}
+
+// Begin records the start of a rebalance from one ring to another.
+func (this *Manifest) Begin(previous, current *Ring) error {
+	this.Previous = previous.Shards()
+	this.Shards = current.Shards()
+	for _, id := range this.Shards {
+		this.NextID = max(this.NextID, id+1)
+	}
+	return this.Save()
+}
+
+// Complete marks the ongoing rebalance as finished, and returns the shards that have left the ring.
+func (this *Manifest) Complete() ([]int, error) {
+	current := this.Ring()
+	removed := []int{}
+	for _, id := range this.Previous {
+		if !current.Contains(id) {
+			removed = append(removed, id)
+		}
+	}
+	this.Previous = nil
+	return removed, this.Save()
+}
@@ -0,0 +1,167 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sharding
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	DEFAULT_MOVE_BATCH = 1024
+	DEFAULT_SCAN_BATCH = 16 * DEFAULT_MOVE_BATCH // The keys to move collected in a scan of a source shard
+)
+
+// Rebalance moves the keys in the source shards that don't belong to them under the new ring.
+//
+// During a rebalance the stores write new values to the owners under the new ring and delete
+// them from the previous owners, so the values of the keys to move are immutable in the source
+// shards. The keys are moved batch by batch with the topology lock held, which keeps the
+// readers from seeing a key in both shards or in neither of them.
+//
+// A source is read for the next DEFAULT_SCAN_BATCH keys to move in the ascending order, so a bounded
+// number of keys and values is in memory at a time. The sources implementing stgintf.Iterable resume
+// right after the last key moved, the others are scanned again for every DEFAULT_SCAN_BATCH keys.
+func Rebalance(
+	sources []int,
+	shardOf func(int) stgintf.ReadWriteStore[string, []byte],
+	ring *Ring,
+	topology sync.Locker,
+	stop func() bool,
+) error {
+	for _, id := range sources {
+		source := shardOf(id)
+		for cursor, started := "", false; ; started = true {
+			keys, values, err := nextEntries(source, cursor, started, func(key string) bool { return ring.Locate(key) != id })
+			if err != nil {
+				return err
+			}
+
+			if len(keys) == 0 {
+				break
+			}
+
+			for start := 0; start < len(keys); start += DEFAULT_MOVE_BATCH {
+				if stop != nil && stop() {
+					return ErrRebalanceAbort
+				}
+
+				end := min(start+DEFAULT_MOVE_BATCH, len(keys))
+				if err := moveBatch(source, keys[start:end], values[start:end], shardOf, ring, topology); err != nil {
+					return err
+				}
+			}
+			cursor = keys[len(keys)-1]
+		}
+	}
+	return nil
+}
+
+// nextEntries returns the first DEFAULT_SCAN_BATCH entries of the source after the cursor accepted by the
+// filter, in the ascending order of the keys.
+func nextEntries(source stgintf.ReadWriteStore[string, []byte], cursor string, started bool, accept func(string) bool) ([]string, [][]byte, error) {
+	if iterable, ok := source.(stgintf.Iterable[string, []byte]); ok {
+		if started {
+			cursor += "\x00" // The smallest key after the cursor
+		}
+
+		keys, values := []string{}, [][]byte{}
+		err := iterable.Iterate(cursor, func(key string, value []byte) bool {
+			if accept(key) {
+				keys, values = append(keys, key), append(values, value)
+			}
+			return len(keys) < DEFAULT_SCAN_BATCH
+		})
+		return keys, values, err
+	}
+	return scanEntries(source, func(key string) bool { return (!started || key > cursor) && accept(key) })
+}
+
+// scanEntries returns the first DEFAULT_SCAN_BATCH entries of the source accepted by the filter, in the
+// ascending order of the keys. The query itself keeps nothing, the entries are collected in a window
+// which is sorted and cut whenever it doubles.
+func scanEntries(source stgintf.ReadWriteStore[string, []byte], accept func(string) bool) ([]string, [][]byte, error) {
+	type entry struct {
+		key   string
+		value []byte
+	}
+
+	entries := []entry{}
+	cut := func() {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		entries = entries[:min(len(entries), DEFAULT_SCAN_BATCH)]
+	}
+
+	limit, bounded := "", false
+	_, _, errs := source.Query("", func(key string, value []byte) bool {
+		if (bounded && key >= limit) || !accept(key) {
+			return false
+		}
+
+		if entries = append(entries, entry{key, bytes.Clone(value)}); len(entries) == 2*DEFAULT_SCAN_BATCH {
+			cut()
+			limit, bounded = entries[len(entries)-1].key, true
+		}
+		return false
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+
+	cut()
+	keys, values := make([]string, len(entries)), make([][]byte, len(entries))
+	for i, entry := range entries {
+		keys[i], values[i] = entry.key, entry.value
+	}
+	return keys, values, nil
+}
+
+func moveBatch(
+	source stgintf.ReadWriteStore[string, []byte],
+	keys []string,
+	values [][]byte,
+	shardOf func(int) stgintf.ReadWriteStore[string, []byte],
+	ring *Ring,
+	topology sync.Locker,
+) error {
+	topology.Lock()
+	defer topology.Unlock()
+
+	for i, key := range keys {
+		if !source.Has(key) { // Deleted or overwritten since the scan.
+			continue
+		}
+
+		// The target has a newer value if the key was written after the rebalance started.
+		if target := shardOf(ring.Locate(key)); !target.Has(key) {
+			if err := target.Set(key, values[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := source.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package sharding provides the consistent hashing ring used by the sharded stores
+// to add and remove shards without moving the keys that stay with their owners.
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cespare/xxhash"
+)
+
+const DEFAULT_VIRTUAL_NODES = 128
+
+var (
+	ErrNoRing         = errors.New("sharding: the store isn't using a consistent hashing ring")
+	ErrRebalancing    = errors.New("sharding: a rebalance is already in progress")
+	ErrUnknownShard   = errors.New("sharding: unknown shard")
+	ErrLastShard      = errors.New("sharding: can't remove the last shard")
+	ErrRebalanceAbort = errors.New("sharding: rebalance aborted")
+	ErrNoShards       = errors.New("sharding: at least one shard is needed")
+)
+
+// Ring is an immutable consistent hashing ring. Each shard is mapped to a number of
+// virtual nodes on the ring, and a key belongs to the first virtual node clockwise from its hash.
+type Ring struct {
+	vnodes int
+	shards []int
+	points []uint64
+	owners []int
+}
+
+func NewRing(vnodes int, shards ...int) *Ring {
+	if vnodes <= 0 {
+		vnodes = DEFAULT_VIRTUAL_NODES
+	}
+
+	ring := &Ring{
+		vnodes: vnodes,
+		shards: append([]int{}, shards...),
+	}
+	sort.Ints(ring.shards)
+
+	type point struct {
+		hash  uint64
+		owner int
+	}
+
+	points := make([]point, 0, len(ring.shards)*vnodes)
+	for _, shard := range ring.shards {
+		for i := 0; i < vnodes; i++ {
+			points = append(points, point{xxhash.Sum64String(fmt.Sprintf("shard-%d#%d", shard, i)), shard})
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+
+	ring.points = make([]uint64, len(points))
+	ring.owners = make([]int, len(points))
+	for i, p := range points {
+		ring.points[i], ring.owners[i] = p.hash, p.owner
+	}
+	return ring
+}
+
+// Locate returns the shard the key belongs to, or -1 if the ring is empty.
+func (this *Ring) Locate(key string) int {
+	if len(this.points) == 0 {
+		return -1
+	}
+
+	hash := xxhash.Sum64String(key)
+	idx := sort.Search(len(this.points), func(i int) bool { return this.points[i] >= hash })
+	if idx == len(this.points) {
+		idx = 0 // Wrap around
+	}
+	return this.owners[idx]
+}
+
+func (this *Ring) VirtualNodes() int { return this.vnodes }
+func (this *Ring) Shards() []int     { return append([]int{}, this.shards...) }
+
+func (this *Ring) Contains(shard int) bool {
+	idx := sort.SearchInts(this.shards, shard)
+	return idx < len(this.shards) && this.shards[idx] == shard
+}
+
+// With returns a new ring with the shard added.
+func (this *Ring) With(shard int) *Ring {
+	if this.Contains(shard) {
+		return this
+	}
+	return NewRing(this.vnodes, append(this.Shards(), shard)...)
+}
+
+// Without returns a new ring with the shard removed.
+func (this *Ring) Without(shard int) *Ring {
+	shards := make([]int, 0, len(this.shards))
+	for _, id := range this.shards {
+		if id != shard {
+			shards = append(shards, id)
+		}
+	}
+	return NewRing(this.vnodes, shards...)
+}
@@ -0,0 +1,227 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sharding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	memdb "github.com/arcology-network/common-lib/storage/memdb"
+)
+
+func TestRingMinimalMovement(t *testing.T) {
+	ring := NewRing(64, 0, 1, 2, 3)
+	if shard := NewRing(64).Locate("key"); shard != -1 {
+		t.Error("an empty ring shouldn't locate any key")
+	}
+
+	counts := map[int]int{}
+	for i := 0; i < 10000; i++ {
+		counts[ring.Locate(fmt.Sprint(i))]++
+	}
+	for shard, count := range counts {
+		if count < 1000 {
+			t.Errorf("shard %d only has %d keys", shard, count)
+		}
+	}
+
+	grown := ring.With(4)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint(i)
+		if before, after := ring.Locate(key), grown.Locate(key); before != after && after != 4 {
+			t.Fatalf("key %s moved from %d to %d instead of the new shard", key, before, after)
+		}
+	}
+
+	shrunk := ring.Without(2)
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint(i)
+		if before, after := ring.Locate(key), shrunk.Locate(key); before != after && before != 2 {
+			t.Fatalf("key %s moved from %d to %d but its shard wasn't removed", key, before, after)
+		}
+	}
+}
+
+func TestManifestPersistence(t *testing.T) {
+	root := t.TempDir()
+	manifest, err := LoadManifest(root, 32, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.Begin(manifest.Ring(), manifest.Ring().With(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadManifest(root, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reloaded.Shards, []int{0, 1, 2, 3}) || !reflect.DeepEqual(reloaded.Previous, []int{0, 1, 2}) || reloaded.NextID != 4 {
+		t.Fatalf("unexpected manifest %+v", reloaded)
+	}
+
+	if removed, err := reloaded.Complete(); err != nil || len(removed) != 0 || reloaded.PreviousRing() != nil {
+		t.Fatal("Complete failed", removed, err)
+	}
+
+	if _, err := LoadManifest(t.TempDir(), 32, 0); !errors.Is(err, ErrNoShards) {
+		t.Fatalf("expected ErrNoShards, got %v", err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	shards := map[int]stgintf.ReadWriteStore[string, []byte]{
+		0: memdb.NewMemoryDB(), 1: memdb.NewMemoryDB(), 2: memdb.NewMemoryDB(),
+	}
+	shardOf := func(id int) stgintf.ReadWriteStore[string, []byte] { return shards[id] }
+
+	// More keys to move than a scan collects, so the sources are scanned in several windows.
+	previous := NewRing(16, 0, 1)
+	for i := 0; i < 8*DEFAULT_SCAN_BATCH; i++ {
+		key := fmt.Sprint(i)
+		shards[previous.Locate(key)].Set(key, []byte(key))
+	}
+
+	current := previous.With(2)
+	if err := Rebalance([]int{0, 1}, shardOf, current, &sync.Mutex{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8*DEFAULT_SCAN_BATCH; i++ {
+		key := fmt.Sprint(i)
+		for id, shard := range shards {
+			if shard.Has(key) != (id == current.Locate(key)) {
+				t.Fatalf("key %s is in the wrong shard", key)
+			}
+		}
+	}
+}
+
+func TestRouterKeepsThePreviousCopyOnFailure(t *testing.T) {
+	previous, current := NewRing(16, 0), NewRing(16, 0, 1)
+	moving := []string{}
+	for i := 0; len(moving) < 4; i++ {
+		if key := fmt.Sprint(i); current.Locate(key) == 1 {
+			moving = append(moving, key)
+		}
+	}
+
+	old := memdb.NewMemoryDB()
+	for _, key := range moving {
+		old.Set(key, []byte("old"))
+	}
+
+	// The new owner fails the writes of the first key.
+	target := faulty.NewStore(memdb.NewMemoryDB(), faulty.Config{Rules: []faulty.Rule{{
+		Keys:        regexp.MustCompile("^" + moving[0] + "$"),
+		Probability: 1,
+	}}})
+
+	shards := map[int]stgintf.ReadWriteStore[string, []byte]{0: old, 1: target}
+	locks := map[int]*sync.RWMutex{0: {}, 1: {}}
+	router := Router{
+		Shard:  func(id int) stgintf.ReadWriteStore[string, []byte] { return shards[id] },
+		Lock:   func(id int) *sync.RWMutex { return locks[id] },
+		Locate: current.Locate,
+		Previous: func(key string) (int, bool) {
+			id := previous.Locate(key)
+			return id, id != current.Locate(key)
+		},
+	}
+
+	values := [][]byte{[]byte("new"), []byte("new"), []byte("new"), []byte("new")}
+	errs := router.SetBatch(moving, values)
+	if !errors.Is(errs[0], faulty.ErrInjected) || errs[1] != nil {
+		t.Fatalf("expected only the first write to fail, got %v", errs)
+	}
+
+	if !old.Has(moving[0]) || old.Has(moving[1]) {
+		t.Fatal("expected only the copies of the keys written to be dropped")
+	}
+
+	errs = router.DeleteBatch(moving)
+	if !errors.Is(errs[0], faulty.ErrInjected) || !old.Has(moving[0]) || target.Has(moving[2]) {
+		t.Fatalf("expected only the failed delete to keep its copy, got %v", errs)
+	}
+}
+
+// sortedShard is an Iterable over a sorted list of keys, counting the entries it visits.
+type sortedShard struct {
+	keys    []string
+	visited int
+}
+
+func (this *sortedShard) Iterate(from string, visitor func(string, []byte) bool) error {
+	for _, key := range this.keys {
+		if key < from {
+			continue
+		}
+
+		this.visited++
+		if !visitor(key, []byte(key)) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestIterateMerge(t *testing.T) {
+	shards := []*sortedShard{{}, {}, {}}
+	expected := []string{}
+	for i := 0; i < 3*ITERATE_WINDOW+10; i++ {
+		key := fmt.Sprintf("%05d", i)
+		shards[i*i%3].keys = append(shards[i*i%3].keys, key)
+		expected = append(expected, key)
+	}
+
+	iterables := []stgintf.Iterable[string, []byte]{shards[0], shards[1], shards[2]}
+	visited := []string{}
+	if err := Iterate(iterables, "", func(key string, value []byte) bool {
+		visited = append(visited, key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(visited, expected) {
+		t.Fatalf("the entries aren't merged in order: %v", visited)
+	}
+
+	for i, shard := range shards { // Each window resumes after the previous one instead of starting over.
+		if shard.visited != len(shard.keys) {
+			t.Errorf("shard %d: visited %d entries for %d keys", i, shard.visited, len(shard.keys))
+		}
+	}
+
+	visited = visited[:0]
+	Iterate(iterables, expected[100], func(key string, value []byte) bool {
+		visited = append(visited, key)
+		return len(visited) < 5
+	})
+	if !reflect.DeepEqual(visited, expected[100:105]) {
+		t.Fatalf("expected %v, got %v", expected[100:105], visited)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package sharding
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/arcology-network/common-lib/common"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+// Router is the write path shared by the sharded stores. The keys are written to their current owners,
+// a batch per shard. During a rebalance the stale copies are then deleted from the previous owners,
+// only for the keys written successfully, so a failed write never deletes the only good copy of a key.
+type Router struct {
+	Shard    func(id int) stgintf.ReadWriteStore[string, []byte]
+	Lock     func(id int) *sync.RWMutex
+	Locate   func(key string) int         // The current owner of the key
+	Previous func(key string) (int, bool) // The owner of the key before the ongoing rebalance, if another one
+}
+
+// SetBatch writes the keys, and returns an error for each key, nil if written.
+func (this Router) SetBatch(keys []string, values [][]byte) []error {
+	return this.write(keys, values, false)
+}
+
+// DeleteBatch deletes the keys, and returns an error for each key, nil if deleted.
+func (this Router) DeleteBatch(keys []string) []error {
+	return this.write(keys, nil, true)
+}
+
+func (this Router) write(keys []string, values [][]byte, deleting bool) []error {
+	errs := make([]error, len(keys))
+	this.apply(this.group(keys, errs, this.Locate), keys, values, deleting, errs)
+
+	if this.Previous != nil {
+		previous := func(key string) int {
+			if id, ok := this.Previous(key); ok {
+				return id
+			}
+			return -1
+		}
+		this.apply(this.group(keys, errs, previous), keys, nil, true, errs)
+	}
+	return errs
+}
+
+// group returns the indices of the keys with no error yet by the shards the keys are located in,
+// leaving out the keys located nowhere.
+func (this Router) group(keys []string, errs []error, locate func(string) int) map[int][]int {
+	groups := map[int][]int{}
+	for i, key := range keys {
+		if errs[i] != nil {
+			continue
+		}
+
+		if id := locate(key); id >= 0 {
+			groups[id] = append(groups[id], i)
+		}
+	}
+	return groups
+}
+
+// apply writes or deletes the groups of keys in parallel, a batch per shard under the shard lock.
+func (this Router) apply(groups map[int][]int, keys []string, values [][]byte, deleting bool, errs []error) {
+	ids := make([]int, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	worker := func(start, end, index int, args ...any) {
+		for _, id := range ids[start:end] {
+			indices := groups[id]
+			shardKeys := make([]string, len(indices))
+			for j, i := range indices {
+				shardKeys[j] = keys[i]
+			}
+
+			var shardErrs []error
+			this.Lock(id).Lock()
+			if deleting {
+				shardErrs = this.Shard(id).DeleteBatch(shardKeys)
+			} else {
+				shardValues := make([][]byte, len(indices))
+				for j, i := range indices {
+					shardValues[j] = values[i]
+				}
+				shardErrs = this.Shard(id).SetBatch(shardKeys, shardValues)
+			}
+			this.Lock(id).Unlock()
+
+			for j, err := range shardErrs { // Some stores return nil if all the keys succeed.
+				errs[indices[j]] = err
+			}
+		}
+	}
+	common.ParallelWorker(len(ids), max(len(ids), 1), worker)
+}
@@ -0,0 +1,512 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package snapshot implements a backend independent snapshot stream for the key-value stores.
+// Any ReadWriteStore[string, []byte] can be exported to the stream and imported back into
+// another store, which makes it possible to migrate data between different backends.
+//
+// Stream Layout:
+// +----------+----------+-----+----------+------------+
+// |  Header  | Chunk[0] | ... | Chunk[n] |  Manifest  |
+// +----------+----------+-----+----------+------------+
+//
+// Every section is written as a frame:
+// +-------------+--------------+-----------+---------------------+
+// | Type (u8)   | Length (u64) | Payload   | SHA256(Payload) [32] |
+// +-------------+--------------+-----------+---------------------+
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	MAGIC              = "ARCSNAP"
+	VERSION            = uint32(1)
+	DEFAULT_CHUNK_SIZE = 4096 // The default number of entries in a chunk.
+	EXPORT_WINDOW      = 16   // The number of chunks Export collects in a scan of the store.
+
+	FRAME_HEADER   = uint8(1)
+	FRAME_CHUNK    = uint8(2)
+	FRAME_MANIFEST = uint8(3)
+
+	frameHeaderLen = 1 + 8
+)
+
+var (
+	ErrBadMagic         = errors.New("snapshot: not a snapshot stream")
+	ErrBadVersion       = errors.New("snapshot: unsupported stream version")
+	ErrBadChecksum      = errors.New("snapshot: checksum mismatch")
+	ErrUnexpectedFrame  = errors.New("snapshot: unexpected frame")
+	ErrManifestMismatch = errors.New("snapshot: manifest doesn't match the chunks")
+	ErrMalformed        = errors.New("snapshot: malformed frame")
+)
+
+// Header is the first frame of a snapshot stream.
+type Header struct {
+	Version   uint32
+	ChunkSize uint64
+}
+
+func (this *Header) Encode() []byte {
+	return codec.Byteset{
+		[]byte(MAGIC),
+		codec.Uint32(this.Version).Encode(),
+		codec.Uint64(this.ChunkSize).Encode(),
+	}.Encode()
+}
+
+func (this *Header) Decode(buffer []byte) (*Header, error) {
+	fields, err := decodeFields(buffer)
+	if err != nil || len(fields) != 3 || string(fields[0]) != MAGIC {
+		return nil, ErrBadMagic
+	}
+
+	if len(fields[1]) != int(codec.Uint32(0).Size()) || len(fields[2]) != codec.UINT64_LEN {
+		return nil, fmt.Errorf("%w: header", ErrMalformed)
+	}
+
+	this.Version = uint32(codec.Uint32(0).Decode(fields[1]).(codec.Uint32))
+	this.ChunkSize = uint64(codec.Uint64(0).Decode(fields[2]).(codec.Uint64))
+	if this.Version != VERSION {
+		return nil, ErrBadVersion
+	}
+	return this, nil
+}
+
+// ChunkInfo describes a chunk in the manifest. Keys are exported in ascending order,
+// so the First and Last keys of the chunks form a key-range index of the stream.
+type ChunkInfo struct {
+	Index    uint64
+	Count    uint64
+	First    string
+	Last     string
+	Checksum [32]byte
+}
+
+func (this *ChunkInfo) Encode() []byte {
+	return codec.Byteset{
+		codec.Uint64(this.Index).Encode(),
+		codec.Uint64(this.Count).Encode(),
+		codec.String(this.First).Encode(),
+		codec.String(this.Last).Encode(),
+		this.Checksum[:],
+	}.Encode()
+}
+
+func (this *ChunkInfo) Decode(buffer []byte) (*ChunkInfo, error) {
+	fields, err := decodeFields(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) != 5 || len(fields[0]) != codec.UINT64_LEN || len(fields[1]) != codec.UINT64_LEN || len(fields[4]) != len(this.Checksum) {
+		return nil, fmt.Errorf("%w: chunk info", ErrMalformed)
+	}
+
+	this.Index = uint64(codec.Uint64(0).Decode(fields[0]).(codec.Uint64))
+	this.Count = uint64(codec.Uint64(0).Decode(fields[1]).(codec.Uint64))
+	this.First = string(fields[2])
+	this.Last = string(fields[3])
+	copy(this.Checksum[:], fields[4])
+	return this, nil
+}
+
+// Manifest is the last frame of a snapshot stream.
+type Manifest struct {
+	Total  uint64
+	Chunks []*ChunkInfo
+}
+
+func (this *Manifest) Encode() []byte {
+	fields := make([][]byte, 0, len(this.Chunks)+1)
+	fields = append(fields, codec.Uint64(this.Total).Encode())
+	for _, chunk := range this.Chunks {
+		fields = append(fields, chunk.Encode())
+	}
+	return codec.Byteset(fields).Encode()
+}
+
+func (this *Manifest) Decode(buffer []byte) (*Manifest, error) {
+	fields, err := decodeFields(buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) == 0 || len(fields[0]) != codec.UINT64_LEN {
+		return nil, fmt.Errorf("%w: manifest", ErrMalformed)
+	}
+
+	this.Total = uint64(codec.Uint64(0).Decode(fields[0]).(codec.Uint64))
+	this.Chunks = make([]*ChunkInfo, len(fields)-1)
+	for i := 1; i < len(fields); i++ {
+		if this.Chunks[i-1], err = (&ChunkInfo{}).Decode(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	return this, nil
+}
+
+// Locate returns the chunks whose key ranges may contain the given key.
+func (this *Manifest) Locate(key string) []*ChunkInfo {
+	chunks := []*ChunkInfo{}
+	for _, chunk := range this.Chunks {
+		if chunk.First <= key && key <= chunk.Last {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks
+}
+
+// Export writes all the entries in the store to the writer as a snapshot stream, in the ascending
+// order of the keys. The stores implementing stgintf.Iterable are walked once from the first key,
+// a chunk of entries in memory at a time. The others are scanned through their Query method with an
+// empty prefix, because some of the stores only do exact matches without a predicate. Each scan
+// collects the next EXPORT_WINDOW chunks of keys after the last one exported, so only a window of the
+// entries is in memory at a time, at the cost of a scan per window. The entries written to the store
+// during the export may or may not be exported, export a snapshot of the store for a consistent view.
+func Export(store stgintf.ReadWriteStore[string, []byte], writer io.Writer, chunkSize ...int) (*Manifest, error) {
+	stream, err := newExporter(writer, chunkSize...)
+	if err != nil {
+		return nil, err
+	}
+
+	if iterable, ok := store.(stgintf.Iterable[string, []byte]); ok {
+		return stream.iterate(iterable)
+	}
+
+	window := stream.size * EXPORT_WINDOW
+	cursor, started := "", false
+	for {
+		entries := make([]entry, 0, window)
+		limit, bounded := "", false // Once the window is full, only the keys below its last one can get in.
+		_, _, errs := store.Query("", func(key string, value []byte) bool {
+			if (started && key <= cursor) || (bounded && key >= limit) {
+				return false
+			}
+
+			entries = append(entries, entry{key, bytes.Clone(value)})
+			if len(entries) == 2*window {
+				entries = trim(entries, window)
+				limit, bounded = entries[len(entries)-1].key, true
+			}
+			return false // Kept in the window rather than in the result of the query
+		})
+
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+
+		entries = trim(entries, window)
+		if len(entries) == 0 {
+			return stream.finish()
+		}
+
+		for start := 0; start < len(entries); start += stream.size {
+			if err := stream.writeChunk(entries[start:min(start+stream.size, len(entries))]); err != nil {
+				return nil, err
+			}
+		}
+		cursor, started = entries[len(entries)-1].key, true
+	}
+}
+
+// iterate writes the entries of the store chunk by chunk as they are visited.
+func (this *exporter) iterate(store stgintf.Iterable[string, []byte]) (*Manifest, error) {
+	var err error
+	entries := make([]entry, 0, this.size)
+	iterErr := store.Iterate("", func(key string, value []byte) bool {
+		if entries = append(entries, entry{key, value}); len(entries) == this.size {
+			err, entries = this.writeChunk(entries), entries[:0]
+		}
+		return err == nil
+	})
+
+	if err = errors.Join(iterErr, err); err != nil {
+		return nil, err
+	}
+
+	if len(entries) > 0 {
+		if err := this.writeChunk(entries); err != nil {
+			return nil, err
+		}
+	}
+	return this.finish()
+}
+
+// ExportKVs writes the key-value pairs to the writer as a snapshot stream.
+func ExportKVs(keys []string, values [][]byte, writer io.Writer, chunkSize ...int) (*Manifest, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("snapshot: %d keys but %d values", len(keys), len(values))
+	}
+
+	stream, err := newExporter(writer, chunkSize...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort the entries by key so that each chunk covers a continuous key range.
+	entries := make([]entry, len(keys))
+	for i := range keys {
+		entries[i] = entry{keys[i], values[i]}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	for start := 0; start < len(entries); start += stream.size {
+		if err := stream.writeChunk(entries[start:min(start+stream.size, len(entries))]); err != nil {
+			return nil, err
+		}
+	}
+	return stream.finish()
+}
+
+type entry struct {
+	key   string
+	value []byte
+}
+
+// trim sorts the entries and keeps the first n of them.
+func trim(entries []entry, n int) []entry {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries[:min(n, len(entries))]
+}
+
+// exporter writes the frames of a stream, and builds the manifest as the chunks are written.
+type exporter struct {
+	writer   *bufio.Writer
+	size     int
+	manifest *Manifest
+}
+
+func newExporter(writer io.Writer, chunkSize ...int) (*exporter, error) {
+	size := DEFAULT_CHUNK_SIZE
+	if len(chunkSize) > 0 && chunkSize[0] > 0 {
+		size = chunkSize[0]
+	}
+
+	stream := &exporter{writer: bufio.NewWriter(writer), size: size, manifest: &Manifest{}}
+	header := &Header{Version: VERSION, ChunkSize: uint64(size)}
+	if _, err := writeFrame(stream.writer, FRAME_HEADER, header.Encode()); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (this *exporter) writeChunk(entries []entry) error {
+	keys, values := make([]string, len(entries)), make([][]byte, len(entries))
+	for i, entry := range entries {
+		keys[i], values[i] = entry.key, entry.value
+	}
+
+	payload := codec.Byteset{codec.Strings(keys).Encode(), codec.Byteset(values).Encode()}.Encode()
+	checksum, err := writeFrame(this.writer, FRAME_CHUNK, payload)
+	if err != nil {
+		return err
+	}
+
+	this.manifest.Total += uint64(len(keys))
+	this.manifest.Chunks = append(this.manifest.Chunks, &ChunkInfo{
+		Index:    uint64(len(this.manifest.Chunks)),
+		Count:    uint64(len(keys)),
+		First:    keys[0],
+		Last:     keys[len(keys)-1],
+		Checksum: checksum,
+	})
+	return nil
+}
+
+func (this *exporter) finish() (*Manifest, error) {
+	if _, err := writeFrame(this.writer, FRAME_MANIFEST, this.manifest.Encode()); err != nil {
+		return nil, err
+	}
+	return this.manifest, this.writer.Flush()
+}
+
+// Import reads a snapshot stream and writes all the entries into the store, chunk by chunk.
+// It returns the manifest of the stream once every chunk has been verified and written. The chunks
+// are written as they arrive, so a failed import leaves the chunks before the failure in the store,
+// which has to be discarded then.
+func Import(reader io.Reader, store stgintf.ReadWriteStore[string, []byte]) (*Manifest, error) {
+	return Scan(reader, func(_ *ChunkInfo, keys []string, values [][]byte) error {
+		return errors.Join(store.SetBatch(keys, values)...)
+	})
+}
+
+// Scan reads a snapshot stream and calls the visitor for every verified chunk in the stream.
+func Scan(reader io.Reader, visitor func(*ChunkInfo, []string, [][]byte) error) (*Manifest, error) {
+	buffered := bufio.NewReader(reader)
+	frameType, payload, _, err := readFrame(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	if frameType != FRAME_HEADER {
+		return nil, ErrUnexpectedFrame
+	}
+
+	if _, err := (&Header{}).Decode(payload); err != nil {
+		return nil, err
+	}
+
+	received := []*ChunkInfo{}
+	for {
+		frameType, payload, checksum, err := readFrame(buffered)
+		if err != nil {
+			return nil, err
+		}
+
+		switch frameType {
+		case FRAME_CHUNK:
+			fields, err := decodeFields(payload)
+			if err != nil || len(fields) != 2 {
+				return nil, fmt.Errorf("%w: chunk %d", ErrMalformed, len(received))
+			}
+
+			keyFields, err := decodeFields(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%w: chunk %d", ErrMalformed, len(received))
+			}
+
+			values, err := decodeFields(fields[1])
+			if err != nil || len(keyFields) != len(values) {
+				return nil, fmt.Errorf("%w: chunk %d", ErrMalformed, len(received))
+			}
+
+			keys := make([]string, len(keyFields))
+			for i, key := range keyFields {
+				keys[i] = string(key)
+			}
+
+			info := &ChunkInfo{Index: uint64(len(received)), Count: uint64(len(keys)), Checksum: checksum}
+			if len(keys) > 0 {
+				info.First, info.Last = keys[0], keys[len(keys)-1]
+			}
+
+			if err := visitor(info, keys, values); err != nil {
+				return nil, err
+			}
+			received = append(received, info)
+
+		case FRAME_MANIFEST:
+			manifest, err := (&Manifest{}).Decode(payload)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(manifest.Chunks) != len(received) {
+				return nil, ErrManifestMismatch
+			}
+
+			total := uint64(0)
+			for i, chunk := range manifest.Chunks {
+				if chunk.Checksum != received[i].Checksum || chunk.Count != received[i].Count {
+					return nil, ErrManifestMismatch
+				}
+				total += chunk.Count
+			}
+
+			if total != manifest.Total {
+				return nil, ErrManifestMismatch
+			}
+			return manifest, nil
+
+		default:
+			return nil, ErrUnexpectedFrame
+		}
+	}
+}
+
+// decodeFields splits a codec.Byteset encoding, like codec.Byteset.Decode does, after checking the
+// header against the buffer, so a malformed frame is rejected instead of panicking.
+func decodeFields(buffer []byte) ([][]byte, error) {
+	if len(buffer) == 0 {
+		return [][]byte{}, nil
+	}
+
+	if len(buffer) < codec.UINT64_LEN {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMalformed, len(buffer))
+	}
+
+	count := binary.LittleEndian.Uint64(buffer)
+	if count == 0 || count > uint64(len(buffer))/codec.UINT64_LEN-1 {
+		return nil, fmt.Errorf("%w: %d fields in %d bytes", ErrMalformed, count, len(buffer))
+	}
+
+	body := uint64(len(buffer)) - (count+1)*codec.UINT64_LEN
+	prev := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		offset := binary.LittleEndian.Uint64(buffer[(i+1)*codec.UINT64_LEN:])
+		if offset < prev || offset > body || (i == 0 && offset != 0) {
+			return nil, fmt.Errorf("%w: field %d at %d of %d bytes", ErrMalformed, i, offset, body)
+		}
+		prev = offset
+	}
+	return codec.Byteset{}.Decode(buffer).(codec.Byteset), nil
+}
+
+func writeFrame(writer io.Writer, frameType uint8, payload []byte) ([32]byte, error) {
+	checksum := sha256.Sum256(payload)
+
+	var header [frameHeaderLen]byte
+	header[0] = frameType
+	binary.LittleEndian.PutUint64(header[1:], uint64(len(payload)))
+
+	for _, buffer := range [][]byte{header[:], payload, checksum[:]} {
+		if _, err := writer.Write(buffer); err != nil {
+			return checksum, err
+		}
+	}
+	return checksum, nil
+}
+
+func readFrame(reader io.Reader) (uint8, []byte, [32]byte, error) {
+	var checksum [32]byte
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, checksum, err
+	}
+
+	if header[0] < FRAME_HEADER || header[0] > FRAME_MANIFEST {
+		return 0, nil, checksum, ErrUnexpectedFrame
+	}
+
+	// Copy the payload progressively instead of trusting the length field for the allocation.
+	length := int64(binary.LittleEndian.Uint64(header[1:]))
+	payload := bytes.NewBuffer(nil)
+	if n, err := io.CopyN(payload, reader, length); err != nil || n != length {
+		return 0, nil, checksum, io.ErrUnexpectedEOF
+	}
+
+	if _, err := io.ReadFull(reader, checksum[:]); err != nil {
+		return 0, nil, checksum, err
+	}
+
+	if actual := sha256.Sum256(payload.Bytes()); !bytes.Equal(actual[:], checksum[:]) {
+		return 0, nil, checksum, ErrBadChecksum
+	}
+	return header[0], payload.Bytes(), checksum, nil
+}
@@ -0,0 +1,266 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	badgerdb "github.com/arcology-network/common-lib/storage/badger"
+	filedb "github.com/arcology-network/common-lib/storage/filedb"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	memdb "github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+
+	"github.com/arcology-network/common-lib/codec"
+)
+
+func populate(t *testing.T, store stgintf.ReadWriteStore[string, []byte], n int) ([]string, [][]byte) {
+	t.Helper()
+	keys := make([]string, n)
+	values := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		keys[i] = fmt.Sprintf("key-%05d", i)
+		values[i] = []byte(fmt.Sprintf("value-%d", i))
+	}
+
+	for i, err := range store.SetBatch(keys, values) {
+		if err != nil {
+			t.Fatalf("SetBatch[%d]: %v", i, err)
+		}
+	}
+	return keys, values
+}
+
+func verify(t *testing.T, store stgintf.ReadWriteStore[string, []byte], keys []string, values [][]byte) {
+	t.Helper()
+	for i, key := range keys {
+		v, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", key, err)
+		}
+		if !bytes.Equal(v.([]byte), values[i]) {
+			t.Fatalf("Get(%s): expected %s, got %s", key, values[i], v)
+		}
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	source := memdb.NewMemoryDB()
+	keys, values := populate(t, source, 1000)
+
+	buffer := bytes.NewBuffer(nil)
+	exported, err := Export(source, buffer, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.Total != 1000 || len(exported.Chunks) != 16 {
+		t.Fatalf("unexpected manifest: total %d, chunks %d", exported.Total, len(exported.Chunks))
+	}
+
+	if chunks := exported.Locate("key-00070"); len(chunks) != 1 || chunks[0].Index != 1 {
+		t.Error("Locate failed")
+	}
+
+	target := memdb.NewMemoryDB()
+	imported, err := Import(bytes.NewReader(buffer.Bytes()), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imported.Total != exported.Total || len(imported.Chunks) != len(exported.Chunks) {
+		t.Error("manifest mismatch")
+	}
+	verify(t, target, keys, values)
+}
+
+func TestSnapshotExportWindows(t *testing.T) {
+	source := memdb.NewMemoryDB()
+	keys, values := populate(t, source, 1000)
+
+	// 7 entries a chunk and 112 a window, so the store is scanned 10 times and the windows must line up.
+	buffer := bytes.NewBuffer(nil)
+	exported, err := Export(source, buffer, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.Total != 1000 || len(exported.Chunks) != 143 {
+		t.Fatalf("unexpected manifest: total %d, chunks %d", exported.Total, len(exported.Chunks))
+	}
+
+	for i, chunk := range exported.Chunks[1:] {
+		if chunk.First <= exported.Chunks[i].Last {
+			t.Fatalf("chunk %d starts at %s before the end of the previous one", i+1, chunk.First)
+		}
+	}
+
+	target := memdb.NewMemoryDB()
+	if _, err := Import(buffer, target); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, target, keys, values)
+}
+
+// unqueryable fails every scan, so the stores wrapped in it can only be exported by iterating them.
+type unqueryable struct {
+	*pebbledb.ParaPebbleDB
+}
+
+func (unqueryable) Query(string, func(string, []byte) bool) ([]string, [][]byte, []error) {
+	return nil, nil, []error{fmt.Errorf("scanned")}
+}
+
+func TestSnapshotExportIterable(t *testing.T) {
+	db, err := pebbledb.NewParaPebbleDB(filepath.Join(t.TempDir(), "para-pebble"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	keys, values := populate(t, db, 1000)
+
+	// The entries of all the shards are merged into a single ascending stream.
+	buffer := bytes.NewBuffer(nil)
+	exported, err := Export(unqueryable{db}, buffer, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exported.Total != 1000 || len(exported.Chunks) != 143 {
+		t.Fatalf("unexpected manifest: total %d, chunks %d", exported.Total, len(exported.Chunks))
+	}
+
+	for i, chunk := range exported.Chunks[1:] {
+		if chunk.First <= exported.Chunks[i].Last {
+			t.Fatalf("chunk %d starts at %s before the end of the previous one", i+1, chunk.First)
+		}
+	}
+
+	target := memdb.NewMemoryDB()
+	if _, err := Import(buffer, target); err != nil {
+		t.Fatal(err)
+	}
+	verify(t, target, keys, values)
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	source := memdb.NewMemoryDB()
+	populate(t, source, 100)
+
+	buffer := bytes.NewBuffer(nil)
+	if _, err := Export(source, buffer, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := bytes.Clone(buffer.Bytes())
+	corrupted[len(corrupted)/2] ^= 0xff
+	if _, err := Import(bytes.NewReader(corrupted), memdb.NewMemoryDB()); err == nil {
+		t.Error("should have detected the corruption")
+	}
+
+	truncated := buffer.Bytes()[:buffer.Len()-10]
+	if _, err := Import(bytes.NewReader(truncated), memdb.NewMemoryDB()); err == nil {
+		t.Error("should have detected the truncation")
+	}
+
+	if _, err := Import(bytes.NewReader([]byte("not a snapshot")), memdb.NewMemoryDB()); err == nil {
+		t.Error("should have rejected the stream")
+	}
+}
+
+func TestSnapshotAcrossBackends(t *testing.T) {
+	root := t.TempDir()
+
+	pebble, err := pebbledb.NewPebbleDB(filepath.Join(root, "pebble"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pebble.Close()
+
+	paraPebble, err := pebbledb.NewParaPebbleDB(filepath.Join(root, "para-pebble"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer paraPebble.Close()
+
+	badger := badgerdb.NewBadgerDB(filepath.Join(root, "badger"))
+	defer badger.Close()
+
+	paraBadger := badgerdb.NewParaBadgerDB(filepath.Join(root, "para-badger"), nil)
+	defer paraBadger.Close()
+
+	fileDB, err := filedb.NewFileDB(filepath.Join(root, "filedb"), 8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := []stgintf.ReadWriteStore[string, []byte]{
+		memdb.NewMemoryDB(), pebble, paraPebble, badger, paraBadger, fileDB,
+	}
+
+	keys, values := populate(t, stores[0], 300)
+	for i := 1; i < len(stores); i++ {
+		buffer := bytes.NewBuffer(nil)
+		if _, err := Export(stores[i-1], buffer, 50); err != nil {
+			t.Fatalf("export from store %d: %v", i-1, err)
+		}
+
+		manifest, err := Import(buffer, stores[i])
+		if err != nil {
+			t.Fatalf("import into store %d: %v", i, err)
+		}
+
+		if manifest.Total != uint64(len(keys)) {
+			t.Fatalf("store %d: expected %d entries, got %d", i, len(keys), manifest.Total)
+		}
+		verify(t, stores[i], keys, values)
+	}
+}
+
+func TestSnapshotMalformedFrames(t *testing.T) {
+	info := (&ChunkInfo{Index: 1, Count: 2, First: "a", Last: "b"}).Encode()
+	if _, err := (&ChunkInfo{}).Decode(info); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := (&Manifest{Total: 2, Chunks: []*ChunkInfo{{Count: 2, First: "a", Last: "b"}}}).Encode()
+	if decoded, err := (&Manifest{}).Decode(manifest); err != nil || decoded.Total != 2 || len(decoded.Chunks) != 1 {
+		t.Fatalf("failed to decode the manifest, %v", err)
+	}
+
+	malformed := [][]byte{
+		{1, 2, 3},
+		codec.Byteset{[]byte("too"), []byte("few")}.Encode(),
+		append(codec.Uint64(1000).Encode(), make([]byte, 16)...), // Too many fields
+		info[:len(info)-5],
+		manifest[:len(manifest)-40],
+	}
+
+	for i, buffer := range malformed {
+		if _, err := (&ChunkInfo{}).Decode(buffer); err == nil {
+			t.Errorf("case %d: expected the chunk info to be rejected", i)
+		}
+
+		if _, err := (&Manifest{}).Decode(buffer); err == nil {
+			t.Errorf("case %d: expected the manifest to be rejected", i)
+		}
+	}
+}
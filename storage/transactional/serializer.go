@@ -0,0 +1,94 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package transactional
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Serializer converts the objects added to the transactions to bytes and back. The recover functions
+// get the bytes, and normally decode them with the Serializer() of the DB. The name is saved in the
+// journal, so a transaction isn't recovered by a DB reopened with a different serializer.
+type Serializer interface {
+	Name() string
+	Marshal(obj interface{}) ([]byte, error)
+	Unmarshal(data []byte, target interface{}) error // The target is a pointer.
+}
+
+type GobSerializer struct{}
+
+func (GobSerializer) Name() string { return "gob" }
+
+func (GobSerializer) Marshal(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, target interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+}
+
+type JSONSerializer struct{}
+
+func (JSONSerializer) Name() string                            { return "json" }
+func (JSONSerializer) Marshal(obj interface{}) ([]byte, error) { return json.Marshal(obj) }
+func (JSONSerializer) Unmarshal(data []byte, target interface{}) error {
+	return json.Unmarshal(data, target)
+}
+
+// CodecSerializer works with the types of the codec package, and any other types encoding and
+// decoding themselves the same way.
+type CodecSerializer struct{}
+
+func (CodecSerializer) Name() string { return "codec" }
+
+func (CodecSerializer) Marshal(obj interface{}) ([]byte, error) {
+	encoder, ok := obj.(interface{ Encode() []byte })
+	if !ok {
+		return nil, fmt.Errorf("transactional: %T doesn't implement Encode() []byte", obj)
+	}
+	return encoder.Encode(), nil
+}
+
+// Unmarshal decodes into a pointer to a value whose Decode([]byte) returns the decoded value,
+// like the types of the codec package.
+func (CodecSerializer) Unmarshal(data []byte, target interface{}) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("transactional: the target %T isn't a pointer", target)
+	}
+
+	decoder, ok := ptr.Elem().Interface().(interface{ Decode([]byte) interface{} })
+	if !ok {
+		return fmt.Errorf("transactional: %T doesn't implement Decode([]byte) interface{}", ptr.Elem().Interface())
+	}
+
+	decoded := reflect.ValueOf(decoder.Decode(data))
+	if !decoded.Type().AssignableTo(ptr.Elem().Type()) {
+		return fmt.Errorf("transactional: can't assign %s to %s", decoded.Type(), ptr.Elem().Type())
+	}
+	ptr.Elem().Set(decoded)
+	return nil
+}
@@ -51,3 +51,29 @@ func (db *SimpleFileDB) Get(key string) ([]byte, error) {
 func (db *SimpleFileDB) Delete(key string) error {
 	return os.Remove(db.root + key)
 }
+
+// Keys returns the keys of all the files in the DB.
+func (db *SimpleFileDB) Keys() ([]string, error) {
+	entries, err := os.ReadDir(db.root)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
+
+// Sync flushes the directory, so the files created, renamed or deleted survive a crash.
+func (db *SimpleFileDB) Sync() error {
+	dir, err := os.Open(db.root)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
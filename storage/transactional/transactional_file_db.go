@@ -19,147 +19,410 @@ package transactional
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+
+	"github.com/arcology-network/common-lib/codec"
+)
+
+const (
+	JOURNAL_SUFFIX = ".tx"   // The journal of a transaction is saved as <id>.tx.
+	ITEM_SUFFIX    = ".item" // The items are saved as <id>-<index>.item.
+)
+
+var (
+	ErrTxExists           = errors.New("transactional: the transaction already exists")
+	ErrTxEnded            = errors.New("transactional: the transaction has ended")
+	ErrUnknownRecoverFunc = errors.New("transactional: recover function not found")
+	ErrSerializerMismatch = errors.New("transactional: the transaction was written with another serializer")
+	ErrCorruptedJournal   = errors.New("transactional: corrupted journal")
 )
 
+// RecoverFunc applies an item of a transaction. It gets the object itself if the transaction is still
+// in memory, or the bytes marshaled by the serializer of the DB if the transaction is recovered from
+// the files.
 type RecoverFunc func(obj interface{}, bs []byte) error
 
+// Registry maps the names saved in the journals to the recover functions. The functions missing
+// from a registry are looked up in its parent.
+type Registry struct {
+	lock   sync.RWMutex
+	funcs  map[string]RecoverFunc
+	parent *Registry
+}
+
+// NewRegistry creates a registry falling back to DefaultRegistry, where the functions registered
+// with the package level RegisterRecoverFunc are.
+func NewRegistry() *Registry {
+	return &Registry{funcs: make(map[string]RecoverFunc), parent: DefaultRegistry}
+}
+
+func (r *Registry) Register(name string, rf RecoverFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.funcs[name] = rf
+}
+
+func (r *Registry) Get(name string) (RecoverFunc, bool) {
+	r.lock.RLock()
+	rf, ok := r.funcs[name]
+	r.lock.RUnlock()
+
+	if !ok && r.parent != nil {
+		return r.parent.Get(name)
+	}
+	return rf, ok
+}
+
+// RecoverFuncRegistry holds the functions of DefaultRegistry.
+//
+// Deprecated: Register the functions with TransactionalFileDB.RegisterRecoverFunc instead.
 var RecoverFuncRegistry = make(map[string]RecoverFunc)
 
+// DefaultRegistry is the registry shared by the whole process, which all the other registries fall back to.
+var DefaultRegistry = &Registry{funcs: RecoverFuncRegistry}
+
+// RegisterRecoverFunc registers a recover function in DefaultRegistry.
+//
+// Deprecated: Use TransactionalFileDB.RegisterRecoverFunc, which doesn't affect the other DBs.
 func RegisterRecoverFunc(name string, rf RecoverFunc) {
-	RecoverFuncRegistry[name] = rf
+	DefaultRegistry.Register(name, rf)
 }
 
+// NewTransaction begins a transaction saved in the DB, with the items serialized with gob and
+// applied with the functions of DefaultRegistry.
+//
+// Deprecated: Use TransactionalFileDB.BeginTransaction instead.
+func NewTransaction(id string, db *SimpleFileDB) (*Transaction, error) {
+	tfdb := &TransactionalFileDB{root: db.root, db: db, registry: DefaultRegistry, serializer: GobSerializer{}}
+	return tfdb.BeginTransaction(id)
+}
+
+// TransactionalFileDB journals the items of the transactions to files, so a transaction ended but
+// not applied completely before a crash can be applied again with the recover functions. Both the
+// items and the journal are fsynced, and the journal is only written after all the items are in.
 type TransactionalFileDB struct {
-	root string
-	db   *SimpleFileDB
+	root       string
+	db         *SimpleFileDB
+	registry   *Registry
+	serializer Serializer
+}
+
+// NewTransactionalFileDB creates a DB in the root directory, which should end with a separator. The
+// items are serialized with gob unless another serializer is given.
+func NewTransactionalFileDB(root string, serializer ...Serializer) *TransactionalFileDB {
+	return newTransactionalFileDB(root, NewRegistry(), serializer...)
 }
 
-func NewTransactionalFileDB(root string) *TransactionalFileDB {
-	return &TransactionalFileDB{
-		root: root,
-		db:   NewSimpleFileDB(root),
+func newTransactionalFileDB(root string, registry *Registry, serializer ...Serializer) *TransactionalFileDB {
+	tfdb := &TransactionalFileDB{
+		root:       root,
+		db:         NewSimpleFileDB(root),
+		registry:   registry,
+		serializer: GobSerializer{},
+	}
+
+	if len(serializer) > 0 && serializer[0] != nil {
+		tfdb.serializer = serializer[0]
 	}
+	return tfdb
 }
 
+// RegisterRecoverFunc registers a recover function of the DB under the name used with Transaction.Add.
+func (tfdb *TransactionalFileDB) RegisterRecoverFunc(name string, rf RecoverFunc) {
+	tfdb.registry.Register(name, rf)
+}
+
+func (tfdb *TransactionalFileDB) Registry() *Registry    { return tfdb.registry }
+func (tfdb *TransactionalFileDB) Serializer() Serializer { return tfdb.serializer }
+
 func (tfdb *TransactionalFileDB) BeginTransaction(id string) (*Transaction, error) {
-	return NewTransaction(id, tfdb.db)
+	if tfdb.exists(id+JOURNAL_SUFFIX) || tfdb.exists(id) {
+		return nil, fmt.Errorf("%w: %v", ErrTxExists, id)
+	}
+	return tfdb.newTransaction(id), nil
 }
 
+// Recover applies the transaction again from its journal, and removes its files afterwards. It
+// does nothing if the transaction doesn't exist, or has never ended.
 func (tfdb *TransactionalFileDB) Recover(id string) error {
-	fmt.Printf("[TransactionalFileDB.Recover] id = %s\n", id)
-	bs, err := tfdb.db.Get(id)
+	tx, err := tfdb.load(id)
+	if err != nil || tx == nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// Pending returns the IDs of the transactions ended but not cleared yet, in the order of the IDs.
+// These are the transactions to recover after a restart.
+func (tfdb *TransactionalFileDB) Pending() ([]string, error) {
+	keys, err := tfdb.db.Keys()
 	if err != nil {
-		fmt.Printf("[TransactionalFileDB.Recover] transaction not found, err = %v\n", err)
-		return nil
+		return nil, err
 	}
 
-	var rfs map[string]string
-	err = gob.NewDecoder(bytes.NewBuffer(bs)).Decode(&rfs)
+	ids := make([]string, 0)
+	for _, key := range keys {
+		if strings.HasSuffix(key, JOURNAL_SUFFIX) {
+			ids = append(ids, strings.TrimSuffix(key, JOURNAL_SUFFIX))
+		} else if tfdb.isLegacyJournal(key) {
+			ids = append(ids, key)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// RecoverAll recovers all the pending transactions in the order of their IDs. The transactions
+// failing to recover are kept for the next attempt.
+func (tfdb *TransactionalFileDB) RecoverAll() error {
+	ids, err := tfdb.Pending()
 	if err != nil {
-		fmt.Printf("[TransactionalFileDB.Recover] Decode transaction file err: %v\n", err)
 		return err
 	}
-	tx := Transaction{
-		id:  id,
-		db:  tfdb.db,
-		rfs: rfs,
+
+	var errs []error
+	for _, id := range ids {
+		if err := tfdb.Recover(id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
 	}
-	return tx.commit()
+	return errors.Join(errs...)
 }
 
-type Transaction struct {
-	id   string
-	db   *SimpleFileDB
-	rfs  map[string]string
-	buf  map[string]interface{}
-	wg   sync.WaitGroup
-	lock sync.Mutex
+func (tfdb *TransactionalFileDB) newTransaction(id string) *Transaction {
+	return &Transaction{
+		id:         id,
+		db:         tfdb.db,
+		registry:   tfdb.registry,
+		serializer: tfdb.serializer,
+		buf:        make(map[string]interface{}),
+	}
 }
 
-func NewTransaction(id string, db *SimpleFileDB) (*Transaction, error) {
-	if _, err := db.Get(id); err != nil {
-		return &Transaction{
-			id:  id,
-			db:  db,
-			rfs: make(map[string]string),
-			buf: make(map[string]interface{}),
-		}, nil
+// load reads the journal of the transaction, or returns nil if there is none. The journals written
+// before the items were ordered are gob encoded maps from the item keys to the recover functions,
+// saved under the plain IDs.
+func (tfdb *TransactionalFileDB) load(id string) (*Transaction, error) {
+	tx := tfdb.newTransaction(id)
+	if bs, err := tfdb.db.Get(id + JOURNAL_SUFFIX); err == nil {
+		return tx, tx.decodeJournal(bs)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	bs, err := tfdb.db.Get(id)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Transaction already exists: %v", id)
+
+	var rfs map[string]string
+	if err := gob.NewDecoder(bytes.NewBuffer(bs)).Decode(&rfs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedJournal, err)
+	}
+
+	if tfdb.serializer.Name() != (GobSerializer{}).Name() {
+		return nil, fmt.Errorf("%w: gob", ErrSerializerMismatch)
+	}
+
+	tx.legacy = true
+	for key, rf := range rfs {
+		tx.entries = append(tx.entries, entry{key: key, rf: rf})
+	}
+	return tx, nil
 }
 
+func (tfdb *TransactionalFileDB) exists(key string) bool {
+	_, err := os.Stat(tfdb.root + key)
+	return err == nil
+}
+
+// isLegacyJournal tells if the file is a journal in the legacy format. The items of the legacy
+// transactions are named by their sha256 hashes.
+func (tfdb *TransactionalFileDB) isLegacyJournal(key string) bool {
+	if strings.Contains(key, ".") || len(key) == 64 && strings.Trim(key, "0123456789abcdef") == "" {
+		return false
+	}
+
+	bs, err := tfdb.db.Get(key)
+	if err != nil {
+		return false
+	}
+
+	var rfs map[string]string
+	return gob.NewDecoder(bytes.NewBuffer(bs)).Decode(&rfs) == nil
+}
+
+type entry struct {
+	key string
+	rf  string
+}
+
+type Transaction struct {
+	id         string
+	db         *SimpleFileDB
+	registry   *Registry
+	serializer Serializer
+	legacy     bool                   // Recovered from a legacy journal
+	entries    []entry                // In the order of Add
+	errs       []error                // The errors of the entries
+	buf        map[string]interface{} // The objects added, by their keys
+	ended      bool
+	wg         sync.WaitGroup
+	lock       sync.Mutex
+}
+
+// Add writes the object to a file in the background. The errors are returned by End.
 func (t *Transaction) Add(obj interface{}, rf string) error {
-	if _, ok := RecoverFuncRegistry[rf]; !ok {
-		return fmt.Errorf("Recover function not found: %v", rf)
+	if _, ok := t.registry.Get(rf); !ok {
+		return fmt.Errorf("%w: %v", ErrUnknownRecoverFunc, rf)
 	}
 
+	t.lock.Lock()
+	if t.ended {
+		t.lock.Unlock()
+		return ErrTxEnded
+	}
+	idx := len(t.entries)
+	key := fmt.Sprintf("%s-%d%s", t.id, idx, ITEM_SUFFIX)
+	t.entries = append(t.entries, entry{key: key, rf: rf})
+	t.errs = append(t.errs, nil)
+	t.buf[key] = obj
 	t.wg.Add(1)
+	t.lock.Unlock()
 
 	go func() {
-		var buf bytes.Buffer
-		err := gob.NewEncoder(&buf).Encode(obj)
-		if err != nil {
-			return
+		defer t.wg.Done()
+
+		value, err := t.serializer.Marshal(obj)
+		if err == nil {
+			err = t.db.Set(key, value)
 		}
 
-		value := buf.Bytes()
-		key := fmt.Sprintf("%x", sha256.Sum256(value))
-		err = t.db.Set(key, buf.Bytes())
 		if err != nil {
-			return
+			t.lock.Lock()
+			t.errs[idx] = fmt.Errorf("item %d (%s): %w", idx, rf, err)
+			t.lock.Unlock()
 		}
-
-		t.lock.Lock()
-		t.rfs[key] = rf
-		t.buf[key] = obj
-		t.lock.Unlock()
-
-		t.wg.Done()
 	}()
 	return nil
 }
 
+// End waits for all the items to be written, and then writes the journal, after which the transaction
+// can be recovered. If some items failed, their errors are returned, and the journal isn't written.
 func (t *Transaction) End() error {
+	t.lock.Lock()
+	t.ended = true
+	t.lock.Unlock()
 	t.wg.Wait()
 
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(t.rfs)
-	if err != nil {
+	if err := errors.Join(t.errs...); err != nil {
 		return err
 	}
 
-	return t.db.Set(t.id, buf.Bytes())
+	if err := t.db.Sync(); err != nil { // The items must be in before the journal.
+		return err
+	}
+
+	if err := t.db.Set(t.id+JOURNAL_SUFFIX, t.encodeJournal()); err != nil {
+		return err
+	}
+	return t.db.Sync()
+}
+
+// Errs returns the errors of the items in the order they were added, nil for the items written
+// successfully. It should only be called after End.
+func (t *Transaction) Errs() []error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]error{}, t.errs...)
 }
 
 func (t *Transaction) commit() error {
-	fmt.Printf("[Transaction.commit] rfs = %v\n", t.rfs)
-	for key, rf := range t.rfs {
-		if len(t.buf) != 0 {
-			RecoverFuncRegistry[rf](t.buf[key], nil)
-			continue
+	for _, entry := range t.entries {
+		rf, ok := t.registry.Get(entry.rf)
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrUnknownRecoverFunc, entry.rf)
 		}
 
-		if bs, err := t.db.Get(key); err != nil {
-			fmt.Printf("[Transaction.commit] transaction file not found: %v, err: %v\n", key, err)
-			return err
-		} else {
-			if err = RecoverFuncRegistry[rf](nil, bs); err != nil {
+		if obj, ok := t.buf[entry.key]; ok {
+			if err := rf(obj, nil); err != nil {
 				return err
 			}
+			continue
 		}
-	}
 
+		bs, err := t.db.Get(entry.key)
+		if err != nil {
+			return err
+		}
+
+		if err = rf(nil, bs); err != nil {
+			return err
+		}
+	}
 	return t.Clear()
 }
 
+// Clear removes the files of the transaction. The journal goes first, so a transaction is never
+// left with a journal referring to the missing items.
 func (t *Transaction) Clear() error {
-	t.db.Delete(t.id)
-	for key := range t.rfs {
-		t.db.Delete(key)
+	journal := t.id + JOURNAL_SUFFIX
+	if t.legacy {
+		journal = t.id
+	}
+
+	if err := t.db.Delete(journal); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := t.db.Sync(); err != nil {
+		return err
+	}
+
+	for _, entry := range t.entries {
+		if err := t.db.Delete(entry.key); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Transaction) encodeJournal() []byte {
+	keys, rfs := make([]string, len(t.entries)), make([]string, len(t.entries))
+	for i, entry := range t.entries {
+		keys[i], rfs[i] = entry.key, entry.rf
+	}
+	return codec.Byteset{[]byte(t.serializer.Name()), codec.Strings(keys).Encode(), codec.Strings(rfs).Encode()}.Encode()
+}
+
+func (t *Transaction) decodeJournal(bs []byte) error {
+	fields := codec.Byteset{}.Decode(bs).(codec.Byteset)
+	if len(fields) != 3 {
+		return ErrCorruptedJournal
+	}
+
+	if name := string(fields[0]); name != t.serializer.Name() {
+		return fmt.Errorf("%w: %s", ErrSerializerMismatch, name)
+	}
+
+	keys := codec.Strings{}.Decode(fields[1]).(codec.Strings)
+	rfs := codec.Strings{}.Decode(fields[2]).(codec.Strings)
+	if len(keys) != len(rfs) {
+		return ErrCorruptedJournal
+	}
+
+	for i := range keys {
+		t.entries = append(t.entries, entry{key: keys[i], rf: rfs[i]})
 	}
 	return nil
 }
@@ -20,13 +20,20 @@ package transactional
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/arcology-network/common-lib/codec"
 )
 
 func TestTransactionalFileDB(t *testing.T) {
-	RegisterRecoverFunc("rf1", func(obj interface{}, bs []byte) error {
+	begin := time.Now()
+	tfdb := NewTransactionalFileDB("./tfdb/")
+	tfdb.RegisterRecoverFunc("rf1", func(obj interface{}, bs []byte) error {
 		var str string
 		if obj != nil {
 			str = obj.(string)
@@ -40,7 +47,7 @@ func TestTransactionalFileDB(t *testing.T) {
 		fmt.Printf("apply data: %v\n", str)
 		return nil
 	})
-	RegisterRecoverFunc("rf2", func(obj interface{}, bs []byte) error {
+	tfdb.RegisterRecoverFunc("rf2", func(obj interface{}, bs []byte) error {
 		var array []byte
 		if obj != nil {
 			array = obj.([]byte)
@@ -54,7 +61,7 @@ func TestTransactionalFileDB(t *testing.T) {
 		fmt.Printf("apply data: %v\n", array)
 		return nil
 	})
-	RegisterRecoverFunc("rf3", func(obj interface{}, bs []byte) error {
+	tfdb.RegisterRecoverFunc("rf3", func(obj interface{}, bs []byte) error {
 		var array []byte
 		if obj != nil {
 			array = obj.([]byte)
@@ -69,8 +76,6 @@ func TestTransactionalFileDB(t *testing.T) {
 		return nil
 	})
 
-	begin := time.Now()
-	tfdb := NewTransactionalFileDB("./tfdb/")
 	tx, err := tfdb.BeginTransaction("1")
 	if err != nil {
 		t.Error(err)
@@ -99,3 +104,159 @@ func TestTransactionalFileDB(t *testing.T) {
 	}
 	t.Logf("elapsed time: %v\n", time.Since(begin))
 }
+
+func TestTransactionalFileDBItemErrors(t *testing.T) {
+	tfdb := NewTransactionalFileDB(t.TempDir() + "/")
+	tfdb.RegisterRecoverFunc("rf", func(obj interface{}, bs []byte) error { return nil })
+
+	tx, _ := tfdb.BeginTransaction("1")
+	if err := tx.Add("ok", "rf"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Add(make(chan int), "rf"); err != nil { // Gob can't encode channels.
+		t.Fatal(err)
+	}
+	if err := tx.Add("ok", "missing"); !errors.Is(err, ErrUnknownRecoverFunc) {
+		t.Fatal("expected ErrUnknownRecoverFunc, got", err)
+	}
+
+	if err := tx.End(); err == nil { // Used to deadlock
+		t.Fatal("expected the failed item to be reported")
+	}
+	if errs := tx.Errs(); len(errs) != 2 || errs[0] != nil || errs[1] == nil {
+		t.Fatal("unexpected item errors", errs)
+	}
+
+	if pending, _ := tfdb.Pending(); len(pending) != 0 {
+		t.Fatal("a failed transaction shouldn't be journaled", pending)
+	}
+
+	if err := tx.Add("late", "rf"); !errors.Is(err, ErrTxEnded) {
+		t.Fatal("expected ErrTxEnded, got", err)
+	}
+
+	// The registries are per DB.
+	if err := NewTransactionalFileDB(t.TempDir()+"/").newTransaction("1").Add("x", "rf"); !errors.Is(err, ErrUnknownRecoverFunc) {
+		t.Fatal("expected ErrUnknownRecoverFunc, got", err)
+	}
+}
+
+func TestTransactionalFileDBRecoverAll(t *testing.T) {
+	for _, serializer := range []Serializer{GobSerializer{}, JSONSerializer{}, CodecSerializer{}} {
+		t.Run(serializer.Name(), func(t *testing.T) {
+			root := t.TempDir() + "/"
+			var applied []string
+			tfdb := NewTransactionalFileDB(root, serializer)
+			tfdb.RegisterRecoverFunc("apply", func(obj interface{}, bs []byte) error {
+				var str codec.String
+				if err := tfdb.Serializer().Unmarshal(bs, &str); err != nil {
+					return err
+				}
+				applied = append(applied, string(str))
+				return nil
+			})
+
+			for _, id := range []string{"2", "1"} {
+				tx, err := tfdb.BeginTransaction(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tx.Add(codec.String(id+"a"), "apply")
+				tx.Add(codec.String(id+"b"), "apply")
+				if err := tx.End(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := tfdb.BeginTransaction("1"); !errors.Is(err, ErrTxExists) {
+				t.Fatal("expected ErrTxExists, got", err)
+			}
+
+			// Restarted
+			restarted := NewTransactionalFileDB(root, serializer)
+			restarted.registry = tfdb.registry
+			if pending, err := restarted.Pending(); err != nil || !reflect.DeepEqual(pending, []string{"1", "2"}) {
+				t.Fatal("unexpected pending transactions", pending, err)
+			}
+
+			if err := restarted.RecoverAll(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(applied, []string{"1a", "1b", "2a", "2b"}) {
+				t.Fatal("unexpected items applied", applied)
+			}
+
+			if files, _ := filepath.Glob(root + "*"); len(files) != 0 {
+				t.Fatal("the transactions aren't cleared", files)
+			}
+		})
+	}
+}
+
+func TestTransactionalFileDBLegacyJournal(t *testing.T) {
+	root := t.TempDir() + "/"
+	db := NewSimpleFileDB(root)
+
+	// A journal written by the earlier versions, with the items named by their hashes.
+	var item, journal bytes.Buffer
+	gob.NewEncoder(&item).Encode("legacy")
+	key := fmt.Sprintf("%064x", 1)
+	db.Set(key, item.Bytes())
+	gob.NewEncoder(&journal).Encode(map[string]string{key: "rf"})
+	db.Set("7", journal.Bytes())
+
+	tfdb := NewTransactionalFileDB(root)
+	var recovered string
+	tfdb.RegisterRecoverFunc("rf", func(obj interface{}, bs []byte) error {
+		return gob.NewDecoder(bytes.NewBuffer(bs)).Decode(&recovered)
+	})
+
+	if pending, _ := tfdb.Pending(); !reflect.DeepEqual(pending, []string{"7"}) {
+		t.Fatal("unexpected pending transactions", pending)
+	}
+
+	if err := tfdb.RecoverAll(); err != nil || recovered != "legacy" {
+		t.Fatal("the legacy transaction isn't recovered", recovered, err)
+	}
+
+	if pending, _ := tfdb.Pending(); len(pending) != 0 {
+		t.Fatal("the legacy transaction isn't cleared", pending)
+	}
+}
+
+func TestTransactionalFileDBDefaultRegistry(t *testing.T) {
+	root := t.TempDir() + "/"
+	applied := []string{}
+	RegisterRecoverFunc("legacy", func(obj interface{}, bs []byte) error {
+		var str string
+		if err := gob.NewDecoder(bytes.NewBuffer(bs)).Decode(&str); err != nil {
+			return err
+		}
+		applied = append(applied, str)
+		return nil
+	})
+	defer delete(RecoverFuncRegistry, "legacy")
+
+	tx, err := NewTransaction("1", NewSimpleFileDB(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Add("item", "legacy"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTransaction("1", NewSimpleFileDB(root)); !errors.Is(err, ErrTxExists) {
+		t.Fatal("expected ErrTxExists, got", err)
+	}
+
+	// The DBs with their own registries still find the functions registered in the default one.
+	if err := NewTransactionalFileDB(root).Recover("1"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"item"}) {
+		t.Fatal("unexpected items applied", applied)
+	}
+}
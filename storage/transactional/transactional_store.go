@@ -19,7 +19,6 @@ package transactional
 
 import (
 	"context"
-	"fmt"
 )
 
 type AddDataRequest struct {
@@ -29,20 +28,29 @@ type AddDataRequest struct {
 
 type TransactionalStore struct {
 	tfdb         *TransactionalFileDB
+	registry     *Registry
 	current      *Transaction
 	previous     *Transaction
 	optimization bool
 }
 
 func NewTransactionalStore() *TransactionalStore {
-	return &TransactionalStore{}
+	return &TransactionalStore{registry: NewRegistry()}
 }
 
+// Config sets up the store with the "root" directory and the "optimization" flag. The optional
+// "serializer" is a Serializer, gob by default.
 func (ts *TransactionalStore) Config(params map[string]interface{}) {
-	ts.tfdb = NewTransactionalFileDB(params["root"].(string))
+	serializer, _ := params["serializer"].(Serializer)
+	ts.tfdb = newTransactionalFileDB(params["root"].(string), ts.registry, serializer)
 	ts.optimization = params["optimization"].(bool)
 }
 
+// RegisterRecoverFunc registers a recover function of the store, which can be done before Config.
+func (ts *TransactionalStore) RegisterRecoverFunc(name string, rf RecoverFunc) {
+	ts.registry.Register(name, rf)
+}
+
 func (ts *TransactionalStore) BeginTransaction(ctx context.Context, id *string, _ *int) (err error) {
 	if ts.current != nil {
 		panic("BeginTransaction called in another transaction.")
@@ -77,7 +85,11 @@ func (ts *TransactionalStore) EndTransaction(ctx context.Context, _ *int, _ *int
 	return err
 }
 
+// RecoverAll recovers all the transactions left by the last run, it should be called at startup.
+func (ts *TransactionalStore) RecoverAll(ctx context.Context, _ *int, _ *int) error {
+	return ts.tfdb.RecoverAll()
+}
+
 func (ts *TransactionalStore) Recover(ctx context.Context, id *string, _ *int) error {
-	fmt.Printf("[TransactionalStore.Recover] id = %s\n", *id)
 	return ts.tfdb.Recover(*id)
 }
@@ -0,0 +1,430 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package twophase
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	DECISION_COMMIT = uint8(1) // All the participants are prepared, they must commit.
+	DECISION_DONE   = uint8(2) // All the participants have committed.
+
+	DECISION_SIZE = 8 + 1 + 4 // [height u64][decision u8][crc32 u32]
+)
+
+// Coordinator runs the two phase commits over a fixed set of participants, and keeps the commit
+// decisions in a log file that is fsynced before any participant is told to commit.
+type Coordinator struct {
+	path         string
+	names        []string
+	participants []Participant
+
+	lock       sync.Mutex
+	file       decisionLog
+	last       uint64          // The last height decided to commit.
+	incomplete map[uint64]bool // The heights committed but not applied by all the participants yet.
+	broken     error           // Why the coordinator can no longer decide, nil if it can.
+}
+
+// decisionLog is the file the decisions are appended to, which is an *os.File except in the tests.
+type decisionLog interface {
+	io.ReadWriteCloser
+	Stat() (os.FileInfo, error)
+	Sync() error
+	Truncate(size int64) error
+	Seek(offset int64, whence int) (int64, error)
+}
+
+// NewCoordinator opens the decision log at the path, creating it if it doesn't exist. The participants
+// should be added with Register, and Recover should be called before the first commit.
+func NewCoordinator(path string) (*Coordinator, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	coordinator := &Coordinator{path: path, file: file, incomplete: map[uint64]bool{}}
+	if err := coordinator.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return coordinator, nil
+}
+
+// Register adds a participant under a unique name, the names are only used in the errors.
+func (this *Coordinator) Register(name string, participant Participant) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for _, existing := range this.names {
+		if existing == name {
+			return fmt.Errorf("%w: %s", ErrDuplicate, name)
+		}
+	}
+	this.names = append(this.names, name)
+	this.participants = append(this.participants, participant)
+	return nil
+}
+
+// Height returns the last height decided to commit.
+func (this *Coordinator) Height() uint64 {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.last
+}
+
+// Commit writes the batches to the participants atomically as the transaction of the height, the
+// batches are keyed by the participant names. Every participant takes part in the transaction,
+// even without a batch, so they all move to the height together.
+//
+// If a participant fails to prepare, the transaction is aborted and the error is returned. Once
+// decided, the transaction is committed even if some participants fail to apply it, in which case
+// ErrIncomplete is returned, and the commit is retried by the next Commit or by Recover.
+// If the decision fails to be written and can't be rolled back either, whether it is durable is
+// unknown, so nothing is aborted and ErrUndecided is returned. The coordinator has to be reopened
+// and recovered then, to follow whatever the log holds.
+func (this *Coordinator) Commit(height uint64, batches map[string]*Batch) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.broken != nil {
+		return this.broken
+	}
+
+	if height <= this.last {
+		return fmt.Errorf("%w: %d after %d", ErrHeightOrder, height, this.last)
+	}
+
+	for name := range batches {
+		if this.indexOf(name) < 0 {
+			return fmt.Errorf("twophase: unknown participant %s", name)
+		}
+	}
+
+	if err := this.finishIncomplete(); err != nil { // The batches must be applied in height order.
+		return err
+	}
+
+	// Phase one
+	errs := this.forEach(func(i int, participant Participant) error {
+		batch := batches[this.names[i]]
+		if batch == nil {
+			batch = NewBatch()
+		}
+		return participant.Prepare(height, batch)
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return errors.Join(err, this.abort(height))
+	}
+
+	if err := this.appendDecision(height, DECISION_COMMIT); err != nil {
+		if errors.Is(err, ErrUndecided) { // Aborting could contradict the decision after a restart.
+			return err
+		}
+		return errors.Join(err, this.abort(height))
+	}
+	this.last = height
+
+	// Phase two, the transaction is committed from now on.
+	return this.finish(height)
+}
+
+// Recover resolves the transactions left in doubt by a crash. The prepared transactions with a commit
+// decision are committed, and the others are aborted. It fails with ErrHeightMismatch if the
+// participants still aren't at the same height afterwards. The decision log is compacted at the end.
+func (this *Coordinator) Recover() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.broken != nil {
+		return this.broken
+	}
+
+	for i, participant := range this.participants {
+		prepared, err := participant.Prepared()
+		if err != nil {
+			return fmt.Errorf("%s: %w", this.names[i], err)
+		}
+
+		for _, height := range prepared {
+			if this.incomplete[height] {
+				continue // Committed below
+			}
+
+			if err := participant.Abort(height); err != nil {
+				return fmt.Errorf("%s: %w", this.names[i], err)
+			}
+		}
+	}
+
+	if err := this.finishIncomplete(); err != nil {
+		return err
+	}
+
+	heights := make([]uint64, len(this.participants))
+	for i, participant := range this.participants {
+		height, err := participant.Height()
+		if err != nil {
+			return fmt.Errorf("%s: %w", this.names[i], err)
+		}
+		heights[i] = height
+	}
+
+	for i := range heights {
+		if heights[i] != heights[0] {
+			return fmt.Errorf("%w: %s at %d and %s at %d", ErrHeightMismatch, this.names[0], heights[0], this.names[i], heights[i])
+		}
+	}
+	return this.compact()
+}
+
+func (this *Coordinator) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.file.Close()
+}
+
+// finish tells all the participants to commit the decided height.
+func (this *Coordinator) finish(height uint64) error {
+	this.incomplete[height] = true
+	errs := this.forEach(func(_ int, participant Participant) error {
+		return participant.Commit(height)
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w: %d: %w", ErrIncomplete, height, err)
+	}
+
+	// Losing the done record is harmless, since committing again is a no-op, so it isn't fsynced.
+	if err := this.appendDecision(height, DECISION_DONE); err != nil {
+		return err
+	}
+	delete(this.incomplete, height)
+	return nil
+}
+
+// finishIncomplete retries the heights decided but not applied by all the participants, in height order.
+func (this *Coordinator) finishIncomplete() error {
+	heights := make([]uint64, 0, len(this.incomplete))
+	for height := range this.incomplete {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	for _, height := range heights {
+		if err := this.finish(height); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort tells all the participants to discard the height, the errors are returned but the
+// transaction is aborted anyway, the leftovers are discarded by Recover.
+func (this *Coordinator) abort(height uint64) error {
+	errs := this.forEach(func(_ int, participant Participant) error {
+		return participant.Abort(height)
+	})
+	return errors.Join(errs...)
+}
+
+// forEach calls the function on all the participants in parallel, the errors are prefixed with
+// the participant names.
+func (this *Coordinator) forEach(f func(int, Participant) error) []error {
+	errs := make([]error, len(this.participants))
+	var wg sync.WaitGroup
+	for i, participant := range this.participants {
+		wg.Add(1)
+		go func(i int, participant Participant) {
+			defer wg.Done()
+			if err := f(i, participant); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", this.names[i], err)
+			}
+		}(i, participant)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (this *Coordinator) indexOf(name string) int {
+	for i, existing := range this.names {
+		if existing == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (this *Coordinator) appendDecision(height uint64, decision uint8) error {
+	if decision != DECISION_COMMIT {
+		_, err := this.file.Write(encodeDecision(height, decision))
+		return err
+	}
+
+	info, err := this.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = this.file.Write(encodeDecision(height, decision))
+	if err == nil {
+		err = this.file.Sync()
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	// The decision may have reached the disk even though the write or the sync failed, so it has
+	// to be removed durably before the transaction can be aborted.
+	if truncateErr := this.truncate(info.Size()); truncateErr != nil {
+		this.broken = fmt.Errorf("%w: %d: %w", ErrUndecided, height, errors.Join(err, truncateErr))
+		return this.broken
+	}
+	return err
+}
+
+// truncate cuts the log back to the size and syncs it.
+func (this *Coordinator) truncate(size int64) error {
+	if err := this.file.Truncate(size); err != nil {
+		return err
+	}
+
+	if _, err := this.file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	return this.file.Sync()
+}
+
+// load replays the decision log, a torn decision at the end is truncated.
+func (this *Coordinator) load() error {
+	buffer, err := io.ReadAll(this.file)
+	if err != nil {
+		return err
+	}
+
+	valid := 0
+	for ; valid+DECISION_SIZE <= len(buffer); valid += DECISION_SIZE {
+		height, decision, ok := decodeDecision(buffer[valid : valid+DECISION_SIZE])
+		if !ok {
+			break
+		}
+
+		switch decision {
+		case DECISION_COMMIT:
+			this.incomplete[height] = true
+			this.last = max(this.last, height)
+		case DECISION_DONE:
+			delete(this.incomplete, height)
+		}
+	}
+
+	if err := this.file.Truncate(int64(valid)); err != nil {
+		return err
+	}
+	_, err = this.file.Seek(int64(valid), io.SeekStart)
+	return err
+}
+
+// compact rewrites the log with only the decisions still needed, which are the incomplete ones and
+// the last one, kept for the height.
+func (this *Coordinator) compact() error {
+	buffer := make([]byte, 0, DECISION_SIZE*(len(this.incomplete)+2))
+	for height := range this.incomplete {
+		buffer = append(buffer, encodeDecision(height, DECISION_COMMIT)...)
+	}
+
+	if this.last > 0 && !this.incomplete[this.last] {
+		buffer = append(buffer, encodeDecision(this.last, DECISION_COMMIT)...)
+		buffer = append(buffer, encodeDecision(this.last, DECISION_DONE)...)
+	}
+
+	tmp := this.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(buffer); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, this.path); err != nil {
+		return err
+	}
+
+	if err := syncDir(filepath.Dir(this.path)); err != nil {
+		return err
+	}
+
+	reopened, err := os.OpenFile(this.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	this.file.Close()
+	this.file = reopened
+	return nil
+}
+
+func encodeDecision(height uint64, decision uint8) []byte {
+	buffer := make([]byte, DECISION_SIZE)
+	binary.LittleEndian.PutUint64(buffer[:8], height)
+	buffer[8] = decision
+	binary.LittleEndian.PutUint32(buffer[9:], crc32.ChecksumIEEE(buffer[:9]))
+	return buffer
+}
+
+func decodeDecision(buffer []byte) (uint64, uint8, bool) {
+	if crc32.ChecksumIEEE(buffer[:9]) != binary.LittleEndian.Uint32(buffer[9:]) {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint64(buffer[:8]), buffer[8], true
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
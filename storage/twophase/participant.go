@@ -0,0 +1,200 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package twophase
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arcology-network/common-lib/codec"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+)
+
+const (
+	RESERVED_PREFIX = "__2pc/"                      // The keys under the prefix are used by the participants.
+	PREPARED_PREFIX = RESERVED_PREFIX + "prepared/" // Followed by the height, the value is the staged batch.
+	HEIGHT_KEY      = RESERVED_PREFIX + "height"    // The last committed height
+)
+
+var _ Participant = (*StoreParticipant)(nil)
+
+// StoreParticipant makes a ReadWriteStore a participant. The staged batches and the committed height
+// are kept in the store itself under the reserved prefix, so they are as durable as the data. The
+// store should return the raw bytes, so it shouldn't have a decoder.
+//
+// A staged batch has to be durable before Prepare votes for the commit, so the store is synced after
+// staging it, with the sync function of the participant, or the Sync method of the store if there is
+// none. Prepare fails with ErrNotSyncable if there is neither. A crash while applying a batch leaves
+// it staged, so it is applied again by the recovery.
+type StoreParticipant struct {
+	store stgintf.ReadWriteStore[string, []byte]
+	sync  func() error
+	lock  sync.Mutex
+}
+
+// NewStoreParticipant creates a participant over the store. The sync function is only needed if the
+// store doesn't implement stgintf.Syncable, like the decorators of a syncable store, or the memory
+// stores with nothing to sync.
+func NewStoreParticipant(store stgintf.ReadWriteStore[string, []byte], sync ...func() error) *StoreParticipant {
+	participant := &StoreParticipant{store: store}
+	if len(sync) > 0 && sync[0] != nil {
+		participant.sync = sync[0]
+	} else if syncable, ok := store.(stgintf.Syncable); ok {
+		participant.sync = syncable.Sync
+	}
+	return participant
+}
+
+func (this *StoreParticipant) Prepare(height uint64, batch *Batch) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.sync == nil {
+		return ErrNotSyncable
+	}
+
+	for _, key := range batch.Keys {
+		if strings.HasPrefix(key, RESERVED_PREFIX) {
+			return fmt.Errorf("twophase: the key %s is reserved", key)
+		}
+	}
+
+	if err := this.store.Set(preparedKey(height), batch.Encode()); err != nil {
+		return err
+	}
+	return this.sync()
+}
+
+func (this *StoreParticipant) Commit(height uint64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	committed, err := this.height()
+	if err != nil {
+		return err
+	}
+
+	v, err := this.store.Get(preparedKey(height))
+	if err != nil && !errors.Is(err, stgintf.ErrNotFound) {
+		return err
+	}
+
+	if v == nil {
+		if height <= committed { // Committed already
+			return nil
+		}
+		return fmt.Errorf("%w: %d", ErrUnknownTx, height)
+	}
+
+	batch := &Batch{}
+	if err := batch.Decode(v.([]byte)); err != nil {
+		return err
+	}
+
+	setKeys, setValues, deleteKeys := batch.final()
+	if len(setKeys) > 0 {
+		if err := errors.Join(this.store.SetBatch(setKeys, setValues)...); err != nil {
+			return err
+		}
+	}
+
+	if len(deleteKeys) > 0 {
+		if err := errors.Join(this.store.DeleteBatch(deleteKeys)...); err != nil {
+			return err
+		}
+	}
+
+	if height > committed {
+		if err := this.store.Set(HEIGHT_KEY, codec.Uint64(height).Encode()); err != nil {
+			return err
+		}
+	}
+	if err := this.store.Delete(preparedKey(height)); err != nil { // Only removed after the data is in.
+		return err
+	}
+
+	// The coordinator forgets the decision once all the participants have committed.
+	if this.sync != nil {
+		return this.sync()
+	}
+	return nil
+}
+
+func (this *StoreParticipant) Abort(height uint64) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if !this.store.Has(preparedKey(height)) {
+		return nil
+	}
+	return this.store.Delete(preparedKey(height))
+}
+
+func (this *StoreParticipant) Prepared() ([]uint64, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	keys, _, errs := this.store.Query(PREPARED_PREFIX, func(key string, _ []byte) bool {
+		return strings.HasPrefix(key, PREPARED_PREFIX)
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	heights := make([]uint64, 0, len(keys))
+	for _, key := range keys {
+		height, err := strconv.ParseUint(strings.TrimPrefix(key, PREPARED_PREFIX), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid key %s", ErrCorrupted, key)
+		}
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights, nil
+}
+
+func (this *StoreParticipant) Height() (uint64, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.height()
+}
+
+func (this *StoreParticipant) height() (uint64, error) {
+	v, err := this.store.Get(HEIGHT_KEY)
+	if errors.Is(err, stgintf.ErrNotFound) || (err == nil && v == nil) {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if buffer, _ := v.([]byte); len(buffer) == codec.UINT64_LEN {
+		return uint64(codec.Uint64(0).Decode(buffer).(codec.Uint64)), nil
+	}
+	return 0, fmt.Errorf("%w: invalid height", ErrCorrupted)
+}
+
+// preparedKey is the key of the batch staged for the height, padded so the keys sort by height.
+func preparedKey(height uint64) string {
+	return fmt.Sprintf("%s%020d", PREPARED_PREFIX, height)
+}
@@ -0,0 +1,133 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package twophase commits the writes of a block to several stores atomically, so the state DB,
+// the receipt DB and the index DB always end up at the same height, even after a crash.
+//
+// A transaction is identified by the height of the block. The Coordinator first asks every
+// participant to prepare its writes, which stages them durably without applying them. Only if all
+// of them are prepared, the decision to commit is appended to a durable decision log, after which
+// the participants apply the staged writes. Otherwise they are all asked to abort.
+//
+// On restart, Recover resolves the transactions left in doubt by a crash. The ones with a commit
+// decision in the log are committed, and all the others are aborted, since no participant could
+// have applied a transaction that was never decided.
+package twophase
+
+import (
+	"errors"
+
+	"github.com/arcology-network/common-lib/codec"
+)
+
+const (
+	OP_SET    = uint8(0)
+	OP_DELETE = uint8(1)
+)
+
+var (
+	ErrHeightOrder    = errors.New("twophase: the height must be greater than the last decided one")
+	ErrUnknownTx      = errors.New("twophase: the transaction isn't prepared")
+	ErrIncomplete     = errors.New("twophase: the transaction is committed but not applied by all the participants yet")
+	ErrHeightMismatch = errors.New("twophase: the participants are at different heights")
+	ErrCorrupted      = errors.New("twophase: corrupted data")
+	ErrDuplicate      = errors.New("twophase: duplicate participant")
+	ErrUndecided      = errors.New("twophase: the commit decision may or may not be durable, the coordinator must be reopened")
+	ErrNotSyncable    = errors.New("twophase: the participant store can't be synced")
+)
+
+// Participant is a store taking part in the two phase commits. All the methods must be idempotent,
+// since they may be called again for the same height during the recovery.
+type Participant interface {
+	// Prepare stages the writes of the height durably, without making them visible. Once prepared,
+	// the participant must be able to commit the writes even after a restart.
+	Prepare(height uint64, batch *Batch) error
+
+	// Commit applies the staged writes of the height. It is a no-op if the height is already committed.
+	Commit(height uint64) error
+
+	// Abort discards the staged writes of the height, if any.
+	Abort(height uint64) error
+
+	// Prepared returns the heights staged but neither committed nor aborted yet.
+	Prepared() ([]uint64, error)
+
+	// Height returns the last committed height.
+	Height() (uint64, error)
+}
+
+// Batch is the writes of a transaction to a participant, applied in order.
+type Batch struct {
+	Ops    []uint8
+	Keys   []string
+	Values [][]byte // Nil for the deletions
+}
+
+func NewBatch() *Batch { return &Batch{} }
+
+func (this *Batch) Set(key string, value []byte) *Batch {
+	this.Ops, this.Keys, this.Values = append(this.Ops, OP_SET), append(this.Keys, key), append(this.Values, value)
+	return this
+}
+
+func (this *Batch) Delete(key string) *Batch {
+	this.Ops, this.Keys, this.Values = append(this.Ops, OP_DELETE), append(this.Keys, key), append(this.Values, nil)
+	return this
+}
+
+func (this *Batch) Len() int { return len(this.Keys) }
+
+func (this *Batch) Encode() []byte {
+	return codec.Byteset{this.Ops, codec.Strings(this.Keys).Encode(), codec.Byteset(this.Values).Encode()}.Encode()
+}
+
+func (this *Batch) Decode(buffer []byte) error {
+	fields := codec.Byteset{}.Decode(buffer).(codec.Byteset)
+	if len(fields) != 3 {
+		return ErrCorrupted
+	}
+
+	this.Ops = fields[0]
+	this.Keys = codec.Strings{}.Decode(fields[1]).(codec.Strings)
+	this.Values = codec.Byteset{}.Decode(fields[2]).(codec.Byteset)
+	if len(this.Keys) != len(this.Ops) || (len(this.Ops) > 0 && len(this.Values) != len(this.Ops)) {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// final returns the keys of the batch with their last operations and values, since only the last
+// write to a key matters.
+func (this *Batch) final() (setKeys []string, setValues [][]byte, deleteKeys []string) {
+	last := make(map[string]int, len(this.Keys))
+	for i, key := range this.Keys {
+		last[key] = i
+	}
+
+	for i, key := range this.Keys {
+		if last[key] != i {
+			continue
+		}
+
+		if this.Ops[i] == OP_DELETE {
+			deleteKeys = append(deleteKeys, key)
+		} else {
+			setKeys, setValues = append(setKeys, key), append(setValues, this.Values[i])
+		}
+	}
+	return
+}
@@ -0,0 +1,304 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package twophase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/arcology-network/common-lib/storage/faulty"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	"github.com/arcology-network/common-lib/storage/memdb"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/vfs"
+)
+
+var names = []string{"state", "receipts", "index"}
+
+func newCoordinator(t *testing.T, path string, stores []stgintf.ReadWriteStore[string, []byte]) *Coordinator {
+	t.Helper()
+	coordinator, err := NewCoordinator(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, store := range stores {
+		if err := coordinator.Register(names[i], participantOf(store)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return coordinator
+}
+
+// participantOf makes a participant of the store, the memory stores have nothing to sync.
+func participantOf(store stgintf.ReadWriteStore[string, []byte]) *StoreParticipant {
+	if _, ok := store.(stgintf.Syncable); ok {
+		return NewStoreParticipant(store)
+	}
+	return NewStoreParticipant(store, func() error { return nil })
+}
+
+func checkHeights(t *testing.T, stores []stgintf.ReadWriteStore[string, []byte], expected uint64) {
+	t.Helper()
+	for i, store := range stores {
+		height, err := participantOf(store).Height()
+		if err != nil || height != expected {
+			t.Fatalf("expected %s at %d, got %d %v", names[i], expected, height, err)
+		}
+	}
+}
+
+func TestTwoPhaseCommit(t *testing.T) {
+	dir := t.TempDir()
+	state, _ := pebbledb.NewPebbleDB(filepath.Join(dir, "state"))
+	defer state.Close()
+	stores := []stgintf.ReadWriteStore[string, []byte]{state, memdb.NewMemoryDB(), memdb.NewMemoryDB()}
+
+	coordinator := newCoordinator(t, filepath.Join(dir, "decisions"), stores)
+	defer coordinator.Close()
+	if err := coordinator.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := coordinator.Commit(1, map[string]*Batch{
+		"state":    NewBatch().Set("alice", []byte{1}).Set("bob", []byte{2}).Delete("bob"),
+		"receipts": NewBatch().Set("tx1", []byte("ok")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkHeights(t, stores, 1) // The index DB moves along without a batch.
+
+	if v, _ := state.Get("alice"); v == nil || v.([]byte)[0] != 1 || state.Has("bob") {
+		t.Fatal("unexpected state", v)
+	}
+
+	if prepared, _ := participantOf(state).Prepared(); len(prepared) != 0 {
+		t.Fatal("nothing should be left staged", prepared)
+	}
+
+	if err := coordinator.Commit(1, nil); !errors.Is(err, ErrHeightOrder) {
+		t.Fatal("expected ErrHeightOrder, got", err)
+	}
+
+	if err := coordinator.Commit(2, map[string]*Batch{"unknown": NewBatch()}); err == nil {
+		t.Fatal("expected an error for an unknown participant")
+	}
+}
+
+func TestTwoPhaseAbort(t *testing.T) {
+	dir := t.TempDir()
+	receipts := faulty.NewStore[string, []byte](memdb.NewMemoryDB(), faulty.Config{Rules: []faulty.Rule{{
+		Ops:         []string{faulty.OP_SET},
+		Keys:        regexp.MustCompile("^" + PREPARED_PREFIX + "0+2$"), // Fails to prepare height 2
+		Probability: 1,
+	}}})
+	stores := []stgintf.ReadWriteStore[string, []byte]{memdb.NewMemoryDB(), receipts}
+
+	coordinator := newCoordinator(t, filepath.Join(dir, "decisions"), stores)
+	defer coordinator.Close()
+
+	if err := coordinator.Commit(1, map[string]*Batch{"state": NewBatch().Set("a", []byte{1})}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := coordinator.Commit(2, map[string]*Batch{"state": NewBatch().Set("a", []byte{2}), "receipts": NewBatch().Set("r", nil)})
+	if !errors.Is(err, faulty.ErrInjected) {
+		t.Fatal("expected the injected failure, got", err)
+	}
+
+	checkHeights(t, stores, 1)
+	if v, _ := stores[0].Get("a"); v.([]byte)[0] != 1 || receipts.Has("r") {
+		t.Fatal("the aborted transaction is visible")
+	}
+
+	if prepared, _ := participantOf(stores[0]).Prepared(); len(prepared) != 0 {
+		t.Fatal("the aborted batch is still staged", prepared)
+	}
+
+	if err := coordinator.Commit(3, nil); err != nil { // The aborted height can be skipped.
+		t.Fatal(err)
+	}
+	checkHeights(t, stores, 3)
+}
+
+func TestTwoPhaseRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "decisions")
+	indexDB := memdb.NewMemoryDB()
+	index := faulty.NewStore[string, []byte](indexDB, faulty.Config{Rules: []faulty.Rule{{
+		Ops:         []string{faulty.OP_SET_BATCH}, // Fails to apply after preparing
+		Probability: 1,
+	}}})
+	stores := []stgintf.ReadWriteStore[string, []byte]{memdb.NewMemoryDB(), memdb.NewMemoryDB(), index}
+
+	coordinator := newCoordinator(t, path, stores)
+	err := coordinator.Commit(1, map[string]*Batch{
+		"state": NewBatch().Set("a", []byte{1}),
+		"index": NewBatch().Set("i", []byte{1}),
+	})
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatal("expected ErrIncomplete, got", err)
+	}
+	coordinator.Close() // Crashed before the index DB was retried
+
+	// A transaction prepared but never decided, since the coordinator crashed before the decision.
+	for _, store := range stores[:2] {
+		if err := participantOf(store).Prepare(2, NewBatch().Set("a", []byte{2})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Restarted with the index DB working again.
+	stores[2] = indexDB
+	coordinator = newCoordinator(t, path, stores)
+	if coordinator.Height() != 1 {
+		t.Fatal("expected the decided height to be 1, got", coordinator.Height())
+	}
+
+	if err := coordinator.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	checkHeights(t, stores, 1)
+
+	if v, _ := stores[0].Get("a"); v.([]byte)[0] != 1 || !stores[2].Has("i") {
+		t.Fatal("the decided transaction isn't applied everywhere", v)
+	}
+
+	for _, store := range stores {
+		if prepared, _ := participantOf(store).Prepared(); len(prepared) != 0 {
+			t.Fatal("the undecided transaction isn't aborted", prepared)
+		}
+	}
+
+	// The compacted log still knows the last height.
+	coordinator.Close()
+	coordinator = newCoordinator(t, path, stores)
+	defer coordinator.Close()
+	if coordinator.Height() != 1 {
+		t.Fatal("expected 1 after compaction, got", coordinator.Height())
+	}
+	if err := coordinator.Commit(2, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkHeights(t, stores, 2)
+}
+
+func TestTwoPhasePrepareDurable(t *testing.T) {
+	fs := vfs.NewStrictMem()
+	open := func() *pebbledb.PebbleDB {
+		db, err := pebbledb.OpenPebbleDB("", &pebble.Options{FS: fs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+
+	db := open()
+	if err := NewStoreParticipant(db).Prepare(1, NewBatch().Set("a", []byte{1})); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crashed after voting yes, only the synced writes survive.
+	fs.SetIgnoreSyncs(true)
+	db.Close()
+	fs.ResetToSyncedState()
+	fs.SetIgnoreSyncs(false)
+
+	db = open()
+	defer db.Close()
+	if err := NewStoreParticipant(db).Commit(1); err != nil {
+		t.Fatal("the prepared batch is lost", err)
+	}
+	if v, err := db.Get("a"); err != nil || v.([]byte)[0] != 1 {
+		t.Fatal("the prepared batch isn't applied", v, err)
+	}
+
+	if err := NewStoreParticipant(memdb.NewMemoryDB()).Prepare(1, NewBatch()); !errors.Is(err, ErrNotSyncable) {
+		t.Fatal("expected ErrNotSyncable, got", err)
+	}
+}
+
+// flakyLog fails the syncs of the decision log, and the truncations too if asked.
+type flakyLog struct {
+	*os.File
+	failSync     int // The number of syncs left to fail.
+	failTruncate bool
+}
+
+var errDisk = errors.New("disk failure")
+
+func (this *flakyLog) Sync() error {
+	if this.failSync > 0 {
+		this.failSync--
+		return errDisk
+	}
+	return this.File.Sync()
+}
+
+func (this *flakyLog) Truncate(size int64) error {
+	if this.failTruncate {
+		return errDisk
+	}
+	return this.File.Truncate(size)
+}
+
+func TestTwoPhaseDecisionFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "decisions")
+	stores := []stgintf.ReadWriteStore[string, []byte]{memdb.NewMemoryDB(), memdb.NewMemoryDB()}
+
+	// The decision is rolled back, so the transaction can be aborted safely.
+	coordinator := newCoordinator(t, path, stores)
+	coordinator.file = &flakyLog{File: coordinator.file.(*os.File), failSync: 1}
+	if err := coordinator.Commit(1, map[string]*Batch{"state": NewBatch().Set("a", []byte{1})}); !errors.Is(err, errDisk) || errors.Is(err, ErrUndecided) {
+		t.Fatal("expected the sync failure, got", err)
+	}
+	checkHeights(t, stores, 0)
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Fatal("the failed decision is still in the log")
+	}
+
+	// The decision can't be rolled back, so the prepared transaction is left for the recovery.
+	coordinator.file = &flakyLog{File: coordinator.file.(*flakyLog).File, failSync: 1, failTruncate: true}
+	if err := coordinator.Commit(2, map[string]*Batch{"state": NewBatch().Set("a", []byte{2})}); !errors.Is(err, ErrUndecided) {
+		t.Fatal("expected ErrUndecided, got", err)
+	}
+	if prepared, _ := participantOf(stores[0]).Prepared(); len(prepared) != 1 {
+		t.Fatal("the undecided transaction shouldn't be aborted", prepared)
+	}
+	if err := coordinator.Commit(3, nil); !errors.Is(err, ErrUndecided) {
+		t.Fatal("expected ErrUndecided until reopened, got", err)
+	}
+	coordinator.Close()
+
+	// The decision has reached the log after all, so the recovery commits it.
+	coordinator = newCoordinator(t, path, stores)
+	defer coordinator.Close()
+	if err := coordinator.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	checkHeights(t, stores, 2)
+	if v, _ := stores[0].Get("a"); v.([]byte)[0] != 2 {
+		t.Fatal("the decided transaction isn't applied", v)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ *   Copyright (c) 2026 Arcology Network
+ *
+ *   This program is free software: you can redistribute it and/or modify
+ *   it under the terms of the GNU General Public License as published by
+ *   the Free Software Foundation, either version 3 of the License, or
+ *   (at your option) any later version.
+ *
+ *   This program is distributed in the hope that it will be useful,
+ *   but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *   GNU General Public License for more details.
+ *
+ *   You should have received a copy of the GNU General Public License
+ *   along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// stgmigrate copies the contents of one store into another through the snapshot stream format.
+//
+// Usage:
+//
+//	stgmigrate -from pebble:/data/state -to badger:/data/state-badger
+//	stgmigrate -from parapebble:/data/state -to file:/backup/state.snap
+//	stgmigrate -from file:/backup/state.snap -to filedb:/data/state-filedb
+//	stgmigrate -from pebble:/data/blocks -to pebble:/data/blocks-ordered -rekey uint64
+//
+// Supported endpoints are pebble, parapebble, badger, parabadger, filedb and file, where file
+// refers to a snapshot file. A filedb target is recreated from scratch. A failed import leaves the
+// target store partially written, it is removed if the migration created it, otherwise it has to be
+// discarded by hand.
+//
+// With -rekey, the keys of a store written by a codec with integer keys of the type are converted
+// from the little endian encoding to the ordered one on the way to the target store. This is the
+// upgrade path for the stores written before the ordered keys became the default of the codecs.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	badgerdb "github.com/arcology-network/common-lib/storage/badger"
+	stgcodec "github.com/arcology-network/common-lib/storage/codec"
+	filedb "github.com/arcology-network/common-lib/storage/filedb"
+	stgintf "github.com/arcology-network/common-lib/storage/interface"
+	pebbledb "github.com/arcology-network/common-lib/storage/pebble"
+	"github.com/arcology-network/common-lib/storage/snapshot"
+)
+
+// rekeyers convert the integer keys of the types from the little endian encoding to the ordered one.
+var rekeyers = map[string]func(string) (string, error){
+	"int":    rekeyer[int],
+	"int8":   rekeyer[int8],
+	"int16":  rekeyer[int16],
+	"int32":  rekeyer[int32],
+	"int64":  rekeyer[int64],
+	"uint":   rekeyer[uint],
+	"uint8":  rekeyer[uint8],
+	"uint16": rekeyer[uint16],
+	"uint32": rekeyer[uint32],
+	"uint64": rekeyer[uint64],
+}
+
+func rekeyer[K stgcodec.Convertible](key string) (string, error) {
+	return stgcodec.ReencodeKey[K](key, stgcodec.KEY_ENCODING_LITTLE_ENDIAN, stgcodec.KEY_ENCODING_ORDERED)
+}
+
+// rekeyed converts the keys written to the store.
+type rekeyed struct {
+	stgintf.ReadWriteStore[string, []byte]
+	rekey func(string) (string, error)
+}
+
+func (this *rekeyed) Set(key string, value []byte) error {
+	return this.SetBatch([]string{key}, [][]byte{value})[0]
+}
+
+func (this *rekeyed) SetBatch(keys []string, values [][]byte) []error {
+	converted := make([]string, len(keys))
+	for i, key := range keys {
+		var err error
+		if converted[i], err = this.rekey(key); err != nil {
+			errs := make([]error, len(keys))
+			errs[i] = fmt.Errorf("key %x: %w", key, err)
+			return errs
+		}
+	}
+
+	errs := this.ReadWriteStore.SetBatch(converted, values)
+	if len(errs) == 0 { // Some stores return nothing if all succeed.
+		errs = make([]error, len(keys))
+	}
+	return errs
+}
+
+type endpoint struct {
+	kind    string
+	path    string
+	store   stgintf.ReadWriteStore[string, []byte]
+	close   func() error
+	created bool // The target didn't exist before, or is a filedb recreated from scratch.
+}
+
+func main() {
+	from := flag.String("from", "", "source endpoint, <kind>:<path>")
+	to := flag.String("to", "", "target endpoint, <kind>:<path>")
+	chunkSize := flag.Int("chunk", snapshot.DEFAULT_CHUNK_SIZE, "number of entries per chunk")
+	shards := flag.Uint("shards", 8, "number of shards of a filedb endpoint")
+	depth := flag.Uint("depth", 2, "directory depth of a filedb endpoint")
+	rekey := flag.String("rekey", "", "integer key type to convert from little endian to the ordered encoding, like uint64")
+	flag.Parse()
+
+	if len(*from) == 0 || len(*to) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	t0 := time.Now()
+	manifest, err := migrate(*from, *to, *chunkSize, uint32(*shards), uint8(*depth), *rekey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "stgmigrate:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("migrated %d entries in %d chunks from %s to %s in %v\n", manifest.Total, len(manifest.Chunks), *from, *to, time.Since(t0))
+}
+
+func migrate(from, to string, chunkSize int, shards uint32, depth uint8, rekey string) (*snapshot.Manifest, error) {
+	source, err := open(from, shards, depth, false)
+	if err != nil {
+		return nil, err
+	}
+	defer source.close()
+
+	target, err := open(to, shards, depth, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { target.close() }()
+
+	if len(rekey) > 0 {
+		convert, ok := rekeyers[rekey]
+		if !ok {
+			return nil, fmt.Errorf("unknown key type %q to rekey", rekey)
+		}
+
+		if target.kind == "file" {
+			return nil, fmt.Errorf("the keys can only be converted into a store")
+		}
+		target.store = &rekeyed{ReadWriteStore: target.store, rekey: convert}
+	}
+
+	switch {
+	case source.kind == "file" && target.kind == "file":
+		return nil, fmt.Errorf("at least one endpoint needs to be a store")
+
+	case source.kind == "file":
+		file, err := os.Open(source.path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return importInto(file, target)
+
+	case target.kind == "file":
+		file, err := os.Create(target.path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		manifest, err := snapshot.Export(source.store, file, chunkSize)
+		if err != nil {
+			return nil, err
+		}
+		return manifest, file.Sync()
+
+	default: // Stream the snapshot directly from one store to the other.
+		reader, writer := io.Pipe()
+		go func() {
+			_, err := snapshot.Export(source.store, writer, chunkSize)
+			writer.CloseWithError(err)
+		}()
+		return importInto(reader, target)
+	}
+}
+
+// importInto imports the stream into the target store. The store created for the import is removed
+// if the import fails, an existing one is only reported, since it may have held something else.
+func importInto(reader io.Reader, target *endpoint) (*snapshot.Manifest, error) {
+	manifest, err := snapshot.Import(reader, target.store)
+	if err == nil {
+		return manifest, nil
+	}
+
+	if !target.created {
+		return nil, fmt.Errorf("%w, %s is left partially imported and has to be discarded", err, target.path)
+	}
+
+	target.close()
+	target.close = func() error { return nil }
+	if removeErr := os.RemoveAll(target.path); removeErr != nil {
+		return nil, fmt.Errorf("%w, %s is left partially imported: %w", err, target.path, removeErr)
+	}
+	return nil, err
+}
+
+func open(spec string, shards uint32, depth uint8, isTarget bool) (*endpoint, error) {
+	kind, path, ok := strings.Cut(spec, ":")
+	if !ok || len(path) == 0 {
+		return nil, fmt.Errorf("invalid endpoint %q, expecting <kind>:<path>", spec)
+	}
+
+	ep := &endpoint{kind: kind, path: path, close: func() error { return nil }}
+	if isTarget {
+		_, err := os.Stat(path)
+		ep.created = os.IsNotExist(err) || kind == "filedb"
+	}
+
+	switch kind {
+	case "file":
+	case "pebble":
+		db, err := pebbledb.NewPebbleDB(path)
+		if err != nil {
+			return nil, err
+		}
+		ep.store, ep.close = db, db.Close
+	case "parapebble":
+		db, err := pebbledb.NewParaPebbleDB(path, nil)
+		if err != nil {
+			return nil, err
+		}
+		ep.store, ep.close = db, db.Close
+	case "badger":
+		db := badgerdb.NewBadgerDB(path)
+		ep.store, ep.close = db, db.Close
+	case "parabadger":
+		db := badgerdb.NewParaBadgerDB(path, nil)
+		ep.store, ep.close = db, db.Close
+	case "filedb":
+		var db *filedb.FileDB
+		var err error
+		if isTarget {
+			db, err = filedb.NewFileDB(path, shards, depth)
+		} else {
+			db, err = filedb.LoadFileDB(path, shards, depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ep.store = db
+	default:
+		return nil, fmt.Errorf("unknown endpoint kind %q", kind)
+	}
+	return ep, nil
+}